@@ -24,6 +24,9 @@ const _ = grpc.SupportPackageIsVersion7
 type RuneServiceClient interface {
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	Txn(ctx context.Context, in *TxnRequest, opts ...grpc.CallOption) (*TxnResponse, error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (RuneService_WatchClient, error)
 }
 
 type runeServiceClient struct {
@@ -52,12 +55,65 @@ func (c *runeServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grp
 	return out, nil
 }
 
+func (c *runeServiceClient) Txn(ctx context.Context, in *TxnRequest, opts ...grpc.CallOption) (*TxnResponse, error) {
+	out := new(TxnResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.RuneService/Txn", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *runeServiceClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.RuneService/List", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *runeServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (RuneService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &RuneService_ServiceDesc.Streams[0], "/api.v1.RuneService/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &runeServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RuneService_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type runeServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *runeServiceWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // RuneServiceServer is the server API for RuneService service.
 // All implementations must embed UnimplementedRuneServiceServer
 // for forward compatibility
 type RuneServiceServer interface {
 	Put(context.Context, *PutRequest) (*PutResponse, error)
 	Get(context.Context, *GetRequest) (*GetResponse, error)
+	Txn(context.Context, *TxnRequest) (*TxnResponse, error)
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	Watch(*WatchRequest, RuneService_WatchServer) error
 	mustEmbedUnimplementedRuneServiceServer()
 }
 
@@ -71,6 +127,15 @@ func (UnimplementedRuneServiceServer) Put(context.Context, *PutRequest) (*PutRes
 func (UnimplementedRuneServiceServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
 }
+func (UnimplementedRuneServiceServer) Txn(context.Context, *TxnRequest) (*TxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Txn not implemented")
+}
+func (UnimplementedRuneServiceServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedRuneServiceServer) Watch(*WatchRequest, RuneService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
 func (UnimplementedRuneServiceServer) mustEmbedUnimplementedRuneServiceServer() {}
 
 // UnsafeRuneServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +185,63 @@ func _RuneService_Get_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _RuneService_Txn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuneServiceServer).Txn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.RuneService/Txn",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuneServiceServer).Txn(ctx, req.(*TxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RuneService_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuneServiceServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.RuneService/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuneServiceServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RuneService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RuneServiceServer).Watch(m, &runeServiceWatchServer{stream})
+}
+
+type RuneService_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type runeServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *runeServiceWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // RuneService_ServiceDesc is the grpc.ServiceDesc for RuneService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,6 +257,648 @@ var RuneService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Get",
 			Handler:    _RuneService_Get_Handler,
 		},
+		{
+			MethodName: "Txn",
+			Handler:    _RuneService_Txn_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _RuneService_List_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _RuneService_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/v1/rune.proto",
+}
+
+// SysServiceClient is the client API for SysService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SysServiceClient interface {
+	EnableMount(ctx context.Context, in *EnableMountRequest, opts ...grpc.CallOption) (*EnableMountResponse, error)
+	DisableMount(ctx context.Context, in *DisableMountRequest, opts ...grpc.CallOption) (*DisableMountResponse, error)
+	TuneMount(ctx context.Context, in *TuneMountRequest, opts ...grpc.CallOption) (*TuneMountResponse, error)
+	ListMounts(ctx context.Context, in *ListMountsRequest, opts ...grpc.CallOption) (*ListMountsResponse, error)
+	// StorageStats and CompactStorage act on the storage backend of the node
+	// that serves the request.
+	StorageStats(ctx context.Context, in *StorageStatsRequest, opts ...grpc.CallOption) (*StorageStatsResponse, error)
+	CompactStorage(ctx context.Context, in *CompactStorageRequest, opts ...grpc.CallOption) (*CompactStorageResponse, error)
+	// StorageHash returns a node of the hash tree of the node's storage, so
+	// that replicas can be compared without transferring their data.
+	StorageHash(ctx context.Context, in *StorageHashRequest, opts ...grpc.CallOption) (*StorageHashResponse, error)
+	// MigrateStoragePaths moves every value stored in the other path layout to
+	// the one the server is configured with, hashed or plain.
+	MigrateStoragePaths(ctx context.Context, in *MigrateStoragePathsRequest, opts ...grpc.CallOption) (*MigrateStoragePathsResponse, error)
+}
+
+type sysServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSysServiceClient(cc grpc.ClientConnInterface) SysServiceClient {
+	return &sysServiceClient{cc}
+}
+
+func (c *sysServiceClient) EnableMount(ctx context.Context, in *EnableMountRequest, opts ...grpc.CallOption) (*EnableMountResponse, error) {
+	out := new(EnableMountResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.SysService/EnableMount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sysServiceClient) DisableMount(ctx context.Context, in *DisableMountRequest, opts ...grpc.CallOption) (*DisableMountResponse, error) {
+	out := new(DisableMountResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.SysService/DisableMount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sysServiceClient) TuneMount(ctx context.Context, in *TuneMountRequest, opts ...grpc.CallOption) (*TuneMountResponse, error) {
+	out := new(TuneMountResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.SysService/TuneMount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sysServiceClient) ListMounts(ctx context.Context, in *ListMountsRequest, opts ...grpc.CallOption) (*ListMountsResponse, error) {
+	out := new(ListMountsResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.SysService/ListMounts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sysServiceClient) StorageStats(ctx context.Context, in *StorageStatsRequest, opts ...grpc.CallOption) (*StorageStatsResponse, error) {
+	out := new(StorageStatsResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.SysService/StorageStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sysServiceClient) CompactStorage(ctx context.Context, in *CompactStorageRequest, opts ...grpc.CallOption) (*CompactStorageResponse, error) {
+	out := new(CompactStorageResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.SysService/CompactStorage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sysServiceClient) StorageHash(ctx context.Context, in *StorageHashRequest, opts ...grpc.CallOption) (*StorageHashResponse, error) {
+	out := new(StorageHashResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.SysService/StorageHash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sysServiceClient) MigrateStoragePaths(ctx context.Context, in *MigrateStoragePathsRequest, opts ...grpc.CallOption) (*MigrateStoragePathsResponse, error) {
+	out := new(MigrateStoragePathsResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.SysService/MigrateStoragePaths", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SysServiceServer is the server API for SysService service.
+// All implementations must embed UnimplementedSysServiceServer
+// for forward compatibility
+type SysServiceServer interface {
+	EnableMount(context.Context, *EnableMountRequest) (*EnableMountResponse, error)
+	DisableMount(context.Context, *DisableMountRequest) (*DisableMountResponse, error)
+	TuneMount(context.Context, *TuneMountRequest) (*TuneMountResponse, error)
+	ListMounts(context.Context, *ListMountsRequest) (*ListMountsResponse, error)
+	// StorageStats and CompactStorage act on the storage backend of the node
+	// that serves the request.
+	StorageStats(context.Context, *StorageStatsRequest) (*StorageStatsResponse, error)
+	CompactStorage(context.Context, *CompactStorageRequest) (*CompactStorageResponse, error)
+	// StorageHash returns a node of the hash tree of the node's storage, so
+	// that replicas can be compared without transferring their data.
+	StorageHash(context.Context, *StorageHashRequest) (*StorageHashResponse, error)
+	// MigrateStoragePaths moves every value stored in the other path layout to
+	// the one the server is configured with, hashed or plain.
+	MigrateStoragePaths(context.Context, *MigrateStoragePathsRequest) (*MigrateStoragePathsResponse, error)
+	mustEmbedUnimplementedSysServiceServer()
+}
+
+// UnimplementedSysServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedSysServiceServer struct {
+}
+
+func (UnimplementedSysServiceServer) EnableMount(context.Context, *EnableMountRequest) (*EnableMountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnableMount not implemented")
+}
+func (UnimplementedSysServiceServer) DisableMount(context.Context, *DisableMountRequest) (*DisableMountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableMount not implemented")
+}
+func (UnimplementedSysServiceServer) TuneMount(context.Context, *TuneMountRequest) (*TuneMountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TuneMount not implemented")
+}
+func (UnimplementedSysServiceServer) ListMounts(context.Context, *ListMountsRequest) (*ListMountsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMounts not implemented")
+}
+func (UnimplementedSysServiceServer) StorageStats(context.Context, *StorageStatsRequest) (*StorageStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StorageStats not implemented")
+}
+func (UnimplementedSysServiceServer) CompactStorage(context.Context, *CompactStorageRequest) (*CompactStorageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompactStorage not implemented")
+}
+func (UnimplementedSysServiceServer) StorageHash(context.Context, *StorageHashRequest) (*StorageHashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StorageHash not implemented")
+}
+func (UnimplementedSysServiceServer) MigrateStoragePaths(context.Context, *MigrateStoragePathsRequest) (*MigrateStoragePathsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MigrateStoragePaths not implemented")
+}
+func (UnimplementedSysServiceServer) mustEmbedUnimplementedSysServiceServer() {}
+
+// UnsafeSysServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SysServiceServer will
+// result in compilation errors.
+type UnsafeSysServiceServer interface {
+	mustEmbedUnimplementedSysServiceServer()
+}
+
+func RegisterSysServiceServer(s grpc.ServiceRegistrar, srv SysServiceServer) {
+	s.RegisterService(&SysService_ServiceDesc, srv)
+}
+
+func _SysService_EnableMount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnableMountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SysServiceServer).EnableMount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.SysService/EnableMount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SysServiceServer).EnableMount(ctx, req.(*EnableMountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SysService_DisableMount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableMountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SysServiceServer).DisableMount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.SysService/DisableMount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SysServiceServer).DisableMount(ctx, req.(*DisableMountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SysService_TuneMount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TuneMountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SysServiceServer).TuneMount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.SysService/TuneMount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SysServiceServer).TuneMount(ctx, req.(*TuneMountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SysService_ListMounts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMountsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SysServiceServer).ListMounts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.SysService/ListMounts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SysServiceServer).ListMounts(ctx, req.(*ListMountsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SysService_StorageStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StorageStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SysServiceServer).StorageStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.SysService/StorageStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SysServiceServer).StorageStats(ctx, req.(*StorageStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SysService_CompactStorage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompactStorageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SysServiceServer).CompactStorage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.SysService/CompactStorage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SysServiceServer).CompactStorage(ctx, req.(*CompactStorageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SysService_StorageHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StorageHashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SysServiceServer).StorageHash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.SysService/StorageHash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SysServiceServer).StorageHash(ctx, req.(*StorageHashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SysService_MigrateStoragePaths_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MigrateStoragePathsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SysServiceServer).MigrateStoragePaths(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.SysService/MigrateStoragePaths",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SysServiceServer).MigrateStoragePaths(ctx, req.(*MigrateStoragePathsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SysService_ServiceDesc is the grpc.ServiceDesc for SysService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SysService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.v1.SysService",
+	HandlerType: (*SysServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "EnableMount",
+			Handler:    _SysService_EnableMount_Handler,
+		},
+		{
+			MethodName: "DisableMount",
+			Handler:    _SysService_DisableMount_Handler,
+		},
+		{
+			MethodName: "TuneMount",
+			Handler:    _SysService_TuneMount_Handler,
+		},
+		{
+			MethodName: "ListMounts",
+			Handler:    _SysService_ListMounts_Handler,
+		},
+		{
+			MethodName: "StorageStats",
+			Handler:    _SysService_StorageStats_Handler,
+		},
+		{
+			MethodName: "CompactStorage",
+			Handler:    _SysService_CompactStorage_Handler,
+		},
+		{
+			MethodName: "StorageHash",
+			Handler:    _SysService_StorageHash_Handler,
+		},
+		{
+			MethodName: "MigrateStoragePaths",
+			Handler:    _SysService_MigrateStoragePaths_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/v1/rune.proto",
+}
+
+// RaftServiceClient is the client API for RaftService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RaftServiceClient interface {
+	Join(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (*JoinResponse, error)
+	RemovePeer(ctx context.Context, in *RemovePeerRequest, opts ...grpc.CallOption) (*RemovePeerResponse, error)
+	ListPeers(ctx context.Context, in *ListPeersRequest, opts ...grpc.CallOption) (*ListPeersResponse, error)
+	// ClusterHealth reports the health of every member as autopilot on the
+	// leader sees it.
+	ClusterHealth(ctx context.Context, in *ClusterHealthRequest, opts ...grpc.CallOption) (*ClusterHealthResponse, error)
+	// ServerStats reports the Raft state of the node it is sent to. The
+	// leader's autopilot calls it on every member to track their health.
+	ServerStats(ctx context.Context, in *ServerStatsRequest, opts ...grpc.CallOption) (*ServerStatsResponse, error)
+	// ListQuarantined lists the log entries the cluster could not decode and
+	// skipped. They are replicated, so any member can list them.
+	ListQuarantined(ctx context.Context, in *ListQuarantinedRequest, opts ...grpc.CallOption) (*ListQuarantinedResponse, error)
+}
+
+type raftServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRaftServiceClient(cc grpc.ClientConnInterface) RaftServiceClient {
+	return &raftServiceClient{cc}
+}
+
+func (c *raftServiceClient) Join(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (*JoinResponse, error) {
+	out := new(JoinResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.RaftService/Join", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftServiceClient) RemovePeer(ctx context.Context, in *RemovePeerRequest, opts ...grpc.CallOption) (*RemovePeerResponse, error) {
+	out := new(RemovePeerResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.RaftService/RemovePeer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftServiceClient) ListPeers(ctx context.Context, in *ListPeersRequest, opts ...grpc.CallOption) (*ListPeersResponse, error) {
+	out := new(ListPeersResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.RaftService/ListPeers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftServiceClient) ClusterHealth(ctx context.Context, in *ClusterHealthRequest, opts ...grpc.CallOption) (*ClusterHealthResponse, error) {
+	out := new(ClusterHealthResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.RaftService/ClusterHealth", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftServiceClient) ServerStats(ctx context.Context, in *ServerStatsRequest, opts ...grpc.CallOption) (*ServerStatsResponse, error) {
+	out := new(ServerStatsResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.RaftService/ServerStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftServiceClient) ListQuarantined(ctx context.Context, in *ListQuarantinedRequest, opts ...grpc.CallOption) (*ListQuarantinedResponse, error) {
+	out := new(ListQuarantinedResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.RaftService/ListQuarantined", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RaftServiceServer is the server API for RaftService service.
+// All implementations must embed UnimplementedRaftServiceServer
+// for forward compatibility
+type RaftServiceServer interface {
+	Join(context.Context, *JoinRequest) (*JoinResponse, error)
+	RemovePeer(context.Context, *RemovePeerRequest) (*RemovePeerResponse, error)
+	ListPeers(context.Context, *ListPeersRequest) (*ListPeersResponse, error)
+	// ClusterHealth reports the health of every member as autopilot on the
+	// leader sees it.
+	ClusterHealth(context.Context, *ClusterHealthRequest) (*ClusterHealthResponse, error)
+	// ServerStats reports the Raft state of the node it is sent to. The
+	// leader's autopilot calls it on every member to track their health.
+	ServerStats(context.Context, *ServerStatsRequest) (*ServerStatsResponse, error)
+	// ListQuarantined lists the log entries the cluster could not decode and
+	// skipped. They are replicated, so any member can list them.
+	ListQuarantined(context.Context, *ListQuarantinedRequest) (*ListQuarantinedResponse, error)
+	mustEmbedUnimplementedRaftServiceServer()
+}
+
+// UnimplementedRaftServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedRaftServiceServer struct {
+}
+
+func (UnimplementedRaftServiceServer) Join(context.Context, *JoinRequest) (*JoinResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Join not implemented")
+}
+func (UnimplementedRaftServiceServer) RemovePeer(context.Context, *RemovePeerRequest) (*RemovePeerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePeer not implemented")
+}
+func (UnimplementedRaftServiceServer) ListPeers(context.Context, *ListPeersRequest) (*ListPeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPeers not implemented")
+}
+func (UnimplementedRaftServiceServer) ClusterHealth(context.Context, *ClusterHealthRequest) (*ClusterHealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClusterHealth not implemented")
+}
+func (UnimplementedRaftServiceServer) ServerStats(context.Context, *ServerStatsRequest) (*ServerStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ServerStats not implemented")
+}
+func (UnimplementedRaftServiceServer) ListQuarantined(context.Context, *ListQuarantinedRequest) (*ListQuarantinedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListQuarantined not implemented")
+}
+func (UnimplementedRaftServiceServer) mustEmbedUnimplementedRaftServiceServer() {}
+
+// UnsafeRaftServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RaftServiceServer will
+// result in compilation errors.
+type UnsafeRaftServiceServer interface {
+	mustEmbedUnimplementedRaftServiceServer()
+}
+
+func RegisterRaftServiceServer(s grpc.ServiceRegistrar, srv RaftServiceServer) {
+	s.RegisterService(&RaftService_ServiceDesc, srv)
+}
+
+func _RaftService_Join_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JoinRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).Join(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.RaftService/Join",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).Join(ctx, req.(*JoinRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftService_RemovePeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemovePeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).RemovePeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.RaftService/RemovePeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).RemovePeer(ctx, req.(*RemovePeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftService_ListPeers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPeersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).ListPeers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.RaftService/ListPeers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).ListPeers(ctx, req.(*ListPeersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftService_ClusterHealth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterHealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).ClusterHealth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.RaftService/ClusterHealth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).ClusterHealth(ctx, req.(*ClusterHealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftService_ServerStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServerStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).ServerStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.RaftService/ServerStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).ServerStats(ctx, req.(*ServerStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftService_ListQuarantined_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListQuarantinedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).ListQuarantined(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.RaftService/ListQuarantined",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).ListQuarantined(ctx, req.(*ListQuarantinedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RaftService_ServiceDesc is the grpc.ServiceDesc for RaftService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RaftService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.v1.RaftService",
+	HandlerType: (*RaftServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Join",
+			Handler:    _RaftService_Join_Handler,
+		},
+		{
+			MethodName: "RemovePeer",
+			Handler:    _RaftService_RemovePeer_Handler,
+		},
+		{
+			MethodName: "ListPeers",
+			Handler:    _RaftService_ListPeers_Handler,
+		},
+		{
+			MethodName: "ClusterHealth",
+			Handler:    _RaftService_ClusterHealth_Handler,
+		},
+		{
+			MethodName: "ServerStats",
+			Handler:    _RaftService_ServerStats_Handler,
+		},
+		{
+			MethodName: "ListQuarantined",
+			Handler:    _RaftService_ListQuarantined_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/v1/rune.proto",
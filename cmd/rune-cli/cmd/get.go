@@ -8,6 +8,8 @@ import (
 	apiv1 "github.com/thelamedev/rune/api/v1"
 )
 
+var getConsistency string
+
 var getCmd = &cobra.Command{
 	Use:   "get [path]",
 	Short: "Get a secret at a given path",
@@ -15,9 +17,15 @@ var getCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		path := args[0]
+		consistency, err := parseConsistency(getConsistency)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
 		resp, err := client.Get(cmd.Context(), &apiv1.GetRequest{
-			Path: path,
+			Path:        path,
+			Consistency: consistency,
 		})
 		if err != nil {
 			fmt.Printf("Failed to get secret: %v\n", err)
@@ -29,5 +37,6 @@ var getCmd = &cobra.Command{
 }
 
 func init() {
+	getCmd.Flags().StringVar(&getConsistency, "consistency", "", "How up to date the secret must be: stale, leader or linearizable (default: the server's)")
 	rootCmd.AddCommand(getCmd)
 }
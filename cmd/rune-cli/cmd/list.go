@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	apiv1 "github.com/thelamedev/rune/api/v1"
+)
+
+var (
+	listDepth       int
+	listPageSize    int32
+	listConsistency string
+	// listReadConsistency is listConsistency, parsed.
+	listReadConsistency apiv1.ReadConsistency
+
+	listCmd = &cobra.Command{
+		Use:   "list [path]",
+		Short: "List the secrets below a given path",
+		Long:  `Lists the secrets and folders below a path in the Rune vault, rendered as a tree.`,
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := ""
+			if len(args) == 1 {
+				path = strings.TrimSuffix(args[0], "/")
+			}
+
+			var err error
+			if listReadConsistency, err = parseConsistency(listConsistency); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			root := path + "/"
+			if path == "" {
+				root = "/"
+			}
+			fmt.Println(root)
+
+			if err := printTree(cmd.Context(), path, "", 1); err != nil {
+				fmt.Printf("Failed to list secrets: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+// printTree prints the entries of the folder at path, recursing into
+// sub-folders until listDepth is reached.
+func printTree(ctx context.Context, path, indent string, depth int) error {
+	entries, err := listFolder(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		branch, childIndent := "├── ", "│   "
+		if i == len(entries)-1 {
+			branch, childIndent = "└── ", "    "
+		}
+		fmt.Println(indent + branch + entry.Name)
+
+		if entry.Folder && (listDepth <= 0 || depth < listDepth) {
+			child := strings.TrimSuffix(entry.Name, "/")
+			if path != "" {
+				child = path + "/" + child
+			}
+			if err := printTree(ctx, child, indent+childIndent, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listFolder fetches every page of the immediate children of path.
+func listFolder(ctx context.Context, path string) ([]*apiv1.ListEntry, error) {
+	var entries []*apiv1.ListEntry
+	req := &apiv1.ListRequest{Path: path, PageSize: listPageSize, Consistency: listReadConsistency}
+	for {
+		resp, err := client.List(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, resp.Entries...)
+		if resp.NextPageToken == "" {
+			return entries, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+func init() {
+	listCmd.Flags().IntVarP(&listDepth, "depth", "d", 0, "Maximum folder depth to descend into (0 for no limit)")
+	listCmd.Flags().Int32Var(&listPageSize, "page-size", 100, "Number of entries to fetch per request")
+	listCmd.Flags().StringVar(&listConsistency, "consistency", "", "How up to date the listing must be: stale, leader or linearizable (default: the server's)")
+	rootCmd.AddCommand(listCmd)
+}
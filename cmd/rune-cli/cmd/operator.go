@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var operatorCmd = &cobra.Command{
+	Use:   "operator",
+	Short: "Operate the Rune server",
+	Long:  `Commands for operators, acting on the server the CLI is connected to.`,
+}
+
+func init() {
+	rootCmd.AddCommand(operatorCmd)
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	apiv1 "github.com/thelamedev/rune/api/v1"
+)
+
+var (
+	joinAPIAddr string
+
+	raftCmd = &cobra.Command{
+		Use:   "raft",
+		Short: "Manage the members of the Raft cluster",
+		Long: `Commands acting on the membership of the Raft cluster. They can be sent to any
+member; members that do not lead the cluster pass them on to the leader.`,
+	}
+
+	raftJoinCmd = &cobra.Command{
+		Use:   "join <node-id> <raft-addr>",
+		Short: "Add a node to the cluster",
+		Long: `Adds a running, unbootstrapped node to the cluster, which then replicates the
+cluster's data to it. A member with the same ID or Raft address is replaced.
+With autopilot, the node joins as a non-voter and is promoted to voter once it
+has kept up with the leader for a while.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := raftClient.Join(cmd.Context(), &apiv1.JoinRequest{
+				NodeId:      args[0],
+				RaftAddress: args[1],
+				ApiAddress:  joinAPIAddr,
+			})
+			if err != nil {
+				fmt.Printf("Failed to join node: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Node %s joined the cluster\n", args[0])
+		},
+	}
+
+	raftListPeersCmd = &cobra.Command{
+		Use:   "list-peers",
+		Short: "List the members of the cluster",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := raftClient.ListPeers(cmd.Context(), &apiv1.ListPeersRequest{})
+			if err != nil {
+				fmt.Printf("Failed to list peers: %v\n", err)
+				os.Exit(1)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NODE\tRAFT ADDRESS\tAPI ADDRESS\tSTATE\tVOTER\tLAST CONTACT")
+			for _, p := range resp.Peers {
+				state := "follower"
+				if p.Leader {
+					state = "leader"
+				}
+				contact := "-"
+				if p.LastContactMs > 0 {
+					contact = (time.Duration(p.LastContactMs) * time.Millisecond).String() + " ago"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n", p.NodeId, p.RaftAddress, p.ApiAddress, state, p.Voter, contact)
+			}
+			w.Flush()
+		},
+	}
+
+	raftHealthCmd = &cobra.Command{
+		Use:   "health",
+		Short: "Show the health of the members of the cluster",
+		Long: `Shows the health of every member as autopilot on the leader sees it: whether
+it keeps up with the leader, and for how long it has been healthy or not.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := raftClient.ClusterHealth(cmd.Context(), &apiv1.ClusterHealthRequest{})
+			if err != nil {
+				fmt.Printf("Failed to check cluster health: %v\n", err)
+				os.Exit(1)
+			}
+
+			state := "healthy"
+			if !resp.Healthy {
+				state = "unhealthy"
+			}
+			fmt.Printf("Cluster is %s and can lose %d voter(s)\n\n", state, resp.FailureTolerance)
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NODE\tVOTER\tHEALTH\tSINCE\tLAST CONTACT\tLAST INDEX\tTERM")
+			for _, srv := range resp.Servers {
+				health := "healthy"
+				switch {
+				case srv.Dead:
+					health = "dead"
+				case !srv.Healthy:
+					health = "unhealthy"
+				}
+				if srv.Leader {
+					health += " (leader)"
+				}
+				since := time.Since(time.UnixMilli(srv.StableSinceMs)).Round(time.Second).String()
+				contact := "-"
+				if srv.LastContactMs >= 0 {
+					contact = (time.Duration(srv.LastContactMs) * time.Millisecond).String() + " ago"
+				}
+				fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\t%d\t%d\n", srv.NodeId, srv.Voter, health, since, contact, srv.LastIndex, srv.Term)
+			}
+			w.Flush()
+
+			for _, srv := range resp.Servers {
+				if srv.Error != "" {
+					fmt.Printf("\n%s: %s\n", srv.NodeId, srv.Error)
+				}
+			}
+		},
+	}
+
+	raftQuarantinedCmd = &cobra.Command{
+		Use:   "quarantined",
+		Short: "List the log entries the cluster skipped",
+		Long: `Lists the Raft log entries that held no valid command, which every member
+skipped instead of applying. They are replicated, so any member can list them.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := raftClient.ListQuarantined(cmd.Context(), &apiv1.ListQuarantinedRequest{})
+			if err != nil {
+				fmt.Printf("Failed to list quarantined entries: %v\n", err)
+				os.Exit(1)
+			}
+			if len(resp.Entries) == 0 {
+				fmt.Println("No log entries are quarantined")
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "INDEX\tTERM\tSIZE\tREASON")
+			for _, e := range resp.Entries {
+				fmt.Fprintf(w, "%d\t%d\t%d\t%s\n", e.Index, e.Term, len(e.Data), e.Reason)
+			}
+			w.Flush()
+		},
+	}
+
+	raftRemovePeerCmd = &cobra.Command{
+		Use:   "remove-peer <node-id>",
+		Short: "Remove a node from the cluster",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := raftClient.RemovePeer(cmd.Context(), &apiv1.RemovePeerRequest{NodeId: args[0]})
+			if err != nil {
+				fmt.Printf("Failed to remove peer: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Node %s was removed from the cluster\n", args[0])
+		},
+	}
+)
+
+func init() {
+	raftJoinCmd.Flags().StringVar(&joinAPIAddr, "api-addr", "", "Address the node serves the API on, so it can take requests while leading")
+	raftCmd.AddCommand(raftJoinCmd, raftListPeersCmd, raftHealthCmd, raftQuarantinedCmd, raftRemovePeerCmd)
+	operatorCmd.AddCommand(raftCmd)
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	apiv1 "github.com/thelamedev/rune/api/v1"
@@ -11,7 +12,9 @@ import (
 )
 
 var (
-	client apiv1.RuneServiceClient
+	client     apiv1.RuneServiceClient
+	sysClient  apiv1.SysServiceClient
+	raftClient apiv1.RaftServiceClient
 
 	rootCmd = &cobra.Command{
 		Use:   "rune-cli",
@@ -25,10 +28,25 @@ var (
 			}
 
 			client = apiv1.NewRuneServiceClient(conn)
+			sysClient = apiv1.NewSysServiceClient(conn)
+			raftClient = apiv1.NewRaftServiceClient(conn)
 		},
 	}
 )
 
+// parseConsistency parses the name of a read consistency, where an empty
+// name leaves it to the server.
+func parseConsistency(name string) (apiv1.ReadConsistency, error) {
+	if name == "" {
+		return apiv1.ReadConsistency_READ_CONSISTENCY_DEFAULT, nil
+	}
+	value, ok := apiv1.ReadConsistency_value["READ_CONSISTENCY_"+strings.ToUpper(name)]
+	if !ok || value == 0 {
+		return 0, fmt.Errorf("unknown read consistency %q, expected stale, leader or linearizable", name)
+	}
+	return apiv1.ReadConsistency(value), nil
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
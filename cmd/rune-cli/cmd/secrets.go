@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	apiv1 "github.com/thelamedev/rune/api/v1"
+)
+
+var (
+	secretsDescription string
+	secretsOptions     map[string]string
+
+	secretsCmd = &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage secrets engines",
+		Long:  `Enables, disables, tunes and lists the secrets engines mounted in the Rune vault.`,
+	}
+
+	secretsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the mounted secrets engines",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := sysClient.ListMounts(cmd.Context(), &apiv1.ListMountsRequest{})
+			if err != nil {
+				fmt.Printf("Failed to list mounts: %v\n", err)
+				os.Exit(1)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PATH\tTYPE\tID\tDESCRIPTION\tOPTIONS")
+			for _, m := range resp.Mounts {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Path, m.Type, m.Id, m.Description, formatOptions(m.Options))
+			}
+			w.Flush()
+		},
+	}
+
+	secretsEnableCmd = &cobra.Command{
+		Use:   "enable [type] [path]",
+		Short: "Mount a secrets engine at a path",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := sysClient.EnableMount(cmd.Context(), &apiv1.EnableMountRequest{
+				Type:        args[0],
+				Path:        args[1],
+				Description: secretsDescription,
+				Options:     secretsOptions,
+			})
+			if err != nil {
+				fmt.Printf("Failed to enable secrets engine: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Enabled the %s secrets engine at %q\n", resp.Mount.Type, resp.Mount.Path)
+		},
+	}
+
+	secretsDisableCmd = &cobra.Command{
+		Use:   "disable [path]",
+		Short: "Unmount a secrets engine and delete all of its secrets",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := sysClient.DisableMount(cmd.Context(), &apiv1.DisableMountRequest{Path: args[0]})
+			if err != nil {
+				fmt.Printf("Failed to disable secrets engine: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Disabled the secrets engine at %q\n", args[0])
+		},
+	}
+
+	secretsTuneCmd = &cobra.Command{
+		Use:   "tune [path]",
+		Short: "Change the description or options of a mounted secrets engine",
+		Long: `Changes the settings of a mounted secrets engine. Options are merged into the
+existing ones, and an option given with an empty value is removed.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			req := &apiv1.TuneMountRequest{Path: args[0], Options: secretsOptions}
+			if cmd.Flags().Changed("description") {
+				req.Description = &secretsDescription
+			}
+			resp, err := sysClient.TuneMount(cmd.Context(), req)
+			if err != nil {
+				fmt.Printf("Failed to tune secrets engine: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Tuned the secrets engine at %q\n", resp.Mount.Path)
+		},
+	}
+)
+
+func formatOptions(options map[string]string) string {
+	pairs := make([]string, 0, len(options))
+	for k, v := range options {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{secretsEnableCmd, secretsTuneCmd} {
+		cmd.Flags().StringVar(&secretsDescription, "description", "", "Human-readable description of the mount")
+		cmd.Flags().StringToStringVar(&secretsOptions, "options", nil, "Engine options as key=value pairs")
+	}
+	secretsCmd.AddCommand(secretsListCmd, secretsEnableCmd, secretsDisableCmd, secretsTuneCmd)
+	rootCmd.AddCommand(secretsCmd)
+}
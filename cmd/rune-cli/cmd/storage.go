@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	apiv1 "github.com/thelamedev/rune/api/v1"
+)
+
+var (
+	storageDepth uint32
+	storageTop   uint32
+
+	storageCmd = &cobra.Command{
+		Use:   "storage",
+		Short: "Inspect and maintain the server's storage backend",
+	}
+
+	storageStatsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Show what the storage backend holds",
+		Long: `Shows the number of keys and value bytes per key prefix, the largest values and,
+for file-backed storage, the size of the database file and how much of it is free.
+Hit ratios are shown for the caches the server reads through.
+Keys and sizes are as stored, so values are counted encrypted.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := sysClient.StorageStats(cmd.Context(), &apiv1.StorageStatsRequest{
+				PrefixDepth: storageDepth,
+				TopValues:   storageTop,
+			})
+			if err != nil {
+				fmt.Printf("Failed to get storage stats: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Keys:        %d\n", resp.Keys)
+			fmt.Printf("Value Bytes: %d\n", resp.ValueBytes)
+			if f := resp.File; f != nil {
+				fmt.Printf("File:        %s\n", f.Path)
+				fmt.Printf("File Size:   %d\n", f.Size)
+				fmt.Printf("Page Size:   %d\n", f.PageSize)
+				fmt.Printf("Free Pages:  %d (+%d pending), %d bytes reclaimable\n", f.FreePages, f.PendingPages, f.FreeBytes)
+			}
+			if c := resp.Cache; c != nil {
+				fmt.Printf("Cache:       %d entries, %d bytes, %.1f%% hits (%d hits, %d misses)\n", c.Entries, c.Bytes, c.HitRatio*100, c.Hits, c.Misses)
+			}
+			if c := resp.PlaintextCache; c != nil {
+				fmt.Printf("Plaintext:   %d entries, %.1f%% hits (%d hits, %d misses)\n", c.Entries, c.HitRatio*100, c.Hits, c.Misses)
+			}
+
+			fmt.Println()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PREFIX\tKEYS\tBYTES")
+			for _, p := range resp.Prefixes {
+				fmt.Fprintf(w, "%s\t%d\t%d\n", p.Prefix, p.Keys, p.ValueBytes)
+			}
+			w.Flush()
+
+			fmt.Println()
+			w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "LARGEST VALUES\tBYTES")
+			for _, v := range resp.LargestValues {
+				fmt.Fprintf(w, "%s\t%d\n", v.Key, v.Size)
+			}
+			w.Flush()
+		},
+	}
+
+	storageCompactCmd = &cobra.Command{
+		Use:   "compact",
+		Short: "Rewrite the storage file to reclaim free space",
+		Long: `Copies the database into a fresh file without its free pages and swaps it in.
+Reads continue during the copy, but writes wait until it is done.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := sysClient.CompactStorage(cmd.Context(), &apiv1.CompactStorageRequest{})
+			if err != nil {
+				fmt.Printf("Failed to compact storage: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Compacted storage from %d to %d bytes\n", resp.SizeBefore, resp.SizeAfter)
+		},
+	}
+
+	storageMigratePathsCmd = &cobra.Command{
+		Use:   "migrate-paths",
+		Short: "Move stored values to the server's path layout",
+		Long: `Moves every value stored under a plain path to its hashed path when the server
+runs with -hash-paths, or back to its plain path when it does not. Run it once
+after changing the setting. With -hash-paths, it also drops what the path index
+keeps of deleted secrets, so it is worth running now and then. It is safe to
+run while the server is in use, and to run again if it is interrupted.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := sysClient.MigrateStoragePaths(cmd.Context(), &apiv1.MigrateStoragePathsRequest{})
+			if err != nil {
+				fmt.Printf("Failed to migrate storage paths: %v\n", err)
+				os.Exit(1)
+			}
+			layout := "plain"
+			if resp.PathHashing {
+				layout = "hashed"
+			}
+			fmt.Printf("Moved %d values to %s paths\n", resp.Moved, layout)
+		},
+	}
+)
+
+func init() {
+	storageStatsCmd.Flags().Uint32Var(&storageDepth, "depth", 1, "Number of key segments to group keys by")
+	storageStatsCmd.Flags().Uint32Var(&storageTop, "top", 10, "Number of largest values to show")
+	storageCmd.AddCommand(storageStatsCmd, storageCompactCmd, storageMigratePathsCmd)
+	operatorCmd.AddCommand(storageCmd)
+}
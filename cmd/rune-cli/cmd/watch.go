@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	watchStartIndex uint64
+
+	watchCmd = &cobra.Command{
+		Use:   "watch [path]",
+		Short: "Watch the secrets below a given path for changes",
+		Long: `Streams changes to the secrets below a path in the Rune vault, one line per change.
+Values are never included; use "rune-cli get" to read a changed secret.
+
+If the connection drops, the watch resumes after the last change it printed, so no
+change is missed as long as the server still retains it.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := ""
+			if len(args) == 1 {
+				path = args[0]
+			}
+
+			if err := watch(cmd.Context(), path, watchStartIndex); err != nil {
+				fmt.Printf("Failed to watch secrets: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+// watch prints events until the context is cancelled, reconnecting from the
+// last received index when the stream breaks.
+func watch(ctx context.Context, path string, index uint64) error {
+	backoff := 100 * time.Millisecond
+	for {
+		next, err := watchOnce(ctx, path, index)
+		if next > index {
+			index = next
+			backoff = 100 * time.Millisecond
+		}
+
+		switch status.Code(err) {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Internal:
+			fmt.Fprintf(os.Stderr, "Watch interrupted (%v), resuming after index %d\n", status.Convert(err).Message(), index)
+		case codes.Canceled:
+			return nil
+		default:
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 10*time.Second)
+	}
+}
+
+// watchOnce runs a single Watch stream and returns the index of the last event
+// it printed.
+func watchOnce(ctx context.Context, path string, index uint64) (uint64, error) {
+	stream, err := client.Watch(ctx, &apiv1.WatchRequest{Path: path, StartIndex: index})
+	if err != nil {
+		return index, err
+	}
+
+	for {
+		ev, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return index, status.Error(codes.Unavailable, "server closed the stream")
+		}
+		if err != nil {
+			return index, err
+		}
+
+		fmt.Printf("%d\t%s\t%s\n", ev.Index, strings.ToLower(ev.Type.String()), ev.Path)
+		index = ev.Index
+	}
+}
+
+func init() {
+	watchCmd.Flags().Uint64Var(&watchStartIndex, "start-index", 0, "Resume after this index instead of only showing new changes")
+	rootCmd.AddCommand(watchCmd)
+}
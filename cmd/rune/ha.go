@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// haLockName is the lock that elects the active server among servers sharing
+// an HA storage backend.
+const haLockName = "active"
+
+// acquireActive blocks until this server holds the HA lock, periodically
+// logging which server is active in the meantime. The returned channel is
+// closed if the lock is lost.
+func acquireActive(ctx context.Context, lock storage.HALock) (<-chan struct{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			if holder, held, err := lock.Holder(ctx); err == nil && held {
+				log.Printf("Standing by, the active server is %s", holder)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return lock.Lock(ctx)
+}
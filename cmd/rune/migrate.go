@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/thelamedev/rune/internal/migrate"
+	"github.com/thelamedev/rune/internal/raft"
+)
+
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := addStorageFlags(flags, "from-")
+	to := addStorageFlags(flags, "to-")
+	checkpointFile := flags.String("checkpoint", "", "File recording progress, to resume an interrupted migration from")
+	dryRun := flags.Bool("dry-run", false, "Read the source and check the destination without writing to it")
+	verify := flags.Bool("verify", true, "Compare every key of the destination with the source after copying")
+	raftDataDir := flags.String("to-raft-data-dir", "", "Seed a new Raft cluster in this directory with the copied data, whose first node uses the destination as its local storage (with -to-storage bolt, the default is rune.db inside it)")
+	raftNodeID := flags.String("to-raft-node-id", "", "With -to-raft-data-dir, the -node-id of the first node (default: the hostname)")
+	raftAddr := flags.String("to-raft-addr", "127.0.0.1:7000", "With -to-raft-data-dir, the -raft-addr of the first node")
+	flags.Parse(args)
+
+	if *raftDataDir != "" && to.backend == "bolt" {
+		to.setDefault("path", filepath.Join(*raftDataDir, "rune.db"))
+	}
+	if from.backend == to.backend && maps.Equal(from.options, to.options) {
+		log.Fatal("Usage: rune operator migrate -from-storage name -from-storage-opt key=value -to-storage name -to-storage-opt key=value [-to-raft-data-dir dir]")
+	}
+
+	// Writes to the local storage of a Raft node bypass the log, so the other
+	// nodes never see them. A cluster is instead seeded with a snapshot of the
+	// copied data before its first node starts.
+	if path, ok := to.options["path"]; ok && to.backend == "bolt" && hasRaftState(filepath.Dir(path)) {
+		log.Fatalf("%s belongs to a Raft node, whose other nodes would never see the copied keys; copy them into a new -to-raft-data-dir instead", path)
+	}
+	if *raftDataDir != "" {
+		if hasRaftState(*raftDataDir) {
+			log.Fatalf("%s already holds a Raft node; seed a new cluster in an empty directory", *raftDataDir)
+		}
+		if *raftNodeID == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("Failed to determine node ID: %v", err)
+			}
+			*raftNodeID = hostname
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	src := from.mustOpen()
+	defer src.Close()
+	dst := to.mustOpen()
+	defer dst.Close()
+
+	opts := migrate.Options{DryRun: *dryRun}
+	if *checkpointFile != "" {
+		data, err := os.ReadFile(*checkpointFile)
+		switch {
+		case err == nil:
+			opts.StartAfter = strings.TrimSuffix(string(data), "\n")
+			fmt.Printf("Resuming after %q\n", opts.StartAfter)
+		case !errors.Is(err, os.ErrNotExist):
+			log.Fatalf("Failed to read checkpoint: %v", err)
+		}
+		opts.Checkpoint = func(key string) error {
+			return writeCheckpoint(*checkpointFile, key)
+		}
+	}
+
+	summary, err := migrate.Migrate(ctx, src, dst, opts)
+	if errors.Is(err, migrate.ErrDestinationNotEmpty) {
+		log.Fatalf("The %s destination already holds keys; pass the -checkpoint of an interrupted migration to resume it", to.backend)
+	}
+	if err != nil {
+		if summary != nil && summary.LastKey != "" {
+			log.Printf("Copied %d keys, up to %q", summary.Keys, summary.LastKey)
+		}
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	if *dryRun {
+		fmt.Printf("Dry run: would copy %d keys (%d bytes) from %s to %s storage\n", summary.Keys, summary.Bytes, from.backend, to.backend)
+		return
+	}
+	fmt.Printf("Copied %d keys (%d bytes) from %s to %s storage\n", summary.Keys, summary.Bytes, from.backend, to.backend)
+
+	if *verify {
+		mismatches, err := migrate.Verify(ctx, src, dst, 0)
+		if err != nil {
+			log.Fatalf("Failed to verify migration: %v", err)
+		}
+		for _, m := range mismatches {
+			fmt.Printf("%s\t%s\n", m.Kind, m.Key)
+		}
+		if len(mismatches) > 0 {
+			log.Fatalf("Verification failed: %d keys differ", len(mismatches))
+		}
+		fmt.Println("Verified: the destination matches the source")
+	}
+
+	if *raftDataDir != "" {
+		meta, err := raft.SeedSnapshot(*raftDataDir, *raftNodeID, *raftAddr, dst)
+		if err != nil {
+			log.Fatalf("Failed to seed Raft cluster: %v", err)
+		}
+		fmt.Printf("Seeded a Raft cluster in %s with snapshot %s; start its first node with -raft -data-dir %s -node-id %s -raft-addr %s\n",
+			*raftDataDir, meta.ID, *raftDataDir, *raftNodeID, *raftAddr)
+	}
+
+	if *checkpointFile != "" {
+		if err := os.Remove(*checkpointFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to remove checkpoint: %v", err)
+		}
+	}
+}
+
+// hasRaftState reports whether dir holds the log of a Raft node.
+func hasRaftState(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "raft-log.db"))
+	return err == nil
+}
+
+// writeCheckpoint replaces the checkpoint file atomically, so an interruption
+// never leaves a partial key behind.
+func writeCheckpoint(path, key string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(key+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/thelamedev/rune/internal/backup"
+)
+
+const operatorUsage = `Usage: rune operator <command> [flags]
+
+Commands:
+  backup keygen     Generate a key for encrypting and signing backups
+  backup save       Write a backup of a storage backend to a file
+  backup inspect    Show the header of a backup and verify it
+  backup restore    Replace the contents of a storage backend with a backup
+  migrate           Copy every key from one storage backend into another, or
+                    into a new Raft cluster with -to-raft-data-dir
+  raft verify       Compare the data of running servers and list divergent keys
+
+The server must be stopped before saving from or restoring into its storage,
+and before migrating between backends.
+`
+
+func runOperator(args []string) {
+	if len(args) > 0 && args[0] == "migrate" {
+		runMigrate(args[1:])
+		return
+	}
+	if len(args) > 1 && args[0] == "raft" && args[1] == "verify" {
+		runRaftVerify(args[2:])
+		return
+	}
+	if len(args) < 2 || args[0] != "backup" {
+		fmt.Fprint(os.Stderr, operatorUsage)
+		os.Exit(1)
+	}
+
+	switch args[1] {
+	case "keygen":
+		runBackupKeygen(args[2:])
+	case "save":
+		runBackupSave(args[2:])
+	case "inspect":
+		runBackupInspect(args[2:])
+	case "restore":
+		runBackupRestore(args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown backup command %q\n\n%s", args[1], operatorUsage)
+		os.Exit(1)
+	}
+}
+
+func runBackupKeygen(args []string) {
+	flags := flag.NewFlagSet("backup keygen", flag.ExitOnError)
+	out := flags.String("out", "", "File to write the key to (required)")
+	flags.Parse(args)
+
+	if *out == "" {
+		log.Fatal("-out is required")
+	}
+
+	key, err := backup.GenerateKey()
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := os.WriteFile(*out, []byte(encoded), 0o600); err != nil {
+		log.Fatalf("Failed to write key: %v", err)
+	}
+	fmt.Printf("Backup key written to %s\n", *out)
+}
+
+func runBackupSave(args []string) {
+	flags := flag.NewFlagSet("backup save", flag.ExitOnError)
+	storageConfig := addStorageFlags(flags, "")
+	out := flags.String("out", "", "File to write the backup to (required)")
+	keyFile := flags.String("key-file", "", "File holding the backup key (required)")
+	clusterID := flags.String("cluster-id", "", "ID of the cluster the backup is taken from")
+	keyringTerm := flags.Uint64("keyring-term", 1, "Term of the keyring the stored values are encrypted under")
+	flags.Parse(args)
+
+	if *out == "" || *keyFile == "" {
+		log.Fatal("-out and -key-file are required")
+	}
+	key := readBackupKey(*keyFile)
+	store := storageConfig.mustOpen()
+	defer store.Close()
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Fatalf("Failed to create backup file: %v", err)
+	}
+
+	summary, err := backup.Save(context.Background(), f, store, backup.Options{
+		Key:         key,
+		ClusterID:   *clusterID,
+		KeyringTerm: *keyringTerm,
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		log.Fatalf("Failed to save backup: %v", err)
+	}
+
+	fmt.Printf("Saved %d records to %s\n", summary.Records, *out)
+}
+
+func runBackupInspect(args []string) {
+	flags := flag.NewFlagSet("backup inspect", flag.ExitOnError)
+	keyFile := flags.String("key-file", "", "File holding the backup key, to decrypt and verify the backup")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: rune operator backup inspect [-key-file file] <backup>")
+	}
+
+	var key []byte
+	if *keyFile != "" {
+		key = readBackupKey(*keyFile)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open backup: %v", err)
+	}
+	defer f.Close()
+
+	summary, err := backup.Inspect(f, key)
+	if err != nil {
+		log.Fatalf("Failed to inspect backup: %v", err)
+	}
+
+	fmt.Printf("Format Version: %d\n", summary.Header.FormatVersion)
+	fmt.Printf("Cluster ID:     %s\n", summary.Header.ClusterID)
+	fmt.Printf("Keyring Term:   %d\n", summary.Header.KeyringTerm)
+	fmt.Printf("Created At:     %s\n", summary.Header.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("Records:        %d\n", summary.Records)
+	if summary.Verified {
+		fmt.Println("Signature:      verified")
+	} else {
+		fmt.Println("Signature:      not verified (no -key-file)")
+	}
+}
+
+func runBackupRestore(args []string) {
+	flags := flag.NewFlagSet("backup restore", flag.ExitOnError)
+	storageConfig := addStorageFlags(flags, "")
+	keyFile := flags.String("key-file", "", "File holding the backup key (required)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *keyFile == "" {
+		log.Fatal("Usage: rune operator backup restore -key-file file [-storage name -storage-opt key=value] <backup>")
+	}
+	key := readBackupKey(*keyFile)
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open backup: %v", err)
+	}
+	defer f.Close()
+
+	store := storageConfig.mustOpen()
+	defer store.Close()
+
+	summary, err := backup.Restore(context.Background(), f, store, key)
+	if err != nil {
+		log.Fatalf("Failed to restore backup: %v", err)
+	}
+	fmt.Printf("Restored %d records into %s storage\n", summary.Records, storageConfig.backend)
+}
+
+func readBackupKey(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read backup key: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != backup.KeySize {
+		log.Fatalf("Backup key in %s is not a base64-encoded %d byte key", path, backup.KeySize)
+	}
+	return key
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/logical"
+	"github.com/thelamedev/rune/internal/logical/kv"
+	"github.com/thelamedev/rune/internal/merkle"
+	"github.com/thelamedev/rune/internal/mount"
+	"github.com/thelamedev/rune/internal/raft"
+	"github.com/thelamedev/rune/internal/seal"
+	"github.com/thelamedev/rune/internal/server"
+	"github.com/thelamedev/rune/internal/storage"
+	"github.com/thelamedev/rune/internal/watch"
+)
+
+// engines are the secrets engines that can be mounted.
+var engines = map[string]logical.Factory{
+	"kv": kv.Factory,
+}
+
+func runServer(args []string) {
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	dev := flags.Bool("dev", false, "Run an ephemeral, in-memory server that is initialized and unsealed on start")
+	addr := flags.String("addr", ":8000", "Address to serve the gRPC API on")
+	storageConfig := addStorageFlags(flags, "")
+	keyFile := flags.String("unseal-keys-file", "", "File the unseal keys are kept in, and created in on the first start, or with -raft by -bootstrap (default \"unseal-keys.json\", or inside -data-dir with -raft)")
+	dbPath := flags.String("db", "", "Path to the BoltDB database file, shorthand for -storage-opt path=... (default \"rune.db\", or inside -data-dir with -raft)")
+	useRaft := flags.Bool("raft", false, "Replicate writes through Raft instead of writing to storage directly")
+	nodeID := flags.String("node-id", "", "Unique ID of this node in the Raft cluster (default: the hostname)")
+	raftAddr := flags.String("raft-addr", "127.0.0.1:7000", "Address to bind the Raft transport to")
+	dataDir := flags.String("data-dir", "data", "Directory for Raft logs, snapshots and the local database")
+	bootstrap := flags.Bool("bootstrap", false, "Bootstrap a new single-node Raft cluster")
+	cacheEntries := flags.Int("cache-entries", 10000, "Number of stored values to cache in memory (0 disables the cache)")
+	cacheBytes := flags.Int("cache-bytes", 64<<20, "Maximum total size of cached values in bytes")
+	plaintextTTL := flags.Duration("plaintext-cache-ttl", 0, "Cache decrypted secrets for this long (0 disables)")
+	ha := flags.Bool("ha", false, "Share the storage backend with other servers and only serve while elected active")
+	hashPaths := flags.Bool("hash-paths", false, "Hide the paths of secrets in storage (move existing data with rune-cli operator storage migrate-paths)")
+	readConsistency := flags.String("read-consistency", "stale", "With -raft, the consistency of reads that do not ask for one: stale, leader or linearizable")
+	forwardReads := flags.Bool("forward-reads", false, "Deprecated: same as -read-consistency leader")
+	snapshotCompression := flags.String("snapshot-compression", "none", "With -raft, how to compress Raft snapshots: none, gzip or zstd. Only compress once every node has been upgraded to read compressed snapshots")
+	useAutopilot := flags.Bool("autopilot", true, "With -raft, add joining nodes as non-voters and promote them once stable, and remove dead nodes")
+	stabilizationTime := flags.Duration("autopilot-stabilization-time", 10*time.Second, "How long a joining node must stay healthy before autopilot promotes it to voter")
+	cleanupDeadServers := flags.Bool("autopilot-cleanup-dead-servers", true, "Let autopilot remove nodes that stopped answering the leader")
+	deadServerTimeout := flags.Duration("autopilot-dead-server-timeout", 10*time.Minute, "How long a node must be unreachable before autopilot removes it")
+	clusterSecretFile := flags.String("cluster-secret-file", "", "With -raft, a file holding the secret every node of the cluster shares, which followers sign the requests they pass on to the leader with")
+	apiAddr := flags.String("api-addr", "", "Address other servers and clients use to reach this server (default: the hostname and -addr port)")
+	flags.Parse(args)
+
+	log.Println("--- Starting Rune Server ---")
+
+	if *forwardReads {
+		*readConsistency = "leader"
+	}
+	defaultConsistency, ok := apiv1.ReadConsistency_value["READ_CONSISTENCY_"+strings.ToUpper(*readConsistency)]
+	if !ok || defaultConsistency == 0 {
+		log.Fatalf("Unknown read consistency %q", *readConsistency)
+	}
+
+	compression, err := raft.ParseSnapshotCompression(*snapshotCompression)
+	if err != nil {
+		log.Fatalf("Invalid -snapshot-compression: %v", err)
+	}
+
+	var clusterSecret []byte
+	if *clusterSecretFile != "" {
+		secret, err := os.ReadFile(*clusterSecretFile)
+		if err != nil {
+			log.Fatalf("Failed to read cluster secret: %v", err)
+		}
+		clusterSecret = bytes.TrimSpace(secret)
+		if len(clusterSecret) < 32 {
+			log.Fatalf("Cluster secret in %s must be at least 32 bytes long", *clusterSecretFile)
+		}
+	} else if *useRaft {
+		log.Println("WARNING: no -cluster-secret-file, so followers cannot pass requests on to the leader")
+	}
+
+	if *dev {
+		log.Println("Running in DEV mode: all data is kept in memory and lost on shutdown")
+		storageConfig.backend = "inmem"
+	}
+	if *dbPath != "" {
+		storageConfig.setDefault("path", *dbPath)
+	}
+	if storageConfig.backend == "bolt" {
+		if *useRaft {
+			storageConfig.setDefault("path", filepath.Join(*dataDir, "rune.db"))
+		}
+		storageConfig.setDefault("path", "rune.db")
+	}
+
+	local := storageConfig.mustOpen()
+	defer func() {
+		if err := local.Close(); err != nil {
+			log.Fatalf("Failed to close storage: %v", err)
+		}
+	}()
+
+	var lost <-chan struct{}
+	if *ha {
+		haBackend, ok := local.(storage.HABackend)
+		if !ok {
+			log.Fatalf("Storage backend %q does not support HA mode", storageConfig.backend)
+		}
+		if *useRaft || *dev {
+			log.Fatal("HA mode cannot be combined with -raft or -dev")
+		}
+		if *apiAddr == "" {
+			*apiAddr = defaultAPIAddr(*addr)
+		}
+
+		lock, err := haBackend.HALock(haLockName, *apiAddr)
+		if err != nil {
+			log.Fatalf("Failed to create HA lock: %v", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		log.Printf("Waiting to become the active server as %s", *apiAddr)
+		lost, err = acquireActive(ctx, lock)
+		stop()
+		if err != nil {
+			log.Fatalf("Failed to become the active server: %v", err)
+		}
+		defer lock.Unlock()
+		log.Println("This server is now ACTIVE")
+	}
+
+	// The cache sits directly on top of the local store so that commands the
+	// FSM applies on followers invalidate it too.
+	if *cacheEntries > 0 {
+		local = storage.NewCache(local, storage.CacheOptions{
+			MaxEntries: *cacheEntries,
+			MaxBytes:   *cacheBytes,
+		})
+	}
+
+	// Every node keeps a hash tree of its storage, so that replicas can be
+	// compared with rune operator raft verify. Like the cache, it has to see
+	// the commands the FSM applies.
+	hashed := merkle.NewStore(local)
+	if err := hashed.Rebuild(context.Background()); err != nil {
+		log.Fatalf("Failed to hash storage: %v", err)
+	}
+	local = hashed
+
+	// Changes are published to the hub as they are applied to local storage,
+	// which with Raft happens on every node.
+	hub := watch.NewHub(watch.DefaultRetention)
+
+	var store storage.Storage = watch.NewStore(local, hub)
+	var appliedIndex func() uint64
+	var cluster server.Cluster
+	if *useRaft {
+		if *nodeID == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("Failed to determine node ID: %v", err)
+			}
+			*nodeID = hostname
+		}
+
+		node, err := raft.NewRaftNode(&raft.Config{
+			NodeID:    *nodeID,
+			BindAddr:  *raftAddr,
+			Bootstrap: *bootstrap,
+			DataDir:   *dataDir,
+		}, raft.NewFSM(local,
+			raft.WithWatchHub(hub),
+			raft.WithSnapshotCompression(compression),
+			raft.WithSnapshotDir(*dataDir),
+		))
+		if err != nil {
+			log.Fatalf("Failed to start raft node: %v", err)
+		}
+		defer func() {
+			if err := node.Shutdown(); err != nil {
+				log.Printf("Failed to shutdown raft node: %v", err)
+			}
+		}()
+
+		log.Printf("Raft node %q listening on %s", *nodeID, *raftAddr)
+		replicated := raft.NewStore(node, local)
+		store = replicated
+		appliedIndex = node.AppliedIndex
+
+		// The leader records where it serves the API, so that followers can
+		// pass writes and membership changes on to it.
+		if *apiAddr == "" {
+			*apiAddr = defaultAPIAddr(*addr)
+		}
+		var clusterOpts []raft.ClusterOption
+		if *useAutopilot {
+			// The leader fetches the state of the other nodes from their API.
+			peerStats := &server.PeerStats{}
+			defer peerStats.Close()
+
+			autopilot := raft.DefaultAutopilotConfig()
+			autopilot.ServerStabilizationTime = *stabilizationTime
+			autopilot.CleanupDeadServers = *cleanupDeadServers
+			autopilot.DeadServerTimeout = *deadServerTimeout
+			autopilot.Stats = peerStats.Fetch
+			clusterOpts = append(clusterOpts, raft.WithAutopilot(autopilot))
+		}
+		raftCluster := raft.NewCluster(node, replicated, *apiAddr, clusterOpts...)
+		clusterCtx, stopCluster := context.WithCancel(context.Background())
+		defer stopCluster()
+		go raftCluster.Run(clusterCtx)
+		cluster = raftCluster
+	}
+
+	keyShares, keyThreshold := 5, 3
+	sealManager := seal.New(keyShares, keyThreshold)
+
+	// The vault is unsealed with the same keys every time it starts, or
+	// nothing it stored could be decrypted. Every node of a Raft cluster
+	// decrypts the data of the others, so only the node that bootstraps it
+	// generates them, and the others are started with a copy of its file.
+	// Dev-mode servers keep nothing, so they start with new keys.
+	var shares []string
+	if *dev {
+		log.Println("Initializing and unsealing the vault")
+		shares, err = sealManager.GenerateKeys(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to generate seal keys: %v", err)
+		}
+		for i := range keyThreshold {
+			if _, _, err := sealManager.Unseal(context.Background(), shares[i]); err != nil {
+				log.Fatalf("Failed to unseal vault: %v", err)
+			}
+		}
+	} else {
+		if *keyFile == "" {
+			*keyFile = "unseal-keys.json"
+			if *useRaft {
+				*keyFile = filepath.Join(*dataDir, "unseal-keys.json")
+			}
+		}
+		log.Printf("Unsealing the vault with the keys in %s", *keyFile)
+		shares, err = sealManager.UnsealFromFile(context.Background(), *keyFile, !*useRaft || *bootstrap)
+		if errors.Is(err, seal.ErrNoKeyFile) {
+			log.Fatalf("%v: copy the unseal key file of the node that bootstrapped the cluster there", err)
+		}
+		if err != nil {
+			log.Fatalf("Failed to unseal vault: %v", err)
+		}
+	}
+
+	if !sealManager.IsUnsealed() {
+		log.Fatalf("Vault failed to unseal")
+	}
+	log.Println("Vault is UNSEALED")
+
+	if *dev {
+		printDevBanner(*addr, shares)
+	}
+
+	masterKey, err := sealManager.MasterKey()
+	if err != nil {
+		log.Fatalf("Failed to get master key from unsealed vault: %v", err)
+	}
+	// Everything above the barrier is encrypted before it reaches storage,
+	// or replication when running with Raft. With -hash-paths, so are the
+	// paths, and every node of a cluster has to be started with it.
+	var barrierOpts []barrier.Option
+	if *hashPaths {
+		barrierOpts = append(barrierOpts, barrier.WithPathHashing())
+	}
+	securityBarrier := barrier.New(store, barrierOpts...)
+	if err := securityBarrier.Unseal(masterKey); err != nil {
+		log.Fatalf("Failed to unseal the barrier: %v", err)
+	}
+
+	// Requests are routed to the secrets engine mounted at their path. Other
+	// nodes of a Raft cluster may change the mount table, so it is reloaded
+	// whenever it changes.
+	mounts := mount.NewTable(securityBarrier, hub, engines)
+	if err := mounts.Load(context.Background()); err != nil {
+		log.Fatalf("Failed to load the mount table: %v", err)
+	}
+	followCtx, stopFollowing := context.WithCancel(context.Background())
+	defer stopFollowing()
+	// Secrets stored before mounts existed are moved into the default mount
+	// once, and the data of unmounted mounts left behind is deleted, by the
+	// only node that can write.
+	if cluster == nil {
+		if err := mounts.Upgrade(context.Background()); err != nil {
+			log.Fatalf("Failed to upgrade the mount table: %v", err)
+		}
+		if err := mounts.Cleanup(context.Background()); err != nil {
+			log.Printf("Failed to delete the data of unmounted mounts: %v", err)
+		}
+	} else {
+		go upgradeMounts(followCtx, mounts, cluster)
+	}
+	go func() {
+		if err := mounts.Follow(followCtx); err != nil && followCtx.Err() == nil {
+			log.Printf("Stopped following mount table changes: %v", err)
+		}
+	}()
+
+	serverConfig := server.Config{
+		Storage:           mounts,
+		Seal:              sealManager,
+		Events:            mounts,
+		Mounts:            mounts,
+		Backend:           local,
+		Hashes:            hashed.Tree(),
+		AppliedIndex:      appliedIndex,
+		Paths:             securityBarrier,
+		Cluster:           cluster,
+		ClusterSecret:     clusterSecret,
+		ReadConsistency:   apiv1.ReadConsistency(defaultConsistency),
+		PlaintextCacheTTL: *plaintextTTL,
+	}
+
+	grpcServer, services, err := server.NewGRPCServer(&serverConfig)
+	if err != nil {
+		log.Fatalf("Failed to create gRPC server: %v", err)
+	}
+
+	log.Println("Starting gRPC server")
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *addr, err)
+	}
+
+	go func() {
+		log.Printf("gRPC server listening on %s", listener.Addr())
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Fatalf("Failed to serve gRPC server: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case <-quit:
+	case <-lost:
+		// Another server may already be active, so stop serving at once
+		// rather than risk two servers writing to the backend.
+		grpcServer.Stop()
+		log.Fatal("Lost the HA lock, stepping down")
+	}
+
+	log.Println("Shutting down gRPC server")
+	grpcServer.GracefulStop()
+	if err := services.Close(); err != nil {
+		log.Printf("Failed to close connections to the leader: %v", err)
+	}
+	log.Println("gRPC server stopped")
+}
+
+// upgradeMounts upgrades the mount table and deletes the data unmounted
+// mounts left behind once this node leads the cluster, until ctx is done.
+func upgradeMounts(ctx context.Context, mounts *mount.Table, cluster server.Cluster) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if cluster.IsLeader() {
+			err := mounts.Upgrade(ctx)
+			if err == nil {
+				err = mounts.Cleanup(ctx)
+			}
+			if err == nil {
+				return
+			}
+			log.Printf("Failed to upgrade the mount table: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// defaultAPIAddr advertises the hostname with the port the API listens on.
+func defaultAPIAddr(listenAddr string) string {
+	_, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return listenAddr
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return net.JoinHostPort(hostname, port)
+}
+
+// printDevBanner prints the credentials of a dev-mode server. Rune has no
+// token authentication yet, so the unseal keys are the only credentials there
+// are.
+func printDevBanner(addr string, unsealKeys []string) {
+	fmt.Fprintf(os.Stderr, `
+WARNING! Dev mode is enabled. The vault is already initialized and unsealed,
+all data is kept in memory, and everything is lost when the server stops.
+Do not run dev mode in production.
+
+    API Address: %s
+`, addr)
+	for i, key := range unsealKeys {
+		fmt.Fprintf(os.Stderr, "    Unseal Key %d: %s\n", i+1, key)
+	}
+	fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// storageFlags select and configure a storage backend from the registry.
+type storageFlags struct {
+	backend string
+	options map[string]string
+}
+
+func addStorageFlags(flags *flag.FlagSet, prefix string) *storageFlags {
+	sf := &storageFlags{options: make(map[string]string)}
+	flags.StringVar(&sf.backend, prefix+"storage", "bolt", fmt.Sprintf("Storage backend to use (one of %s)", strings.Join(storage.Backends(), ", ")))
+	flags.Func(prefix+"storage-opt", "Storage backend option as key=value, e.g. path=rune.db (repeatable)", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("expected key=value, got %q", s)
+		}
+		sf.options[key] = value
+		return nil
+	})
+	return sf
+}
+
+// setDefault sets a backend option unless it was given on the command line.
+func (sf *storageFlags) setDefault(key, value string) {
+	if _, ok := sf.options[key]; !ok {
+		sf.options[key] = value
+	}
+}
+
+// mustOpen opens and initializes the selected backend, exiting on failure.
+func (sf *storageFlags) mustOpen() storage.Storage {
+	store, err := storage.Open(sf.backend, sf.options)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", sf.backend, err)
+	}
+	if err := store.Initialize(context.Background()); err != nil {
+		log.Fatalf("Failed to initialize %s storage: %v", sf.backend, err)
+	}
+	return store
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/merkle"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// verifyAttempts bounds how often the roots are read again while the servers
+// catch up with each other's applied index.
+const verifyAttempts = 10
+
+// hashSource reads the hash tree of a server, remembering the applied index
+// of its last response.
+type hashSource struct {
+	addr   string
+	client apiv1.SysServiceClient
+	index  uint64
+}
+
+func (s *hashSource) Node(ctx context.Context, path string) (*merkle.Node, error) {
+	resp, err := s.client.StorageHash(ctx, &apiv1.StorageHashRequest{Path: path})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.addr, err)
+	}
+	s.index = resp.AppliedIndex
+
+	n := &merkle.Node{Path: resp.Path, Hash: resp.Hash, Children: resp.Children}
+	for _, e := range resp.Entries {
+		n.Entries = append(n.Entries, merkle.Entry{Key: e.Key, Hash: e.Hash})
+	}
+	return n, nil
+}
+
+func runRaftVerify(args []string) {
+	flags := flag.NewFlagSet("raft verify", flag.ExitOnError)
+	servers := flags.String("servers", "", "Comma-separated API addresses of the servers to compare (required)")
+	timeout := flags.Duration("timeout", time.Minute, "Time allowed for the whole check")
+	flags.Parse(args)
+
+	addrs := strings.Split(*servers, ",")
+	if *servers == "" || len(addrs) < 2 {
+		log.Fatal("Usage: rune operator raft verify -servers host:port,host:port[,...]")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	sources := make([]merkle.Source, len(addrs))
+	nodes := make([]*hashSource, len(addrs))
+	for i, addr := range addrs {
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Fatalf("Failed to connect to %s: %v", addr, err)
+		}
+		defer conn.Close()
+		nodes[i] = &hashSource{addr: addr, client: apiv1.NewSysServiceClient(conn)}
+		sources[i] = nodes[i]
+	}
+
+	// Servers that have applied different parts of the log differ anyway, so
+	// wait a little for them to converge before comparing.
+	roots := make([]*merkle.Node, len(nodes))
+	for attempt := 1; ; attempt++ {
+		for i, n := range nodes {
+			root, err := n.Node(ctx, "")
+			if err != nil {
+				log.Fatalf("Failed to read root hash: %v", err)
+			}
+			roots[i] = root
+		}
+		if sameIndex(nodes) || attempt == verifyAttempts {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	startIndex := make([]uint64, len(nodes))
+	for i, n := range nodes {
+		startIndex[i] = n.index
+		fmt.Printf("%-24s index %-10d root %s\n", n.addr, n.index, hex.EncodeToString(roots[i].Hash))
+	}
+	if !sameIndex(nodes) {
+		fmt.Println("\nWarning: the servers have applied different log indexes, so writes in flight show up as divergences")
+	}
+
+	divergences, err := merkle.Compare(ctx, sources)
+	if err != nil {
+		log.Fatalf("Failed to compare servers: %v", err)
+	}
+	if len(divergences) == 0 {
+		fmt.Printf("\nAll %d servers hold the same data\n", len(nodes))
+		return
+	}
+
+	fmt.Printf("\nDivergent keys (%d):\n", len(divergences))
+	path := ""
+	for _, d := range divergences {
+		if d.Path != path {
+			path = d.Path
+			fmt.Printf("\nKeys hashing to %s...:\n", path)
+		}
+		fmt.Printf("  %s\n", d.Key)
+		for i, h := range d.Hashes {
+			value := "missing"
+			if h != nil {
+				value = hex.EncodeToString(h)[:16]
+			}
+			fmt.Printf("    %-24s %s\n", nodes[i].addr, value)
+		}
+	}
+
+	for i, n := range nodes {
+		if n.index != startIndex[i] {
+			fmt.Println("\nWarning: writes were applied during the check; run it again to confirm the divergences")
+			break
+		}
+	}
+	os.Exit(2)
+}
+
+func sameIndex(nodes []*hashSource) bool {
+	for _, n := range nodes[1:] {
+		if n.index != nodes[0].index {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,407 @@
+// Package backup implements Rune's portable backup format.
+//
+// A backup is independent of the storage backend it was taken from, so it can
+// be restored into any storage.Storage. The stream is laid out as:
+//
+//	magic "RUNEBKUP" | uint32 header length | JSON header
+//	frames: uint32 length | AES-256-GCM sealed record, terminated by a zero length
+//	trailer: uint64 record count | HMAC-SHA256
+//
+// Each record holds one key and its value as stored, so values that are
+// already encrypted by Rune stay encrypted under the vault's keys. Records
+// are additionally sealed with a key derived from the backup key, and the
+// trailing HMAC over everything before it detects truncation, reordering and
+// tampering.
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+const (
+	// FormatVersion is the version of the format written by Save.
+	FormatVersion = 1
+
+	// KeySize is the size of a backup key.
+	KeySize = 32
+
+	saltSize        = 16
+	maxHeaderSize   = 64 << 10
+	maxFrameSize    = 128 << 20
+	encryptionLabel = "rune backup encryption"
+	macLabel        = "rune backup mac"
+)
+
+var magic = []byte("RUNEBKUP")
+
+var (
+	ErrInvalidKeySize     = errors.New("invalid backup key size")
+	ErrNotBackup          = errors.New("not a rune backup")
+	ErrUnsupportedVersion = errors.New("unsupported backup format version")
+	ErrSignatureMismatch  = errors.New("backup signature does not match")
+)
+
+// Header describes a backup. It is stored in the clear so a backup can be
+// inspected without its key, but it is covered by the signature.
+type Header struct {
+	FormatVersion int       `json:"format_version"`
+	ClusterID     string    `json:"cluster_id,omitempty"`
+	KeyringTerm   uint64    `json:"keyring_term"`
+	CreatedAt     time.Time `json:"created_at"`
+	Salt          []byte    `json:"salt"`
+}
+
+// Options configures Save.
+type Options struct {
+	// Key encrypts and signs the backup. It must be KeySize bytes.
+	Key         []byte
+	ClusterID   string
+	KeyringTerm uint64
+}
+
+// Summary describes a backup that was written or read.
+type Summary struct {
+	Header  Header
+	Records uint64
+	// Verified is true when the signature was checked against a key.
+	Verified bool
+}
+
+// GenerateKey returns a new random backup key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate backup key: %w", err)
+	}
+	return key, nil
+}
+
+// Save streams every key in src to w.
+func Save(ctx context.Context, w io.Writer, src storage.Storage, opts Options) (*Summary, error) {
+	if len(opts.Key) != KeySize {
+		return nil, ErrInvalidKeySize
+	}
+
+	header := Header{
+		FormatVersion: FormatVersion,
+		ClusterID:     opts.ClusterID,
+		KeyringTerm:   opts.KeyringTerm,
+		CreatedAt:     time.Now().UTC(),
+		Salt:          make([]byte, saltSize),
+	}
+	if _, err := rand.Read(header.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	keys, err := deriveKeys(opts.Key, header.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriter(w)
+	mac := hmac.New(sha256.New, keys.mac)
+	out := io.MultiWriter(bw, mac)
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode header: %w", err)
+	}
+	if _, err := out.Write(magic); err != nil {
+		return nil, err
+	}
+	if err := writeFrame(out, headerBytes); err != nil {
+		return nil, err
+	}
+
+	var records uint64
+	err = storage.Walk(ctx, src, "", func(key string, value []byte) error {
+		sealed := keys.seal(records, encodeRecord(key, value))
+		records++
+		return writeFrame(out, sealed)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write records: %w", err)
+	}
+
+	if err := writeFrame(out, nil); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(out, binary.BigEndian, records); err != nil {
+		return nil, err
+	}
+	if _, err := bw.Write(mac.Sum(nil)); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &Summary{Header: header, Records: records, Verified: true}, nil
+}
+
+// Inspect reads a backup without restoring it. With a key, every record is
+// decrypted and the signature is verified; without one only the header is
+// trusted as far as it goes and records are merely counted.
+func Inspect(r io.Reader, key []byte) (*Summary, error) {
+	return read(r, key, nil)
+}
+
+// Restore replaces the contents of dst with the backup read from r. The
+// backup is decrypted and verified in full before dst is touched, which is
+// why r must be seekable, and is then handed to dst.Restore as a record
+// stream. The swap is atomic wherever dst.Restore is.
+func Restore(ctx context.Context, r io.ReadSeeker, dst storage.Storage, key []byte) (*Summary, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKeySize
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := read(r, key, nil); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		summary *Summary
+		err     error
+	}
+	done := make(chan result, 1)
+	pr, pw := io.Pipe()
+	go func() {
+		summary, err := writeRecords(ctx, pw, r, key)
+		pw.CloseWithError(err)
+		done <- result{summary, err}
+	}()
+
+	err = dst.Restore(pr)
+	// Unblock the writer if dst gave up before reading everything.
+	pr.Close()
+	res := <-done
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore backup: %w", err)
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	return res.summary, nil
+}
+
+// writeRecords converts the backup read from r into a storage record stream.
+func writeRecords(ctx context.Context, w io.Writer, r io.Reader, key []byte) (*Summary, error) {
+	rw, err := storage.NewRecordWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := read(r, key, func(key string, value []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return rw.Write(key, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, rw.Close()
+}
+
+// read parses a backup, calling fn for every record when key is set.
+func read(r io.Reader, key []byte, fn storage.WalkFunc) (*Summary, error) {
+	if key != nil && len(key) != KeySize {
+		return nil, ErrInvalidKeySize
+	}
+
+	br := bufio.NewReader(r)
+	gotMagic := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, gotMagic); err != nil || string(gotMagic) != string(magic) {
+		return nil, ErrNotBackup
+	}
+
+	headerBytes, err := readFrame(br, maxHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	var summary Summary
+	if err := json.Unmarshal(headerBytes, &summary.Header); err != nil {
+		return nil, fmt.Errorf("failed to decode header: %w", err)
+	}
+	if summary.Header.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, summary.Header.FormatVersion)
+	}
+
+	var keys *derivedKeys
+	var mac hash.Hash
+	if key != nil {
+		if keys, err = deriveKeys(key, summary.Header.Salt); err != nil {
+			return nil, err
+		}
+		mac = hmac.New(sha256.New, keys.mac)
+		mac.Write(magic)
+		writeFrame(mac, headerBytes)
+	}
+
+	for {
+		frame, err := readFrame(br, maxFrameSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read record %d: %w", summary.Records, err)
+		}
+		if mac != nil {
+			writeFrame(mac, frame)
+		}
+		if len(frame) == 0 {
+			break
+		}
+
+		if keys != nil {
+			record, err := keys.open(summary.Records, frame)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt record %d: %w", summary.Records, err)
+			}
+			k, v, err := decodeRecord(record)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode record %d: %w", summary.Records, err)
+			}
+			if fn != nil {
+				if err := fn(k, v); err != nil {
+					return nil, err
+				}
+			}
+		}
+		summary.Records++
+	}
+
+	var count uint64
+	if err := binary.Read(br, binary.BigEndian, &count); err != nil {
+		return nil, fmt.Errorf("failed to read trailer: %w", err)
+	}
+	if count != summary.Records {
+		return nil, fmt.Errorf("backup is corrupt: trailer counts %d records, found %d", count, summary.Records)
+	}
+	signature := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(br, signature); err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+
+	if mac != nil {
+		binary.Write(mac, binary.BigEndian, count)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, ErrSignatureMismatch
+		}
+		summary.Verified = true
+	}
+
+	return &summary, nil
+}
+
+type derivedKeys struct {
+	aead cipher.AEAD
+	mac  []byte
+}
+
+func deriveKeys(key, salt []byte) (*derivedKeys, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKeySize
+	}
+
+	encKey, err := hkdf.Key(sha256.New, key, salt, encryptionLabel, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	macKey, err := hkdf.Key(sha256.New, key, salt, macLabel, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive signing key: %w", err)
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &derivedKeys{aead: aead, mac: macKey}, nil
+}
+
+// The encryption key is unique to each backup through its random salt, so the
+// record index alone is a safe nonce.
+func (k *derivedKeys) nonce(index uint64) []byte {
+	nonce := make([]byte, k.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+func (k *derivedKeys) seal(index uint64, record []byte) []byte {
+	return k.aead.Seal(nil, k.nonce(index), record, nil)
+}
+
+func (k *derivedKeys) open(index uint64, sealed []byte) ([]byte, error) {
+	return k.aead.Open(nil, k.nonce(index), sealed, nil)
+}
+
+func encodeRecord(key string, value []byte) []byte {
+	record := binary.AppendUvarint(nil, uint64(len(key)))
+	record = append(record, key...)
+	return append(record, value...)
+}
+
+func decodeRecord(record []byte) (string, []byte, error) {
+	keyLen, n := binary.Uvarint(record)
+	if n <= 0 || uint64(len(record)-n) < keyLen {
+		return "", nil, errors.New("invalid record")
+	}
+	return string(record[n : n+int(keyLen)]), record[n+int(keyLen):], nil
+}
+
+func writeFrame(w io.Writer, frame []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(frame)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, limit uint32) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > limit {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit", n)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return frame, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
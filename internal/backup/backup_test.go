@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+func newTestSource(t *testing.T) storage.Storage {
+	t.Helper()
+
+	store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "rune.db"))
+	require.NoError(t, err)
+	require.NoError(t, store.Initialize(t.Context()))
+	t.Cleanup(func() {
+		require.NoError(t, store.Close())
+	})
+
+	for k, v := range map[string]string{
+		"secrets/db/pass": "ciphertext-1",
+		"secrets/db/user": "ciphertext-2",
+		"sys/mounts":      "ciphertext-3",
+	} {
+		require.NoError(t, store.Put(t.Context(), k, []byte(v)))
+	}
+	return store
+}
+
+func TestSaveRestore(t *testing.T) {
+	ctx := t.Context()
+	src := newTestSource(t)
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	saved, err := Save(ctx, &buf, src, Options{Key: key, ClusterID: "cluster-1", KeyringTerm: 3})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), saved.Records)
+
+	// Restore into a different backend, replacing what it held before.
+	dst := storage.NewMemStore()
+	require.NoError(t, dst.Put(ctx, "stale", []byte("gone after restore")))
+
+	restored, err := Restore(ctx, bytes.NewReader(buf.Bytes()), dst, key)
+	require.NoError(t, err)
+	require.True(t, restored.Verified)
+	require.Equal(t, uint64(3), restored.Records)
+	require.Equal(t, "cluster-1", restored.Header.ClusterID)
+	require.Equal(t, uint64(3), restored.Header.KeyringTerm)
+
+	keys, err := dst.List(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"secrets/db/pass", "secrets/db/user", "sys/mounts"}, keys)
+
+	val, err := dst.Get(ctx, "secrets/db/user")
+	require.NoError(t, err)
+	require.Equal(t, "ciphertext-2", string(val))
+}
+
+func TestRestore_SwapsAtomically(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = Save(t.Context(), &buf, newTestSource(t), Options{Key: key})
+	require.NoError(t, err)
+
+	for name, newStore := range map[string]func(t *testing.T) storage.Storage{
+		"bolt": func(t *testing.T) storage.Storage {
+			store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "restore.db"))
+			require.NoError(t, err)
+			require.NoError(t, store.Initialize(t.Context()))
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+		"memory": func(t *testing.T) storage.Storage { return storage.NewMemStore() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst := newStore(t)
+			require.NoError(t, dst.Put(t.Context(), "stale", []byte("kept until restored")))
+
+			// A restore that fails part way leaves dst as it was.
+			ctx, cancel := context.WithCancel(t.Context())
+			cancel()
+			_, err := Restore(ctx, bytes.NewReader(buf.Bytes()), dst, key)
+			require.ErrorIs(t, err, context.Canceled)
+			keys, err := dst.List(t.Context(), "")
+			require.NoError(t, err)
+			require.Equal(t, []string{"stale"}, keys)
+
+			_, err = Restore(t.Context(), bytes.NewReader(buf.Bytes()), dst, key)
+			require.NoError(t, err)
+			keys, err = dst.List(t.Context(), "")
+			require.NoError(t, err)
+			require.Equal(t, []string{"secrets/db/pass", "secrets/db/user", "sys/mounts"}, keys)
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	ctx := t.Context()
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = Save(ctx, &buf, newTestSource(t), Options{Key: key, ClusterID: "cluster-1"})
+	require.NoError(t, err)
+
+	t.Run("without key", func(t *testing.T) {
+		summary, err := Inspect(bytes.NewReader(buf.Bytes()), nil)
+		require.NoError(t, err)
+		require.False(t, summary.Verified)
+		require.Equal(t, uint64(3), summary.Records)
+		require.Equal(t, "cluster-1", summary.Header.ClusterID)
+		require.Equal(t, FormatVersion, summary.Header.FormatVersion)
+	})
+
+	t.Run("with key", func(t *testing.T) {
+		summary, err := Inspect(bytes.NewReader(buf.Bytes()), key)
+		require.NoError(t, err)
+		require.True(t, summary.Verified)
+	})
+
+	t.Run("with wrong key", func(t *testing.T) {
+		wrongKey, err := GenerateKey()
+		require.NoError(t, err)
+		_, err = Inspect(bytes.NewReader(buf.Bytes()), wrongKey)
+		require.Error(t, err)
+	})
+}
+
+func TestRestore_RejectsTamperedBackup(t *testing.T) {
+	ctx := t.Context()
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = Save(ctx, &buf, newTestSource(t), Options{Key: key, ClusterID: "cluster-1"})
+	require.NoError(t, err)
+	backup := buf.Bytes()
+
+	tampered := map[string][]byte{
+		"truncated": backup[:len(backup)-10],
+		"header":    bytes.Replace(backup, []byte("cluster-1"), []byte("cluster-2"), 1),
+		"record":    flipByte(backup, len(backup)/2),
+		"signature": flipByte(backup, len(backup)-1),
+		"magic":     flipByte(backup, 0),
+	}
+
+	for name, data := range tampered {
+		t.Run(name, func(t *testing.T) {
+			dst := storage.NewMemStore()
+			require.NoError(t, dst.Put(ctx, "existing", []byte("kept")))
+
+			_, err := Restore(ctx, bytes.NewReader(data), dst, key)
+			require.Error(t, err)
+
+			// Nothing is touched unless the whole backup verifies.
+			val, err := dst.Get(ctx, "existing")
+			require.NoError(t, err)
+			require.Equal(t, "kept", string(val))
+		})
+	}
+
+	t.Run("header reports signature mismatch", func(t *testing.T) {
+		_, err := Inspect(bytes.NewReader(tampered["header"]), key)
+		require.True(t, errors.Is(err, ErrSignatureMismatch), "expected ErrSignatureMismatch, got %v", err)
+	})
+}
+
+func flipByte(b []byte, i int) []byte {
+	c := bytes.Clone(b)
+	c[i] ^= 0xff
+	return c
+}
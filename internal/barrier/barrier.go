@@ -0,0 +1,399 @@
+// Package barrier implements the security barrier between Rune and its
+// storage backend. Everything that passes through the barrier is encrypted
+// with the keyring before it reaches storage, and nothing passes through it
+// while the vault is sealed.
+package barrier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/thelamedev/rune/internal/crypto"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+var (
+	ErrSealed = errors.New("barrier is sealed")
+	// ErrValueCheck is returned for transactions that compare a stored value,
+	// which is impossible because encryption is randomized. Existence checks
+	// are supported.
+	ErrValueCheck = errors.New("value checks are not supported through the barrier")
+)
+
+// Storage is the part of storage.Storage that subsystems use. Both the
+// Barrier and its Views implement it.
+type Storage interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+	Transaction(ctx context.Context, ops []storage.TxnOp) error
+}
+
+// Barrier wraps a storage backend and encrypts every value written through
+// it. Keys are stored as they are unless path hashing is enabled, in which
+// case they are hidden too.
+type Barrier struct {
+	backend   storage.Storage
+	hashPaths bool
+
+	mu     sync.RWMutex
+	engine *crypto.AESGCMEngine // nil while sealed
+	paths  *pathCipher          // nil while sealed
+}
+
+type Option func(*Barrier)
+
+// WithPathHashing stores every value under a keyed hash of its path, and the
+// path itself in an encrypted index, so that the paths of secrets cannot be
+// read from storage or backups. Data stored
+// without it has to be moved with MigratePaths, and back again if it is
+// turned off.
+func WithPathHashing() Option {
+	return func(b *Barrier) {
+		b.hashPaths = true
+	}
+}
+
+func New(backend storage.Storage, opts ...Option) *Barrier {
+	b := &Barrier{backend: backend}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Unseal installs the master key, after which values can be read and written.
+func (b *Barrier) Unseal(masterKey []byte) error {
+	engine, err := crypto.NewAESGCM(masterKey)
+	if err != nil {
+		return fmt.Errorf("failed to unseal barrier: %w", err)
+	}
+	paths, err := newPathCipher(masterKey)
+	if err != nil {
+		return fmt.Errorf("failed to unseal barrier: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.engine = engine
+	b.paths = paths
+	return nil
+}
+
+// Seal forgets the master key. All further access fails with ErrSealed until
+// the barrier is unsealed again.
+func (b *Barrier) Seal() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.engine = nil
+	b.paths = nil
+}
+
+// HashesPaths reports whether paths are hidden in storage.
+func (b *Barrier) HashesPaths() bool {
+	return b.hashPaths
+}
+
+func (b *Barrier) Sealed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.engine == nil
+}
+
+// keys returns the keyring, and the cipher hiding paths or nil if paths are
+// stored as they are.
+func (b *Barrier) keys() (*crypto.AESGCMEngine, *pathCipher, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.engine == nil {
+		return nil, nil, ErrSealed
+	}
+	if !b.hashPaths {
+		return b.engine, nil, nil
+	}
+	return b.engine, b.paths, nil
+}
+
+// StorageKey returns the key path is stored under in the backend. Prefixes
+// ending in "/" map to prefixes of the keys below them.
+func (b *Barrier) StorageKey(path string) (string, error) {
+	_, paths, err := b.keys()
+	if err != nil {
+		return "", err
+	}
+	return storageKey(paths, path), nil
+}
+
+// Path returns the path stored under a key of the backend, such as the key of
+// a storage event. It returns false for keys that are not paths stored
+// through the barrier, and for every key while the barrier is sealed.
+func (b *Barrier) Path(key string) (string, bool) {
+	_, paths, err := b.keys()
+	if err != nil {
+		return "", false
+	}
+	if paths == nil {
+		return key, true
+	}
+	index, ok := indexKey(key)
+	if !ok {
+		return "", false
+	}
+	// The key of a deleted path still has its entry in the index, so the
+	// events of deletes can be named as well.
+	value, err := b.backend.Get(context.Background(), index)
+	if err != nil {
+		return "", false
+	}
+	path, _, err := paths.openIndex(index, value)
+	return path, err == nil
+}
+
+func (b *Barrier) Initialize(ctx context.Context) error {
+	return b.backend.Initialize(ctx)
+}
+
+func (b *Barrier) Get(ctx context.Context, key string) ([]byte, error) {
+	keyring, paths, err := b.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := b.backend.Get(ctx, storageKey(paths, key))
+	if paths != nil && errors.Is(err, storage.ErrKeyNotFound) {
+		// Report the path rather than the key it is hidden under.
+		return nil, fmt.Errorf("%w: %s", storage.ErrKeyNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := keyring.Decrypt(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %q: %w", key, err)
+	}
+	return plaintext, nil
+}
+
+func (b *Barrier) Put(ctx context.Context, key string, value []byte) error {
+	keyring, paths, err := b.keys()
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := keyring.Encrypt(value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %q: %w", key, err)
+	}
+	if paths == nil {
+		return b.backend.Put(ctx, key, ciphertext)
+	}
+	index, err := paths.indexEntry(key, true)
+	if err != nil {
+		return err
+	}
+	return b.backend.Transaction(ctx, []storage.TxnOp{
+		{Op: storage.TxnPut, Key: paths.encode(key), Value: ciphertext},
+		index,
+	})
+}
+
+func (b *Barrier) Delete(ctx context.Context, key string) error {
+	_, paths, err := b.keys()
+	if err != nil {
+		return err
+	}
+	if paths == nil {
+		return b.backend.Delete(ctx, key)
+	}
+	index, err := paths.indexEntry(key, false)
+	if err != nil {
+		return err
+	}
+	return b.backend.Transaction(ctx, []storage.TxnOp{
+		{Op: storage.TxnDelete, Key: paths.encode(key)},
+		index,
+	})
+}
+
+func (b *Barrier) List(ctx context.Context, prefix string) ([]string, error) {
+	_, paths, err := b.keys()
+	if err != nil {
+		return nil, err
+	}
+	if paths == nil {
+		return b.backend.List(ctx, prefix)
+	}
+	res, err := listPaths(ctx, b.backend, paths, storage.ListOptions{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(res.Entries))
+	for i, e := range res.Entries {
+		keys[i] = e.Key
+	}
+	return keys, nil
+}
+
+func (b *Barrier) ListPaged(ctx context.Context, opts storage.ListOptions) (*storage.ListResult, error) {
+	_, paths, err := b.keys()
+	if err != nil {
+		return nil, err
+	}
+	if paths == nil {
+		return storage.ListPaged(ctx, b.backend, opts)
+	}
+	return listPaths(ctx, b.backend, paths, opts)
+}
+
+// Walk visits every key under prefix with its decrypted value.
+func (b *Barrier) Walk(ctx context.Context, prefix string, fn storage.WalkFunc) error {
+	keyring, paths, err := b.keys()
+	if err != nil {
+		return err
+	}
+	if paths != nil {
+		return walkPaths(ctx, b.backend, keyring, paths, prefix, fn)
+	}
+	return storage.Walk(ctx, b.backend, prefix, func(key string, ciphertext []byte) error {
+		plaintext, err := keyring.Decrypt(ciphertext)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %q: %w", key, err)
+		}
+		return fn(key, plaintext)
+	})
+}
+
+// Transaction encrypts every written value and passes the transaction on.
+// Only existence checks are possible: a TxnCheck with a value fails with
+// ErrValueCheck. With path hashing, every write also updates the index.
+func (b *Barrier) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	keyring, paths, err := b.keys()
+	if err != nil {
+		return err
+	}
+
+	sealed := make([]storage.TxnOp, 0, len(ops))
+	for _, op := range ops {
+		path := op.Key
+		switch {
+		case op.Op == storage.TxnCheck && op.Value != nil:
+			return ErrValueCheck
+		case op.Op == storage.TxnPut:
+			ciphertext, err := keyring.Encrypt(op.Value)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %q: %w", op.Key, err)
+			}
+			op.Value = ciphertext
+		}
+		op.Key = storageKey(paths, path)
+		sealed = append(sealed, op)
+
+		if paths != nil && (op.Op == storage.TxnPut || op.Op == storage.TxnDelete) {
+			index, err := paths.indexEntry(path, op.Op == storage.TxnPut)
+			if err != nil {
+				return err
+			}
+			sealed = append(sealed, index)
+		}
+	}
+	return b.backend.Transaction(ctx, sealed)
+}
+
+// Snapshot streams the backend's snapshot. Values stay encrypted, so
+// snapshots can be taken and restored while the barrier is sealed.
+func (b *Barrier) Snapshot(w io.Writer) error {
+	return b.backend.Snapshot(w)
+}
+
+func (b *Barrier) Restore(r io.Reader) error {
+	return b.backend.Restore(r)
+}
+
+func (b *Barrier) Close() error {
+	b.Seal()
+	return b.backend.Close()
+}
+
+// View returns a view of the keys under prefix, which should end in "/".
+func (b *Barrier) View(prefix string) *View {
+	return &View{barrier: b, prefix: prefix}
+}
+
+// View confines a subsystem to the keys under a prefix of the barrier. Keys
+// passed to and returned from a View are relative to its prefix, so a
+// subsystem cannot name keys outside of it.
+type View struct {
+	barrier *Barrier
+	prefix  string
+}
+
+// Prefix returns the absolute prefix of the view.
+func (v *View) Prefix() string {
+	return v.prefix
+}
+
+// SubView returns a view of the keys under prefix within this view.
+func (v *View) SubView(prefix string) *View {
+	return &View{barrier: v.barrier, prefix: v.prefix + prefix}
+}
+
+func (v *View) Get(ctx context.Context, key string) ([]byte, error) {
+	return v.barrier.Get(ctx, v.prefix+key)
+}
+
+func (v *View) Put(ctx context.Context, key string, value []byte) error {
+	return v.barrier.Put(ctx, v.prefix+key, value)
+}
+
+func (v *View) Delete(ctx context.Context, key string) error {
+	return v.barrier.Delete(ctx, v.prefix+key)
+}
+
+func (v *View) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := v.barrier.List(ctx, v.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, v.prefix)
+	}
+	return keys, nil
+}
+
+func (v *View) ListPaged(ctx context.Context, opts storage.ListOptions) (*storage.ListResult, error) {
+	opts.Prefix = v.prefix + opts.Prefix
+	if opts.StartAfter != "" {
+		opts.StartAfter = v.prefix + opts.StartAfter
+	}
+
+	res, err := v.barrier.ListPaged(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res.Entries {
+		res.Entries[i].Key = strings.TrimPrefix(res.Entries[i].Key, v.prefix)
+	}
+	res.Cursor = strings.TrimPrefix(res.Cursor, v.prefix)
+	return res, nil
+}
+
+func (v *View) Walk(ctx context.Context, prefix string, fn storage.WalkFunc) error {
+	return v.barrier.Walk(ctx, v.prefix+prefix, func(key string, value []byte) error {
+		return fn(strings.TrimPrefix(key, v.prefix), value)
+	})
+}
+
+func (v *View) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	scoped := make([]storage.TxnOp, len(ops))
+	for i, op := range ops {
+		op.Key = v.prefix + op.Key
+		scoped[i] = op
+	}
+	return v.barrier.Transaction(ctx, scoped)
+}
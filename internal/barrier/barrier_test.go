@@ -0,0 +1,224 @@
+package barrier
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/thelamedev/rune/internal/crypto"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+func newTestBarrier(t *testing.T, opts ...Option) (*Barrier, *storage.MemStore) {
+	t.Helper()
+
+	backend := storage.NewMemStore()
+	b := New(backend, opts...)
+
+	key := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate master key: %v", err)
+	}
+	if err := b.Unseal(key); err != nil {
+		t.Fatalf("failed to unseal barrier: %v", err)
+	}
+	return b, backend
+}
+
+func TestBarrier(t *testing.T) {
+	b, backend := newTestBarrier(t)
+	ctx := t.Context()
+
+	key := "sys/policy/admin"
+	value := []byte("path \"*\" { capabilities = [\"root\"] }")
+
+	t.Run("Put and Get", func(t *testing.T) {
+		if err := b.Put(ctx, key, value); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+		got, err := b.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get value: %v", err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Fatalf("expected value %q, got %q", value, got)
+		}
+
+		raw, err := backend.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get raw value: %v", err)
+		}
+		if bytes.Contains(raw, value) {
+			t.Fatal("expected the value to be encrypted at rest")
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		err := b.Transaction(ctx, []storage.TxnOp{
+			{Op: storage.TxnCheckExists, Key: key},
+			{Op: storage.TxnCheck, Key: "sys/policy/new"},
+			{Op: storage.TxnPut, Key: "sys/policy/new", Value: []byte("new")},
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		raw, _ := backend.Get(ctx, "sys/policy/new")
+		if bytes.Equal(raw, []byte("new")) {
+			t.Fatal("expected transaction writes to be encrypted at rest")
+		}
+		got, err := b.Get(ctx, "sys/policy/new")
+		if err != nil || string(got) != "new" {
+			t.Fatalf("expected %q, got %q (%v)", "new", got, err)
+		}
+
+		err = b.Transaction(ctx, []storage.TxnOp{{Op: storage.TxnCheck, Key: key, Value: value}})
+		if !errors.Is(err, ErrValueCheck) {
+			t.Fatalf("expected ErrValueCheck, got %v", err)
+		}
+	})
+
+	t.Run("Tampered value", func(t *testing.T) {
+		raw, _ := backend.Get(ctx, key)
+		raw[len(raw)-1] ^= 0xff
+		backend.Put(ctx, "sys/tampered", raw)
+
+		if _, err := b.Get(ctx, "sys/tampered"); err == nil {
+			t.Fatal("expected tampered ciphertext to fail decryption")
+		}
+	})
+
+	t.Run("Sealed", func(t *testing.T) {
+		b.Seal()
+		if !b.Sealed() {
+			t.Fatal("expected barrier to be sealed")
+		}
+
+		if _, err := b.Get(ctx, key); !errors.Is(err, ErrSealed) {
+			t.Errorf("expected Get to fail with ErrSealed, got %v", err)
+		}
+		if err := b.Put(ctx, key, value); !errors.Is(err, ErrSealed) {
+			t.Errorf("expected Put to fail with ErrSealed, got %v", err)
+		}
+		if err := b.Delete(ctx, key); !errors.Is(err, ErrSealed) {
+			t.Errorf("expected Delete to fail with ErrSealed, got %v", err)
+		}
+		if _, err := b.List(ctx, ""); !errors.Is(err, ErrSealed) {
+			t.Errorf("expected List to fail with ErrSealed, got %v", err)
+		}
+		if err := b.Transaction(ctx, nil); !errors.Is(err, ErrSealed) {
+			t.Errorf("expected Transaction to fail with ErrSealed, got %v", err)
+		}
+	})
+}
+
+func TestView(t *testing.T) {
+	t.Run("Plain paths", func(t *testing.T) {
+		b, _ := newTestBarrier(t)
+		testView(t, b)
+	})
+	t.Run("Hashed paths", func(t *testing.T) {
+		b, _ := newTestBarrier(t, WithPathHashing())
+		testView(t, b)
+	})
+}
+
+func testView(t *testing.T, b *Barrier) {
+	ctx := t.Context()
+
+	sys := b.View("sys/")
+	mount := b.View("logical/6c1e2f0a/")
+
+	if err := sys.Put(ctx, "seal-config", []byte("5/3")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+	for _, k := range []string{"db/pass", "db/user", "api/key"} {
+		if err := mount.Put(ctx, k, []byte(k)); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+	}
+
+	t.Run("Scoping", func(t *testing.T) {
+		if _, err := mount.Get(ctx, "seal-config"); !errors.Is(err, storage.ErrKeyNotFound) {
+			t.Fatalf("expected a mount not to see sys keys, got %v", err)
+		}
+		got, err := b.Get(ctx, "logical/6c1e2f0a/db/pass")
+		if err != nil || string(got) != "db/pass" {
+			t.Fatalf("expected view key to live under its prefix, got %q (%v)", got, err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		got, err := mount.List(ctx, "db/")
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected := []string{"db/pass", "db/user"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("ListPaged", func(t *testing.T) {
+		res, err := mount.ListPaged(ctx, storage.ListOptions{Delimiter: "/", Limit: 1})
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected := []storage.ListEntry{{Key: "api/", IsFolder: true}}
+		if !reflect.DeepEqual(res.Entries, expected) || res.Cursor != "api/" {
+			t.Fatalf("expected entries %v, got %v (cursor %q)", expected, res.Entries, res.Cursor)
+		}
+
+		res, err = mount.ListPaged(ctx, storage.ListOptions{Delimiter: "/", StartAfter: res.Cursor})
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected = []storage.ListEntry{{Key: "db/", IsFolder: true}}
+		if !reflect.DeepEqual(res.Entries, expected) {
+			t.Fatalf("expected entries %v, got %v", expected, res.Entries)
+		}
+	})
+
+	t.Run("SubView", func(t *testing.T) {
+		db := mount.SubView("db/")
+		got, err := db.Get(ctx, "user")
+		if err != nil || string(got) != "db/user" {
+			t.Fatalf("expected sub-view to read its parent's keys, got %q (%v)", got, err)
+		}
+		if db.Prefix() != "logical/6c1e2f0a/db/" {
+			t.Fatalf("unexpected sub-view prefix %q", db.Prefix())
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		err := mount.Transaction(ctx, []storage.TxnOp{
+			{Op: storage.TxnCheckExists, Key: "db/pass"},
+			{Op: storage.TxnDelete, Key: "db/pass"},
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if _, err := b.Get(ctx, "logical/6c1e2f0a/db/pass"); !errors.Is(err, storage.ErrKeyNotFound) {
+			t.Fatalf("expected the scoped key to be deleted, got %v", err)
+		}
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		var got []string
+		err := mount.Walk(ctx, "", func(key string, value []byte) error {
+			if key != string(value) {
+				t.Errorf("expected value %q at %q, got %q", key, key, value)
+			}
+			got = append(got, key)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to walk keys: %v", err)
+		}
+		expected := []string{"api/key", "db/user"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, got)
+		}
+	})
+}
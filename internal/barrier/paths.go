@@ -0,0 +1,410 @@
+package barrier
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/thelamedev/rune/internal/crypto"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+const (
+	pathMACInfo = "rune barrier path mac"
+	pathEncInfo = "rune barrier path encryption"
+
+	// hiddenDataPrefix holds the values of hidden paths, each under the MAC
+	// of its path. hiddenIndexPrefix holds the index entry of every path
+	// under the same MAC.
+	hiddenDataPrefix  = "hidden/data/"
+	hiddenIndexPrefix = "hidden/index/"
+
+	// indexPadding is the block paths are padded to in the index, so that
+	// the length of an entry only roughly gives away the length of its path.
+	indexPadding = 64
+)
+
+var errForeignKey = errors.New("key was not stored with path hashing")
+
+// pathCipher hides paths from storage. The value of a path is stored under
+// a fixed-length HMAC of the path, so storage learns neither the names, nor
+// their lengths, nor how they are nested. The path itself is kept in an
+// index entry under the same HMAC, encrypted with AES-GCM and padded.
+//
+// The index is how paths are listed, and how keys in storage events are
+// mapped back to paths. Deleting a path therefore leaves its index entry
+// behind marked as deleted, so that the event of the delete can still be
+// named; MigratePaths drops these entries.
+type pathCipher struct {
+	macKey []byte
+	aead   cipher.AEAD
+}
+
+func newPathCipher(masterKey []byte) (*pathCipher, error) {
+	macKey, err := hkdf.Key(sha256.New, masterKey, nil, pathMACInfo, sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive path MAC key: %w", err)
+	}
+	encKey, err := hkdf.Key(sha256.New, masterKey, nil, pathEncInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive path encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &pathCipher{macKey: macKey, aead: aead}, nil
+}
+
+func (c *pathCipher) mac(path string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(path))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// encode returns the key the value of path is stored under.
+func (c *pathCipher) encode(path string) string {
+	return hiddenDataPrefix + c.mac(path)
+}
+
+// indexEntry returns the write that records path in the index, as present
+// or as deleted.
+func (c *pathCipher) indexEntry(path string, present bool) (storage.TxnOp, error) {
+	mac := c.mac(path)
+
+	size := 1 + binary.MaxVarintLen64 + len(path)
+	plaintext := make([]byte, 0, size+indexPadding-size%indexPadding)
+	if present {
+		plaintext = append(plaintext, 1)
+	} else {
+		plaintext = append(plaintext, 0)
+	}
+	plaintext = binary.AppendUvarint(plaintext, uint64(len(path)))
+	plaintext = append(plaintext, path...)
+	plaintext = plaintext[:cap(plaintext)]
+
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return storage.TxnOp{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	// The MAC is authenticated along with the path, so that entries cannot
+	// be swapped between keys.
+	value := c.aead.Seal(nonce, nonce, plaintext, []byte(mac))
+	return storage.TxnOp{Op: storage.TxnPut, Key: hiddenIndexPrefix + mac, Value: value}, nil
+}
+
+// openIndex returns the path recorded in the index entry stored under key,
+// and whether it is present, or errForeignKey if the entry was not written
+// with the same keys.
+func (c *pathCipher) openIndex(key string, value []byte) (string, bool, error) {
+	mac, ok := strings.CutPrefix(key, hiddenIndexPrefix)
+	if !ok || len(value) < c.aead.NonceSize() {
+		return "", false, errForeignKey
+	}
+	nonce, ciphertext := value[:c.aead.NonceSize()], value[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(mac))
+	if err != nil || len(plaintext) == 0 {
+		return "", false, errForeignKey
+	}
+	n, read := binary.Uvarint(plaintext[1:])
+	if read <= 0 || n > uint64(len(plaintext)-1-read) {
+		return "", false, errForeignKey
+	}
+	return string(plaintext[1+read : 1+read+int(n)]), plaintext[0] == 1, nil
+}
+
+// indexKey returns the key of the index entry for the value stored under
+// key, or false if key is not a hidden path.
+func indexKey(key string) (string, bool) {
+	mac, ok := strings.CutPrefix(key, hiddenDataPrefix)
+	if !ok || strings.Contains(mac, "/") {
+		return "", false
+	}
+	return hiddenIndexPrefix + mac, true
+}
+
+// storageKey returns the key path is stored under, given the cipher hiding
+// paths or nil. With a cipher, prefixes ending in "/" map to the prefix all
+// hidden values share.
+func storageKey(paths *pathCipher, path string) string {
+	switch {
+	case paths == nil:
+		return path
+	case path == "" || strings.HasSuffix(path, "/"):
+		return hiddenDataPrefix
+	}
+	return paths.encode(path)
+}
+
+// listPaths returns a page of the hidden paths matching opts. The index is
+// not in the order of the paths, so each page reads all of it, but only the
+// entries of the page are held in memory while it is read.
+func listPaths(ctx context.Context, backend storage.Storage, paths *pathCipher, opts storage.ListOptions) (*storage.ListResult, error) {
+	var entries []storage.ListEntry
+	full := false
+	err := storage.Walk(ctx, backend, hiddenIndexPrefix, func(key string, value []byte) error {
+		path, present, err := paths.openIndex(key, value)
+		if err != nil || !present || !strings.HasPrefix(path, opts.Prefix) {
+			return nil
+		}
+
+		entry := storage.ListEntry{Key: path}
+		if opts.Delimiter != "" {
+			rest := path[len(opts.Prefix):]
+			if i := strings.Index(rest, opts.Delimiter); i >= 0 {
+				entry = storage.ListEntry{Key: opts.Prefix + rest[:i+len(opts.Delimiter)], IsFolder: true}
+			}
+		}
+		if entry.Key <= opts.StartAfter {
+			return nil
+		}
+
+		i, found := slices.BinarySearchFunc(entries, entry.Key, func(e storage.ListEntry, key string) int {
+			return strings.Compare(e.Key, key)
+		})
+		if found || (full && i == len(entries)) {
+			return nil
+		}
+		entries = slices.Insert(entries, i, entry)
+		// Keep one entry past the page, to know whether there are more.
+		if opts.Limit > 0 && len(entries) > opts.Limit+1 {
+			entries = entries[:opts.Limit+1]
+			full = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &storage.ListResult{Entries: entries}
+	if opts.Limit > 0 && len(entries) > opts.Limit {
+		res.Entries = entries[:opts.Limit]
+		res.Cursor = res.Entries[opts.Limit-1].Key
+	}
+	return res, nil
+}
+
+// walkPaths walks the hidden paths under prefix in path order.
+func walkPaths(ctx context.Context, backend storage.Storage, keyring *crypto.AESGCMEngine, paths *pathCipher, prefix string, fn storage.WalkFunc) error {
+	res, err := listPaths(ctx, backend, paths, storage.ListOptions{Prefix: prefix})
+	if err != nil {
+		return err
+	}
+	for _, e := range res.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ciphertext, err := backend.Get(ctx, paths.encode(e.Key))
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			// Deleted since it was listed.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", e.Key, err)
+		}
+		plaintext, err := keyring.Decrypt(ciphertext)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %q: %w", e.Key, err)
+		}
+		if err := fn(e.Key, plaintext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratePageSize is the number of keys MigratePaths reads at a time.
+const migratePageSize = 1000
+
+// MigratePaths moves every value stored in the other path layout to the key
+// it belongs under in the current one: from plain paths to hidden ones when
+// path hashing is enabled, and back when it is not. Values are moved as they
+// are, without being re-encrypted. Keys holding anything the barrier cannot
+// decrypt, such as HA locks, are left alone. With path hashing, the index
+// entries of deleted paths are dropped as well. It returns the number of
+// values moved.
+//
+// Each value is moved in a transaction that checks it has not changed, and
+// is dropped instead if a value has been written under the new key since.
+// MigratePaths can therefore run while the barrier is in use, and be resumed
+// by running it again.
+func (b *Barrier) MigratePaths(ctx context.Context) (int, error) {
+	b.mu.RLock()
+	keyring, paths, hashPaths := b.engine, b.paths, b.hashPaths
+	b.mu.RUnlock()
+	if keyring == nil {
+		return 0, ErrSealed
+	}
+	if hashPaths {
+		return b.hidePaths(ctx, keyring, paths)
+	}
+	return b.revealPaths(ctx, keyring, paths)
+}
+
+// hidePaths moves the values stored under plain paths to hidden ones.
+func (b *Barrier) hidePaths(ctx context.Context, keyring *crypto.AESGCMEngine, paths *pathCipher) (int, error) {
+	moved := 0
+	opts := storage.ListOptions{Limit: migratePageSize}
+	for {
+		res, err := storage.ListPaged(ctx, b.backend, opts)
+		if err != nil {
+			return moved, err
+		}
+
+		for _, e := range res.Entries {
+			if strings.HasPrefix(e.Key, hiddenIndexPrefix) {
+				if err := b.dropDeletedPath(ctx, paths, e.Key); err != nil {
+					return moved, err
+				}
+				continue
+			}
+			if strings.HasPrefix(e.Key, hiddenDataPrefix) {
+				continue
+			}
+			index, err := paths.indexEntry(e.Key, true)
+			if err != nil {
+				return moved, err
+			}
+			ok, err := b.movePath(ctx, keyring, e.Key, paths.encode(e.Key), index)
+			if err != nil {
+				return moved, err
+			}
+			if ok {
+				moved++
+			}
+		}
+
+		if res.Cursor == "" {
+			return moved, nil
+		}
+		opts.StartAfter = res.Cursor
+	}
+}
+
+// revealPaths moves the values stored under hidden paths back to plain ones,
+// and drops the index.
+func (b *Barrier) revealPaths(ctx context.Context, keyring *crypto.AESGCMEngine, paths *pathCipher) (int, error) {
+	moved := 0
+	opts := storage.ListOptions{Prefix: hiddenIndexPrefix, Limit: migratePageSize}
+	for {
+		res, err := storage.ListPaged(ctx, b.backend, opts)
+		if err != nil {
+			return moved, err
+		}
+
+		for _, e := range res.Entries {
+			value, err := b.backend.Get(ctx, e.Key)
+			if errors.Is(err, storage.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return moved, fmt.Errorf("failed to read index entry: %w", err)
+			}
+			path, _, err := paths.openIndex(e.Key, value)
+			if err != nil {
+				continue
+			}
+			drop := storage.TxnOp{Op: storage.TxnDelete, Key: e.Key}
+			ok, err := b.movePath(ctx, keyring, paths.encode(path), path, drop)
+			if err != nil {
+				return moved, err
+			}
+			if ok {
+				moved++
+				continue
+			}
+			// Nothing is left under the hidden path, so the entry is not
+			// needed any more.
+			err = b.backend.Transaction(ctx, []storage.TxnOp{
+				{Op: storage.TxnCheck, Key: paths.encode(path)},
+				{Op: storage.TxnCheck, Key: e.Key, Value: value},
+				drop,
+			})
+			if err != nil && !errors.Is(err, storage.ErrTxnCheckFailed) {
+				return moved, err
+			}
+		}
+
+		if res.Cursor == "" {
+			return moved, nil
+		}
+		opts.StartAfter = res.Cursor
+	}
+}
+
+// dropDeletedPath deletes the index entry stored under key if it records a
+// deleted path and has not been rewritten since it was read.
+func (b *Barrier) dropDeletedPath(ctx context.Context, paths *pathCipher, key string) error {
+	value, err := b.backend.Get(ctx, key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read index entry: %w", err)
+	}
+	if _, present, err := paths.openIndex(key, value); err != nil || present {
+		return nil
+	}
+	err = b.backend.Transaction(ctx, []storage.TxnOp{
+		{Op: storage.TxnCheck, Key: key, Value: value},
+		{Op: storage.TxnDelete, Key: key},
+	})
+	if errors.Is(err, storage.ErrTxnCheckFailed) {
+		return nil
+	}
+	return err
+}
+
+// movePath moves the value at oldKey to newKey, along with index, which
+// updates the index for the move. It reports whether the value was moved
+// rather than dropped or skipped.
+func (b *Barrier) movePath(ctx context.Context, keyring *crypto.AESGCMEngine, oldKey, newKey string, index storage.TxnOp) (bool, error) {
+	ciphertext, err := b.backend.Get(ctx, oldKey)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %q: %w", oldKey, err)
+	}
+	if _, err := keyring.Decrypt(ciphertext); err != nil {
+		return false, nil
+	}
+
+	err = b.backend.Transaction(ctx, []storage.TxnOp{
+		{Op: storage.TxnCheck, Key: oldKey, Value: ciphertext},
+		{Op: storage.TxnCheck, Key: newKey},
+		{Op: storage.TxnPut, Key: newKey, Value: ciphertext},
+		{Op: storage.TxnDelete, Key: oldKey},
+		index,
+	})
+	if !errors.Is(err, storage.ErrTxnCheckFailed) {
+		return err == nil, err
+	}
+
+	// Either the value changed, which leaves it for the next run, or a newer
+	// value is already stored under the new key and the old one is stale.
+	err = b.backend.Transaction(ctx, []storage.TxnOp{
+		{Op: storage.TxnCheck, Key: oldKey, Value: ciphertext},
+		{Op: storage.TxnCheckExists, Key: newKey},
+		{Op: storage.TxnDelete, Key: oldKey},
+	})
+	if errors.Is(err, storage.ErrTxnCheckFailed) {
+		return false, nil
+	}
+	return false, err
+}
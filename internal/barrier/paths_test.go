@@ -0,0 +1,231 @@
+package barrier
+
+import (
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thelamedev/rune/internal/crypto"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+func TestPathCipher(t *testing.T) {
+	b, _ := newTestBarrier(t)
+	c := b.paths
+
+	want := len(c.encode("a"))
+	for _, path := range []string{"secret/prod/payments/stripe_key", "a", "dir/", "a//b", "ünïcode/ключ"} {
+		key := c.encode(path)
+		if !strings.HasPrefix(key, hiddenDataPrefix) || len(key) != want || strings.Count(key, "/") != 2 {
+			t.Errorf("expected %q to be hidden under a fixed-length key, got %q", path, key)
+		}
+
+		index, err := c.indexEntry(path, true)
+		if err != nil {
+			t.Fatalf("failed to index %q: %v", path, err)
+		}
+		if got, ok := indexKey(key); !ok || got != index.Key {
+			t.Errorf("expected %q to be indexed under %q, got %q", key, index.Key, got)
+		}
+		got, present, err := c.openIndex(index.Key, index.Value)
+		if err != nil || got != path || !present {
+			t.Errorf("expected the index entry to hold %q, got %q %v (%v)", path, got, present, err)
+		}
+	}
+
+	// Paths of similar length are indistinguishable in the index.
+	short, _ := c.indexEntry("a", true)
+	long, _ := c.indexEntry("secret/prod/payments/key", false)
+	if len(short.Value) != len(long.Value) {
+		t.Errorf("expected padded index entries, got %d and %d bytes", len(short.Value), len(long.Value))
+	}
+	if _, present, err := c.openIndex(long.Key, long.Value); err != nil || present {
+		t.Errorf("expected a deleted entry, got %v (%v)", present, err)
+	}
+
+	if _, _, err := c.openIndex(short.Key, long.Value); !errors.Is(err, errForeignKey) {
+		t.Errorf("expected an entry moved to another key to be rejected, got %v", err)
+	}
+	other, _ := newTestBarrier(t)
+	if _, _, err := other.paths.openIndex(short.Key, short.Value); !errors.Is(err, errForeignKey) {
+		t.Errorf("expected an entry written under another master key to be rejected, got %v", err)
+	}
+	for _, key := range []string{"secret/db", hiddenDataPrefix + "a/b", hiddenIndexPrefix + "x"} {
+		if _, ok := indexKey(key); ok {
+			t.Errorf("expected %q not to be a hidden path", key)
+		}
+	}
+}
+
+func TestBarrier_PathHashing(t *testing.T) {
+	b, backend := newTestBarrier(t, WithPathHashing())
+	ctx := t.Context()
+
+	for _, path := range []string{"secret/prod/db", "secret/prod/api", "secret/staging/db", "secretive"} {
+		if err := b.Put(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("failed to put %s: %v", path, err)
+		}
+	}
+
+	keys, _ := backend.List(ctx, "")
+	for _, key := range keys {
+		if strings.Contains(key, "secret") {
+			t.Fatalf("expected paths to be hidden in storage, got %q", key)
+		}
+		if _, ok := indexKey(key); !ok {
+			continue
+		}
+		if path, ok := b.Path(key); !ok || !strings.HasPrefix(path, "secret") {
+			t.Fatalf("expected %q to map back to its path, got %q", key, path)
+		}
+	}
+
+	got, err := b.List(ctx, "secret/prod/")
+	if err != nil || !reflect.DeepEqual(got, []string{"secret/prod/api", "secret/prod/db"}) {
+		t.Fatalf("unexpected listing %q (%v)", got, err)
+	}
+	got, err = b.List(ctx, "secret")
+	if err != nil || len(got) != 4 {
+		t.Fatalf("expected a prefix within a name to match 4 paths, got %q (%v)", got, err)
+	}
+
+	res, err := b.ListPaged(ctx, storage.ListOptions{Prefix: "secret/", Delimiter: "/"})
+	expected := []storage.ListEntry{{Key: "secret/prod/", IsFolder: true}, {Key: "secret/staging/", IsFolder: true}}
+	if err != nil || !reflect.DeepEqual(res.Entries, expected) {
+		t.Fatalf("expected entries %v, got %v (%v)", expected, res, err)
+	}
+
+	var pages []string
+	opts := storage.ListOptions{Prefix: "secret", Limit: 3}
+	for {
+		res, err := b.ListPaged(ctx, opts)
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		for _, e := range res.Entries {
+			pages = append(pages, e.Key)
+		}
+		if res.Cursor == "" {
+			break
+		}
+		opts.StartAfter = res.Cursor
+	}
+	if !reflect.DeepEqual(pages, []string{"secret/prod/api", "secret/prod/db", "secret/staging/db", "secretive"}) {
+		t.Fatalf("unexpected pages %q", pages)
+	}
+
+	// A deleted path is no longer listed, but its key still maps back to it.
+	if err := b.Delete(ctx, "secretive"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	deleted, _ := b.StorageKey("secretive")
+	if path, ok := b.Path(deleted); !ok || path != "secretive" {
+		t.Fatalf("expected the key of a deleted path to map back to it, got %q", path)
+	}
+	if got, err := b.List(ctx, "secret"); err != nil || len(got) != 3 {
+		t.Fatalf("expected 3 paths after the delete, got %q (%v)", got, err)
+	}
+
+	key, err := b.StorageKey("secret/prod/db")
+	if err != nil {
+		t.Fatalf("failed to map path: %v", err)
+	}
+	if _, err := backend.Get(ctx, key); err != nil {
+		t.Fatalf("expected the value under its storage key, got %v", err)
+	}
+	if _, err := b.Get(ctx, "secret/prod/missing"); !errors.Is(err, storage.ErrKeyNotFound) || !strings.Contains(err.Error(), "secret/prod/missing") {
+		t.Fatalf("expected ErrKeyNotFound naming the path, got %v", err)
+	}
+
+	b.Seal()
+	if _, ok := b.Path(key); ok {
+		t.Fatal("expected keys not to map to paths while sealed")
+	}
+	if _, err := b.StorageKey("secret/"); !errors.Is(err, ErrSealed) {
+		t.Fatalf("expected ErrSealed, got %v", err)
+	}
+}
+
+func TestBarrier_MigratePaths(t *testing.T) {
+	ctx := t.Context()
+	backend := storage.NewMemStore()
+	key := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate master key: %v", err)
+	}
+	plain, hashed := New(backend), New(backend, WithPathHashing())
+	for _, b := range []*Barrier{plain, hashed} {
+		if err := b.Unseal(key); err != nil {
+			t.Fatalf("failed to unseal barrier: %v", err)
+		}
+	}
+
+	paths := []string{"logical/kv/db/pass", "logical/kv/api", "sys/mounts"}
+	for _, path := range paths {
+		if err := plain.Put(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("failed to put %s: %v", path, err)
+		}
+	}
+	// Written below the barrier, so it has to stay where it is.
+	if err := backend.Put(ctx, "core/lock", []byte("holder")); err != nil {
+		t.Fatalf("failed to put lock: %v", err)
+	}
+
+	// A value written since hashing was enabled wins over the old one.
+	if err := hashed.Put(ctx, "sys/mounts", []byte("newer")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+
+	moved, err := hashed.MigratePaths(ctx)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if moved != 2 {
+		t.Fatalf("expected 2 values to be moved, got %d", moved)
+	}
+	for _, path := range paths {
+		want := path
+		if path == "sys/mounts" {
+			want = "newer"
+		}
+		if got, err := hashed.Get(ctx, path); err != nil || string(got) != want {
+			t.Fatalf("expected %q at %s, got %q (%v)", want, path, got, err)
+		}
+	}
+	keys, _ := backend.List(ctx, "")
+	if len(keys) != 7 || !slices.Contains(keys, "core/lock") {
+		t.Fatalf("expected the migrated keys, their index and the lock only, got %q", keys)
+	}
+	if moved, err := hashed.MigratePaths(ctx); err != nil || moved != 0 {
+		t.Fatalf("expected a second run to move nothing, got %d (%v)", moved, err)
+	}
+
+	// The index entries of deleted paths are dropped.
+	if err := hashed.Delete(ctx, "logical/kv/api"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if moved, err := hashed.MigratePaths(ctx); err != nil || moved != 0 {
+		t.Fatalf("expected a run after a delete to move nothing, got %d (%v)", moved, err)
+	}
+	if keys, _ := backend.List(ctx, ""); len(keys) != 5 {
+		t.Fatalf("expected the deleted path to leave no keys behind, got %q", keys)
+	}
+	if err := hashed.Put(ctx, "logical/kv/api", []byte("logical/kv/api")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	// And back again.
+	if moved, err := plain.MigratePaths(ctx); err != nil || moved != 3 {
+		t.Fatalf("expected 3 values to be moved back, got %d (%v)", moved, err)
+	}
+	if keys, _ := backend.List(ctx, "hidden/"); len(keys) != 0 {
+		t.Fatalf("expected the index to be dropped, got %q", keys)
+	}
+	got, err := plain.List(ctx, "logical/")
+	if err != nil || !reflect.DeepEqual(got, []string{"logical/kv/api", "logical/kv/db/pass"}) {
+		t.Fatalf("unexpected listing after migrating back %q (%v)", got, err)
+	}
+}
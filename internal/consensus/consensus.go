@@ -1,19 +1,236 @@
+// Package consensus defines the commands replicated through the Raft log and
+// how they are encoded.
+//
+// Commands are Command protobuf messages carrying the version they were
+// encoded with. Logs written before commands were versioned hold JSON
+// instead, which Decode still reads: a JSON command always starts with '{',
+// which no encoded Command does.
 package consensus
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
 
-type CommandType int
+	"github.com/thelamedev/rune/internal/storage"
+	"google.golang.org/protobuf/proto"
+)
+
+// Version is the newest version of commands this build can decode. Version
+// 2 added BATCH commands.
+const Version = 2
 
+// Each command is stamped with the oldest version that can apply it, so that
+// nodes which have not been upgraded yet keep applying the commands they
+// know during a rolling upgrade.
 const (
-	CommandPut CommandType = iota
+	versionSingle = 1
+	versionBatch  = 2
+)
+
+// MaxKeySize is the longest key a command may write, which is as long as
+// BoltDB allows. Other backends allow shorter keys, which the node proposing
+// a command checks against its own with storage.ValidateKey.
+const MaxKeySize = 32 << 10
+
+var (
+	// ErrUnsupportedVersion is returned for commands encoded by a newer
+	// version of Rune, which this node cannot apply until it is upgraded.
+	ErrUnsupportedVersion = errors.New("unsupported command version")
+	ErrInvalidCommand     = errors.New("invalid command")
 )
 
-type PutCommand struct {
-	Key   string
-	Value string
+var (
+	txnOpTypes = map[storage.TxnOpType]TxnOp_Type{
+		storage.TxnPut:         TxnOp_PUT,
+		storage.TxnDelete:      TxnOp_DELETE,
+		storage.TxnCheck:       TxnOp_CHECK,
+		storage.TxnCheckExists: TxnOp_CHECK_EXISTS,
+	}
+	storageOpTypes = map[TxnOp_Type]storage.TxnOpType{
+		TxnOp_PUT:          storage.TxnPut,
+		TxnOp_DELETE:       storage.TxnDelete,
+		TxnOp_CHECK:        storage.TxnCheck,
+		TxnOp_CHECK_EXISTS: storage.TxnCheckExists,
+	}
+)
+
+// NewPut returns a command that writes value at key.
+func NewPut(key string, value []byte) *Command {
+	return &Command{Version: versionSingle, Type: CommandType_COMMAND_TYPE_PUT, Key: key, Value: value}
+}
+
+// NewDelete returns a command that removes key.
+func NewDelete(key string) *Command {
+	return &Command{Version: versionSingle, Type: CommandType_COMMAND_TYPE_DELETE, Key: key}
+}
+
+// NewTxn returns a command that applies ops as one transaction. Operations
+// of unknown types are encoded as invalid, failing the command when applied.
+//
+// A check without a value asserts that its key is missing, so the value of
+// each operation is encoded with its presence, keeping an empty value
+// distinct from none.
+func NewTxn(ops []storage.TxnOp) *Command {
+	cmd := &Command{Version: versionSingle, Type: CommandType_COMMAND_TYPE_TXN, Ops: make([]*TxnOp, len(ops))}
+	for i, op := range ops {
+		typ, ok := txnOpTypes[op.Op]
+		if !ok {
+			typ = -1
+		}
+		cmd.Ops[i] = &TxnOp{Type: typ, Key: op.Key, Value: op.Value}
+	}
+	return cmd
+}
+
+// NewBatch returns a command that applies cmds in order, each as its own
+// transaction, from a single log entry. cmds must not be batches.
+func NewBatch(cmds []*Command) *Command {
+	batch := &Command{Version: versionBatch, Type: CommandType_COMMAND_TYPE_BATCH, Commands: make([]*Command, len(cmds))}
+	for i, cmd := range cmds {
+		batch.Commands[i] = &Command{Type: cmd.Type, Key: cmd.Key, Value: cmd.Value, Ops: cmd.Ops}
+	}
+	return batch
+}
+
+// Batched returns the commands of a BATCH command, or the command itself.
+func (c *Command) Batched() []*Command {
+	if c.Type == CommandType_COMMAND_TYPE_BATCH {
+		return c.Commands
+	}
+	return []*Command{c}
+}
+
+// TxnOps returns the operations that apply a PUT, DELETE or TXN command as
+// one transaction.
+func (c *Command) TxnOps() []storage.TxnOp {
+	switch c.Type {
+	case CommandType_COMMAND_TYPE_PUT:
+		return []storage.TxnOp{{Op: storage.TxnPut, Key: c.Key, Value: c.Value}}
+	case CommandType_COMMAND_TYPE_DELETE:
+		return []storage.TxnOp{{Op: storage.TxnDelete, Key: c.Key}}
+	}
+
+	ops := make([]storage.TxnOp, len(c.Ops))
+	for i, op := range c.Ops {
+		ops[i] = storage.TxnOp{Op: storageOpTypes[op.Type], Key: op.Key, Value: op.Value}
+	}
+	return ops
+}
+
+// Encode returns the log entry for cmd.
+func Encode(cmd *Command) ([]byte, error) {
+	data, err := proto.Marshal(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode command: %w", err)
+	}
+	return data, nil
+}
+
+// Decode returns the command held by a log entry. Entries encoded by a newer
+// version fail with ErrUnsupportedVersion, and entries that hold no valid
+// command with ErrInvalidCommand.
+func Decode(data []byte) (*Command, error) {
+	return decode(data, Version)
 }
 
-type command struct {
-	Type    CommandType     `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+// decode is Decode for a build that supports commands up to maxVersion.
+func decode(data []byte, maxVersion uint32) (*Command, error) {
+	if len(data) > 0 && data[0] == '{' {
+		return decodeLegacy(data)
+	}
+
+	cmd := &Command{}
+	if err := proto.Unmarshal(data, cmd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+	if cmd.Version > maxVersion {
+		return nil, fmt.Errorf("%w: %d, this node supports up to %d", ErrUnsupportedVersion, cmd.Version, maxVersion)
+	}
+	if err := Validate(cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// Validate checks that cmd can be applied to any storage backend, failing
+// with ErrInvalidCommand if it cannot. Commands are validated before they
+// are proposed, so that every node rejects them alike rather than failing
+// to write them.
+func Validate(cmd *Command) error {
+	switch cmd.Type {
+	case CommandType_COMMAND_TYPE_PUT, CommandType_COMMAND_TYPE_DELETE:
+		return validateKey(cmd.Key)
+	case CommandType_COMMAND_TYPE_TXN:
+	case CommandType_COMMAND_TYPE_BATCH:
+		return validateBatch(cmd)
+	default:
+		return fmt.Errorf("%w: unknown type %v", ErrInvalidCommand, cmd.Type)
+	}
+	for _, op := range cmd.Ops {
+		if _, ok := storageOpTypes[op.Type]; !ok {
+			return fmt.Errorf("%w: unknown transaction op %v", ErrInvalidCommand, op.Type)
+		}
+		if err := validateKey(op.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateBatch(batch *Command) error {
+	if len(batch.Commands) == 0 {
+		return fmt.Errorf("%w: empty batch", ErrInvalidCommand)
+	}
+	for _, cmd := range batch.Commands {
+		if cmd.Type == CommandType_COMMAND_TYPE_BATCH {
+			return fmt.Errorf("%w: nested batch", ErrInvalidCommand)
+		}
+		if err := Validate(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("%w: empty key", ErrInvalidCommand)
+	case len(key) > MaxKeySize:
+		return fmt.Errorf("%w: key of %d bytes exceeds %d", ErrInvalidCommand, len(key), MaxKeySize)
+	}
+	return nil
+}
+
+// legacyCommand is the JSON encoding of commands before they were versioned.
+type legacyCommand struct {
+	Op    string          `json:"op,omitempty"`
+	Key   string          `json:"key,omitempty"`
+	Value []byte          `json:"value,omitempty"`
+	Ops   []storage.TxnOp `json:"ops,omitempty"`
+}
+
+func decodeLegacy(data []byte) (*Command, error) {
+	var legacy legacyCommand
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+
+	var cmd *Command
+	switch legacy.Op {
+	case "set":
+		cmd = NewPut(legacy.Key, legacy.Value)
+	case "delete":
+		cmd = NewDelete(legacy.Key)
+	case "txn":
+		cmd = NewTxn(legacy.Ops)
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidCommand, legacy.Op)
+	}
+	cmd.Version = 0
+	if err := Validate(cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
 }
@@ -0,0 +1,203 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thelamedev/rune/internal/storage"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	ops := []storage.TxnOp{
+		{Op: storage.TxnCheck, Key: "missing"},
+		{Op: storage.TxnCheck, Key: "empty", Value: []byte{}},
+		{Op: storage.TxnCheckExists, Key: "present"},
+		{Op: storage.TxnPut, Key: "a", Value: []byte("1")},
+		{Op: storage.TxnDelete, Key: "b"},
+	}
+	batch := NewBatch([]*Command{NewPut("k", []byte("v")), NewTxn(ops)})
+	for _, cmd := range []*Command{NewPut("k", []byte{0, 1, 2}), NewDelete("k"), NewTxn(ops), batch} {
+		data, err := Encode(cmd)
+		if err != nil {
+			t.Fatalf("failed to encode %v: %v", cmd, err)
+		}
+		got, err := Decode(data)
+		if err != nil {
+			t.Fatalf("failed to decode %v: %v", cmd, err)
+		}
+		if !proto.Equal(got, cmd) {
+			t.Fatalf("expected %v, got %v", cmd, got)
+		}
+	}
+
+	data, _ := Encode(NewTxn(ops))
+	got, _ := Decode(data)
+	if !reflect.DeepEqual(got.TxnOps(), ops) {
+		t.Fatalf("expected ops %v, got %v", ops, got.TxnOps())
+	}
+	if got.TxnOps()[0].Value != nil {
+		t.Fatal("expected a check for a missing key to keep its nil value")
+	}
+
+	// Values are stored as they are rather than base64-encoded.
+	value := make([]byte, 1024)
+	data, _ = Encode(NewPut("k", value))
+	if len(data) > len(value)+16 {
+		t.Fatalf("expected a compact encoding, got %d bytes for a %d byte value", len(data), len(value))
+	}
+}
+
+func TestDecode_OlderVersions(t *testing.T) {
+	// A node that has not been upgraded yet still applies every command but
+	// batches.
+	for _, cmd := range []*Command{
+		NewPut("k", []byte("v")),
+		NewDelete("k"),
+		NewTxn([]storage.TxnOp{{Op: storage.TxnCheck, Key: "k"}, {Op: storage.TxnPut, Key: "k", Value: []byte("v")}}),
+	} {
+		data, _ := Encode(cmd)
+		got, err := decode(data, 1)
+		if err != nil || !proto.Equal(got, cmd) {
+			t.Fatalf("expected a version 1 node to decode %v, got %v (%v)", cmd, got, err)
+		}
+	}
+
+	data, _ := Encode(NewBatch([]*Command{NewPut("k", []byte("v"))}))
+	if _, err := decode(data, 1); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected a version 1 node to refuse a batch, got %v", err)
+	}
+}
+
+func TestDecode_Legacy(t *testing.T) {
+	for _, tc := range []struct {
+		legacy legacyCommand
+		want   *Command
+	}{
+		{legacyCommand{Op: "set", Key: "k", Value: []byte("v")}, NewPut("k", []byte("v"))},
+		{legacyCommand{Op: "delete", Key: "k"}, NewDelete("k")},
+		{
+			legacyCommand{Op: "txn", Ops: []storage.TxnOp{{Op: storage.TxnCheck, Key: "k"}, {Op: storage.TxnPut, Key: "k", Value: []byte("v")}}},
+			NewTxn([]storage.TxnOp{{Op: storage.TxnCheck, Key: "k"}, {Op: storage.TxnPut, Key: "k", Value: []byte("v")}}),
+		},
+		{
+			// A check for an empty value is not one for a missing key.
+			legacyCommand{Op: "txn", Ops: []storage.TxnOp{{Op: storage.TxnCheck, Key: "k", Value: []byte{}}, {Op: storage.TxnDelete, Key: "k"}}},
+			NewTxn([]storage.TxnOp{{Op: storage.TxnCheck, Key: "k", Value: []byte{}}, {Op: storage.TxnDelete, Key: "k"}}),
+		},
+	} {
+		data, err := json.Marshal(tc.legacy)
+		if err != nil {
+			t.Fatalf("failed to marshal legacy command: %v", err)
+		}
+		got, err := Decode(data)
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", data, err)
+		}
+		tc.want.Version = 0
+		if !proto.Equal(got, tc.want) {
+			t.Fatalf("expected %v, got %v", tc.want, got)
+		}
+	}
+
+	if _, err := Decode([]byte(`{"op":"compact"}`)); !errors.Is(err, ErrInvalidCommand) {
+		t.Fatalf("expected ErrInvalidCommand for an unknown op, got %v", err)
+	}
+}
+
+func TestDecode_Failures(t *testing.T) {
+	future := NewPut("k", []byte("v"))
+	future.Version = Version + 1
+	data, _ := Encode(future)
+	if _, err := Decode(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	unknownType := &Command{Version: Version, Type: CommandType(42)}
+	data, _ = Encode(unknownType)
+	if _, err := Decode(data); !errors.Is(err, ErrInvalidCommand) {
+		t.Fatalf("expected ErrInvalidCommand for an unknown type, got %v", err)
+	}
+
+	data, _ = Encode(NewTxn([]storage.TxnOp{{Op: storage.TxnOpType(42), Key: "k"}}))
+	if _, err := Decode(data); !errors.Is(err, ErrInvalidCommand) {
+		t.Fatalf("expected ErrInvalidCommand for an unknown op, got %v", err)
+	}
+
+	for _, data := range [][]byte{nil, {0xff, 0xff}, []byte("{not json")} {
+		if _, err := Decode(data); !errors.Is(err, ErrInvalidCommand) {
+			t.Fatalf("expected ErrInvalidCommand for %q, got %v", data, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	long := strings.Repeat("k", MaxKeySize+1)
+	for _, cmd := range []*Command{
+		NewPut("", []byte("v")),
+		NewDelete(long),
+		NewTxn([]storage.TxnOp{{Op: storage.TxnPut, Key: "k"}, {Op: storage.TxnDelete}}),
+		NewTxn([]storage.TxnOp{{Op: storage.TxnCheckExists, Key: long}}),
+		NewBatch(nil),
+		NewBatch([]*Command{NewPut("k", nil), NewDelete("")}),
+		NewBatch([]*Command{NewBatch([]*Command{NewPut("k", nil)})}),
+	} {
+		if err := Validate(cmd); !errors.Is(err, ErrInvalidCommand) {
+			t.Fatalf("expected ErrInvalidCommand for %v, got %v", cmd, err)
+		}
+	}
+
+	if err := Validate(NewPut(strings.Repeat("k", MaxKeySize), nil)); err != nil {
+		t.Fatalf("expected a key of MaxKeySize to be valid, got %v", err)
+	}
+	if err := Validate(NewTxn(nil)); err != nil {
+		t.Fatalf("expected an empty transaction to be valid, got %v", err)
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want ResultCode
+	}{
+		{nil, ResultOK},
+		{fmt.Errorf("%w: empty key", ErrInvalidCommand), ResultInvalid},
+		{ErrUnsupportedVersion, ResultInvalid},
+		{fmt.Errorf("secrets/db: %w", storage.ErrKeyNotFound), ResultNotFound},
+		{storage.ErrTxnCheckFailed, ResultConflict},
+	} {
+		res, ok := NewResult(tc.err)
+		if !ok || res.Code != tc.want || res.Err != tc.err {
+			t.Fatalf("expected %v for %v, got %+v", tc.want, tc.err, res)
+		}
+	}
+
+	if _, ok := NewResult(errors.New("disk I/O error")); ok {
+		t.Fatal("expected a storage failure not to be a result")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	put := NewPut("k", []byte("v"))
+	batch := NewBatch([]*Command{put, NewDelete("k")})
+	if put.Version != versionSingle {
+		t.Fatal("expected NewBatch to leave the commands it batches alone")
+	}
+
+	got := batch.Batched()
+	if len(got) != 2 || got[0].Version != 0 || got[0].Key != "k" || got[1].Type != CommandType_COMMAND_TYPE_DELETE {
+		t.Fatalf("unexpected batched commands %v", got)
+	}
+	if got := put.Batched(); len(got) != 1 || got[0] != put {
+		t.Fatalf("expected a command to batch only itself, got %v", got)
+	}
+
+	want := []storage.TxnOp{{Op: storage.TxnPut, Key: "k", Value: []byte("v")}}
+	if !reflect.DeepEqual(put.TxnOps(), want) {
+		t.Fatalf("expected ops %v, got %v", want, put.TxnOps())
+	}
+}
@@ -0,0 +1,70 @@
+package consensus
+
+import (
+	"errors"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// ResultCode classifies the outcome of applying a command.
+type ResultCode int
+
+const (
+	// ResultOK means the command was applied.
+	ResultOK ResultCode = iota
+	// ResultInvalid means the log entry held no command this node can
+	// apply: it could not be decoded, was encoded by a newer version, failed
+	// validation, or wrote a key the storage backend cannot store.
+	ResultInvalid
+	// ResultNotFound means the command named a key that does not exist.
+	ResultNotFound
+	// ResultConflict means a check of a transaction failed, so none of its
+	// operations were applied.
+	ResultConflict
+)
+
+func (c ResultCode) String() string {
+	switch c {
+	case ResultOK:
+		return "ok"
+	case ResultInvalid:
+		return "invalid"
+	case ResultNotFound:
+		return "not found"
+	case ResultConflict:
+		return "conflict"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is what applying a command returns to the node that proposed it,
+// through raft.ApplyFuture.Response. Every node applies a command to the same
+// result, so failures are part of the replicated state rather than errors of
+// a node. A BATCH command returns a []*Result instead, one for each of its
+// commands, unless the whole entry was invalid.
+type Result struct {
+	Code ResultCode
+	// Err says why the command was not applied, and is nil for ResultOK. It
+	// wraps ErrInvalidCommand, ErrUnsupportedVersion, storage.ErrInvalidKey,
+	// storage.ErrKeyNotFound or storage.ErrTxnCheckFailed, matching Code.
+	Err error
+}
+
+// NewResult classifies the outcome of applying a command. It reports false
+// for errors that are not an outcome of the command, but a failure of the
+// storage it was applied to.
+func NewResult(err error) (*Result, bool) {
+	switch {
+	case err == nil:
+		return &Result{Code: ResultOK}, true
+	case errors.Is(err, ErrInvalidCommand), errors.Is(err, ErrUnsupportedVersion), errors.Is(err, storage.ErrInvalidKey):
+		return &Result{Code: ResultInvalid, Err: err}, true
+	case errors.Is(err, storage.ErrKeyNotFound):
+		return &Result{Code: ResultNotFound, Err: err}, true
+	case errors.Is(err, storage.ErrTxnCheckFailed):
+		return &Result{Code: ResultConflict, Err: err}, true
+	default:
+		return nil, false
+	}
+}
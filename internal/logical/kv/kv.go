@@ -0,0 +1,45 @@
+// Package kv implements the key/value secrets engine, which stores values
+// as they are given at the requested path.
+package kv
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/logical"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// Backend stores each secret under its path in the mount's barrier view.
+type Backend struct {
+	storage barrier.Storage
+}
+
+// Factory creates a KV engine. It takes no options.
+func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+	for name := range conf.Options {
+		return nil, fmt.Errorf("unknown kv option %q", name)
+	}
+	return &Backend{storage: conf.Storage}, nil
+}
+
+func (b *Backend) Get(ctx context.Context, path string) ([]byte, error) {
+	return b.storage.Get(ctx, path)
+}
+
+func (b *Backend) Put(ctx context.Context, path string, value []byte) error {
+	return b.storage.Put(ctx, path, value)
+}
+
+func (b *Backend) Delete(ctx context.Context, path string) error {
+	return b.storage.Delete(ctx, path)
+}
+
+func (b *Backend) ListPaged(ctx context.Context, opts storage.ListOptions) (*storage.ListResult, error) {
+	return storage.ListPaged(ctx, b.storage, opts)
+}
+
+func (b *Backend) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	return b.storage.Transaction(ctx, ops)
+}
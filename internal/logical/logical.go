@@ -0,0 +1,39 @@
+// Package logical defines the interface between Rune and its secrets engines.
+//
+// Every engine instance is mounted at a path prefix and sees request paths
+// relative to that prefix. It keeps its state in a barrier view of its own,
+// so engines cannot read or write each other's data.
+package logical
+
+import (
+	"context"
+	"errors"
+
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// ErrUnsupportedOperation is returned by engines for requests they do not
+// serve, such as writes to an engine whose paths are read-only.
+var ErrUnsupportedOperation = errors.New("operation is not supported by the secrets engine")
+
+// Backend is a secrets engine. Paths are relative to the engine's mount.
+type Backend interface {
+	Get(ctx context.Context, path string) ([]byte, error)
+	Put(ctx context.Context, path string, value []byte) error
+	Delete(ctx context.Context, path string) error
+	ListPaged(ctx context.Context, opts storage.ListOptions) (*storage.ListResult, error)
+	Transaction(ctx context.Context, ops []storage.TxnOp) error
+}
+
+// BackendConfig is what an engine is created with.
+type BackendConfig struct {
+	// Storage is the engine's private view of the barrier.
+	Storage barrier.Storage
+	// Options are the engine-specific options of the mount.
+	Options map[string]string
+}
+
+// Factory creates an engine instance for a mount. It is called again with
+// the new options whenever the mount is tuned.
+type Factory func(ctx context.Context, conf *BackendConfig) (Backend, error)
@@ -0,0 +1,139 @@
+// Package lru implements a size-bounded least-recently-used cache.
+package lru
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type Options[V any] struct {
+	// MaxEntries bounds the number of entries. Zero means no bound.
+	MaxEntries int
+	// MaxCost bounds the total cost of all entries, as reported by Cost.
+	// Zero means no bound.
+	MaxCost int
+	// Cost returns the cost of a value, typically its size in bytes. It is
+	// required when MaxCost is set.
+	Cost func(V) int
+	// TTL expires entries this long after they were added. Zero means
+	// entries never expire.
+	TTL time.Duration
+}
+
+// Cache is a thread-safe LRU cache.
+type Cache[K comparable, V any] struct {
+	mu    sync.Mutex
+	opts  Options[V]
+	ll    *list.List
+	items map[K]*list.Element
+	cost  int
+	now   func() time.Time
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	cost    int
+	expires time.Time
+}
+
+func New[K comparable, V any](opts Options[V]) *Cache[K, V] {
+	return &Cache[K, V]{
+		opts:  opts,
+		ll:    list.New(),
+		items: make(map[K]*list.Element),
+		now:   time.Now,
+	}
+}
+
+// Get returns the value for key and marks it as recently used.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.removeElement(el)
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// Add inserts or replaces the value for key, evicting the least recently used
+// entries until the cache is within its bounds. A value that is too costly to
+// ever fit is not cached.
+func (c *Cache[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	e := &entry[K, V]{key: key, value: value}
+	if c.opts.Cost != nil {
+		e.cost = c.opts.Cost(value)
+	}
+	if c.opts.MaxCost > 0 && e.cost > c.opts.MaxCost {
+		return
+	}
+	if c.opts.TTL > 0 {
+		e.expires = c.now().Add(c.opts.TTL)
+	}
+
+	c.items[key] = c.ll.PushFront(e)
+	c.cost += e.cost
+
+	for (c.opts.MaxEntries > 0 && c.ll.Len() > c.opts.MaxEntries) ||
+		(c.opts.MaxCost > 0 && c.cost > c.opts.MaxCost) {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Remove drops key from the cache.
+func (c *Cache[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Purge drops every entry.
+func (c *Cache[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[K]*list.Element)
+	c.cost = 0
+}
+
+// Len returns the number of entries, including any that have expired but
+// have not been evicted yet.
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Cost returns the total cost of all entries.
+func (c *Cache[K, V]) Cost() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cost
+}
+
+func (c *Cache[K, V]) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*entry[K, V])
+	delete(c.items, e.key)
+	c.cost -= e.cost
+}
@@ -0,0 +1,91 @@
+package lru
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string, int](Options[int]{MaxEntries: 2})
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	c.Add("c", 3) // evicts b, the least recently used
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("expected %s to be cached", k)
+		}
+	}
+}
+
+func TestCache_MaxCost(t *testing.T) {
+	c := New[string, []byte](Options[[]byte]{
+		MaxCost: 10,
+		Cost:    func(v []byte) int { return len(v) },
+	})
+
+	c.Add("a", make([]byte, 4))
+	c.Add("b", make([]byte, 4))
+	c.Add("c", make([]byte, 4)) // 12 bytes, evicts a
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected a to be evicted")
+	}
+	if c.Cost() != 8 {
+		t.Errorf("expected cost 8, got %d", c.Cost())
+	}
+
+	c.Add("huge", make([]byte, 11))
+	if _, ok := c.Get("huge"); ok {
+		t.Error("expected a value larger than MaxCost not to be cached")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected uncacheable value not to evict anything, got %d entries", c.Len())
+	}
+
+	c.Add("b", make([]byte, 1))
+	if c.Cost() != 5 {
+		t.Errorf("expected replacing a value to update the cost, got %d", c.Cost())
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	now := time.Now()
+	c := New[string, int](Options[int]{TTL: time.Second})
+	c.now = func() time.Time { return now }
+
+	c.Add("a", 1)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a to have expired")
+	}
+	if c.Len() != 0 {
+		t.Errorf("expected expired entry to be evicted, got %d entries", c.Len())
+	}
+}
+
+func TestCache_RemoveAndPurge(t *testing.T) {
+	c := New[string, int](Options[int]{})
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected a to be removed")
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("expected empty cache after purge, got %d entries", c.Len())
+	}
+}
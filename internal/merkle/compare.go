@@ -0,0 +1,115 @@
+package merkle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Source serves the nodes of a tree, such as the tree of a remote replica.
+type Source interface {
+	Node(ctx context.Context, path string) (*Node, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(ctx context.Context, path string) (*Node, error)
+
+func (f SourceFunc) Node(ctx context.Context, path string) (*Node, error) {
+	return f(ctx, path)
+}
+
+// TreeSource serves the nodes of a local tree.
+func TreeSource(t *Tree) Source {
+	return SourceFunc(func(_ context.Context, path string) (*Node, error) {
+		return t.Node(path)
+	})
+}
+
+// Divergence is a key whose value is not the same in every source.
+type Divergence struct {
+	// Path is the leaf holding the key.
+	Path string
+	Key  string
+	// Hashes holds the hash of the value in each source, in the order the
+	// sources were given, or nil where the key is missing.
+	Hashes [][]byte
+}
+
+// Compare finds the keys on which sources diverge, descending only into the
+// subtrees whose hashes differ. Divergences are sorted by leaf path, then by
+// key.
+func Compare(ctx context.Context, sources []Source) ([]Divergence, error) {
+	var divergences []Divergence
+	var walk func(path string) error
+	walk = func(path string) error {
+		nodes := make([]*Node, len(sources))
+		for i, src := range sources {
+			n, err := src.Node(ctx, path)
+			if err != nil {
+				return err
+			}
+			if len(path) < Depth && len(n.Children) != Fanout {
+				return fmt.Errorf("source %d: node %q has %d children, expected %d", i, path, len(n.Children), Fanout)
+			}
+			nodes[i] = n
+		}
+		if sameHash(nodes, func(n *Node) []byte { return n.Hash }) {
+			return nil
+		}
+
+		if len(path) == Depth {
+			divergences = append(divergences, compareLeaves(path, nodes)...)
+			return nil
+		}
+		for i := range Fanout {
+			if sameHash(nodes, func(n *Node) []byte { return n.Children[i] }) {
+				continue
+			}
+			if err := walk(ChildPath(path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return divergences, nil
+}
+
+func sameHash(nodes []*Node, hash func(*Node) []byte) bool {
+	for _, n := range nodes[1:] {
+		if !bytes.Equal(hash(n), hash(nodes[0])) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareLeaves returns the keys that are missing from, or differ between,
+// the given versions of a leaf.
+func compareLeaves(path string, leaves []*Node) []Divergence {
+	hashes := make(map[string][][]byte)
+	for i, leaf := range leaves {
+		for _, e := range leaf.Entries {
+			if hashes[e.Key] == nil {
+				hashes[e.Key] = make([][]byte, len(leaves))
+			}
+			hashes[e.Key][i] = e.Hash
+		}
+	}
+
+	var divergences []Divergence
+	for key, h := range hashes {
+		for _, other := range h[1:] {
+			if !bytes.Equal(other, h[0]) {
+				divergences = append(divergences, Divergence{Path: path, Key: key, Hashes: h})
+				break
+			}
+		}
+	}
+	sort.Slice(divergences, func(i, j int) bool { return divergences[i].Key < divergences[j].Key })
+	return divergences
+}
@@ -0,0 +1,188 @@
+package merkle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+func TestTree(t *testing.T) {
+	a, b := NewTree(), NewTree()
+	if !bytes.Equal(a.Root(), b.Root()) {
+		t.Fatal("expected empty trees to have the same root")
+	}
+
+	// The root depends on the contents, not on the order of writes.
+	for i := range 100 {
+		a.Set(fmt.Sprintf("key-%d", i), []byte("v"))
+	}
+	for i := 99; i >= 0; i-- {
+		b.Set(fmt.Sprintf("key-%d", i), []byte("old"))
+		b.Set(fmt.Sprintf("key-%d", i), []byte("v"))
+	}
+	b.Set("extra", []byte("v"))
+	b.Remove("extra")
+	if !bytes.Equal(a.Root(), b.Root()) {
+		t.Fatal("expected trees with the same contents to have the same root")
+	}
+
+	b.Set("key-7", []byte("changed"))
+	if bytes.Equal(a.Root(), b.Root()) {
+		t.Fatal("expected a changed value to change the root")
+	}
+
+	leaf, err := b.Node(fmt.Sprintf("%03x", leafIndex("key-7")))
+	if err != nil {
+		t.Fatalf("failed to get leaf: %v", err)
+	}
+	found := false
+	for _, e := range leaf.Entries {
+		found = found || e.Key == "key-7"
+	}
+	if !found || leaf.Children != nil {
+		t.Fatalf("expected the leaf to hold key-7, got %+v", leaf)
+	}
+
+	root, _ := b.Node("")
+	if len(root.Children) != Fanout {
+		t.Fatalf("expected %d children, got %d", Fanout, len(root.Children))
+	}
+
+	for _, path := range []string{"0000", "g", "A"} {
+		if _, err := b.Node(path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("expected ErrInvalidPath for %q, got %v", path, err)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := t.Context()
+	backend := storage.NewMemStore()
+	if err := backend.Put(ctx, "existing", []byte("x")); err != nil {
+		t.Fatalf("failed to put key: %v", err)
+	}
+
+	s := NewStore(backend)
+	if err := s.Rebuild(ctx); err != nil {
+		t.Fatalf("failed to rebuild tree: %v", err)
+	}
+	if err := s.Put(ctx, "a", []byte("1")); err != nil {
+		t.Fatalf("failed to put key: %v", err)
+	}
+	if err := s.Delete(ctx, "existing"); err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+	err := s.Transaction(ctx, []storage.TxnOp{
+		{Op: storage.TxnCheckExists, Key: "a"},
+		{Op: storage.TxnPut, Key: "b", Value: []byte("2")},
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	err = s.Transaction(ctx, []storage.TxnOp{
+		{Op: storage.TxnCheckExists, Key: "missing"},
+		{Op: storage.TxnPut, Key: "c", Value: []byte("3")},
+	})
+	if !errors.Is(err, storage.ErrTxnCheckFailed) {
+		t.Fatalf("expected ErrTxnCheckFailed, got %v", err)
+	}
+
+	expected := NewTree()
+	expected.Set("a", []byte("1"))
+	expected.Set("b", []byte("2"))
+	if !bytes.Equal(s.Tree().Root(), expected.Root()) {
+		t.Fatal("expected the tree to follow the writes")
+	}
+
+	var snap bytes.Buffer
+	if err := s.Snapshot(&snap); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err := s.Put(ctx, "c", []byte("3")); err != nil {
+		t.Fatalf("failed to put key: %v", err)
+	}
+	if err := s.Restore(&snap); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if !bytes.Equal(s.Tree().Root(), expected.Root()) {
+		t.Fatal("expected the tree to be rebuilt after a restore")
+	}
+}
+
+func TestStore_Batch(t *testing.T) {
+	ctx := t.Context()
+	s := NewStore(storage.NewMemStore())
+
+	results, err := s.Batch(ctx, [][]storage.TxnOp{
+		{{Op: storage.TxnPut, Key: "a", Value: []byte("1")}},
+		{{Op: storage.TxnCheckExists, Key: "missing"}, {Op: storage.TxnPut, Key: "b", Value: []byte("2")}},
+		{{Op: storage.TxnPut, Key: "c", Value: []byte("3")}, {Op: storage.TxnDelete, Key: "a"}},
+	})
+	if err != nil {
+		t.Fatalf("batch failed: %v", err)
+	}
+	if !errors.Is(results[1], storage.ErrTxnCheckFailed) {
+		t.Fatalf("expected the second transaction to fail its check, got %v", results[1])
+	}
+
+	expected := NewTree()
+	expected.Set("c", []byte("3"))
+	if !bytes.Equal(s.Tree().Root(), expected.Root()) {
+		t.Fatal("expected the tree to follow the transactions that were applied")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	trees := []*Tree{NewTree(), NewTree(), NewTree()}
+	for _, tree := range trees {
+		for i := range 1000 {
+			tree.Set(fmt.Sprintf("key-%d", i), []byte("v"))
+		}
+	}
+	trees[1].Set("key-5", []byte("stale"))
+	trees[2].Remove("key-10")
+	trees[2].Set("orphan", []byte("v"))
+
+	var fetched int
+	sources := make([]Source, len(trees))
+	for i, tree := range trees {
+		sources[i] = SourceFunc(func(_ context.Context, path string) (*Node, error) {
+			fetched++
+			return tree.Node(path)
+		})
+	}
+
+	divergences, err := Compare(t.Context(), sources)
+	if err != nil {
+		t.Fatalf("compare failed: %v", err)
+	}
+	got := make(map[string]Divergence)
+	for _, d := range divergences {
+		got[d.Key] = d
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 divergent keys, got %+v", divergences)
+	}
+	if d := got["key-5"]; bytes.Equal(d.Hashes[0], d.Hashes[1]) || !bytes.Equal(d.Hashes[0], d.Hashes[2]) {
+		t.Errorf("expected key-5 to differ on the second tree only, got %+v", d)
+	}
+	if d := got["key-10"]; d.Hashes[2] != nil || d.Hashes[0] == nil {
+		t.Errorf("expected key-10 to be missing from the third tree, got %+v", d)
+	}
+	if d := got["orphan"]; d.Hashes[0] != nil || d.Hashes[2] == nil || d.Path != fmt.Sprintf("%03x", leafIndex("orphan")) {
+		t.Errorf("expected orphan to be on the third tree only, got %+v", d)
+	}
+	// The root, up to three subtrees on each level below it, and nothing else.
+	if maxFetched := len(trees) * (1 + 3*Depth); fetched > maxFetched {
+		t.Errorf("expected at most %d nodes to be fetched, got %d", maxFetched, fetched)
+	}
+
+	divergences, err = Compare(t.Context(), []Source{TreeSource(trees[0]), TreeSource(trees[0])})
+	if err != nil || len(divergences) != 0 {
+		t.Fatalf("expected identical trees not to diverge, got %+v (%v)", divergences, err)
+	}
+}
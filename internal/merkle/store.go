@@ -0,0 +1,152 @@
+package merkle
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// Store is a Storage decorator that keeps a Tree of everything in the backend.
+// It has to see every write, so on a Raft node the FSM should apply commands
+// through it, and it must be rebuilt with Rebuild before it is first read.
+type Store struct {
+	backend storage.Storage
+	tree    *Tree
+
+	// mu serializes writes, so that the tree records concurrent writes to a
+	// key in the order the backend applied them.
+	mu sync.Mutex
+}
+
+func NewStore(backend storage.Storage) *Store {
+	return &Store{backend: backend, tree: NewTree()}
+}
+
+// Tree returns the hash tree of the backend.
+func (s *Store) Tree() *Tree {
+	return s.tree
+}
+
+// Rebuild recomputes the tree from every key in the backend.
+func (s *Store) Rebuild(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rebuild(ctx)
+}
+
+func (s *Store) rebuild(ctx context.Context) error {
+	s.tree.Reset()
+	return storage.Walk(ctx, s.backend, "", func(key string, value []byte) error {
+		s.tree.Set(key, value)
+		return nil
+	})
+}
+
+func (s *Store) Initialize(ctx context.Context) error {
+	return s.backend.Initialize(ctx)
+}
+
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.backend.Get(ctx, key)
+}
+
+func (s *Store) Put(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.backend.Put(ctx, key, value); err != nil {
+		return err
+	}
+	s.tree.Set(key, value)
+	return nil
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.backend.Delete(ctx, key); err != nil {
+		return err
+	}
+	s.tree.Remove(key)
+	return nil
+}
+
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	return s.backend.List(ctx, prefix)
+}
+
+func (s *Store) ListPaged(ctx context.Context, opts storage.ListOptions) (*storage.ListResult, error) {
+	return storage.ListPaged(ctx, s.backend, opts)
+}
+
+func (s *Store) Walk(ctx context.Context, prefix string, fn storage.WalkFunc) error {
+	return storage.Walk(ctx, s.backend, prefix, fn)
+}
+
+func (s *Store) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.backend.Transaction(ctx, ops); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		switch op.Op {
+		case storage.TxnPut:
+			s.tree.Set(op.Key, op.Value)
+		case storage.TxnDelete:
+			s.tree.Remove(op.Key)
+		}
+	}
+	return nil
+}
+
+func (s *Store) Batch(ctx context.Context, txns [][]storage.TxnOp) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results, err := storage.Batch(ctx, s.backend, txns)
+	if err != nil {
+		return nil, err
+	}
+	for i, ops := range txns {
+		if results[i] != nil {
+			continue
+		}
+		for _, op := range ops {
+			switch op.Op {
+			case storage.TxnPut:
+				s.tree.Set(op.Key, op.Value)
+			case storage.TxnDelete:
+				s.tree.Remove(op.Key)
+			}
+		}
+	}
+	return results, nil
+}
+
+func (s *Store) Snapshot(w io.Writer) error {
+	return s.backend.Snapshot(w)
+}
+
+func (s *Store) Restore(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.backend.Restore(r); err != nil {
+		return err
+	}
+	return s.rebuild(context.Background())
+}
+
+func (s *Store) Close() error {
+	return s.backend.Close()
+}
+
+// Unwrap returns the backend the Store writes through to.
+func (s *Store) Unwrap() storage.Storage {
+	return s.backend
+}
@@ -0,0 +1,226 @@
+// Package merkle keeps a hash tree over the keys and values of a store, so
+// that replicas can be compared by exchanging a single root hash, and the
+// keys on which they diverge found by descending only into subtrees whose
+// hashes differ.
+//
+// Keys are spread over the leaves by the SHA-256 of the key. A node of the
+// tree is named by a path of lowercase hex digits: the root is "", its
+// children are "0" to "f", and the leaves have Depth digits, matching the
+// first Depth hex digits of the hashes of the keys they hold.
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Fanout is the number of children of every interior node.
+	Fanout = 16
+	// Depth is the length of the paths of leaves.
+	Depth = 3
+
+	leafCount = 1 << (4 * Depth)
+	hexDigits = "0123456789abcdef"
+)
+
+var ErrInvalidPath = errors.New("invalid tree path")
+
+// Node is a node of the tree.
+type Node struct {
+	Path string
+	Hash []byte
+	// Children holds the hashes of the children of an interior node, in
+	// order of their hex digit.
+	Children [][]byte
+	// Entries holds the keys of a leaf and the hashes of their values,
+	// sorted by key.
+	Entries []Entry
+}
+
+type Entry struct {
+	Key  string
+	Hash []byte
+}
+
+// Tree is a hash tree of key/value pairs. It is safe for concurrent use.
+type Tree struct {
+	mu     sync.Mutex
+	leaves [leafCount]leaf
+}
+
+type leaf struct {
+	entries map[string][sha256.Size]byte
+	hash    [sha256.Size]byte
+	// dirty is set when hash needs to be recomputed.
+	dirty bool
+}
+
+func NewTree() *Tree {
+	t := &Tree{}
+	t.Reset()
+	return t
+}
+
+// Set records the value of key.
+func (t *Tree) Set(key string, value []byte) {
+	h := entryHash(key, value)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l := &t.leaves[leafIndex(key)]
+	if l.entries == nil {
+		l.entries = make(map[string][sha256.Size]byte)
+	}
+	l.entries[key] = h
+	l.dirty = true
+}
+
+// Remove forgets key.
+func (t *Tree) Remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l := &t.leaves[leafIndex(key)]
+	if _, ok := l.entries[key]; ok {
+		delete(l.entries, key)
+		l.dirty = true
+	}
+}
+
+// Reset empties the tree.
+func (t *Tree) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.leaves {
+		t.leaves[i] = leaf{dirty: true}
+	}
+}
+
+// Root returns the hash of the whole tree.
+func (t *Tree) Root() []byte {
+	n, _ := t.Node("")
+	return n.Hash
+}
+
+// Node returns the node at path.
+func (t *Tree) Node(path string) (*Node, error) {
+	first, count, err := leafRange(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := &Node{Path: path}
+	if len(path) == Depth {
+		l := t.leafHash(first)
+		n.Hash = l[:]
+		for key, h := range t.leaves[first].entries {
+			n.Entries = append(n.Entries, Entry{Key: key, Hash: h[:]})
+		}
+		sort.Slice(n.Entries, func(i, j int) bool { return n.Entries[i].Key < n.Entries[j].Key })
+		return n, nil
+	}
+
+	h := sha256.New()
+	step := count / Fanout
+	for i := range Fanout {
+		child := t.subtreeHash(first+i*step, step)
+		n.Children = append(n.Children, child)
+		h.Write(child)
+	}
+	n.Hash = h.Sum(nil)
+	return n, nil
+}
+
+// subtreeHash returns the hash of the subtree covering count leaves from
+// first. It is called with mu held.
+func (t *Tree) subtreeHash(first, count int) []byte {
+	if count == 1 {
+		l := t.leafHash(first)
+		return l[:]
+	}
+	h := sha256.New()
+	step := count / Fanout
+	for i := range Fanout {
+		h.Write(t.subtreeHash(first+i*step, step))
+	}
+	return h.Sum(nil)
+}
+
+// leafHash returns the hash of leaf i, which covers its entries in key
+// order. It is called with mu held.
+func (t *Tree) leafHash(i int) [sha256.Size]byte {
+	l := &t.leaves[i]
+	if !l.dirty {
+		return l.hash
+	}
+
+	keys := make([]string, 0, len(l.entries))
+	for key := range l.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		entry := l.entries[key]
+		h.Write(entry[:])
+	}
+	copy(l.hash[:], h.Sum(nil))
+	l.dirty = false
+	return l.hash
+}
+
+// entryHash binds a value to its key, so that swapping values between keys
+// changes the hash.
+func entryHash(key string, value []byte) [sha256.Size]byte {
+	h := sha256.New()
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(key)))
+	h.Write(n[:])
+	h.Write([]byte(key))
+	h.Write(value)
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// leafIndex returns the leaf holding key.
+func leafIndex(key string) int {
+	sum := sha256.Sum256([]byte(key))
+	return int(binary.BigEndian.Uint16(sum[:2])) >> (16 - 4*Depth)
+}
+
+// leafRange returns the first leaf under path and the number of leaves it
+// covers.
+func leafRange(path string) (int, int, error) {
+	if len(path) > Depth {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	first := 0
+	for _, c := range []byte(path) {
+		d := strings.IndexByte(hexDigits, c)
+		if d < 0 {
+			return 0, 0, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		first = first*Fanout + d
+	}
+	shift := 4 * (Depth - len(path))
+	return first << shift, 1 << shift, nil
+}
+
+// ChildPath returns the path of child i of the node at path.
+func ChildPath(path string, i int) string {
+	return path + hexDigits[i:i+1]
+}
@@ -0,0 +1,243 @@
+// Package migrate copies the contents of one storage backend into another.
+//
+// Values are copied exactly as stored. Everything Rune stores is encrypted by
+// the barrier before it reaches storage, so a migration moves ciphertext and
+// never needs the vault to be unsealed. Migrations are meant to run offline,
+// while no server is using either backend.
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// DefaultPageSize is the number of keys listed from a backend at a time.
+const DefaultPageSize = 1000
+
+// ErrDestinationNotEmpty is returned when starting a fresh migration into a
+// backend that already holds keys, which would mix two vaults' data.
+var ErrDestinationNotEmpty = errors.New("destination storage is not empty")
+
+// Options configures Migrate.
+type Options struct {
+	// DryRun reads the source and checks the destination without writing.
+	DryRun bool
+	// StartAfter resumes an interrupted migration after the last key it
+	// checkpointed. Keys are copied in sorted order, so every key up to and
+	// including StartAfter has already been copied. When resuming, the
+	// destination is expected to hold keys.
+	StartAfter string
+	// Checkpoint, if set, is called with the last key copied after every
+	// page of keys and at the end. Persisting it allows an interrupted
+	// migration to be resumed with StartAfter.
+	Checkpoint func(key string) error
+	// PageSize is the number of keys listed at a time. Zero means
+	// DefaultPageSize.
+	PageSize int
+}
+
+// Summary describes a migration.
+type Summary struct {
+	// Keys and Bytes count the keys and value bytes copied, or that would be
+	// copied in a dry run.
+	Keys  uint64
+	Bytes uint64
+	// LastKey is the last key copied.
+	LastKey string
+}
+
+// Migrate copies every key from src to dst, in sorted key order.
+func Migrate(ctx context.Context, src, dst storage.Storage, opts Options) (*Summary, error) {
+	if opts.StartAfter == "" {
+		res, err := storage.ListPaged(ctx, dst, storage.ListOptions{Limit: 1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list destination: %w", err)
+		}
+		if len(res.Entries) > 0 {
+			return nil, ErrDestinationNotEmpty
+		}
+	}
+
+	summary := &Summary{}
+	checkpointed := opts.StartAfter
+	checkpoint := func() error {
+		if opts.DryRun || opts.Checkpoint == nil || summary.LastKey == checkpointed {
+			return nil
+		}
+		if err := opts.Checkpoint(summary.LastKey); err != nil {
+			return fmt.Errorf("failed to checkpoint after %q: %w", summary.LastKey, err)
+		}
+		checkpointed = summary.LastKey
+		return nil
+	}
+
+	keys := newKeyIterator(src, opts.StartAfter, opts.PageSize)
+	for {
+		key, ok, err := keys.next(ctx)
+		if err != nil {
+			return summary, fmt.Errorf("failed to list source: %w", err)
+		}
+		if !ok {
+			break
+		}
+
+		value, err := src.Get(ctx, key)
+		if err != nil {
+			return summary, fmt.Errorf("failed to read %q from source: %w", key, err)
+		}
+		if !opts.DryRun {
+			if err := dst.Put(ctx, key, value); err != nil {
+				return summary, fmt.Errorf("failed to write %q to destination: %w", key, err)
+			}
+		}
+		summary.Keys++
+		summary.Bytes += uint64(len(value))
+		summary.LastKey = key
+
+		if keys.pageDone() {
+			if err := checkpoint(); err != nil {
+				return summary, err
+			}
+		}
+	}
+	return summary, checkpoint()
+}
+
+// MismatchKind describes how a key differs between source and destination.
+type MismatchKind int
+
+const (
+	// Missing keys exist in the source but not in the destination.
+	Missing MismatchKind = iota
+	// Different keys hold a different value in the destination.
+	Different
+	// Extra keys exist in the destination but not in the source.
+	Extra
+)
+
+func (k MismatchKind) String() string {
+	switch k {
+	case Missing:
+		return "missing"
+	case Different:
+		return "different"
+	case Extra:
+		return "extra"
+	}
+	return "unknown"
+}
+
+type Mismatch struct {
+	Key  string
+	Kind MismatchKind
+}
+
+// Verify compares every key and value of src and dst and returns where they
+// differ. An empty result means the destination is an exact copy.
+func Verify(ctx context.Context, src, dst storage.Storage, pageSize int) ([]Mismatch, error) {
+	srcKeys := newKeyIterator(src, "", pageSize)
+	dstKeys := newKeyIterator(dst, "", pageSize)
+
+	var mismatches []Mismatch
+	srcKey, srcOK, err := srcKeys.next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", err)
+	}
+	dstKey, dstOK, err := dstKeys.next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination: %w", err)
+	}
+
+	for srcOK || dstOK {
+		advanceSrc, advanceDst := false, false
+		switch {
+		case !dstOK || (srcOK && srcKey < dstKey):
+			mismatches = append(mismatches, Mismatch{Key: srcKey, Kind: Missing})
+			advanceSrc = true
+		case !srcOK || dstKey < srcKey:
+			mismatches = append(mismatches, Mismatch{Key: dstKey, Kind: Extra})
+			advanceDst = true
+		default:
+			same, err := sameValue(ctx, src, dst, srcKey)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				mismatches = append(mismatches, Mismatch{Key: srcKey, Kind: Different})
+			}
+			advanceSrc, advanceDst = true, true
+		}
+
+		if advanceSrc {
+			if srcKey, srcOK, err = srcKeys.next(ctx); err != nil {
+				return nil, fmt.Errorf("failed to list source: %w", err)
+			}
+		}
+		if advanceDst {
+			if dstKey, dstOK, err = dstKeys.next(ctx); err != nil {
+				return nil, fmt.Errorf("failed to list destination: %w", err)
+			}
+		}
+	}
+	return mismatches, nil
+}
+
+func sameValue(ctx context.Context, src, dst storage.Storage, key string) (bool, error) {
+	want, err := src.Get(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %q from source: %w", key, err)
+	}
+	got, err := dst.Get(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %q from destination: %w", key, err)
+	}
+	return bytes.Equal(want, got), nil
+}
+
+// keyIterator visits the keys of a backend in sorted order, a page at a time.
+type keyIterator struct {
+	store    storage.Storage
+	pageSize int
+	cursor   string
+	page     []storage.ListEntry
+	done     bool
+}
+
+func newKeyIterator(store storage.Storage, startAfter string, pageSize int) *keyIterator {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &keyIterator{store: store, pageSize: pageSize, cursor: startAfter}
+}
+
+// next returns the next key, or false once every key has been visited.
+func (it *keyIterator) next(ctx context.Context) (string, bool, error) {
+	for len(it.page) == 0 {
+		if it.done {
+			return "", false, nil
+		}
+		res, err := storage.ListPaged(ctx, it.store, storage.ListOptions{
+			StartAfter: it.cursor,
+			Limit:      it.pageSize,
+		})
+		if err != nil {
+			return "", false, err
+		}
+		it.page = res.Entries
+		it.cursor = res.Cursor
+		it.done = res.Cursor == ""
+	}
+
+	key := it.page[0].Key
+	it.page = it.page[1:]
+	return key, true, nil
+}
+
+// pageDone reports whether the key last returned by next ended a page.
+func (it *keyIterator) pageDone() bool {
+	return len(it.page) == 0
+}
@@ -0,0 +1,122 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+func newTestSource(t *testing.T, keys int) storage.Storage {
+	t.Helper()
+
+	store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "rune.db"))
+	require.NoError(t, err)
+	require.NoError(t, store.Initialize(t.Context()))
+	t.Cleanup(func() {
+		require.NoError(t, store.Close())
+	})
+
+	for i := range keys {
+		key := fmt.Sprintf("logical/kv/%03d", i)
+		require.NoError(t, store.Put(t.Context(), key, []byte("ciphertext-"+key)))
+	}
+	return store
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := t.Context()
+	src := newTestSource(t, 25)
+	dst := storage.NewMemStore()
+
+	var checkpoints []string
+	summary, err := Migrate(ctx, src, dst, Options{
+		PageSize: 10,
+		Checkpoint: func(key string) error {
+			checkpoints = append(checkpoints, key)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(25), summary.Keys)
+	require.Equal(t, "logical/kv/024", summary.LastKey)
+	require.Equal(t, []string{"logical/kv/009", "logical/kv/019", "logical/kv/024"}, checkpoints)
+
+	mismatches, err := Verify(ctx, src, dst, 7)
+	require.NoError(t, err)
+	require.Empty(t, mismatches)
+
+	// A second fresh migration would mix two copies of the data.
+	_, err = Migrate(ctx, src, dst, Options{})
+	require.ErrorIs(t, err, ErrDestinationNotEmpty)
+}
+
+func TestMigrate_DryRun(t *testing.T) {
+	ctx := t.Context()
+	src := newTestSource(t, 3)
+	dst := storage.NewMemStore()
+
+	summary, err := Migrate(ctx, src, dst, Options{
+		DryRun: true,
+		Checkpoint: func(string) error {
+			return errors.New("dry runs do not checkpoint")
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), summary.Keys)
+	require.Equal(t, uint64(3*len("ciphertext-logical/kv/000")), summary.Bytes)
+
+	keys, err := dst.List(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestMigrate_Resume(t *testing.T) {
+	ctx := t.Context()
+	src := newTestSource(t, 20)
+	dst := storage.NewMemStore()
+
+	// Interrupt the first run after its first page has been checkpointed.
+	interrupted := errors.New("interrupted")
+	var last string
+	_, err := Migrate(ctx, src, dst, Options{
+		PageSize: 8,
+		Checkpoint: func(key string) error {
+			last = key
+			return interrupted
+		},
+	})
+	require.ErrorIs(t, err, interrupted)
+	require.Equal(t, "logical/kv/007", last)
+
+	summary, err := Migrate(ctx, src, dst, Options{PageSize: 8, StartAfter: last})
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), summary.Keys)
+
+	mismatches, err := Verify(ctx, src, dst, 0)
+	require.NoError(t, err)
+	require.Empty(t, mismatches)
+}
+
+func TestVerify(t *testing.T) {
+	ctx := t.Context()
+	src := newTestSource(t, 4)
+	dst := storage.NewMemStore()
+
+	_, err := Migrate(ctx, src, dst, Options{})
+	require.NoError(t, err)
+	require.NoError(t, dst.Delete(ctx, "logical/kv/001"))
+	require.NoError(t, dst.Put(ctx, "logical/kv/002", []byte("tampered")))
+	require.NoError(t, dst.Put(ctx, "sys/stale", []byte("left over")))
+
+	mismatches, err := Verify(ctx, src, dst, 2)
+	require.NoError(t, err)
+	require.Equal(t, []Mismatch{
+		{Key: "logical/kv/001", Kind: Missing},
+		{Key: "logical/kv/002", Kind: Different},
+		{Key: "sys/stale", Kind: Extra},
+	}, mismatches)
+}
@@ -0,0 +1,81 @@
+package mount
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/thelamedev/rune/internal/logical"
+)
+
+// route is a mounted engine instance.
+type route struct {
+	entry   Entry
+	backend logical.Backend
+}
+
+// router resolves request paths to mounted engines. It is immutable: the
+// mount table builds a new one whenever a mount changes, so requests can
+// match against it without locking.
+type router struct {
+	byPath map[string]*route
+	byID   map[string]*route
+}
+
+func newRouter(routes []*route) *router {
+	r := &router{
+		byPath: make(map[string]*route, len(routes)),
+		byID:   make(map[string]*route, len(routes)),
+	}
+	for _, rt := range routes {
+		r.byPath[rt.entry.Path] = rt
+		r.byID[rt.entry.ID] = rt
+	}
+	return r
+}
+
+// match returns the mount with the longest path that is a prefix of path,
+// and path relative to that mount.
+func (r *router) match(path string) (*route, string, bool) {
+	// Mount paths end in "/", so only prefixes up to a "/" can match.
+	for i := strings.LastIndexByte(path, '/'); i >= 0; i = strings.LastIndexByte(path[:i], '/') {
+		if rt, ok := r.byPath[path[:i+1]]; ok {
+			return rt, path[i+1:], true
+		}
+	}
+	return nil, "", false
+}
+
+// routes returns the mounts sorted by path.
+func (r *router) routes() []*route {
+	routes := make([]*route, 0, len(r.byPath))
+	for _, rt := range r.byPath {
+		routes = append(routes, rt)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].entry.Path < routes[j].entry.Path
+	})
+	return routes
+}
+
+// external maps a key in the barrier to the request path it is served at,
+// or returns false if no mount serves it.
+func (r *router) external(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, dataPrefix)
+	if !ok {
+		return "", false
+	}
+	id, rel, ok := strings.Cut(rest, "/")
+	if !ok {
+		return "", false
+	}
+	rt, ok := r.byID[id]
+	if !ok {
+		return "", false
+	}
+	// A key can be shadowed by a mount nested below its own.
+	path := rt.entry.Path + rel
+	if served, _, _ := r.match(path); served != rt {
+		return "", false
+	}
+	return path, true
+}
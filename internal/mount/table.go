@@ -0,0 +1,669 @@
+// Package mount maintains the mount table, which maps path prefixes to the
+// secrets engines serving them, and routes requests to those engines.
+//
+// The table is stored in the barrier under sys/mounts. Each mount keeps its
+// data under logical/<id>/, so a mount can be moved or removed without
+// touching the data of any other.
+package mount
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/logical"
+	"github.com/thelamedev/rune/internal/storage"
+	"github.com/thelamedev/rune/internal/watch"
+)
+
+// DefaultPath is where the KV engine is mounted until the table is changed.
+const DefaultPath = "secret/"
+
+const (
+	sysPrefix  = "sys/"
+	tableKey   = "mounts"
+	dataPrefix = "logical/"
+)
+
+// reservedPrefixes are the top-level prefixes that never held secrets
+// before mounts existed: the mount table, the data of mounts, and what is
+// stored below the barrier or by it for Rune itself.
+var reservedPrefixes = []string{sysPrefix, dataPrefix, "core/", "hidden/"}
+
+var (
+	ErrNoMount     = errors.New("no secrets engine is mounted at path")
+	ErrPathInUse   = errors.New("a secrets engine is already mounted at path")
+	ErrInvalidPath = errors.New("invalid mount path")
+	ErrUnknownType = errors.New("unknown secrets engine type")
+	// ErrInvalidOptions wraps the error of an engine rejecting its options.
+	ErrInvalidOptions = errors.New("invalid mount options")
+	// ErrCrossMount is returned for transactions that touch more than one
+	// mount, which cannot be applied atomically.
+	ErrCrossMount = errors.New("transaction spans more than one mount")
+)
+
+// Entry describes a mount.
+type Entry struct {
+	// Path is the prefix the engine is mounted at. It ends in "/".
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// ID locates the mount's data, which is stored under logical/<id>/.
+	ID          string            `json:"id"`
+	Description string            `json:"description,omitempty"`
+	Options     map[string]string `json:"options,omitempty"`
+}
+
+// Tune changes the settings of a mount.
+type Tune struct {
+	// Description replaces the description when not nil.
+	Description *string
+	// Options are merged into the mount's options. An empty value removes
+	// the option.
+	Options map[string]string
+}
+
+// defaultEntry is the mount table of a vault that has never changed it. Its
+// ID is fixed so that every node of a cluster agrees on where its data is
+// stored before any table has been written. Secrets stored before mounts
+// existed are moved into it by Upgrade.
+var defaultEntry = Entry{
+	Path:        DefaultPath,
+	Type:        "kv",
+	ID:          "kv",
+	Description: "key/value secret storage",
+}
+
+// deleteBatch is how many keys of an unmounted mount are deleted per
+// transaction, which keeps each well within the limits of the backends and
+// of a Raft log entry. With path hashing, every delete also updates the
+// index, which makes 128 operations, the most etcd allows by default.
+const deleteBatch = 64
+
+type persistedTable struct {
+	Entries []Entry `json:"entries"`
+	// Removed holds the IDs of unmounted mounts whose data is still being
+	// deleted.
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Table is the mount table. It routes Get, Put, Delete, List and Transaction
+// calls to the engine mounted at the longest prefix of each path, and
+// subscribes to their events, with paths as seen by clients.
+type Table struct {
+	barrier *barrier.Barrier
+	sys     *barrier.View
+	hub     *watch.Hub
+	engines map[string]logical.Factory
+
+	// mu serializes changes to the table. Requests only load router.
+	mu     sync.Mutex
+	router atomic.Pointer[router]
+	// stored is set once a table has been stored, which is only done after
+	// the secrets stored before mounts existed have been moved.
+	stored bool
+	// removed is persistedTable.Removed.
+	removed []string
+}
+
+// NewTable returns an empty table. Load must be called once the barrier is
+// unsealed to mount the stored table. engines maps each engine type that
+// can be mounted to its factory.
+func NewTable(b *barrier.Barrier, hub *watch.Hub, engines map[string]logical.Factory) *Table {
+	t := &Table{
+		barrier: b,
+		sys:     b.View(sysPrefix),
+		hub:     hub,
+		engines: engines,
+	}
+	t.router.Store(newRouter(nil))
+	return t
+}
+
+// Load reads the stored mount table and mounts its engines. Engines whose
+// entry has not changed since the last load are kept.
+func (t *Table) Load(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := []Entry{defaultEntry}
+	data, err := t.sys.Get(ctx, tableKey)
+	switch {
+	case err == nil:
+		var table persistedTable
+		if err := json.Unmarshal(data, &table); err != nil {
+			return fmt.Errorf("failed to decode mount table: %w", err)
+		}
+		entries = table.Entries
+		t.removed = table.Removed
+		t.stored = true
+	case !errors.Is(err, storage.ErrKeyNotFound):
+		return fmt.Errorf("failed to read mount table: %w", err)
+	}
+
+	current := t.router.Load()
+	routes := make([]*route, 0, len(entries))
+	for _, entry := range entries {
+		if rt, ok := current.byID[entry.ID]; ok && sameEntry(rt.entry, entry) {
+			routes = append(routes, rt)
+			continue
+		}
+		rt, err := t.newRoute(ctx, entry)
+		if err != nil {
+			return fmt.Errorf("failed to mount %s: %w", entry.Path, err)
+		}
+		routes = append(routes, rt)
+	}
+	t.router.Store(newRouter(routes))
+	return nil
+}
+
+// Upgrade moves the secrets stored before mounts existed, at the top level of
+// the barrier, into the default mount, and then stores the table, so that it
+// is only done once. They are served below DefaultPath afterwards: a secret
+// stored at db/pass moves to secret/db/pass. Changing the table upgrades it
+// first too.
+//
+// Every secret is moved in a transaction of its own, so that a vault holding
+// any number of them is upgraded in bounded steps, and an interrupted upgrade
+// carries on where it stopped. A secret that has been written through the
+// default mount in the meantime is kept instead of the old one.
+func (t *Table) Upgrade(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stored {
+		return nil
+	}
+	if err := t.upgrade(ctx); err != nil {
+		return err
+	}
+	return t.update(ctx, t.router.Load().routes(), t.removed)
+}
+
+// upgrade moves the secrets outside reservedPrefixes into the default mount,
+// unless a table has been stored. It is called with mu held.
+func (t *Table) upgrade(ctx context.Context) error {
+	if t.stored {
+		return nil
+	}
+	moved := 0
+	opts := storage.ListOptions{Delimiter: "/"}
+	for {
+		res, err := t.barrier.ListPaged(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list secrets stored before mounts: %w", err)
+		}
+		for _, entry := range res.Entries {
+			if slices.ContainsFunc(reservedPrefixes, func(prefix string) bool {
+				return strings.HasPrefix(entry.Key, prefix)
+			}) {
+				continue
+			}
+			keys := []string{entry.Key}
+			if entry.IsFolder {
+				if keys, err = t.barrier.List(ctx, entry.Key); err != nil {
+					return fmt.Errorf("failed to list secrets stored before mounts: %w", err)
+				}
+			}
+			for _, key := range keys {
+				if err := t.moveSecret(ctx, key); err != nil {
+					return err
+				}
+				moved++
+			}
+		}
+		if res.Cursor == "" {
+			break
+		}
+		opts.StartAfter = res.Cursor
+	}
+	if moved > 0 {
+		log.Printf("Moved %d secrets stored before mounts existed below %s", moved, DefaultPath)
+	}
+	return nil
+}
+
+func (t *Table) moveSecret(ctx context.Context, key string) error {
+	value, err := t.barrier.Get(ctx, key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", key, err)
+	}
+	target := dataPrefix + defaultEntry.ID + "/" + key
+	err = t.barrier.Transaction(ctx, []storage.TxnOp{
+		{Op: storage.TxnCheck, Key: target},
+		{Op: storage.TxnPut, Key: target, Value: value},
+		{Op: storage.TxnDelete, Key: key},
+	})
+	if errors.Is(err, storage.ErrTxnCheckFailed) {
+		err = t.barrier.Delete(ctx, key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move %q below %s: %w", key, DefaultPath, err)
+	}
+	return nil
+}
+
+// Follow reloads the table whenever it is changed, such as by another node
+// of the cluster, until ctx is done.
+func (t *Table) Follow(ctx context.Context) error {
+	// Events carry the key the table is stored under, which is hidden when
+	// the barrier hashes paths.
+	key, err := t.barrier.StorageKey(sysPrefix + tableKey)
+	if err != nil {
+		return err
+	}
+	for {
+		sub, err := t.hub.Subscribe(key, 0)
+		if err != nil {
+			return err
+		}
+		// Pick up changes made before subscribing.
+		err = t.Load(ctx)
+		if err == nil {
+			err = t.follow(ctx, sub, key)
+		}
+		sub.Close()
+		if !errors.Is(err, watch.ErrCompacted) && !errors.Is(err, watch.ErrLagging) {
+			return err
+		}
+	}
+}
+
+func (t *Table) follow(ctx context.Context, sub *watch.Subscription, key string) error {
+	for {
+		ev, err := sub.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if ev.Key != key {
+			continue
+		}
+		if err := t.Load(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// Mounts returns the mount table, sorted by path.
+func (t *Table) Mounts() []Entry {
+	routes := t.router.Load().routes()
+	entries := make([]Entry, len(routes))
+	for i, rt := range routes {
+		entries[i] = rt.entry
+	}
+	return entries
+}
+
+// Mount mounts a new engine instance and returns its entry. The ID of entry
+// is assigned by the table.
+func (t *Table) Mount(ctx context.Context, entry Entry) (Entry, error) {
+	path, err := normalizePath(entry.Path)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Path = path
+	entry.ID = uuid.NewString()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.upgrade(ctx); err != nil {
+		return Entry{}, err
+	}
+
+	current := t.router.Load()
+	if _, ok := current.byPath[path]; ok {
+		return Entry{}, fmt.Errorf("%w: %s", ErrPathInUse, path)
+	}
+	rt, err := t.newRoute(ctx, entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := t.update(ctx, append(current.routes(), rt), t.removed); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// Unmount removes the mount at path and deletes all of its data. The mount
+// is dropped from the table first, and its data is deleted afterwards in
+// transactions of deleteBatch keys, however much it holds. If deleting fails,
+// Cleanup deletes the rest.
+func (t *Table) Unmount(ctx context.Context, path string) error {
+	path, err := normalizePath(path)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.upgrade(ctx); err != nil {
+		return err
+	}
+
+	current := t.router.Load()
+	removed, ok := current.byPath[path]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoMount, path)
+	}
+	var routes []*route
+	for _, rt := range current.routes() {
+		if rt != removed {
+			routes = append(routes, rt)
+		}
+	}
+
+	if err := t.update(ctx, routes, append(slices.Clone(t.removed), removed.entry.ID)); err != nil {
+		return err
+	}
+	return t.deleteRemoved(ctx)
+}
+
+// Cleanup deletes the data of unmounted mounts that Unmount left behind,
+// such as when the server stopped while it was deleting it.
+func (t *Table) Cleanup(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.deleteRemoved(ctx)
+}
+
+// deleteRemoved deletes the data of every mount in removed, and drops each
+// from removed once its data is gone. It is called with mu held.
+func (t *Table) deleteRemoved(ctx context.Context) error {
+	for len(t.removed) > 0 {
+		id := t.removed[0]
+		data := t.barrier.View(dataPrefix + id + "/")
+		for {
+			res, err := data.ListPaged(ctx, storage.ListOptions{Limit: deleteBatch})
+			if err != nil {
+				return fmt.Errorf("failed to list the data of unmounted mount %s: %w", id, err)
+			}
+			if len(res.Entries) == 0 {
+				break
+			}
+			ops := make([]storage.TxnOp, len(res.Entries))
+			for i, entry := range res.Entries {
+				ops[i] = storage.TxnOp{Op: storage.TxnDelete, Key: entry.Key}
+			}
+			if err := data.Transaction(ctx, ops); err != nil {
+				return fmt.Errorf("failed to delete the data of unmounted mount %s: %w", id, err)
+			}
+		}
+		if err := t.update(ctx, t.router.Load().routes(), t.removed[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tune changes the settings of the mount at path. The engine is recreated
+// with its new options, and keeps its data.
+func (t *Table) Tune(ctx context.Context, path string, tune Tune) (Entry, error) {
+	path, err := normalizePath(path)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.upgrade(ctx); err != nil {
+		return Entry{}, err
+	}
+
+	current := t.router.Load()
+	old, ok := current.byPath[path]
+	if !ok {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNoMount, path)
+	}
+
+	entry := old.entry
+	if tune.Description != nil {
+		entry.Description = *tune.Description
+	}
+	entry.Options = maps.Clone(entry.Options)
+	for name, value := range tune.Options {
+		if entry.Options == nil {
+			entry.Options = make(map[string]string)
+		}
+		if value == "" {
+			delete(entry.Options, name)
+		} else {
+			entry.Options[name] = value
+		}
+	}
+	if len(entry.Options) == 0 {
+		entry.Options = nil
+	}
+
+	tuned, err := t.newRoute(ctx, entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	routes := current.routes()
+	for i, rt := range routes {
+		if rt == old {
+			routes[i] = tuned
+		}
+	}
+	if err := t.update(ctx, routes, t.removed); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// update persists routes as the mount table, with the IDs of the unmounted
+// mounts whose data is still to be deleted, and starts routing to them. It
+// is called with mu held.
+func (t *Table) update(ctx context.Context, routes []*route, removed []string) error {
+	table := persistedTable{Entries: make([]Entry, len(routes)), Removed: removed}
+	for i, rt := range routes {
+		table.Entries[i] = rt.entry
+	}
+	data, err := json.Marshal(table)
+	if err != nil {
+		return fmt.Errorf("failed to encode mount table: %w", err)
+	}
+	if err := t.sys.Put(ctx, tableKey, data); err != nil {
+		return fmt.Errorf("failed to store mount table: %w", err)
+	}
+	t.router.Store(newRouter(routes))
+	t.removed = removed
+	t.stored = true
+	return nil
+}
+
+func (t *Table) newRoute(ctx context.Context, entry Entry) (*route, error) {
+	factory, ok := t.engines[entry.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, entry.Type)
+	}
+	backend, err := factory(ctx, &logical.BackendConfig{
+		Storage: t.barrier.View(dataPrefix + entry.ID + "/"),
+		Options: maps.Clone(entry.Options),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	return &route{entry: entry, backend: backend}, nil
+}
+
+// route returns the engine serving path and path relative to its mount.
+func (t *Table) route(path string) (logical.Backend, string, error) {
+	rt, rel, ok := t.router.Load().match(path)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrNoMount, path)
+	}
+	return rt.backend, rel, nil
+}
+
+func (t *Table) Get(ctx context.Context, path string) ([]byte, error) {
+	backend, rel, err := t.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Get(ctx, rel)
+}
+
+func (t *Table) Put(ctx context.Context, path string, value []byte) error {
+	backend, rel, err := t.route(path)
+	if err != nil {
+		return err
+	}
+	return backend.Put(ctx, rel, value)
+}
+
+func (t *Table) Delete(ctx context.Context, path string) error {
+	backend, rel, err := t.route(path)
+	if err != nil {
+		return err
+	}
+	return backend.Delete(ctx, rel)
+}
+
+// List returns every path under prefix, which must be within a mount.
+func (t *Table) List(ctx context.Context, prefix string) ([]string, error) {
+	var paths []string
+	opts := storage.ListOptions{Prefix: prefix}
+	for {
+		res, err := t.ListPaged(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range res.Entries {
+			paths = append(paths, entry.Key)
+		}
+		if res.Cursor == "" {
+			return paths, nil
+		}
+		opts.StartAfter = res.Cursor
+	}
+}
+
+// ListPaged lists the paths under a prefix within a mount. A prefix above
+// every mount, such as the empty prefix, lists the mounts below it as
+// folders.
+func (t *Table) ListPaged(ctx context.Context, opts storage.ListOptions) (*storage.ListResult, error) {
+	r := t.router.Load()
+	rt, rel, ok := r.match(opts.Prefix)
+	if !ok {
+		if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+			return nil, fmt.Errorf("%w: %s", ErrNoMount, opts.Prefix)
+		}
+		return listMounts(r, opts), nil
+	}
+
+	mountPath := rt.entry.Path
+	opts.Prefix = rel
+	opts.StartAfter = strings.TrimPrefix(opts.StartAfter, mountPath)
+	res, err := rt.backend.ListPaged(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res.Entries {
+		res.Entries[i].Key = mountPath + res.Entries[i].Key
+	}
+	if res.Cursor != "" {
+		res.Cursor = mountPath + res.Cursor
+	}
+	return res, nil
+}
+
+// listMounts lists the mount paths under opts.Prefix as folders.
+func listMounts(r *router, opts storage.ListOptions) *storage.ListResult {
+	res := &storage.ListResult{Entries: []storage.ListEntry{}}
+	last := ""
+	for _, rt := range r.routes() {
+		name, ok := strings.CutPrefix(rt.entry.Path, opts.Prefix)
+		if !ok {
+			continue
+		}
+		if opts.Delimiter != "" {
+			if i := strings.Index(name, opts.Delimiter); i >= 0 {
+				name = name[:i+len(opts.Delimiter)]
+			}
+		}
+		name = opts.Prefix + name
+		if name == last || name <= opts.StartAfter {
+			continue
+		}
+		if opts.Limit > 0 && len(res.Entries) == opts.Limit {
+			res.Cursor = last
+			break
+		}
+		res.Entries = append(res.Entries, storage.ListEntry{Key: name, IsFolder: true})
+		last = name
+	}
+	return res
+}
+
+// Transaction applies ops, which must all fall within the same mount.
+func (t *Table) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	r := t.router.Load()
+
+	var target *route
+	routed := make([]storage.TxnOp, len(ops))
+	for i, op := range ops {
+		rt, rel, ok := r.match(op.Key)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNoMount, op.Key)
+		}
+		if target != nil && rt != target {
+			return ErrCrossMount
+		}
+		target = rt
+		op.Key = rel
+		routed[i] = op
+	}
+	if target == nil {
+		return nil
+	}
+	return target.backend.Transaction(ctx, routed)
+}
+
+// Subscribe watches the paths under prefix, across every mount below it.
+// Events are matched against the mount table at the time they are
+// delivered, so mounts enabled during the watch are included.
+func (t *Table) Subscribe(prefix string, fromIndex uint64) (*watch.Subscription, error) {
+	storagePrefix, err := t.barrier.StorageKey(dataPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return t.hub.SubscribeFunc(storagePrefix, fromIndex, func(key string) (string, bool) {
+		key, ok := t.barrier.Path(key)
+		if !ok {
+			return "", false
+		}
+		path, ok := t.router.Load().external(key)
+		if !ok || !strings.HasPrefix(path, prefix) {
+			return "", false
+		}
+		return path, true
+	})
+}
+
+func normalizePath(path string) (string, error) {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" || path == "/" || strings.Contains(path, "//") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path, nil
+}
+
+func sameEntry(a, b Entry) bool {
+	return a.Path == b.Path && a.Type == b.Type && a.ID == b.ID &&
+		a.Description == b.Description && maps.Equal(a.Options, b.Options)
+}
@@ -0,0 +1,447 @@
+package mount
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/crypto"
+	"github.com/thelamedev/rune/internal/logical"
+	"github.com/thelamedev/rune/internal/logical/kv"
+	"github.com/thelamedev/rune/internal/seal"
+	"github.com/thelamedev/rune/internal/storage"
+	"github.com/thelamedev/rune/internal/watch"
+)
+
+var testEngines = map[string]logical.Factory{"kv": kv.Factory}
+
+func newTestTable(t *testing.T, opts ...barrier.Option) (*Table, *storage.MemStore, *barrier.Barrier, *watch.Hub) {
+	t.Helper()
+
+	backend := storage.NewMemStore()
+	hub := watch.NewHub(0)
+	b := barrier.New(watch.NewStore(backend, hub), opts...)
+	key := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate master key: %v", err)
+	}
+	if err := b.Unseal(key); err != nil {
+		t.Fatalf("failed to unseal barrier: %v", err)
+	}
+
+	table := NewTable(b, hub, testEngines)
+	if err := table.Load(t.Context()); err != nil {
+		t.Fatalf("failed to load mount table: %v", err)
+	}
+	return table, backend, b, hub
+}
+
+func mustMount(t *testing.T, table *Table, path string) Entry {
+	t.Helper()
+
+	entry, err := table.Mount(t.Context(), Entry{Path: path, Type: "kv"})
+	if err != nil {
+		t.Fatalf("failed to mount %s: %v", path, err)
+	}
+	return entry
+}
+
+func TestTable_DefaultMount(t *testing.T) {
+	table, backend, _, _ := newTestTable(t)
+	ctx := t.Context()
+
+	if got := table.Mounts(); !reflect.DeepEqual(got, []Entry{defaultEntry}) {
+		t.Fatalf("expected only the default mount, got %+v", got)
+	}
+	if err := table.Put(ctx, "secret/db/pass", []byte("hunter2")); err != nil {
+		t.Fatalf("failed to put secret: %v", err)
+	}
+	if _, err := backend.Get(ctx, "logical/kv/db/pass"); err != nil {
+		t.Fatalf("expected the default mount to store under logical/kv/, got %v", err)
+	}
+	if err := table.Put(ctx, "unmounted/db/pass", []byte("x")); !errors.Is(err, ErrNoMount) {
+		t.Fatalf("expected ErrNoMount, got %v", err)
+	}
+}
+
+func TestTable_Routing(t *testing.T) {
+	table, _, _, _ := newTestTable(t)
+	ctx := t.Context()
+
+	team := mustMount(t, table, "secret/team")
+	if team.Path != "secret/team/" || team.ID == "" {
+		t.Fatalf("expected a normalized path and an assigned ID, got %+v", team)
+	}
+	if _, err := table.Mount(ctx, Entry{Path: "secret/", Type: "kv"}); !errors.Is(err, ErrPathInUse) {
+		t.Fatalf("expected ErrPathInUse, got %v", err)
+	}
+	if _, err := table.Mount(ctx, Entry{Path: "transit/", Type: "transit"}); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("expected ErrUnknownType, got %v", err)
+	}
+
+	for _, path := range []string{"secret/db", "secret/team/db", "secret/teams"} {
+		if err := table.Put(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("failed to put %s: %v", path, err)
+		}
+	}
+
+	t.Run("Longest prefix", func(t *testing.T) {
+		rt, rel, ok := table.router.Load().match("secret/team/db")
+		if !ok || rt.entry.ID != team.ID || rel != "db" {
+			t.Fatalf("expected secret/team/db to route to the nested mount, got %+v %q", rt, rel)
+		}
+		rt, _, _ = table.router.Load().match("secret/teams")
+		if rt.entry.ID != defaultEntry.ID {
+			t.Fatalf("expected secret/teams to route to the default mount, got %+v", rt.entry)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		res, err := table.ListPaged(ctx, storage.ListOptions{Prefix: "secret/", Delimiter: "/"})
+		if err != nil {
+			t.Fatalf("failed to list secrets: %v", err)
+		}
+		expected := []storage.ListEntry{{Key: "secret/db"}, {Key: "secret/teams"}}
+		if !reflect.DeepEqual(res.Entries, expected) {
+			t.Fatalf("expected entries %v, got %v", expected, res.Entries)
+		}
+
+		res, err = table.ListPaged(ctx, storage.ListOptions{Delimiter: "/", Limit: 1})
+		if err != nil {
+			t.Fatalf("failed to list mounts: %v", err)
+		}
+		expected = []storage.ListEntry{{Key: "secret/", IsFolder: true}}
+		if !reflect.DeepEqual(res.Entries, expected) || res.Cursor != "" {
+			t.Fatalf("expected entries %v, got %v (cursor %q)", expected, res.Entries, res.Cursor)
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		err := table.Transaction(ctx, []storage.TxnOp{
+			{Op: storage.TxnCheckExists, Key: "secret/team/db"},
+			{Op: storage.TxnDelete, Key: "secret/team/db"},
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		err = table.Transaction(ctx, []storage.TxnOp{
+			{Op: storage.TxnDelete, Key: "secret/db"},
+			{Op: storage.TxnDelete, Key: "secret/team/other"},
+		})
+		if !errors.Is(err, ErrCrossMount) {
+			t.Fatalf("expected ErrCrossMount, got %v", err)
+		}
+	})
+}
+
+func TestTable_UnmountAndTune(t *testing.T) {
+	table, backend, _, hub := newTestTable(t)
+	ctx := t.Context()
+
+	entry := mustMount(t, table, "team/")
+	if err := table.Put(ctx, "team/db", []byte("x")); err != nil {
+		t.Fatalf("failed to put secret: %v", err)
+	}
+
+	description := "team secrets"
+	tuned, err := table.Tune(ctx, "team", Tune{Description: &description})
+	if err != nil {
+		t.Fatalf("failed to tune mount: %v", err)
+	}
+	if tuned.Description != description || tuned.ID != entry.ID {
+		t.Fatalf("expected the description to change and the ID to stay, got %+v", tuned)
+	}
+	if got, err := table.Get(ctx, "team/db"); err != nil || string(got) != "x" {
+		t.Fatalf("expected a tuned mount to keep its data, got %q (%v)", got, err)
+	}
+	_, err = table.Tune(ctx, "team/", Tune{Options: map[string]string{"version": "2"}})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+
+	for i := range 2 * deleteBatch {
+		if err := table.Put(ctx, fmt.Sprintf("team/%03d", i), []byte("x")); err != nil {
+			t.Fatalf("failed to put secret: %v", err)
+		}
+	}
+	before := hub.LastIndex()
+	if err := table.Unmount(ctx, "team/"); err != nil {
+		t.Fatalf("failed to unmount: %v", err)
+	}
+	// The table is stored before and after the data is deleted in batches.
+	if writes := hub.LastIndex() - before; writes != 2+3 {
+		t.Fatalf("expected the data to be deleted in 3 batches, got %d writes", writes)
+	}
+	if _, err := table.Get(ctx, "team/db"); !errors.Is(err, ErrNoMount) {
+		t.Fatalf("expected ErrNoMount after unmounting, got %v", err)
+	}
+	if keys, _ := backend.List(ctx, "logical/"+entry.ID+"/"); len(keys) != 0 {
+		t.Fatalf("expected the mount's data to be deleted, got %q", keys)
+	}
+	if err := table.Unmount(ctx, "team/"); !errors.Is(err, ErrNoMount) {
+		t.Fatalf("expected ErrNoMount, got %v", err)
+	}
+}
+
+func TestTable_Cleanup(t *testing.T) {
+	table, backend, b, hub := newTestTable(t)
+	ctx := t.Context()
+
+	entry := mustMount(t, table, "team/")
+	if err := table.Put(ctx, "team/db", []byte("x")); err != nil {
+		t.Fatalf("failed to put secret: %v", err)
+	}
+	// The server stopped after unmounting, before deleting the data.
+	data, err := json.Marshal(persistedTable{Entries: []Entry{defaultEntry}, Removed: []string{entry.ID}})
+	if err != nil {
+		t.Fatalf("failed to encode table: %v", err)
+	}
+	if err := b.Put(ctx, sysPrefix+tableKey, data); err != nil {
+		t.Fatalf("failed to store table: %v", err)
+	}
+
+	restarted := NewTable(b, hub, testEngines)
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatalf("failed to load mount table: %v", err)
+	}
+	if got := restarted.Mounts(); !reflect.DeepEqual(got, []Entry{defaultEntry}) {
+		t.Fatalf("expected the unmounted mount to stay unmounted, got %+v", got)
+	}
+	if err := restarted.Cleanup(ctx); err != nil {
+		t.Fatalf("failed to clean up: %v", err)
+	}
+	if keys, _ := backend.List(ctx, "logical/"+entry.ID+"/"); len(keys) != 0 {
+		t.Fatalf("expected the mount's data to be deleted, got %q", keys)
+	}
+	if len(restarted.removed) != 0 {
+		t.Fatalf("expected nothing left to delete, got %q", restarted.removed)
+	}
+}
+
+func TestTable_Persistence(t *testing.T) {
+	table, _, b, hub := newTestTable(t)
+	ctx := t.Context()
+
+	// A second table over the same barrier, as on another node.
+	follower := NewTable(b, hub, testEngines)
+	if err := follower.Load(ctx); err != nil {
+		t.Fatalf("failed to load mount table: %v", err)
+	}
+	followCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- follower.Follow(followCtx) }()
+	defer func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected Follow to end with the context, got %v", err)
+		}
+	}()
+
+	entry := mustMount(t, table, "team/")
+	if err := table.Unmount(ctx, DefaultPath); err != nil {
+		t.Fatalf("failed to unmount: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(follower.Mounts(), []Entry{entry}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the follower to see %+v, got %+v", entry, follower.Mounts())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTable_Restart(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "unseal-keys.json")
+
+	// start opens the bolt file and unseals the barrier the way the server
+	// does on every start.
+	start := func() (*Table, *storage.BoltStore) {
+		t.Helper()
+		backend, err := storage.NewBoltStore(filepath.Join(dir, "rune.db"))
+		if err != nil {
+			t.Fatalf("failed to open bolt store: %v", err)
+		}
+		if err := backend.Initialize(t.Context()); err != nil {
+			t.Fatalf("failed to initialize bolt store: %v", err)
+		}
+		s := seal.New(5, 3)
+		if _, err := s.UnsealFromFile(t.Context(), keyFile, true); err != nil {
+			t.Fatalf("failed to unseal: %v", err)
+		}
+		key, err := s.MasterKey()
+		if err != nil {
+			t.Fatalf("failed to get master key: %v", err)
+		}
+		hub := watch.NewHub(0)
+		b := barrier.New(watch.NewStore(backend, hub))
+		if err := b.Unseal(key); err != nil {
+			t.Fatalf("failed to unseal barrier: %v", err)
+		}
+		table := NewTable(b, hub, testEngines)
+		if err := table.Load(t.Context()); err != nil {
+			t.Fatalf("failed to load mount table: %v", err)
+		}
+		return table, backend
+	}
+
+	table, backend := start()
+	entry := mustMount(t, table, "team/")
+	if err := table.Put(t.Context(), "team/db/pass", []byte("hunter2")); err != nil {
+		t.Fatalf("failed to put secret: %v", err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatalf("failed to close bolt store: %v", err)
+	}
+
+	table, backend = start()
+	defer backend.Close()
+	if got := table.Mounts(); !reflect.DeepEqual(got, []Entry{defaultEntry, entry}) {
+		t.Fatalf("expected the mounts to survive a restart, got %+v", got)
+	}
+	val, err := table.Get(t.Context(), "team/db/pass")
+	if err != nil || string(val) != "hunter2" {
+		t.Fatalf("expected the secret to survive a restart, got %q, %v", val, err)
+	}
+}
+
+func TestTable_Subscribe(t *testing.T) {
+	table, _, _, _ := newTestTable(t)
+	ctx := t.Context()
+
+	sub, err := table.Subscribe("secret/", 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	mustMount(t, table, "secret/team/")
+	mustMount(t, table, "other/")
+	for _, path := range []string{"other/db", "secret/db", "secret/team/db"} {
+		if err := table.Put(ctx, path, []byte("x")); err != nil {
+			t.Fatalf("failed to put %s: %v", path, err)
+		}
+	}
+
+	for _, want := range []string{"secret/db", "secret/team/db"} {
+		nextCtx, cancel := context.WithTimeout(ctx, time.Second)
+		ev, err := sub.Next(nextCtx)
+		cancel()
+		if err != nil {
+			t.Fatalf("failed to receive event: %v", err)
+		}
+		if ev.Key != want {
+			t.Fatalf("expected an event for %s, got %+v", want, ev)
+		}
+	}
+}
+
+func TestTable_PathHashing(t *testing.T) {
+	table, backend, b, hub := newTestTable(t, barrier.WithPathHashing())
+	ctx := t.Context()
+
+	follower := NewTable(b, hub, testEngines)
+	followCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go follower.Follow(followCtx)
+
+	sub, err := table.Subscribe("team/", 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	entry := mustMount(t, table, "team/")
+	if err := table.Put(ctx, "team/db", []byte("x")); err != nil {
+		t.Fatalf("failed to put secret: %v", err)
+	}
+	if keys, _ := backend.List(ctx, "logical/"); len(keys) != 0 {
+		t.Fatalf("expected paths to be hidden in storage, got %q", keys)
+	}
+
+	nextCtx, cancelNext := context.WithTimeout(ctx, time.Second)
+	defer cancelNext()
+	ev, err := sub.Next(nextCtx)
+	if err != nil || ev.Key != "team/db" {
+		t.Fatalf("expected an event for team/db, got %+v (%v)", ev, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !slices.ContainsFunc(follower.Mounts(), func(e Entry) bool { return e.ID == entry.ID }) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the follower to see %+v, got %+v", entry, follower.Mounts())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTable_Upgrade(t *testing.T) {
+	for name, opts := range map[string][]barrier.Option{
+		"plain paths":  nil,
+		"hidden paths": {barrier.WithPathHashing()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			table, backend, b, _ := newTestTable(t, opts...)
+			ctx := t.Context()
+
+			// Secrets stored before mounts existed, one of which has been
+			// written again through the default mount since.
+			for key, value := range map[string]string{
+				"db/pass":     "hunter2",
+				"app/a/token": "old",
+				"toplevel":    "x",
+			} {
+				if err := b.Put(ctx, key, []byte(value)); err != nil {
+					t.Fatalf("failed to store %s: %v", key, err)
+				}
+			}
+			if err := table.Put(ctx, "secret/app/a/token", []byte("new")); err != nil {
+				t.Fatalf("failed to put secret: %v", err)
+			}
+			if err := backend.Put(ctx, "core/raft/peers/node-1", []byte("addr")); err != nil {
+				t.Fatalf("failed to store peer: %v", err)
+			}
+
+			if err := table.Upgrade(ctx); err != nil {
+				t.Fatalf("failed to upgrade: %v", err)
+			}
+			for path, want := range map[string]string{
+				"secret/db/pass":     "hunter2",
+				"secret/app/a/token": "new",
+				"secret/toplevel":    "x",
+			} {
+				if got, err := table.Get(ctx, path); err != nil || string(got) != want {
+					t.Errorf("expected %s to hold %q, got %q (%v)", path, want, got, err)
+				}
+			}
+			for _, key := range []string{"db/pass", "app/a/token", "toplevel"} {
+				if _, err := b.Get(ctx, key); !errors.Is(err, storage.ErrKeyNotFound) {
+					t.Errorf("expected %s to be moved, got %v", key, err)
+				}
+			}
+			if _, err := backend.Get(ctx, "core/raft/peers/node-1"); err != nil {
+				t.Errorf("expected what Rune stores for itself to stay, got %v", err)
+			}
+
+			// Once the table is stored, nothing is moved again.
+			if err := b.Put(ctx, "later", []byte("x")); err != nil {
+				t.Fatalf("failed to store later: %v", err)
+			}
+			if err := table.Upgrade(ctx); err != nil {
+				t.Fatalf("failed to upgrade again: %v", err)
+			}
+			if _, err := b.Get(ctx, "later"); err != nil {
+				t.Errorf("expected an upgraded table to move nothing, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+)
+
+// ErrAutopilotDisabled is returned for the health of a cluster whose leader
+// does not run autopilot.
+var ErrAutopilotDisabled = errors.New("autopilot is disabled")
+
+// AutopilotConfig configures the autopilot the leader runs to look after the
+// membership of the cluster.
+type AutopilotConfig struct {
+	// Interval is how often the leader checks the health of the servers.
+	Interval time.Duration
+	// LastContactThreshold is how long a server may go without hearing from
+	// the leader and still be healthy.
+	LastContactThreshold time.Duration
+	// MaxTrailingLogs is how many log entries a server may lag behind the
+	// leader and still be healthy.
+	MaxTrailingLogs uint64
+	// ServerStabilizationTime is how long a server that joined as a
+	// non-voter must stay healthy before it is promoted to voter.
+	ServerStabilizationTime time.Duration
+	// CleanupDeadServers removes servers that have been unhealthy and missed
+	// the leader's heartbeats for DeadServerTimeout, as long as the voters
+	// left keep a healthy quorum.
+	CleanupDeadServers bool
+	DeadServerTimeout  time.Duration
+	// Stats fetches the Raft state of another server. Servers whose state
+	// cannot be fetched are unhealthy. Without Stats, or an API address for
+	// the server, it is judged by the leader's heartbeats alone.
+	Stats func(ctx context.Context, peer Peer) (ServerStats, error)
+}
+
+// DefaultAutopilotConfig returns the settings autopilot runs with unless
+// told otherwise.
+func DefaultAutopilotConfig() AutopilotConfig {
+	return AutopilotConfig{
+		Interval:                2 * time.Second,
+		LastContactThreshold:    time.Second,
+		MaxTrailingLogs:         250,
+		ServerStabilizationTime: 10 * time.Second,
+		CleanupDeadServers:      true,
+		DeadServerTimeout:       10 * time.Minute,
+	}
+}
+
+// ServerHealth is the health of a member of the cluster.
+type ServerHealth struct {
+	ID      string
+	Address string
+	Voter   bool
+	Leader  bool
+	Healthy bool
+	// StableSince is when the server last became healthy or unhealthy, as
+	// far as the current leader knows.
+	StableSince time.Time
+	// LastContact is how long ago the server last heard from the leader, or
+	// negative if that is not known.
+	LastContact time.Duration
+	LastIndex   uint64
+	Term        uint64
+	// Dead is set once the server has been unhealthy, and missed the
+	// leader's heartbeats, for DeadServerTimeout.
+	Dead bool
+	// Error is why the server's state could not be fetched.
+	Error string
+
+	failing bool
+}
+
+// ClusterHealth is the health of every member of the cluster.
+type ClusterHealth struct {
+	// Healthy is set while every server is healthy.
+	Healthy bool
+	// FailureTolerance is how many voters may fail without the cluster
+	// losing its quorum.
+	FailureTolerance int
+	Servers          []ServerHealth
+}
+
+// ClusterOption configures a Cluster.
+type ClusterOption func(*Cluster)
+
+// WithAutopilot runs autopilot on the leader: new members join as
+// non-voters and are promoted once they have been healthy for long enough,
+// and dead members are removed.
+func WithAutopilot(config AutopilotConfig) ClusterOption {
+	return func(c *Cluster) {
+		c.autopilot = &autopilot{config: config}
+	}
+}
+
+type autopilot struct {
+	config AutopilotConfig
+
+	mu      sync.Mutex
+	health  *ClusterHealth
+	servers map[string]ServerHealth
+}
+
+// Health returns the health of the cluster as autopilot on the leader last
+// saw it. It fails with ErrNotLeader on other nodes.
+func (c *Cluster) Health(ctx context.Context) (ClusterHealth, error) {
+	if c.autopilot == nil {
+		return ClusterHealth{}, ErrAutopilotDisabled
+	}
+	if !c.node.IsLeader() {
+		return ClusterHealth{}, ErrNotLeader
+	}
+
+	c.autopilot.mu.Lock()
+	health := c.autopilot.health
+	c.autopilot.mu.Unlock()
+	if health != nil {
+		return *health, nil
+	}
+	return c.updateHealth(ctx)
+}
+
+// Stats returns the Raft state of this node.
+func (c *Cluster) Stats() ServerStats {
+	return c.node.Stats()
+}
+
+// runAutopilot checks the health of the servers while this node leads, and
+// promotes or removes them as it changes, until ctx is done.
+func (c *Cluster) runAutopilot(ctx context.Context) {
+	ticker := time.NewTicker(c.autopilot.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !c.node.IsLeader() {
+			c.autopilot.reset()
+			continue
+		}
+		if err := c.pilot(ctx); err != nil {
+			log.Printf("Autopilot failed: %v", err)
+		}
+	}
+}
+
+func (c *Cluster) pilot(ctx context.Context) error {
+	health, err := c.updateHealth(ctx)
+	if err != nil {
+		return err
+	}
+
+	promote, remove := c.autopilot.config.plan(health.Servers, time.Now())
+	for _, srv := range promote {
+		log.Printf("Autopilot: promoting %s to voter, it has been healthy since %s", srv.ID, srv.StableSince.Format(time.RFC3339))
+		if err := c.node.raft.AddVoter(raft.ServerID(srv.ID), raft.ServerAddress(srv.Address), 0, 0).Error(); err != nil {
+			return errors.Wrapf(err, "failed to promote peer %s", srv.ID)
+		}
+	}
+	for _, srv := range remove {
+		log.Printf("Autopilot: removing dead server %s, it has been unhealthy since %s", srv.ID, srv.StableSince.Format(time.RFC3339))
+		if err := c.RemovePeer(ctx, srv.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateHealth fetches the state of every server and records their health.
+func (c *Cluster) updateHealth(ctx context.Context) (ClusterHealth, error) {
+	peers, err := c.Peers(ctx)
+	if err != nil {
+		return ClusterHealth{}, err
+	}
+	leader := c.node.Stats()
+
+	now := time.Now()
+	servers := make([]ServerHealth, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Go(func() {
+			servers[i] = c.autopilot.config.serverHealth(ctx, p, leader, now)
+		})
+	}
+	wg.Wait()
+	return c.autopilot.record(servers, now), nil
+}
+
+// serverHealth judges the health of p against the state of the leader.
+func (cfg AutopilotConfig) serverHealth(ctx context.Context, p Peer, leader ServerStats, now time.Time) ServerHealth {
+	h := ServerHealth{ID: p.ID, Address: p.Address, Voter: p.Voter, Leader: p.Leader, LastContact: -1, failing: p.Failing}
+
+	var stats ServerStats
+	switch {
+	case p.Leader:
+		stats = leader
+	case cfg.Stats == nil || p.APIAddr == "":
+		h.Healthy = !p.Failing
+		return h
+	default:
+		ctx, cancel := context.WithTimeout(ctx, cfg.Interval)
+		defer cancel()
+		var err error
+		if stats, err = cfg.Stats(ctx, p); err != nil {
+			h.Error = err.Error()
+			return h
+		}
+	}
+
+	h.LastIndex, h.Term = stats.LastIndex, stats.Term
+	if !stats.LastContact.IsZero() {
+		h.LastContact = max(now.Sub(stats.LastContact), 0)
+	}
+	h.Healthy = !p.Failing && h.LastContact >= 0 && h.LastContact <= cfg.LastContactThreshold &&
+		stats.Term == leader.Term && stats.LastIndex+cfg.MaxTrailingLogs >= leader.LastIndex
+	if stats.Halted != 0 {
+		h.Healthy = false
+		h.Error = fmt.Sprintf("stopped applying the log at entry %d", stats.Halted)
+	}
+	return h
+}
+
+// record keeps track of how long each server has been healthy or unhealthy,
+// and returns the health of the cluster.
+func (a *autopilot) record(servers []ServerHealth, now time.Time) ClusterHealth {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	last := a.servers
+	a.servers = make(map[string]ServerHealth, len(servers))
+	health := ClusterHealth{Healthy: true, Servers: servers}
+	voters, healthyVoters := 0, 0
+	for i := range servers {
+		srv := &servers[i]
+		srv.StableSince = now
+		if prev, ok := last[srv.ID]; ok && prev.Healthy == srv.Healthy {
+			srv.StableSince = prev.StableSince
+		}
+		srv.Dead = srv.failing && !srv.Healthy && now.Sub(srv.StableSince) >= a.config.DeadServerTimeout
+		a.servers[srv.ID] = *srv
+
+		health.Healthy = health.Healthy && srv.Healthy
+		if srv.Voter {
+			voters++
+			if srv.Healthy {
+				healthyVoters++
+			}
+		}
+	}
+	health.FailureTolerance = max(healthyVoters-(voters/2+1), 0)
+	a.health = &health
+	return health
+}
+
+// reset forgets the health of the servers when this node stops leading, so
+// that it judges them afresh if it leads again.
+func (a *autopilot) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.health = nil
+	a.servers = nil
+}
+
+// plan returns the non-voters that have been healthy for long enough to be
+// promoted, and the dead servers that can be removed without the voters left
+// losing a healthy quorum.
+func (cfg AutopilotConfig) plan(servers []ServerHealth, now time.Time) (promote, remove []ServerHealth) {
+	voters, healthyVoters := 0, 0
+	for _, srv := range servers {
+		if srv.Voter {
+			voters++
+			if srv.Healthy {
+				healthyVoters++
+			}
+		}
+	}
+
+	for _, srv := range servers {
+		switch {
+		case srv.Leader:
+		case !srv.Voter && srv.Healthy:
+			if now.Sub(srv.StableSince) >= cfg.ServerStabilizationTime {
+				promote = append(promote, srv)
+			}
+		case !srv.Dead || !cfg.CleanupDeadServers:
+		case !srv.Voter:
+			remove = append(remove, srv)
+		case healthyVoters >= voters/2+1:
+			// Dead voters are never healthy, so the healthy voters stay a
+			// quorum of the voters left.
+			remove = append(remove, srv)
+			voters--
+		}
+	}
+	return promote, remove
+}
@@ -0,0 +1,136 @@
+package raft
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutopilotConfig_Plan(t *testing.T) {
+	now := time.Now()
+	cfg := DefaultAutopilotConfig()
+	leader := ServerHealth{ID: "node-1", Voter: true, Leader: true, Healthy: true}
+	voter := func(id string) ServerHealth {
+		return ServerHealth{ID: id, Voter: true, Healthy: true, StableSince: now.Add(-time.Hour)}
+	}
+	dead := func(srv ServerHealth) ServerHealth {
+		srv.Healthy, srv.Dead = false, true
+		return srv
+	}
+
+	for name, tc := range map[string]struct {
+		cleanup bool
+		servers []ServerHealth
+		promote []string
+		remove  []string
+	}{
+		"stable non-voter is promoted": {
+			servers: []ServerHealth{leader, {ID: "node-2", Healthy: true, StableSince: now.Add(-cfg.ServerStabilizationTime)}},
+			promote: []string{"node-2"},
+		},
+		"new non-voter waits": {
+			servers: []ServerHealth{leader, {ID: "node-2", Healthy: true, StableSince: now.Add(-time.Second)}},
+		},
+		"unhealthy non-voter waits": {
+			servers: []ServerHealth{leader, {ID: "node-2", StableSince: now.Add(-time.Hour)}},
+		},
+		"dead voter is removed": {
+			cleanup: true,
+			servers: []ServerHealth{leader, voter("node-2"), dead(voter("node-3"))},
+			remove:  []string{"node-3"},
+		},
+		"dead voters are kept without a healthy quorum": {
+			cleanup: true,
+			servers: []ServerHealth{leader, dead(voter("node-2")), dead(voter("node-3"))},
+		},
+		"dead voters are removed while a quorum stays": {
+			cleanup: true,
+			servers: []ServerHealth{leader, voter("node-2"), voter("node-3"), dead(voter("node-4")), dead(voter("node-5"))},
+			remove:  []string{"node-4", "node-5"},
+		},
+		"dead non-voter is removed": {
+			cleanup: true,
+			servers: []ServerHealth{leader, dead(ServerHealth{ID: "node-2"})},
+			remove:  []string{"node-2"},
+		},
+		"dead servers are kept without cleanup": {
+			servers: []ServerHealth{leader, voter("node-2"), dead(voter("node-3")), dead(ServerHealth{ID: "node-4"})},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := cfg
+			cfg.CleanupDeadServers = tc.cleanup
+			promote, remove := cfg.plan(tc.servers, now)
+
+			ids := func(servers []ServerHealth) []string {
+				var ids []string
+				for _, srv := range servers {
+					ids = append(ids, srv.ID)
+				}
+				return ids
+			}
+			require.Equal(t, tc.promote, ids(promote), "promoted")
+			require.Equal(t, tc.remove, ids(remove), "removed")
+		})
+	}
+}
+
+func TestCluster_Autopilot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node1, store1 := newTestRaftNode(t, true, "node-1", t.TempDir())
+	node2, _ := newTestRaftNode(t, false, "node-2", t.TempDir())
+	node3, _ := newTestRaftNode(t, false, "node-3", t.TempDir())
+	require.Eventually(t, node1.IsLeader, 3*time.Second, 50*time.Millisecond, "node-1 never became leader")
+
+	nodes := map[string]*RaftNode{"node-2": node2, "node-3": node3}
+	cfg := DefaultAutopilotConfig()
+	cfg.Interval = 50 * time.Millisecond
+	cfg.ServerStabilizationTime = 500 * time.Millisecond
+	cfg.DeadServerTimeout = 500 * time.Millisecond
+	cfg.Stats = func(ctx context.Context, peer Peer) (ServerStats, error) {
+		return nodes[peer.ID].Stats(), nil
+	}
+	cluster := NewCluster(node1, NewStore(node1, store1), "127.0.0.1:8001", WithAutopilot(cfg))
+	go cluster.Run(ctx)
+
+	voters := func() map[string]bool {
+		peers, err := cluster.Peers(ctx)
+		require.NoError(t, err)
+		voters := make(map[string]bool)
+		for _, p := range peers {
+			voters[p.ID] = p.Voter
+		}
+		return voters
+	}
+
+	t.Run("promotes stable servers", func(t *testing.T) {
+		for id, node := range nodes {
+			require.NoError(t, cluster.Join(ctx, id, node.Addr(), "127.0.0.1:0"))
+		}
+		require.Equal(t, map[string]bool{"node-1": true, "node-2": false, "node-3": false}, voters())
+
+		require.Eventually(t, func() bool {
+			v := voters()
+			return v["node-2"] && v["node-3"]
+		}, 5*time.Second, 50*time.Millisecond, "autopilot never promoted the new servers")
+
+		require.Eventually(t, func() bool {
+			health, err := cluster.Health(ctx)
+			return err == nil && health.Healthy && health.FailureTolerance == 1 && len(health.Servers) == 3
+		}, 5*time.Second, 50*time.Millisecond, "cluster never became healthy")
+	})
+
+	t.Run("removes dead servers", func(t *testing.T) {
+		require.NoError(t, node3.raft.Shutdown().Error())
+
+		require.Eventually(t, func() bool {
+			_, ok := voters()["node-3"]
+			return !ok
+		}, 10*time.Second, 50*time.Millisecond, "autopilot never removed the dead server")
+		require.Equal(t, map[string]bool{"node-1": true, "node-2": true}, voters())
+	})
+}
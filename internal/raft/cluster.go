@@ -0,0 +1,255 @@
+package raft
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// peerPrefix holds the API address of every member of the cluster, keyed by
+// node ID, so that any node can send clients on to the leader. Addresses are
+// not secret, so they are stored as they are, below the barrier.
+const peerPrefix = "core/raft/peers/"
+
+// advertiseInterval is how often the leader checks that its API address is
+// recorded, in case recording it when it gained leadership failed.
+const advertiseInterval = 10 * time.Second
+
+var (
+	ErrNoLeader    = errors.New("no known raft leader")
+	ErrNotLeader   = errors.New("this node is not the raft leader")
+	ErrUnknownPeer = errors.New("unknown raft peer")
+)
+
+// Peer is a member of the cluster.
+type Peer struct {
+	ID      string
+	Address string
+	// APIAddr is where the peer serves clients, if it is known.
+	APIAddr string
+	Voter   bool
+	Leader  bool
+	// Failing is set for peers that stopped answering the leader's
+	// heartbeats. LastContact is when the leader last heard from the peer,
+	// which is the zero time for the leader itself and for peers it has not
+	// heard from yet.
+	Failing     bool
+	LastContact time.Time
+}
+
+// Cluster manages the membership of the cluster a node belongs to. Changes
+// to the membership can only be made on the leader.
+type Cluster struct {
+	node      *RaftNode
+	store     *Store
+	apiAddr   string
+	autopilot *autopilot
+}
+
+// NewCluster returns the cluster of node. store must replicate through node,
+// and apiAddr is the address node serves clients at.
+func NewCluster(node *RaftNode, store *Store, apiAddr string, opts ...ClusterOption) *Cluster {
+	c := &Cluster{node: node, store: store, apiAddr: apiAddr}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// IsLeader reports whether this node is the leader.
+func (c *Cluster) IsLeader() bool {
+	return c.node.IsLeader()
+}
+
+// Barrier blocks until this node, as the leader, has applied every write
+// committed before the call, so that reads made afterwards are linearizable.
+// It fails with ErrNotLeader on other nodes, and on a leader that has been
+// deposed.
+func (c *Cluster) Barrier(ctx context.Context) error {
+	err := c.node.Barrier(ctx)
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		return errors.Wrap(ErrNotLeader, err.Error())
+	}
+	return err
+}
+
+// LeaderContact returns how long ago this node last heard from the leader,
+// which bounds how far behind the leader its reads may be. It is zero on the
+// leader, and false on a node that has not heard from any leader.
+func (c *Cluster) LeaderContact() (time.Duration, bool) {
+	if c.node.IsLeader() {
+		return 0, true
+	}
+	last := c.node.LeaderContact()
+	if last.IsZero() {
+		return 0, false
+	}
+	return time.Since(last), true
+}
+
+// Join adds a node to the cluster as a voter, or with autopilot as a
+// non-voter that autopilot promotes once it is stable. Joining a node that is
+// already a member with the same address only updates its API address, and a
+// member with the same ID or address is replaced.
+func (c *Cluster) Join(ctx context.Context, id, addr, apiAddr string) error {
+	config, err := c.configuration()
+	if err != nil {
+		return err
+	}
+
+	member := false
+	for _, srv := range config.Servers {
+		if srv.ID != raft.ServerID(id) && srv.Address != raft.ServerAddress(addr) {
+			continue
+		}
+		if srv.ID == raft.ServerID(id) && srv.Address == raft.ServerAddress(addr) {
+			member = true
+			continue
+		}
+		if err := c.node.raft.RemoveServer(srv.ID, 0, 0).Error(); err != nil {
+			return errors.Wrapf(err, "failed to remove stale peer %s", srv.ID)
+		}
+	}
+
+	if !member {
+		add := c.node.raft.AddVoter
+		if c.autopilot != nil {
+			add = c.node.raft.AddNonvoter
+		}
+		if err := add(raft.ServerID(id), raft.ServerAddress(addr), 0, 0).Error(); err != nil {
+			return errors.Wrapf(err, "failed to add peer %s", id)
+		}
+	}
+	if apiAddr != "" {
+		if err := c.store.Put(ctx, peerPrefix+id, []byte(apiAddr)); err != nil {
+			return errors.Wrapf(err, "failed to record API address of peer %s", id)
+		}
+	}
+	return nil
+}
+
+// RemovePeer removes the node with the given ID from the cluster. If it is
+// the leader, it steps down once the removal is committed.
+func (c *Cluster) RemovePeer(ctx context.Context, id string) error {
+	config, err := c.configuration()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, srv := range config.Servers {
+		found = found || srv.ID == raft.ServerID(id)
+	}
+	if !found {
+		return errors.Wrap(ErrUnknownPeer, id)
+	}
+
+	// Forget the address first, while this node can still write.
+	if err := c.store.Delete(ctx, peerPrefix+id); err != nil {
+		return errors.Wrapf(err, "failed to forget API address of peer %s", id)
+	}
+	if err := c.node.raft.RemoveServer(raft.ServerID(id), 0, 0).Error(); err != nil {
+		return errors.Wrapf(err, "failed to remove peer %s", id)
+	}
+	return nil
+}
+
+// Peers returns the members of the cluster, sorted by ID.
+func (c *Cluster) Peers(ctx context.Context) ([]Peer, error) {
+	config, err := c.configuration()
+	if err != nil {
+		return nil, err
+	}
+	_, leaderID := c.node.Leader()
+
+	peers := make([]Peer, 0, len(config.Servers))
+	for _, srv := range config.Servers {
+		p := Peer{
+			ID:      string(srv.ID),
+			Address: string(srv.Address),
+			Voter:   srv.Suffrage == raft.Voter,
+			Leader:  string(srv.ID) == leaderID,
+		}
+		if p.APIAddr, err = c.peerAPIAddr(ctx, p.ID); err != nil {
+			return nil, err
+		}
+		p.LastContact, p.Failing = c.node.LastContact(p.ID)
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers, nil
+}
+
+// LeaderAPIAddr returns the address the leader serves clients at.
+func (c *Cluster) LeaderAPIAddr(ctx context.Context) (string, error) {
+	_, id := c.node.Leader()
+	if id == "" {
+		return "", ErrNoLeader
+	}
+	addr, err := c.peerAPIAddr(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if addr == "" {
+		return "", errors.Wrapf(ErrNoLeader, "API address of leader %s is not known yet", id)
+	}
+	return addr, nil
+}
+
+// Run records this node's API address whenever it becomes the leader, so
+// that the other nodes can send clients to it, and runs autopilot while it
+// leads, until ctx is done.
+func (c *Cluster) Run(ctx context.Context) {
+	if c.autopilot != nil {
+		go c.runAutopilot(ctx)
+	}
+
+	ticker := time.NewTicker(advertiseInterval)
+	defer ticker.Stop()
+
+	leaderCh := c.node.raft.LeaderCh()
+	for {
+		if c.node.IsLeader() {
+			if err := c.advertise(ctx); err != nil {
+				log.Printf("Failed to record the API address of this node: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-leaderCh:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cluster) advertise(ctx context.Context) error {
+	id := c.node.config.NodeID
+	if addr, err := c.peerAPIAddr(ctx, id); err != nil || addr == c.apiAddr {
+		return err
+	}
+	return c.store.Put(ctx, peerPrefix+id, []byte(c.apiAddr))
+}
+
+func (c *Cluster) peerAPIAddr(ctx context.Context, id string) (string, error) {
+	addr, err := c.store.Get(ctx, peerPrefix+id)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read API address of peer %s", id)
+	}
+	return string(addr), nil
+}
+
+func (c *Cluster) configuration() (raft.Configuration, error) {
+	future := c.node.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return raft.Configuration{}, errors.Wrap(err, "failed to read raft configuration")
+	}
+	return future.Configuration(), nil
+}
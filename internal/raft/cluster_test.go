@@ -0,0 +1,129 @@
+package raft
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/seal"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// unusedAddr returns an address nothing listens on.
+func unusedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+func TestCluster(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node1, store1 := newTestRaftNode(t, true, "node-1", t.TempDir())
+	node2, store2 := newTestRaftNode(t, false, "node-2", t.TempDir())
+	require.Eventually(t, node1.IsLeader, 3*time.Second, 50*time.Millisecond, "node-1 never became leader")
+
+	cluster1 := NewCluster(node1, NewStore(node1, store1), "127.0.0.1:8001")
+	cluster2 := NewCluster(node2, NewStore(node2, store2), "127.0.0.1:8002")
+	go cluster1.Run(ctx)
+	require.Eventually(t, func() bool {
+		addr, err := cluster1.LeaderAPIAddr(ctx)
+		return err == nil && addr == "127.0.0.1:8001"
+	}, 3*time.Second, 50*time.Millisecond, "leader never recorded its API address")
+
+	t.Run("join", func(t *testing.T) {
+		require.NoError(t, cluster1.Join(ctx, "node-2", node2.Addr(), "127.0.0.1:8002"))
+		// Joining again changes nothing.
+		require.NoError(t, cluster1.Join(ctx, "node-2", node2.Addr(), "127.0.0.1:8002"))
+
+		require.Eventually(t, func() bool {
+			addr, err := cluster2.LeaderAPIAddr(ctx)
+			return err == nil && addr == "127.0.0.1:8001"
+		}, 5*time.Second, 50*time.Millisecond, "follower never learned the leader's API address")
+		require.False(t, cluster2.IsLeader())
+
+		// Data written before the node joined is replicated to it.
+		value, err := store2.Get(ctx, peerPrefix+"node-2")
+		require.NoError(t, err)
+		require.Equal(t, "127.0.0.1:8002", string(value))
+
+		peers, err := cluster1.Peers(ctx)
+		require.NoError(t, err)
+		require.Len(t, peers, 2)
+		// The leader hears from a healthy follower with every heartbeat.
+		require.Zero(t, peers[0].LastContact)
+		require.WithinDuration(t, time.Now(), peers[1].LastContact, 5*time.Second)
+		peers[1].LastContact = time.Time{}
+		require.Equal(t, []Peer{
+			{ID: "node-1", Address: node1.Addr(), APIAddr: "127.0.0.1:8001", Voter: true, Leader: true},
+			{ID: "node-2", Address: node2.Addr(), APIAddr: "127.0.0.1:8002", Voter: true},
+		}, peers)
+	})
+
+	t.Run("reads", func(t *testing.T) {
+		require.NoError(t, cluster1.Barrier(ctx))
+		require.ErrorIs(t, cluster2.Barrier(ctx), ErrNotLeader)
+
+		age, ok := cluster1.LeaderContact()
+		require.True(t, ok)
+		require.Zero(t, age)
+		age, ok = cluster2.LeaderContact()
+		require.True(t, ok, "follower never heard from the leader")
+		require.Less(t, age, 5*time.Second)
+	})
+
+	t.Run("encrypted reads", func(t *testing.T) {
+		// The joining node unseals with the unseal key file of the first.
+		keyFile := filepath.Join(t.TempDir(), "unseal-keys.json")
+		open := func(node *RaftNode, local storage.Storage, create bool) *barrier.Barrier {
+			s := seal.New(5, 3)
+			_, err := s.UnsealFromFile(ctx, keyFile, create)
+			require.NoError(t, err)
+			key, err := s.MasterKey()
+			require.NoError(t, err)
+			b := barrier.New(NewStore(node, local))
+			require.NoError(t, b.Unseal(key))
+			return b
+		}
+		_, err := seal.New(5, 3).UnsealFromFile(ctx, keyFile, false)
+		require.ErrorIs(t, err, seal.ErrNoKeyFile)
+		leader := open(node1, store1, true)
+		follower := open(node2, store2, false)
+
+		require.NoError(t, leader.Put(ctx, "logical/kv/db/pass", []byte("hunter2")))
+		require.Eventually(t, func() bool {
+			value, err := follower.Get(ctx, "logical/kv/db/pass")
+			return err == nil && string(value) == "hunter2"
+		}, 5*time.Second, 50*time.Millisecond, "follower never read the replicated secret")
+	})
+
+	t.Run("unreachable peer", func(t *testing.T) {
+		require.NoError(t, cluster1.Join(ctx, "node-3", unusedAddr(t), ""))
+
+		require.Eventually(t, func() bool {
+			peers, err := cluster1.Peers(ctx)
+			return err == nil && len(peers) == 3 && peers[2].Failing && !peers[2].LastContact.IsZero()
+		}, 5*time.Second, 50*time.Millisecond, "leader never noticed the peer failing")
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.ErrorIs(t, cluster1.RemovePeer(ctx, "node-4"), ErrUnknownPeer)
+
+		for _, id := range []string{"node-3", "node-2"} {
+			require.NoError(t, cluster1.RemovePeer(ctx, id))
+		}
+		peers, err := cluster1.Peers(ctx)
+		require.NoError(t, err)
+		require.Len(t, peers, 1)
+		_, err = store1.Get(ctx, peerPrefix+"node-2")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+}
@@ -0,0 +1,48 @@
+package raft
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// contactTransport records when each peer last answered a request sent over
+// the transport. The leader sends every follower a heartbeat several times
+// per heartbeat timeout, so while this node leads, it knows when it last
+// heard from each of them, which Raft keeps to itself.
+type contactTransport struct {
+	*raft.NetworkTransport
+
+	mu       sync.Mutex
+	contacts map[raft.ServerID]time.Time
+}
+
+func newContactTransport(t *raft.NetworkTransport) *contactTransport {
+	return &contactTransport{NetworkTransport: t, contacts: make(map[raft.ServerID]time.Time)}
+}
+
+func (t *contactTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	err := t.NetworkTransport.AppendEntries(id, target, args, resp)
+	if err == nil {
+		t.mu.Lock()
+		t.contacts[id] = time.Now()
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// lastContact returns when the peer with the given ID last answered, or the
+// zero time if it has not since reset.
+func (t *contactTransport) lastContact(id raft.ServerID) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.contacts[id]
+}
+
+// reset forgets every contact, for when this node stops or starts leading.
+func (t *contactTransport) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.contacts)
+}
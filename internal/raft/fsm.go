@@ -2,75 +2,261 @@ package raft
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"io"
+	"log"
+	"sync/atomic"
+	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/raft"
 	"github.com/pkg/errors"
+	"github.com/thelamedev/rune/internal/consensus"
 	"github.com/thelamedev/rune/internal/storage"
+	"github.com/thelamedev/rune/internal/watch"
 )
 
-type command struct {
-	Op    string `json:"op,omitempty"`
-	Key   string `json:"key,omitempty"`
-	Value []byte `json:"value,omitempty"`
+type fsm struct {
+	store       storage.Storage
+	hub         *watch.Hub
+	compression SnapshotCompression
+	snapshotDir string
+
+	// halted is the index of the log entry the FSM stopped applying at, or
+	// zero while it applies the log.
+	halted atomic.Uint64
 }
 
-type fsm struct {
-	store *storage.BoltStore
+var _ raft.BatchingFSM = (*fsm)(nil)
+
+type FSMOption func(*fsm)
+
+// WithWatchHub publishes every applied write to hub, indexed by its log
+// index, so that watchers on every node see it.
+func WithWatchHub(hub *watch.Hub) FSMOption {
+	return func(f *fsm) {
+		f.hub = hub
+	}
+}
+
+// WithSnapshotCompression compresses persisted snapshots with c instead of
+// leaving them uncompressed. Nodes older than compressed snapshots cannot
+// restore them, so only compress once every node has been upgraded.
+func WithSnapshotCompression(c SnapshotCompression) FSMOption {
+	return func(f *fsm) {
+		f.compression = c
+	}
 }
 
-func NewFSM(store *storage.BoltStore) raft.FSM {
-	return &fsm{
-		store: store,
+// WithSnapshotDir stages snapshots in dir while they are persisted and
+// restored, instead of the system's temporary directory. It should have room
+// for a copy of the store.
+func WithSnapshotDir(dir string) FSMOption {
+	return func(f *fsm) {
+		f.snapshotDir = dir
 	}
 }
 
-func (f *fsm) Apply(log *raft.Log) any {
-	var cmd command
-	if err := json.Unmarshal(log.Data, &cmd); err != nil {
-		panic(errors.Wrap(err, "failed to unmarshal command"))
+func NewFSM(store storage.Storage, opts ...FSMOption) raft.FSM {
+	f := &fsm{
+		store:       store,
+		compression: SnapshotCompressionNone,
 	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
 
+// Apply applies the command of a log entry to the store, and returns its
+// *consensus.Result, or a []*consensus.Result for a BATCH command. Entries
+// that hold no valid command at all are quarantined rather than applied, so
+// that one bad entry does not bring down every node that applies it.
+//
+// Apply panics if the store fails to apply a command. Every other node has
+// applied it, so carrying on without it would silently diverge this node from
+// the cluster.
+//
+// A command encoded by a newer version of Rune halts the FSM instead: neither
+// it nor any later entry is applied, and no snapshots are taken, so that the
+// node applies them once it has been upgraded and restarted. Until then it
+// keeps following the leader, but reports itself unhealthy.
+func (f *fsm) Apply(entry *raft.Log) any {
+	return f.ApplyBatch([]*raft.Log{entry})[0]
+}
+
+// ApplyBatch applies a run of committed log entries like Apply, but in a
+// single storage batch, so that a backend such as BoltDB writes all of them
+// with one fsync. Each command is still applied as its own transaction.
+func (f *fsm) ApplyBatch(entries []*raft.Log) []any {
 	ctx := context.Background()
 
-	switch cmd.Op {
-	case "set":
-		return f.store.Put(ctx, cmd.Key, cmd.Value)
-	case "delete":
-		return f.store.Delete(ctx, cmd.Key)
+	resps := make([]any, len(entries))
+	if index := f.halted.Load(); index != 0 {
+		haltAll(entries, resps, index)
+		return resps
+	}
+
+	// Every command becomes one transaction of the batch, and so does the
+	// record of every quarantined entry.
+	cmds := make([]*consensus.Command, len(entries))
+	var txns [][]storage.TxnOp
+	for i, entry := range entries {
+		if entry.Type != raft.LogCommand {
+			continue
+		}
+		cmd, err := consensus.Decode(entry.Data)
+		if errors.Is(err, consensus.ErrUnsupportedVersion) {
+			log.Printf("Stopped applying the log at entry %d: %v. Upgrade this node and restart it to carry on.", entry.Index, err)
+			f.halted.Store(entry.Index)
+			haltAll(entries[i:], resps[i:], entry.Index)
+			entries = entries[:i]
+			break
+		}
+		if err != nil {
+			txns = append(txns, []storage.TxnOp{quarantineOp(entry, err)})
+			resps[i] = &consensus.Result{Code: consensus.ResultInvalid, Err: err}
+			continue
+		}
+		cmds[i] = cmd
+		for _, c := range cmd.Batched() {
+			txns = append(txns, c.TxnOps())
+		}
+	}
+	if len(txns) == 0 {
+		return resps
+	}
+
+	errs, err := storage.Batch(ctx, f.store, txns)
+	if err != nil {
+		panic(errors.Wrapf(err, "failed to apply %s", describeEntries(entries)))
+	}
+
+	next := 0
+	for i, entry := range entries {
+		if entry.Type != raft.LogCommand {
+			continue
+		}
+		if cmds[i] == nil {
+			next++
+			continue
+		}
+
+		batched := cmds[i].Batched()
+		results := make([]*consensus.Result, len(batched))
+		for j, cmd := range batched {
+			err := errs[next]
+			next++
+			res, ok := consensus.NewResult(err)
+			if !ok {
+				panic(errors.Wrapf(err, "failed to apply log entry %d", entry.Index))
+			}
+			if res.Code == consensus.ResultOK {
+				f.publish(entry.Index, cmd)
+			}
+			results[j] = res
+		}
+		if cmds[i].Type == consensus.CommandType_COMMAND_TYPE_BATCH {
+			resps[i] = results
+		} else {
+			resps[i] = results[0]
+		}
+	}
+	return resps
+}
+
+// haltAll fails every command of entries, which the FSM will not apply until
+// it is restarted, because it halted at log entry index.
+func haltAll(entries []*raft.Log, resps []any, index uint64) {
+	err := haltedError(index)
+	for i, entry := range entries {
+		if entry.Type == raft.LogCommand {
+			resps[i] = &consensus.Result{Code: consensus.ResultInvalid, Err: err}
+		}
+	}
+}
+
+// haltedError is the error of commands that are not applied because the FSM
+// halted at log entry index.
+func haltedError(index uint64) error {
+	return errors.Wrapf(consensus.ErrUnsupportedVersion, "this node stopped applying the log at entry %d, upgrade it", index)
+}
+
+// Halted returns the index of the log entry the FSM stopped applying at, if
+// it has.
+func (f *fsm) Halted() (uint64, bool) {
+	index := f.halted.Load()
+	return index, index != 0
+}
+
+// publish sends the changes cmd made to the watch hub.
+func (f *fsm) publish(index uint64, cmd *consensus.Command) {
+	if f.hub == nil {
+		return
+	}
+	switch cmd.Type {
+	case consensus.CommandType_COMMAND_TYPE_PUT:
+		f.hub.Publish(watch.Event{Index: index, Key: cmd.Key, Op: watch.OpPut})
+	case consensus.CommandType_COMMAND_TYPE_DELETE:
+		f.hub.Publish(watch.Event{Index: index, Key: cmd.Key, Op: watch.OpDelete})
 	default:
-		panic(errors.Errorf("unrecognized command op: %s", cmd.Op))
+		f.hub.PublishTxn(index, cmd.TxnOps())
 	}
 }
 
+func describeEntries(entries []*raft.Log) string {
+	first, last := entries[0].Index, entries[len(entries)-1].Index
+	if first == last {
+		return fmt.Sprintf("log entry %d", first)
+	}
+	return fmt.Sprintf("log entries %d to %d", first, last)
+}
+
 type fsmSnapshot struct {
-	store *storage.BoltStore
+	view        storage.SnapshotView
+	compression SnapshotCompression
+	dir         string
 }
 
+// Snapshot takes a view of the store as of the last applied entry. Raft calls
+// it between applies, and Persist writes the view out later, concurrently with
+// the entries applied since.
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
-	return &fsmSnapshot{store: f.store}, nil
+	// Raft would take the entries the FSM skipped for applied, and compact
+	// them away.
+	if index, ok := f.Halted(); ok {
+		return nil, errors.Errorf("cannot snapshot a store that stopped applying the log at entry %d", index)
+	}
+	view, err := storage.TakeSnapshotView(f.store, f.snapshotDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to take snapshot view")
+	}
+	return &fsmSnapshot{view: view, compression: f.compression, dir: f.snapshotDir}, nil
 }
 
+// Restore replaces the store with a snapshot, once it has been verified
+// against the checksum it was persisted with.
 func (f *fsm) Restore(rc io.ReadCloser) error {
-	return f.store.Restore(rc)
-}
+	defer metrics.MeasureSince([]string{"rune", "raft", "snapshot", "restore"}, time.Now())
 
-func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
-	err := func() error {
-		if err := f.store.Snapshot(sink); err != nil {
-			return errors.Wrap(err, "failed to snapshot store")
-		}
-
-		return nil
-	}()
+	r, cleanup, err := f.openSnapshot(rc)
 	if err != nil {
-		if err := sink.Cancel(); err != nil {
-			return errors.Wrap(err, "failed to cancel snapshot")
-		}
+		return err
 	}
+	defer cleanup()
 
+	err = f.store.Restore(r)
+	if f.hub != nil {
+		f.hub.Invalidate()
+	}
+	// A snapshot is only restored once it is past every entry this node
+	// has, including the one the FSM halted at.
+	if err == nil {
+		f.halted.Store(0)
+	}
 	return err
 }
 
-func (f *fsmSnapshot) Release() {}
+func (f *fsmSnapshot) Release() {
+	f.view.Release()
+}
@@ -0,0 +1,66 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// quarantinePrefix holds the log entries the FSM could not decode, keyed by
+// their index, so that operators can inspect them with rune-cli operator raft
+// quarantined. Whether an entry decodes only depends on its data, and nodes
+// stop at entries of newer versions rather than quarantine them, so every
+// node quarantines the same entries and the records are part of the
+// replicated state like any other write.
+const quarantinePrefix = "core/raft/quarantine/"
+
+// QuarantinedEntry is a log entry the FSM skipped instead of applying.
+type QuarantinedEntry struct {
+	Index  uint64 `json:"index"`
+	Term   uint64 `json:"term"`
+	Reason string `json:"reason"`
+	Data   []byte `json:"data"`
+}
+
+// quarantineOp returns the write that records entry as skipped.
+func quarantineOp(entry *raft.Log, reason error) storage.TxnOp {
+	log.Printf("Quarantining raft log entry %d: %v", entry.Index, reason)
+
+	data, err := json.Marshal(QuarantinedEntry{
+		Index:  entry.Index,
+		Term:   entry.Term,
+		Reason: reason.Error(),
+		Data:   entry.Data,
+	})
+	if err != nil {
+		panic(errors.Wrapf(err, "failed to quarantine log entry %d", entry.Index))
+	}
+	return storage.TxnOp{Op: storage.TxnPut, Key: quarantineKey(entry.Index), Value: data}
+}
+
+// Quarantined returns the log entries the FSM skipped, by index.
+func Quarantined(ctx context.Context, store storage.Storage) ([]QuarantinedEntry, error) {
+	var entries []QuarantinedEntry
+	err := storage.Walk(ctx, store, quarantinePrefix, func(key string, value []byte) error {
+		var entry QuarantinedEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return errors.Wrapf(err, "failed to decode quarantined log entry %s", key)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read quarantined log entries")
+	}
+	return entries, nil
+}
+
+// quarantineKey pads the index so that entries list in log order.
+func quarantineKey(index uint64) string {
+	return fmt.Sprintf("%s%020d", quarantinePrefix, index)
+}
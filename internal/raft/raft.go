@@ -1,13 +1,17 @@
 package raft
 
 import (
+	"context"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
 	"github.com/pkg/errors"
+	"github.com/thelamedev/rune/internal/consensus"
 
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 )
@@ -19,14 +23,33 @@ type Config struct {
 	DataDir   string
 }
 
+// defaultApplyTimeout bounds how long a proposal may wait to be enqueued when
+// the caller's context has no deadline.
+const defaultApplyTimeout = 10 * time.Second
+
 type RaftNode struct {
-	config *Config
-	raft   *raft.Raft
+	config    *Config
+	raft      *raft.Raft
+	fsm       raft.FSM
+	transport *contactTransport
+
+	logStore    *raftboltdb.BoltStore
+	stableStore *raftboltdb.BoltStore
+
+	// failing holds, for every peer that stopped answering the leader's
+	// heartbeats, when it was last heard from.
+	observer     *raft.Observer
+	observations chan raft.Observation
+	contactMu    sync.Mutex
+	failing      map[raft.ServerID]time.Time
 }
 
 func NewRaftNode(cfg *Config, fsm raft.FSM) (*RaftNode, error) {
 	raftConfig := raft.DefaultConfig()
 	raftConfig.LocalID = raft.ServerID(cfg.NodeID)
+	// Let proposals queue up while the leader writes the log, so that they
+	// are committed, and applied by the FSM, in batches.
+	raftConfig.BatchApplyCh = true
 
 	if err := os.MkdirAll(cfg.DataDir, 0o700); err != nil {
 		return nil, errors.Wrap(err, "failed to create data directory")
@@ -36,11 +59,17 @@ func NewRaftNode(cfg *Config, fsm raft.FSM) (*RaftNode, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to resolve bind address")
 	}
+	// With port 0, advertise the port the transport ends up listening on.
+	var advertise net.Addr = addr
+	if addr.Port == 0 {
+		advertise = nil
+	}
 
-	transport, err := raft.NewTCPTransport(cfg.BindAddr, addr, 3, 10*time.Second, os.Stderr)
+	tcp, err := raft.NewTCPTransport(cfg.BindAddr, advertise, 3, 10*time.Second, os.Stderr)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create transport")
 	}
+	transport := newContactTransport(tcp)
 
 	snapshots, err := raft.NewFileSnapshotStore(cfg.DataDir, 2, os.Stderr)
 	if err != nil {
@@ -67,19 +96,228 @@ func NewRaftNode(cfg *Config, fsm raft.FSM) (*RaftNode, error) {
 			Servers: []raft.Server{
 				{
 					ID:      raft.ServerID(cfg.NodeID),
-					Address: raft.ServerAddress(cfg.BindAddr),
+					Address: transport.LocalAddr(),
 				},
 			},
 		}
 
+		// Bootstrapping a node that already has state is a no-op, so that a
+		// node can be restarted with the same flags it was first started with.
 		bootstrapFuture := r.BootstrapCluster(configuration)
-		if err := bootstrapFuture.Error(); err != nil {
+		if err := bootstrapFuture.Error(); err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
 			return nil, errors.Wrap(err, "failed to bootstrap cluster")
 		}
 	}
 
-	return &RaftNode{
-		config: cfg,
-		raft:   r,
-	}, nil
+	n := &RaftNode{
+		config:      cfg,
+		raft:        r,
+		fsm:         fsm,
+		transport:   transport,
+		logStore:    logStore,
+		stableStore: stableStore,
+		failing:     make(map[raft.ServerID]time.Time),
+	}
+	n.observeHeartbeats()
+	return n, nil
+}
+
+// observeHeartbeats tracks which peers fail to answer heartbeats while this
+// node leads, and forgets the contacts the transport recorded whenever it
+// starts or stops leading, for LastContact.
+func (n *RaftNode) observeHeartbeats() {
+	n.observations = make(chan raft.Observation, 16)
+	n.observer = raft.NewObserver(n.observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.FailedHeartbeatObservation, raft.ResumedHeartbeatObservation, raft.RaftState:
+			return true
+		}
+		return false
+	})
+	n.raft.RegisterObserver(n.observer)
+
+	go func() {
+		for o := range n.observations {
+			n.contactMu.Lock()
+			switch data := o.Data.(type) {
+			case raft.FailedHeartbeatObservation:
+				n.failing[data.PeerID] = data.LastContact
+			case raft.ResumedHeartbeatObservation:
+				delete(n.failing, data.PeerID)
+			case raft.RaftState:
+				// Heartbeats are only sent, and failures reported, by the
+				// leader.
+				clear(n.failing)
+				n.transport.reset()
+			}
+			n.contactMu.Unlock()
+		}
+	}()
+}
+
+// Apply proposes cmd to the cluster and blocks until it has been committed
+// and applied to the local FSM. It returns the value the FSM returned for the
+// command. Only the leader can accept proposals; followers fail with
+// raft.ErrNotLeader.
+func (n *RaftNode) Apply(ctx context.Context, cmd *consensus.Command) (any, error) {
+	data, err := consensus.Encode(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := enqueueTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	future := n.raft.Apply(data, timeout)
+	if err := wait(ctx, future); err != nil {
+		return nil, errors.Wrap(err, "failed to apply command")
+	}
+
+	return future.Response(), nil
+}
+
+// Barrier blocks until every log entry committed before it has been applied
+// to the local FSM. Committing the barrier confirms with a quorum that this
+// node is still the leader, so reads made afterwards observe every write
+// acknowledged before Barrier was called. Followers fail with
+// raft.ErrNotLeader.
+func (n *RaftNode) Barrier(ctx context.Context) error {
+	timeout, err := enqueueTimeout(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := wait(ctx, n.raft.Barrier(timeout)); err != nil {
+		return errors.Wrap(err, "failed to commit barrier")
+	}
+	return nil
+}
+
+// enqueueTimeout returns how long a proposal made under ctx may wait to be
+// enqueued. Raft takes a timeout of zero to mean none at all, so a deadline
+// that has already passed fails here instead.
+func enqueueTimeout(ctx context.Context) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return defaultApplyTimeout, nil
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return 0, context.DeadlineExceeded
+	}
+	return timeout, nil
+}
+
+// wait blocks until future is done, or ctx is. A proposal given up on when
+// ctx is done may still be committed.
+func wait(ctx context.Context, future raft.Future) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- future.Error()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsLeader reports whether this node is currently the cluster leader.
+func (n *RaftNode) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Leader returns the raft address and ID of the current leader, or empty
+// values if there is no known leader.
+func (n *RaftNode) Leader() (string, string) {
+	addr, id := n.raft.LeaderWithID()
+	return string(addr), string(id)
+}
+
+// Addr returns the address other nodes reach this node's Raft transport at.
+func (n *RaftNode) Addr() string {
+	return string(n.transport.LocalAddr())
+}
+
+// LastContact returns when the leader last heard from the peer with the
+// given ID, which is the zero time if it has not yet, and whether the peer
+// has stopped answering heartbeats. It only knows about peers while this
+// node is the leader.
+func (n *RaftNode) LastContact(id string) (time.Time, bool) {
+	contact := n.transport.lastContact(raft.ServerID(id))
+
+	n.contactMu.Lock()
+	defer n.contactMu.Unlock()
+	failedAt, failing := n.failing[raft.ServerID(id)]
+	if failedAt.After(contact) {
+		contact = failedAt
+	}
+	return contact, failing
+}
+
+// LeaderContact returns when this node last heard from the leader, which is
+// the zero time if it never has. It is only meaningful on followers.
+func (n *RaftNode) LeaderContact() time.Time {
+	return n.raft.LastContact()
+}
+
+// ServerStats is the Raft state of a node, which autopilot on the leader
+// compares with its own to judge the node's health.
+type ServerStats struct {
+	LastIndex uint64
+	Term      uint64
+	// LastContact is when the node last heard from the leader. It is the
+	// zero time if it never has, and the current time on the leader.
+	LastContact time.Time
+	// Halted is the index of the log entry the node's FSM stopped applying
+	// at, or zero while it applies the log.
+	Halted uint64
+}
+
+// Stats returns the Raft state of this node.
+func (n *RaftNode) Stats() ServerStats {
+	stats := ServerStats{LastIndex: n.raft.LastIndex(), LastContact: n.raft.LastContact()}
+	stats.Halted, _ = n.Halted()
+	stats.Term, _ = strconv.ParseUint(n.raft.Stats()["term"], 10, 64)
+	if n.IsLeader() {
+		stats.LastContact = time.Now()
+	}
+	return stats
+}
+
+// Halted returns the index of the log entry the FSM stopped applying at, if
+// it has, because the entry was written by a newer version of Rune.
+func (n *RaftNode) Halted() (uint64, bool) {
+	if f, ok := n.fsm.(interface{ Halted() (uint64, bool) }); ok {
+		return f.Halted()
+	}
+	return 0, false
+}
+
+// AppliedIndex returns the index of the last log entry applied to the FSM.
+func (n *RaftNode) AppliedIndex() uint64 {
+	return n.raft.AppliedIndex()
+}
+
+// Shutdown stops the raft node and closes its log and stable stores.
+func (n *RaftNode) Shutdown() error {
+	if err := n.raft.Shutdown().Error(); err != nil {
+		return errors.Wrap(err, "failed to shutdown raft")
+	}
+	n.raft.DeregisterObserver(n.observer)
+	close(n.observations)
+	if err := n.logStore.Close(); err != nil {
+		return errors.Wrap(err, "failed to close log store")
+	}
+	if err := n.stableStore.Close(); err != nil {
+		return errors.Wrap(err, "failed to close stable store")
+	}
+	return nil
 }
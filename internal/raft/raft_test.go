@@ -3,6 +3,8 @@ package raft
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -10,10 +12,21 @@ import (
 
 	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/require"
+	"github.com/thelamedev/rune/internal/consensus"
 	"github.com/thelamedev/rune/internal/storage"
+	"github.com/thelamedev/rune/internal/watch"
 )
 
-func newTestRaftNode(t *testing.T, bootstrap bool, nodeID, dataDir string) (*RaftNode, *storage.BoltStore) {
+// legacyCommand is how commands were encoded in the log before they were
+// versioned. Nodes still apply such entries.
+type legacyCommand struct {
+	Op    string          `json:"op,omitempty"`
+	Key   string          `json:"key,omitempty"`
+	Value []byte          `json:"value,omitempty"`
+	Ops   []storage.TxnOp `json:"ops,omitempty"`
+}
+
+func newTestRaftNode(t testing.TB, bootstrap bool, nodeID, dataDir string) (*RaftNode, *storage.BoltStore) {
 	t.Helper()
 
 	storePath := filepath.Join(dataDir, "store.db")
@@ -35,14 +48,15 @@ func newTestRaftNode(t *testing.T, bootstrap bool, nodeID, dataDir string) (*Raf
 	require.NoError(t, err)
 
 	t.Cleanup(func() {
-		shutdownFuture := node.raft.Shutdown()
-		require.NoError(t, shutdownFuture.Error(), "failed to shutdown raft node")
+		require.NoError(t, node.Shutdown(), "failed to shutdown raft node")
 		require.NoError(t, store.Close(), "failed to close bolt store")
 	})
 
 	return node, store
 }
 
+// TestRaftNode_SingleNode_Apply applies legacy JSON entries directly to the
+// log.
 func TestRaftNode_SingleNode_Apply(t *testing.T) {
 	dataDir := t.TempDir()
 	node, store := newTestRaftNode(t, true, "node-1", dataDir)
@@ -57,7 +71,7 @@ func TestRaftNode_SingleNode_Apply(t *testing.T) {
 
 	// 1. Test a 'set' operation.
 	t.Run("set operation", func(t *testing.T) {
-		setCmd := command{
+		setCmd := legacyCommand{
 			Op:    "set",
 			Key:   "hello",
 			Value: []byte("world"),
@@ -80,9 +94,30 @@ func TestRaftNode_SingleNode_Apply(t *testing.T) {
 		}, 2*time.Second, 300*time.Millisecond, "value was not set in store")
 	})
 
-	// 2. Test a 'delete' operation.
+	// 2. Test a 'txn' operation.
+	t.Run("txn operation", func(t *testing.T) {
+		txnCmd := legacyCommand{
+			Op: "txn",
+			Ops: []storage.TxnOp{
+				{Op: storage.TxnCheck, Key: "hello", Value: []byte("world")},
+				{Op: storage.TxnPut, Key: "hello/meta", Value: []byte("v1")},
+			},
+		}
+		cmdBytes, err := json.Marshal(txnCmd)
+		require.NoError(t, err)
+
+		applyFuture := node.raft.Apply(cmdBytes, 500*time.Millisecond)
+		require.NoError(t, applyFuture.Error(), "failed to apply 'txn' command")
+		requireResult(t, consensus.ResultOK, applyFuture.Response())
+
+		val, err := store.Get(ctx, "hello/meta")
+		require.NoError(t, err)
+		require.Equal(t, "v1", string(val))
+	})
+
+	// 3. Test a 'delete' operation.
 	t.Run("delete operation", func(t *testing.T) {
-		deleteCmd := command{
+		deleteCmd := legacyCommand{
 			Op:  "delete",
 			Key: "hello",
 		}
@@ -101,3 +136,294 @@ func TestRaftNode_SingleNode_Apply(t *testing.T) {
 		}, 2*time.Second, 50*time.Millisecond, "value was not deleted from store")
 	})
 }
+
+func TestRaftNode_ApplyHonorsContext(t *testing.T) {
+	node, _ := newTestRaftNode(t, true, "node-1", t.TempDir())
+	require.Eventually(t, node.IsLeader, 3*time.Second, 50*time.Millisecond, "node never became leader")
+
+	t.Run("expired deadline", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		_, err := node.Apply(ctx, consensus.NewPut("k", []byte("v")))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.ErrorIs(t, node.Barrier(ctx), context.DeadlineExceeded)
+	})
+
+	t.Run("deadline while waiting for a quorum", func(t *testing.T) {
+		// With a second voter that never answers, nothing commits until the
+		// leader steps down.
+		require.NoError(t, node.raft.AddVoter("node-2", raft.ServerAddress(unusedAddr(t)), 0, 0).Error())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := node.Apply(ctx, consensus.NewPut("k", []byte("v")))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 400*time.Millisecond, "apply outlived its deadline")
+	})
+}
+
+func TestFSM_AppliesThroughCache(t *testing.T) {
+	ctx := context.Background()
+	cache := storage.NewCache(storage.NewMemStore(), storage.CacheOptions{MaxEntries: 16})
+	require.NoError(t, cache.Put(ctx, "hello", []byte("world")))
+
+	// Warm the cache, as a follower serving reads would.
+	val, err := cache.Get(ctx, "hello")
+	require.NoError(t, err)
+	require.Equal(t, "world", string(val))
+
+	cmdBytes, err := consensus.Encode(consensus.NewPut("hello", []byte("raft")))
+	require.NoError(t, err)
+	requireResult(t, consensus.ResultOK, NewFSM(cache).Apply(&raft.Log{Data: cmdBytes}))
+
+	val, err = cache.Get(ctx, "hello")
+	require.NoError(t, err)
+	require.Equal(t, "raft", string(val), "FSM apply did not invalidate the cache")
+}
+
+func TestFSM_PublishesWatchEvents(t *testing.T) {
+	hub := watch.NewHub(0)
+	sub, err := hub.Subscribe("secrets/", 0)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	fsm := NewFSM(storage.NewMemStore(), WithWatchHub(hub))
+	apply := func(index uint64, cmd *consensus.Command) any {
+		data, err := consensus.Encode(cmd)
+		require.NoError(t, err)
+		return fsm.Apply(&raft.Log{Index: index, Data: data})
+	}
+
+	requireResult(t, consensus.ResultOK, apply(5, consensus.NewPut("secrets/db", []byte("v1"))))
+	requireResult(t, consensus.ResultConflict, apply(6, consensus.NewTxn([]storage.TxnOp{
+		{Op: storage.TxnCheckExists, Key: "secrets/missing"},
+		{Op: storage.TxnPut, Key: "secrets/api", Value: []byte("v1")},
+	})))
+	requireResult(t, consensus.ResultOK, apply(7, consensus.NewDelete("secrets/db")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, want := range []watch.Event{
+		{Index: 5, Key: "secrets/db", Op: watch.OpPut},
+		{Index: 7, Key: "secrets/db", Op: watch.OpDelete},
+	} {
+		got, err := sub.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, want, got, "failed writes must not publish events")
+	}
+}
+
+func TestFSM_QuarantinesInvalidEntries(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStore()
+	fsm := NewFSM(store)
+
+	res := requireResult(t, consensus.ResultInvalid, fsm.Apply(&raft.Log{Index: 3, Term: 2, Data: []byte("garbage")}))
+	require.ErrorIs(t, res.Err, consensus.ErrInvalidCommand)
+
+	quarantined, err := Quarantined(ctx, store)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	require.Equal(t, uint64(3), quarantined[0].Index)
+	require.Equal(t, uint64(2), quarantined[0].Term)
+	require.Equal(t, []byte("garbage"), quarantined[0].Data)
+	require.Contains(t, quarantined[0].Reason, consensus.ErrInvalidCommand.Error())
+
+	// Later entries are applied as usual.
+	data, err := consensus.Encode(consensus.NewPut("hello", []byte("world")))
+	require.NoError(t, err)
+	requireResult(t, consensus.ResultOK, fsm.Apply(&raft.Log{Index: 4, Data: data}))
+}
+
+func TestFSM_HaltsOnNewerVersions(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStore()
+	fsm := NewFSM(store).(*fsm)
+
+	encode := func(cmd *consensus.Command) []byte {
+		data, err := consensus.Encode(cmd)
+		require.NoError(t, err)
+		return data
+	}
+	future := consensus.NewPut("future", []byte("from the future"))
+	future.Version = consensus.Version + 1
+
+	// Commands of a newer version are valid on upgraded nodes, so skipping
+	// them here would diverge this node. It stops applying the log instead,
+	// from the newer entry on.
+	resps := fsm.ApplyBatch([]*raft.Log{
+		{Index: 1, Type: raft.LogCommand, Data: encode(consensus.NewPut("before", []byte("v")))},
+		{Index: 2, Type: raft.LogCommand, Data: encode(future)},
+		{Index: 3, Type: raft.LogCommand, Data: encode(consensus.NewPut("after", []byte("v")))},
+	})
+	requireResult(t, consensus.ResultOK, resps[0])
+	for _, resp := range resps[1:] {
+		res := requireResult(t, consensus.ResultInvalid, resp)
+		require.ErrorIs(t, res.Err, consensus.ErrUnsupportedVersion)
+	}
+	res := requireResult(t, consensus.ResultInvalid, fsm.Apply(&raft.Log{Index: 4, Data: encode(consensus.NewPut("later", []byte("v")))}))
+	require.ErrorIs(t, res.Err, consensus.ErrUnsupportedVersion)
+
+	index, halted := fsm.Halted()
+	require.True(t, halted)
+	require.Equal(t, uint64(2), index)
+	for _, key := range []string{"future", "after", "later"} {
+		_, err := store.Get(ctx, key)
+		require.ErrorIs(t, err, storage.ErrKeyNotFound, "expected %s not to be applied", key)
+	}
+	quarantined, err := Quarantined(ctx, store)
+	require.NoError(t, err)
+	require.Empty(t, quarantined, "expected the newer entry not to be quarantined")
+
+	// A snapshot would let Raft compact away the entries the FSM skipped.
+	_, err = fsm.Snapshot()
+	require.Error(t, err)
+
+	// Restoring a snapshot from the leader, which is past the newer entry,
+	// lets the FSM carry on.
+	source := storage.NewMemStore()
+	require.NoError(t, source.Put(ctx, "future", []byte("from the future")))
+	sink := persistSnapshot(t, NewFSM(source))
+	require.NoError(t, fsm.Restore(io.NopCloser(sink)))
+	_, halted = fsm.Halted()
+	require.False(t, halted)
+	requireResult(t, consensus.ResultOK, fsm.Apply(&raft.Log{Index: 5, Data: encode(consensus.NewPut("later", []byte("v")))}))
+}
+
+func TestFSM_Results(t *testing.T) {
+	fsm := NewFSM(storage.NewMemStore())
+	apply := func(cmd *consensus.Command) *consensus.Result {
+		data, err := consensus.Encode(cmd)
+		require.NoError(t, err)
+		res, ok := fsm.Apply(&raft.Log{Data: data}).(*consensus.Result)
+		require.True(t, ok)
+		return res
+	}
+
+	require.Equal(t, consensus.ResultOK, apply(consensus.NewPut("hello", []byte("world"))).Code)
+	require.Equal(t, consensus.ResultOK, apply(consensus.NewDelete("hello")).Code)
+
+	res := apply(consensus.NewTxn([]storage.TxnOp{{Op: storage.TxnCheck, Key: "hello", Value: []byte("world")}}))
+	require.Equal(t, consensus.ResultConflict, res.Code)
+	require.ErrorIs(t, res.Err, storage.ErrTxnCheckFailed)
+}
+
+func TestFSM_RejectsKeysTheStoreCannotStore(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewFileStore(t.TempDir())
+	require.NoError(t, store.Initialize(ctx))
+	fsm := NewFSM(store)
+
+	// The key is valid for BoltDB, but too long for a file name.
+	data, err := consensus.Encode(consensus.NewPut(strings.Repeat("x", 300), []byte("v")))
+	require.NoError(t, err)
+	res := requireResult(t, consensus.ResultInvalid, fsm.Apply(&raft.Log{Index: 1, Data: data}))
+	require.ErrorIs(t, res.Err, storage.ErrInvalidKey)
+
+	data, err = consensus.Encode(consensus.NewPut("hello", []byte("world")))
+	require.NoError(t, err)
+	requireResult(t, consensus.ResultOK, fsm.Apply(&raft.Log{Index: 2, Data: data}))
+}
+
+// countingBatches counts the storage batches that reach the wrapped store.
+type countingBatches struct {
+	*storage.MemStore
+	batches int
+}
+
+func (s *countingBatches) Batch(ctx context.Context, txns [][]storage.TxnOp) ([]error, error) {
+	s.batches++
+	return s.MemStore.Batch(ctx, txns)
+}
+
+func TestFSM_ApplyBatch(t *testing.T) {
+	ctx := context.Background()
+	store := &countingBatches{MemStore: storage.NewMemStore()}
+	hub := watch.NewHub(0)
+	sub, err := hub.Subscribe("", 0)
+	require.NoError(t, err)
+	defer sub.Close()
+	fsm := NewFSM(store, WithWatchHub(hub)).(raft.BatchingFSM)
+
+	entry := func(index uint64, cmd *consensus.Command) *raft.Log {
+		data, err := consensus.Encode(cmd)
+		require.NoError(t, err)
+		return &raft.Log{Index: index, Type: raft.LogCommand, Data: data}
+	}
+	resps := fsm.ApplyBatch([]*raft.Log{
+		entry(1, consensus.NewPut("a", []byte("1"))),
+		{Index: 2, Type: raft.LogConfiguration},
+		{Index: 3, Type: raft.LogCommand, Data: []byte("garbage")},
+		entry(4, consensus.NewBatch([]*consensus.Command{
+			consensus.NewTxn([]storage.TxnOp{{Op: storage.TxnCheck, Key: "a"}}),
+			consensus.NewPut("b", []byte("2")),
+		})),
+		entry(5, consensus.NewDelete("a")),
+	})
+	require.Len(t, resps, 5)
+	require.Equal(t, 1, store.batches, "expected the entries to be written in one batch")
+
+	requireResult(t, consensus.ResultOK, resps[0])
+	require.Nil(t, resps[1])
+	requireResult(t, consensus.ResultInvalid, resps[2])
+	results, ok := resps[3].([]*consensus.Result)
+	require.True(t, ok, "expected one result for each command of the batch, got %T", resps[3])
+	require.Len(t, results, 2)
+	require.Equal(t, consensus.ResultConflict, results[0].Code)
+	require.Equal(t, consensus.ResultOK, results[1].Code)
+	requireResult(t, consensus.ResultOK, resps[4])
+
+	_, err = store.Get(ctx, "a")
+	require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	val, err := store.Get(ctx, "b")
+	require.NoError(t, err)
+	require.Equal(t, "2", string(val))
+	quarantined, err := Quarantined(ctx, store)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+
+	// Only the writes that were applied are published, and the quarantine
+	// record is not a write of the cluster's.
+	for _, want := range []watch.Event{
+		{Index: 1, Key: "a", Op: watch.OpPut},
+		{Index: 4, Key: "b", Op: watch.OpPut},
+		{Index: 5, Key: "a", Op: watch.OpDelete},
+	} {
+		got, err := sub.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+}
+
+// brokenStore fails every write, like a full or corrupted disk.
+type brokenStore struct {
+	storage.Storage
+}
+
+func (brokenStore) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	return errors.New("disk I/O error")
+}
+
+func TestFSM_PanicsOnStorageFailure(t *testing.T) {
+	fsm := NewFSM(brokenStore{storage.NewMemStore()})
+
+	data, err := consensus.Encode(consensus.NewPut("hello", []byte("world")))
+	require.NoError(t, err)
+	require.PanicsWithError(t, "failed to apply log entry 9: disk I/O error", func() {
+		fsm.Apply(&raft.Log{Index: 9, Data: data})
+	})
+
+	// Nor can it record entries it skips.
+	require.Panics(t, func() {
+		fsm.Apply(&raft.Log{Index: 10, Data: []byte("garbage")})
+	})
+}
+
+func requireResult(t *testing.T, want consensus.ResultCode, resp any) *consensus.Result {
+	t.Helper()
+	res, ok := resp.(*consensus.Result)
+	require.True(t, ok, "FSM returned %T, not a result", resp)
+	require.Equal(t, want, res.Code, "unexpected result: %v", res.Err)
+	return res
+}
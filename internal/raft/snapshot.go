@@ -0,0 +1,326 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/raft"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// SnapshotCompression is how the FSM compresses the store snapshots it
+// persists.
+type SnapshotCompression uint8
+
+const (
+	SnapshotCompressionNone SnapshotCompression = iota
+	SnapshotCompressionGzip
+	SnapshotCompressionZstd
+)
+
+var snapshotCompressionNames = map[SnapshotCompression]string{
+	SnapshotCompressionNone: "none",
+	SnapshotCompressionGzip: "gzip",
+	SnapshotCompressionZstd: "zstd",
+}
+
+func (c SnapshotCompression) String() string {
+	if name, ok := snapshotCompressionNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("SnapshotCompression(%d)", uint8(c))
+}
+
+// ParseSnapshotCompression returns the compression called name: none, gzip
+// or zstd.
+func ParseSnapshotCompression(name string) (SnapshotCompression, error) {
+	for c, n := range snapshotCompressionNames {
+		if n == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown snapshot compression %q", name)
+}
+
+// snapshotMagic starts every snapshot the FSM persists. Snapshots persisted
+// before it hold the bare store snapshot, which Restore still reads.
+var snapshotMagic = [8]byte{'R', 'U', 'N', 'E', 'S', 'N', 'A', 'P'}
+
+// snapshotVersion is the version of the snapshot format this build writes,
+// and the newest it can read.
+const snapshotVersion = 1
+
+// ErrSnapshotChecksum is returned when restoring a snapshot whose contents do
+// not match the checksum it was persisted with.
+var ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+
+// snapshotHeader precedes the compressed store snapshot. It describes the
+// store snapshot before compression, so that Restore can verify it before
+// handing it to the store.
+type snapshotHeader struct {
+	Magic       [8]byte
+	Version     uint8
+	Compression SnapshotCompression
+	Size        uint64
+	Checksum    [sha256.Size]byte
+}
+
+// Persist copies the snapshot view to a local file first, and releases it
+// before compressing the file into the sink. A view such as the read
+// transaction of BoltDB is only held for as long as writing to local disk
+// takes, however slowly the sink is sent to a follower.
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	defer metrics.MeasureSince([]string{"rune", "raft", "snapshot", "persist"}, time.Now())
+
+	err := f.persist(sink)
+	if err != nil {
+		if err := sink.Cancel(); err != nil {
+			return errors.Wrap(err, "failed to cancel snapshot")
+		}
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) persist(sink raft.SnapshotSink) error {
+	staged, err := os.CreateTemp(f.dir, "snapshot-*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create snapshot staging file")
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	header := snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion, Compression: f.compression}
+	sum := sha256.New()
+	buf := bufio.NewWriter(io.MultiWriter(staged, sum))
+	counter := &countingWriter{w: buf}
+	if err := f.view.Snapshot(counter); err != nil {
+		return errors.Wrap(err, "failed to snapshot store")
+	}
+	if err := buf.Flush(); err != nil {
+		return errors.Wrap(err, "failed to stage snapshot")
+	}
+	f.view.Release()
+	header.Size = uint64(counter.n)
+	sum.Sum(header.Checksum[:0])
+
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to rewind staged snapshot")
+	}
+
+	out := &countingWriter{w: sink, progress: []string{"rune", "raft", "snapshot", "written"}}
+	if err := binary.Write(out, binary.BigEndian, &header); err != nil {
+		return errors.Wrap(err, "failed to write snapshot header")
+	}
+	compressor, err := newCompressor(out, f.compression)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(compressor, staged); err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+	if err := compressor.Close(); err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+
+	metrics.SetGauge([]string{"rune", "raft", "snapshot", "size"}, float32(header.Size))
+	metrics.SetGauge([]string{"rune", "raft", "snapshot", "compressed_size"}, float32(out.n))
+	log.Printf("Persisted snapshot %s: %d bytes, %d with %s compression", sink.ID(), header.Size, out.n, f.compression)
+	return nil
+}
+
+// openSnapshot returns the store snapshot held by r, staged to a local file
+// and verified against its checksum, so that a corrupt snapshot is rejected
+// before the store replaces any of its data. Snapshots persisted before they
+// had a header are returned as they are. The returned cleanup removes the
+// staged file.
+func (f *fsm) openSnapshot(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(snapshotMagic))
+	if err != nil || !bytes.Equal(magic, snapshotMagic[:]) {
+		return br, func() {}, nil
+	}
+
+	var header snapshotHeader
+	if err := binary.Read(br, binary.BigEndian, &header); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read snapshot header")
+	}
+	if header.Version > snapshotVersion {
+		return nil, nil, fmt.Errorf("unsupported snapshot version %d, this node supports up to %d", header.Version, snapshotVersion)
+	}
+	decompressor, err := newDecompressor(br, header.Compression)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer decompressor.Close()
+
+	staged, err := os.CreateTemp(f.snapshotDir, "restore-*.tmp")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create snapshot staging file")
+	}
+	cleanup := func() {
+		staged.Close()
+		os.Remove(staged.Name())
+	}
+
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(staged, sum), decompressor)
+	if err == nil {
+		err = verifySnapshot(header, uint64(n), sum)
+	}
+	if err == nil {
+		_, err = staged.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.Wrap(err, "failed to read snapshot")
+	}
+
+	metrics.SetGauge([]string{"rune", "raft", "snapshot", "restored_size"}, float32(n))
+	return bufio.NewReader(staged), cleanup, nil
+}
+
+func verifySnapshot(header snapshotHeader, size uint64, sum hash.Hash) error {
+	if size != header.Size {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrSnapshotChecksum, header.Size, size)
+	}
+	if !bytes.Equal(sum.Sum(nil), header.Checksum[:]) {
+		return ErrSnapshotChecksum
+	}
+	return nil
+}
+
+func newCompressor(w io.Writer, c SnapshotCompression) (io.WriteCloser, error) {
+	switch c {
+	case SnapshotCompressionNone:
+		return nopWriteCloser{w}, nil
+	case SnapshotCompressionGzip:
+		return gzip.NewWriter(w), nil
+	case SnapshotCompressionZstd:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd encoder")
+		}
+		return enc, nil
+	}
+	return nil, fmt.Errorf("unknown snapshot compression %v", c)
+}
+
+func newDecompressor(r io.Reader, c SnapshotCompression) (io.ReadCloser, error) {
+	switch c {
+	case SnapshotCompressionNone:
+		return io.NopCloser(r), nil
+	case SnapshotCompressionGzip:
+		dec, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read gzip snapshot")
+		}
+		return dec, nil
+	case SnapshotCompressionZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read zstd snapshot")
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown snapshot compression %v", c)
+}
+
+// countingWriter counts the bytes written through it, and reports them to
+// the progress gauge if it has one.
+type countingWriter struct {
+	w        io.Writer
+	n        int64
+	progress []string
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if c.progress != nil {
+		metrics.SetGauge(c.progress, float32(c.n))
+	}
+	return n, err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ErrExistingState is returned by SeedSnapshot for a data directory that
+// already holds the Raft state of a node.
+var ErrExistingState = errors.New("data directory already holds raft state")
+
+// SeedSnapshot writes the contents of store to dataDir as the first snapshot
+// of a new cluster, whose only member is the node nodeID at addr. Started
+// with dataDir, that node restores the snapshot into its local store and
+// leads the cluster, and sends the snapshot to every node that joins it, so
+// that data copied into storage offline is replicated like any other.
+func SeedSnapshot(dataDir, nodeID, addr string, store storage.Storage) (raft.SnapshotMeta, error) {
+	for _, name := range []string{"raft-log.db", "raft-stable.db"} {
+		if _, err := os.Stat(filepath.Join(dataDir, name)); err == nil {
+			return raft.SnapshotMeta{}, errors.Wrapf(ErrExistingState, "%s exists", filepath.Join(dataDir, name))
+		}
+	}
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return raft.SnapshotMeta{}, errors.Wrap(err, "failed to create data directory")
+	}
+	snapshots, err := raft.NewFileSnapshotStore(dataDir, 2, os.Stderr)
+	if err != nil {
+		return raft.SnapshotMeta{}, errors.Wrap(err, "failed to create snapshot store")
+	}
+	if existing, err := snapshots.List(); err != nil || len(existing) > 0 {
+		return raft.SnapshotMeta{}, errors.Wrapf(ErrExistingState, "%s holds snapshots", dataDir)
+	}
+
+	configuration := raft.Configuration{Servers: []raft.Server{{
+		Suffrage: raft.Voter,
+		ID:       raft.ServerID(nodeID),
+		Address:  raft.ServerAddress(addr),
+	}}}
+	snap, err := NewFSM(store, WithSnapshotDir(dataDir)).Snapshot()
+	if err != nil {
+		return raft.SnapshotMeta{}, err
+	}
+	defer snap.Release()
+
+	// The snapshot stands in for the first entry of the log, which would
+	// otherwise hold the configuration a bootstrapped node starts with. The
+	// transport only encodes the peer addresses, as the TCP transport does.
+	_, trans := raft.NewInmemTransport("")
+	defer trans.Close()
+	sink, err := snapshots.Create(raft.SnapshotVersionMax, 1, 1, configuration, 1, trans)
+	if err != nil {
+		return raft.SnapshotMeta{}, errors.Wrap(err, "failed to create snapshot")
+	}
+	if err := snap.Persist(sink); err != nil {
+		return raft.SnapshotMeta{}, err
+	}
+	if err := sink.Close(); err != nil {
+		return raft.SnapshotMeta{}, errors.Wrap(err, "failed to write snapshot")
+	}
+
+	metas, err := snapshots.List()
+	if err != nil {
+		return raft.SnapshotMeta{}, errors.Wrap(err, "failed to list snapshots")
+	}
+	if len(metas) == 0 {
+		return raft.SnapshotMeta{}, errors.New("snapshot was not written")
+	}
+	return *metas[0], nil
+}
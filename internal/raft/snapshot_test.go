@@ -0,0 +1,201 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// bufferSink is a snapshot sink that keeps the snapshot in memory.
+type bufferSink struct {
+	bytes.Buffer
+	cancelled bool
+}
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Close() error  { return nil }
+func (s *bufferSink) Cancel() error { s.cancelled = true; return nil }
+
+func persistSnapshot(t *testing.T, f raft.FSM) *bufferSink {
+	t.Helper()
+	snap, err := f.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+
+	sink := &bufferSink{}
+	require.NoError(t, snap.Persist(sink))
+	return sink
+}
+
+func newSnapshotStore(t *testing.T) storage.Storage {
+	t.Helper()
+	store := storage.NewMemStore()
+	for i := range 100 {
+		value := bytes.Repeat([]byte{byte(i)}, 1024)
+		require.NoError(t, store.Put(context.Background(), fmt.Sprintf("secrets/%03d", i), value))
+	}
+	return store
+}
+
+func TestFSMSnapshot_RoundTrip(t *testing.T) {
+	for _, compression := range []SnapshotCompression{SnapshotCompressionNone, SnapshotCompressionGzip, SnapshotCompressionZstd} {
+		t.Run(compression.String(), func(t *testing.T) {
+			ctx := context.Background()
+			source := newSnapshotStore(t)
+			sink := persistSnapshot(t, NewFSM(source, WithSnapshotCompression(compression), WithSnapshotDir(t.TempDir())))
+			if compression != SnapshotCompressionNone {
+				require.Less(t, sink.Len(), 100*1024/10, "snapshot was not compressed")
+			}
+
+			target := storage.NewMemStore()
+			require.NoError(t, target.Put(ctx, "stale", []byte("gone")))
+			require.NoError(t, NewFSM(target).Restore(io.NopCloser(sink)))
+
+			val, err := target.Get(ctx, "secrets/042")
+			require.NoError(t, err)
+			require.Equal(t, bytes.Repeat([]byte{42}, 1024), val)
+			_, err = target.Get(ctx, "stale")
+			require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		})
+	}
+}
+
+func TestFSMSnapshot_RejectsCorruptSnapshots(t *testing.T) {
+	ctx := context.Background()
+	snapshot := persistSnapshot(t, NewFSM(newSnapshotStore(t), WithSnapshotCompression(SnapshotCompressionNone))).Bytes()
+
+	for name, corrupt := range map[string][]byte{
+		"flipped byte": func() []byte {
+			data := bytes.Clone(snapshot)
+			data[len(data)-1] ^= 0xff
+			return data
+		}(),
+		"truncated": snapshot[:len(snapshot)-10],
+	} {
+		t.Run(name, func(t *testing.T) {
+			target := storage.NewMemStore()
+			require.NoError(t, target.Put(ctx, "kept", []byte("value")))
+
+			err := NewFSM(target).Restore(io.NopCloser(bytes.NewReader(corrupt)))
+			require.True(t, errors.Is(err, ErrSnapshotChecksum), "expected a checksum error, got %v", err)
+
+			val, err := target.Get(ctx, "kept")
+			require.NoError(t, err, "the store was restored from a corrupt snapshot")
+			require.Equal(t, "value", string(val))
+		})
+	}
+}
+
+func TestFSMSnapshot_RestoresLegacySnapshots(t *testing.T) {
+	ctx := context.Background()
+
+	// Snapshots used to hold the bare store snapshot.
+	var legacy bytes.Buffer
+	require.NoError(t, newSnapshotStore(t).Snapshot(&legacy))
+
+	target := storage.NewMemStore()
+	require.NoError(t, NewFSM(target).Restore(io.NopCloser(&legacy)))
+	val, err := target.Get(ctx, "secrets/007")
+	require.NoError(t, err)
+	require.Equal(t, bytes.Repeat([]byte{7}, 1024), val)
+}
+
+func TestFSMSnapshot_CancelsOnFailure(t *testing.T) {
+	sink := &bufferSink{}
+	snap, err := NewFSM(newSnapshotStore(t), WithSnapshotDir(t.TempDir()+"/missing")).Snapshot()
+	require.NoError(t, err)
+	require.Error(t, snap.Persist(sink))
+	require.True(t, sink.cancelled)
+}
+
+func TestFSMSnapshot_PersistsStoreAsOfSnapshot(t *testing.T) {
+	for name, open := range map[string]func(t *testing.T) storage.Storage{
+		"bolt": func(t *testing.T) storage.Storage {
+			store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "store.db"))
+			require.NoError(t, err)
+			require.NoError(t, store.Initialize(context.Background()))
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+		"memory": func(t *testing.T) storage.Storage { return storage.NewMemStore() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			require.NoError(t, store.Put(ctx, "before", []byte("v")))
+			snap, err := NewFSM(store, WithSnapshotDir(t.TempDir())).Snapshot()
+			require.NoError(t, err)
+			defer snap.Release()
+
+			// Entries applied while the snapshot is persisted. A write that
+			// grows a BoltDB file waits for the view to be released.
+			applied := make(chan error, 1)
+			go func() {
+				err := store.Put(ctx, "after", []byte("v"))
+				if err == nil {
+					err = store.Delete(ctx, "before")
+				}
+				applied <- err
+			}()
+
+			sink := &bufferSink{}
+			require.NoError(t, snap.Persist(sink))
+			require.NoError(t, <-applied)
+			target := open(t)
+			require.NoError(t, NewFSM(target).Restore(io.NopCloser(sink)))
+
+			_, err = target.Get(ctx, "before")
+			require.NoError(t, err)
+			_, err = target.Get(ctx, "after")
+			require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		})
+	}
+}
+
+func TestSeedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	source := storage.NewMemStore()
+	require.NoError(t, source.Put(ctx, "secrets/db", []byte("hunter2")))
+
+	dataDir := t.TempDir()
+	addr := unusedAddr(t)
+	meta, err := SeedSnapshot(dataDir, "node-1", addr, source)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), meta.Index)
+	_, err = SeedSnapshot(dataDir, "node-1", addr, source)
+	require.ErrorIs(t, err, ErrExistingState)
+
+	// The node started with the seeded data directory leads a cluster of
+	// its own and holds the data.
+	store1, err := storage.NewBoltStore(filepath.Join(dataDir, "store.db"))
+	require.NoError(t, err)
+	require.NoError(t, store1.Initialize(ctx))
+	node1, err := NewRaftNode(&Config{NodeID: "node-1", BindAddr: addr, DataDir: dataDir}, NewFSM(store1))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, node1.Shutdown())
+		require.NoError(t, store1.Close())
+	})
+	require.Eventually(t, node1.IsLeader, 5*time.Second, 50*time.Millisecond, "seeded node never became leader")
+	value, err := store1.Get(ctx, "secrets/db")
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", string(value))
+
+	// Nodes that join are sent the data too.
+	node2, store2 := newTestRaftNode(t, false, "node-2", t.TempDir())
+	cluster := NewCluster(node1, NewStore(node1, store1), "127.0.0.1:8001")
+	require.NoError(t, cluster.Join(ctx, "node-2", node2.Addr(), "127.0.0.1:8002"))
+	require.Eventually(t, func() bool {
+		value, err := store2.Get(ctx, "secrets/db")
+		return err == nil && string(value) == "hunter2"
+	}, 5*time.Second, 50*time.Millisecond, "joining node never received the seeded data")
+}
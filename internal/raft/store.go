@@ -0,0 +1,250 @@
+package raft
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thelamedev/rune/internal/consensus"
+	"github.com/thelamedev/rune/internal/storage"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxBatchWrites and maxBatchBytes bound the writes Store proposes together
+// in one log entry.
+const (
+	maxBatchWrites = 256
+	maxBatchBytes  = 1 << 20
+)
+
+// Store is a storage.Storage whose writes are replicated through Raft. Writes
+// are proposed to the cluster and only return once the FSM has applied them,
+// with the error of the FSM's result. Reads are served from the local store
+// the FSM applies to, so on a follower they may lag behind the leader.
+//
+// Writes made while a proposal is in flight are queued, and proposed together
+// in a single BATCH command once it has been applied, so that concurrent
+// writers share log entries and fsyncs instead of waiting for each other's.
+type Store struct {
+	node  *RaftNode
+	local storage.Storage
+
+	mu        sync.Mutex
+	pending   []*proposal
+	proposing bool
+}
+
+// proposal is a write waiting to be applied.
+type proposal struct {
+	ctx  context.Context
+	cmd  *consensus.Command
+	err  error
+	done chan struct{}
+}
+
+// NewStore returns a Store that proposes writes through node and reads from
+// local, which must be the same store node's FSM applies commands to.
+func NewStore(node *RaftNode, local storage.Storage) *Store {
+	return &Store{
+		node:  node,
+		local: local,
+	}
+}
+
+func (s *Store) Initialize(ctx context.Context) error {
+	return s.local.Initialize(ctx)
+}
+
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.local.Get(ctx, key)
+}
+
+func (s *Store) Put(ctx context.Context, key string, value []byte) error {
+	return s.apply(ctx, consensus.NewPut(key, value))
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.apply(ctx, consensus.NewDelete(key))
+}
+
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	return s.local.List(ctx, prefix)
+}
+
+func (s *Store) ListPaged(ctx context.Context, opts storage.ListOptions) (*storage.ListResult, error) {
+	return storage.ListPaged(ctx, s.local, opts)
+}
+
+func (s *Store) Walk(ctx context.Context, prefix string, fn storage.WalkFunc) error {
+	return storage.Walk(ctx, s.local, prefix, fn)
+}
+
+func (s *Store) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	return s.apply(ctx, consensus.NewTxn(ops))
+}
+
+func (s *Store) Snapshot(w io.Writer) error {
+	return s.local.Snapshot(w)
+}
+
+// Restore is not supported on a replicated store, since restoring only the
+// local copy would diverge it from the rest of the cluster.
+func (s *Store) Restore(r io.Reader) error {
+	return errors.New("restoring a raft-backed store must go through a raft snapshot")
+}
+
+// Close is a no-op; the local store and the raft node are owned and closed by
+// whoever created them.
+func (s *Store) Close() error {
+	return nil
+}
+
+// apply proposes cmd and returns the error of its result. Invalid commands,
+// and commands writing keys the local store cannot store, are rejected before
+// they are proposed, so that they never reach the log. So is every command
+// once the FSM has halted, since it would not see it applied.
+//
+// apply returns the error of ctx once it is done. A write that is still
+// queued is then dropped, but one that was already proposed may yet be
+// applied, as with RaftNode.Apply.
+func (s *Store) apply(ctx context.Context, cmd *consensus.Command) error {
+	if err := consensus.Validate(cmd); err != nil {
+		return err
+	}
+	if index, ok := s.node.Halted(); ok {
+		return haltedError(index)
+	}
+	for _, c := range cmd.Batched() {
+		for _, op := range c.TxnOps() {
+			if err := storage.ValidateKey(s.local, op.Key); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p := &proposal{ctx: ctx, cmd: cmd, done: make(chan struct{})}
+	s.mu.Lock()
+	s.pending = append(s.pending, p)
+	start := !s.proposing
+	s.proposing = true
+	s.mu.Unlock()
+
+	if start {
+		go s.propose()
+	}
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// propose proposes the queued writes until there are none left.
+func (s *Store) propose() {
+	for {
+		s.mu.Lock()
+		batch := s.nextBatch()
+		if len(batch) == 0 {
+			s.proposing = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		s.proposeBatch(batch)
+		for _, p := range batch {
+			close(p.done)
+		}
+	}
+}
+
+// nextBatch takes as many queued writes as fit in one log entry, and at
+// least one if any are left. Writes whose callers have given up on them are
+// dropped rather than proposed. s.mu must be held.
+func (s *Store) nextBatch() []*proposal {
+	var batch []*proposal
+	n, size := 0, 0
+	for ; n < len(s.pending) && len(batch) < maxBatchWrites; n++ {
+		p := s.pending[n]
+		if err := p.ctx.Err(); err != nil {
+			p.err = err
+			close(p.done)
+			continue
+		}
+		size += proto.Size(p.cmd)
+		if len(batch) > 0 && size > maxBatchBytes {
+			break
+		}
+		batch = append(batch, p)
+	}
+	s.pending = s.pending[n:]
+	return batch
+}
+
+// batchContext returns the context a batch is proposed under, which expires
+// with the earliest deadline among its writes, so that the batch does not
+// wait in Raft for longer than any of its callers would.
+func batchContext(batch []*proposal) (context.Context, context.CancelFunc) {
+	var earliest time.Time
+	for _, p := range batch {
+		if deadline, ok := p.ctx.Deadline(); ok && (earliest.IsZero() || deadline.Before(earliest)) {
+			earliest = deadline
+		}
+	}
+	if earliest.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), earliest)
+}
+
+func (s *Store) proposeBatch(batch []*proposal) {
+	// A write proposed on its own is logged as it is, so that single writers
+	// produce the same entries as before batching.
+	cmd := batch[0].cmd
+	if len(batch) > 1 {
+		cmds := make([]*consensus.Command, len(batch))
+		for i, p := range batch {
+			cmds[i] = p.cmd
+		}
+		cmd = consensus.NewBatch(cmds)
+	}
+
+	ctx, cancel := batchContext(batch)
+	defer cancel()
+	resp, err := s.node.Apply(ctx, cmd)
+	if err != nil {
+		for _, p := range batch {
+			p.err = err
+		}
+		return
+	}
+
+	switch resp := resp.(type) {
+	case *consensus.Result:
+		// A whole entry that was rejected fails every write in it.
+		for _, p := range batch {
+			p.err = resp.Err
+		}
+	case []*consensus.Result:
+		if len(resp) != len(batch) {
+			err = errors.Errorf("FSM returned %d results for a batch of %d writes", len(resp), len(batch))
+		}
+		for i, p := range batch {
+			if err != nil {
+				p.err = err
+			} else {
+				p.err = resp[i].Err
+			}
+		}
+	default:
+		for _, p := range batch {
+			p.err = errors.Errorf("unexpected response %T from the FSM", resp)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"github.com/thelamedev/rune/internal/consensus"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+func TestStore_SingleNode(t *testing.T) {
+	node, local := newTestRaftNode(t, true, "node-1", t.TempDir())
+	require.Eventually(t, node.IsLeader, 3*time.Second, 100*time.Millisecond, "node never became leader")
+
+	store := NewStore(node, local)
+	ctx := context.Background()
+
+	t.Run("Put is applied before returning", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, "secrets/db/pass", []byte("hunter2")))
+
+		// No Eventually here: Put only returns once the FSM has applied it.
+		val, err := store.Get(ctx, "secrets/db/pass")
+		require.NoError(t, err)
+		require.Equal(t, "hunter2", string(val))
+
+		keys, err := store.List(ctx, "secrets/")
+		require.NoError(t, err)
+		require.Equal(t, []string{"secrets/db/pass"}, keys)
+	})
+
+	t.Run("Transaction returns the FSM result", func(t *testing.T) {
+		err := store.Transaction(ctx, []storage.TxnOp{
+			{Op: storage.TxnCheck, Key: "secrets/db/pass"},
+			{Op: storage.TxnPut, Key: "secrets/db/pass", Value: []byte("overwritten")},
+		})
+		require.True(t, errors.Is(err, storage.ErrTxnCheckFailed), "expected ErrTxnCheckFailed, got %v", err)
+
+		val, err := store.Get(ctx, "secrets/db/pass")
+		require.NoError(t, err)
+		require.Equal(t, "hunter2", string(val))
+	})
+
+	t.Run("invalid commands are rejected before they are proposed", func(t *testing.T) {
+		before := node.raft.LastIndex()
+		err := store.Put(ctx, "", []byte("v"))
+		require.ErrorIs(t, err, consensus.ErrInvalidCommand)
+		require.Equal(t, before, node.raft.LastIndex())
+	})
+
+	t.Run("concurrent writes are batched", func(t *testing.T) {
+		before := node.raft.LastIndex()
+
+		var wg sync.WaitGroup
+		errs := make([]error, 100)
+		for i := range errs {
+			wg.Go(func() {
+				key := fmt.Sprintf("batched/%02d", i)
+				if i%10 == 0 {
+					// A failed check only fails its own write.
+					errs[i] = store.Transaction(ctx, []storage.TxnOp{
+						{Op: storage.TxnCheckExists, Key: "batched/missing"},
+						{Op: storage.TxnPut, Key: key, Value: []byte("v")},
+					})
+					return
+				}
+				errs[i] = store.Put(ctx, key, []byte("v"))
+			})
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if i%10 == 0 {
+				require.ErrorIs(t, err, storage.ErrTxnCheckFailed)
+			} else {
+				require.NoError(t, err)
+			}
+		}
+		keys, err := store.List(ctx, "batched/")
+		require.NoError(t, err)
+		require.Len(t, keys, 90)
+		require.Less(t, node.raft.LastIndex()-before, uint64(100), "expected writes to share log entries")
+	})
+
+	t.Run("writes given up on are not proposed", func(t *testing.T) {
+		// Hold the queue, as if a proposal were in flight.
+		store.mu.Lock()
+		store.proposing = true
+		store.mu.Unlock()
+
+		cancelled, cancel := context.WithCancel(ctx)
+		errc := make(chan error, 1)
+		go func() { errc <- store.Put(cancelled, "abandoned", []byte("v")) }()
+		require.Eventually(t, func() bool {
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			return len(store.pending) == 1
+		}, time.Second, 10*time.Millisecond)
+		cancel()
+		select {
+		case err := <-errc:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("expected Put to return once its context was cancelled")
+		}
+
+		go store.propose()
+		require.NoError(t, store.Put(ctx, "after", []byte("v")))
+		_, err := store.Get(ctx, "abandoned")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, store.Delete(ctx, "secrets/db/pass"))
+
+		_, err := store.Get(ctx, "secrets/db/pass")
+		require.True(t, errors.Is(err, storage.ErrKeyNotFound), "expected ErrKeyNotFound, got %v", err)
+	})
+}
+
+func TestStore_NotLeader(t *testing.T) {
+	// A node that was never bootstrapped has no cluster to lead.
+	node, local := newTestRaftNode(t, false, "node-1", t.TempDir())
+	store := NewStore(node, local)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := store.Put(ctx, "hello", []byte("world"))
+	require.Error(t, err)
+
+	_, err = local.Get(ctx, "hello")
+	require.Error(t, err, "rejected write must not reach the local store")
+}
+
+// newTestCluster starts an in-process cluster of n nodes, and returns a Store
+// on its leader.
+func newTestCluster(b *testing.B, n int) *Store {
+	b.Helper()
+
+	leader, local := newTestRaftNode(b, true, "node-1", b.TempDir())
+	require.Eventually(b, leader.IsLeader, 5*time.Second, 50*time.Millisecond, "node-1 never became leader")
+	for i := 2; i <= n; i++ {
+		id := fmt.Sprintf("node-%d", i)
+		follower, _ := newTestRaftNode(b, false, id, b.TempDir())
+		require.NoError(b, leader.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(follower.Addr()), 0, 0).Error())
+	}
+	return NewStore(leader, local)
+}
+
+// BenchmarkStore_Put measures replicated writes per second. With many
+// writers, concurrent writes are proposed and applied in batches.
+func BenchmarkStore_Put(b *testing.B) {
+	value := make([]byte, 256)
+	for _, nodes := range []int{1, 3, 5} {
+		for _, writers := range []int{1, 64} {
+			b.Run(fmt.Sprintf("nodes=%d/writers=%d", nodes, writers), func(b *testing.B) {
+				store := newTestCluster(b, nodes)
+				ctx := context.Background()
+
+				b.ResetTimer()
+				var wg sync.WaitGroup
+				var next atomic.Int64
+				for range writers {
+					wg.Go(func() {
+						for i := next.Add(1); i <= int64(b.N); i = next.Add(1) {
+							if err := store.Put(ctx, fmt.Sprintf("bench/%d", i), value); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					})
+				}
+				wg.Wait()
+				b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "writes/s")
+			})
+		}
+	}
+}
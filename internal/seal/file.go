@@ -0,0 +1,94 @@
+package seal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoKeyFile is returned by UnsealFromFile when there is no key file and
+// it may not create one.
+var ErrNoKeyFile = errors.New("unseal key file does not exist")
+
+// keyFile is the contents of the file a server keeps its unseal keys in.
+type keyFile struct {
+	Keys []string `json:"keys"`
+}
+
+// UnsealFromFile unseals s with the keys stored at path, so that a server
+// decrypts its data with the same master key every time it starts. If there
+// is no file yet and create is set, it generates the keys and stores them at
+// path first. It returns the keys.
+//
+// The file holds enough keys to unseal the vault, so anyone who can read it
+// can read every secret. Without it, nothing stored can be decrypted.
+func (s *Seal) UnsealFromFile(ctx context.Context, path string, create bool) ([]string, error) {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && create:
+		keys, err := s.GenerateKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeKeyFile(path, keys); err != nil {
+			return nil, err
+		}
+		return keys, s.unsealWith(ctx, keys)
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("%w: %s", ErrNoKeyFile, path)
+	case err != nil:
+		return nil, fmt.Errorf("failed to read unseal keys: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode unseal keys in %s: %w", path, err)
+	}
+	return file.Keys, s.unsealWith(ctx, file.Keys)
+}
+
+func (s *Seal) unsealWith(ctx context.Context, keys []string) error {
+	if len(keys) < s.threshold {
+		return fmt.Errorf("%w: %d unseal keys, %d are needed", ErrInvalidShare, len(keys), s.threshold)
+	}
+	for _, key := range keys[:s.threshold] {
+		if _, _, err := s.Unseal(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeKeyFile writes keys to path, readable only by the owner. The file is
+// written in full before it is renamed into place, so a crash never leaves a
+// partial file behind.
+func writeKeyFile(path string, keys []string) error {
+	data, err := json.MarshalIndent(keyFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create unseal key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := tmp.Chmod(0o600); err != nil {
+		return fmt.Errorf("failed to create unseal key file: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write unseal keys: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write unseal keys: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write unseal keys: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,9 +1,12 @@
 package seal
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -142,3 +145,39 @@ func TestSeal_Unseal_InvalidShare(t *testing.T) {
 		}
 	})
 }
+
+func TestSeal_UnsealFromFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "unseal-keys.json")
+
+	if _, err := New(5, 3).UnsealFromFile(ctx, path, false); !errors.Is(err, ErrNoKeyFile) {
+		t.Fatalf("expected ErrNoKeyFile without a key file, got %v", err)
+	}
+
+	first := New(5, 3)
+	keys, err := first.UnsealFromFile(ctx, path, true)
+	if err != nil {
+		t.Fatalf("failed to initialize from key file: %v", err)
+	}
+	if len(keys) != 5 || !first.IsUnsealed() {
+		t.Fatalf("expected 5 keys and an unsealed vault, got %d keys", len(keys))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected the key file to be written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("expected the key file to be private, got mode %v", perm)
+	}
+
+	// A restarted server unseals with the same master key.
+	restarted := New(5, 3)
+	if _, err := restarted.UnsealFromFile(ctx, path, true); err != nil {
+		t.Fatalf("failed to unseal from key file: %v", err)
+	}
+	want, _ := first.MasterKey()
+	got, _ := restarted.MasterKey()
+	if !bytes.Equal(want, got) {
+		t.Fatal("expected the same master key after a restart")
+	}
+}
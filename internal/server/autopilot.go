@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// ClusterHealth is passed on to the leader, which is the only node running
+// autopilot.
+func (s *GRPCServer) ClusterHealth(ctx context.Context, req *apiv1.ClusterHealthRequest) (*apiv1.ClusterHealthResponse, error) {
+	if err := s.checkCluster(); err != nil {
+		return nil, err
+	}
+
+	health, err := s.Cluster.Health(ctx)
+	if err != nil {
+		return nil, clusterError(err, "failed to check cluster health")
+	}
+	resp := &apiv1.ClusterHealthResponse{
+		Healthy:          health.Healthy,
+		FailureTolerance: int32(health.FailureTolerance),
+		Servers:          make([]*apiv1.ServerHealth, 0, len(health.Servers)),
+	}
+	for _, srv := range health.Servers {
+		lastContact := int64(-1)
+		if srv.LastContact >= 0 {
+			lastContact = srv.LastContact.Milliseconds()
+		}
+		resp.Servers = append(resp.Servers, &apiv1.ServerHealth{
+			NodeId:        srv.ID,
+			RaftAddress:   srv.Address,
+			Voter:         srv.Voter,
+			Leader:        srv.Leader,
+			Healthy:       srv.Healthy,
+			StableSinceMs: srv.StableSince.UnixMilli(),
+			LastContactMs: lastContact,
+			LastIndex:     srv.LastIndex,
+			Term:          srv.Term,
+			Dead:          srv.Dead,
+			Error:         srv.Error,
+		})
+	}
+	return resp, nil
+}
+
+// ServerStats reports the state of this node, sealed or not, since its seal
+// has no bearing on how well it keeps up with the leader. It fails once the
+// node has stopped applying the log, so that autopilot sees it is unhealthy.
+func (s *GRPCServer) ServerStats(ctx context.Context, req *apiv1.ServerStatsRequest) (*apiv1.ServerStatsResponse, error) {
+	if s.Cluster == nil {
+		return nil, status.Error(codes.Unimplemented, "raft is not enabled on this server")
+	}
+
+	stats := s.Cluster.Stats()
+	if stats.Halted != 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "this node stopped applying the log at entry %d, upgrade it", stats.Halted)
+	}
+	lastContact := int64(-1)
+	if !stats.LastContact.IsZero() {
+		lastContact = max(time.Since(stats.LastContact).Milliseconds(), 0)
+	}
+	return &apiv1.ServerStatsResponse{LastIndex: stats.LastIndex, Term: stats.Term, LastContactMs: lastContact}, nil
+}
+
+// PeerStats fetches the state of the other members of the cluster through
+// their ServerStats RPC, for the leader's autopilot. Connections are kept
+// open for later calls.
+type PeerStats struct {
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// Fetch returns the state of peer.
+func (p *PeerStats) Fetch(ctx context.Context, peer raft.Peer) (raft.ServerStats, error) {
+	conn, err := p.conn(peer.APIAddr)
+	if err != nil {
+		return raft.ServerStats{}, err
+	}
+	resp, err := apiv1.NewRaftServiceClient(conn).ServerStats(ctx, &apiv1.ServerStatsRequest{})
+	if err != nil {
+		return raft.ServerStats{}, err
+	}
+
+	stats := raft.ServerStats{LastIndex: resp.LastIndex, Term: resp.Term}
+	if resp.LastContactMs >= 0 {
+		stats.LastContact = time.Now().Add(-time.Duration(resp.LastContactMs) * time.Millisecond)
+	}
+	return stats, nil
+}
+
+func (p *PeerStats) conn(addr string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if conn, ok := p.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	if p.conns == nil {
+		p.conns = make(map[string]*grpc.ClientConn)
+	}
+	p.conns[addr] = conn
+	return conn, nil
+}
+
+// Close closes the connections to the peers.
+func (p *PeerStats) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for addr, conn := range p.conns {
+		errs = append(errs, conn.Close())
+		delete(p.conns, addr)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestGRPCServer_ClusterHealth(t *testing.T) {
+	ctx := context.Background()
+	stableSince := time.Now().Add(-time.Minute)
+	cluster := &mockCluster{leader: true, health: &raft.ClusterHealth{
+		Healthy:          false,
+		FailureTolerance: 0,
+		Servers: []raft.ServerHealth{
+			{ID: "node-1", Address: "10.0.0.1:7000", Voter: true, Leader: true, Healthy: true, StableSince: stableSince, LastIndex: 42, Term: 3},
+			{ID: "node-2", Address: "10.0.0.2:7000", Voter: true, StableSince: stableSince, LastContact: -1, Dead: true, Error: "connection refused"},
+		},
+	}}
+	server := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}, Cluster: cluster}}
+
+	t.Run("success", func(t *testing.T) {
+		resp, err := server.ClusterHealth(ctx, &apiv1.ClusterHealthRequest{})
+		if err != nil {
+			t.Fatalf("ClusterHealth() returned an unexpected error: %v", err)
+		}
+		if resp.Healthy || len(resp.Servers) != 2 {
+			t.Fatalf("unexpected health %v", resp)
+		}
+		leader, dead := resp.Servers[0], resp.Servers[1]
+		if !leader.Healthy || leader.LastIndex != 42 || leader.Term != 3 || leader.LastContactMs != 0 || leader.StableSinceMs != stableSince.UnixMilli() {
+			t.Fatalf("unexpected leader health %v", leader)
+		}
+		if dead.Healthy || !dead.Dead || dead.LastContactMs != -1 || dead.Error != "connection refused" {
+			t.Fatalf("unexpected dead server health %v", dead)
+		}
+	})
+
+	t.Run("failure when autopilot is disabled", func(t *testing.T) {
+		disabled := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}, Cluster: &mockCluster{leader: true}}}
+		_, err := disabled.ClusterHealth(ctx, &apiv1.ClusterHealthRequest{})
+		expectCode(t, err, codes.FailedPrecondition)
+	})
+}
+
+func TestPeerStats(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	gsrv := grpc.NewServer()
+	// Stats are served while sealed.
+	apiv1.RegisterRaftServiceServer(gsrv, &GRPCServer{Config: &Config{Seal: &mockSealer{}, Cluster: &mockCluster{
+		stats: raft.ServerStats{LastIndex: 7, Term: 2, LastContact: time.Now().Add(-time.Second)},
+	}}})
+	go gsrv.Serve(lis)
+	t.Cleanup(gsrv.Stop)
+
+	var peers PeerStats
+	t.Cleanup(func() { peers.Close() })
+	stats, err := peers.Fetch(t.Context(), raft.Peer{ID: "node-2", APIAddr: lis.Addr().String()})
+	if err != nil {
+		t.Fatalf("Fetch() returned an unexpected error: %v", err)
+	}
+	if stats.LastIndex != 7 || stats.Term != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if age := time.Since(stats.LastContact); age < time.Second || age > 10*time.Second {
+		t.Fatalf("expected the peer to have heard from the leader a second ago, got %s", age)
+	}
+}
+
+func TestServerStats_Halted(t *testing.T) {
+	// A node that stopped applying the log fails its stats, so that the
+	// leader's autopilot reports it unhealthy.
+	srv := &GRPCServer{Config: &Config{Seal: &mockSealer{}, Cluster: &mockCluster{
+		stats: raft.ServerStats{LastIndex: 7, Term: 2, Halted: 5},
+	}}}
+	_, err := srv.ServerStats(t.Context(), &apiv1.ServerStatsRequest{})
+	expectCode(t, err, codes.FailedPrecondition)
+}
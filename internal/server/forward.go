@@ -0,0 +1,326 @@
+package server
+
+import (
+	"container/heap"
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// forwardedKey marks requests a follower has passed on to the leader. Its
+// value authenticates the follower as a member of the cluster, and it keeps
+// requests from being passed on again if leadership moved in the meantime.
+const forwardedKey = "rune-forwarded"
+
+// forwardTokenInfo derives the key followers sign forwarded requests with
+// from the cluster secret.
+const forwardTokenInfo = "rune request forwarding"
+
+// forwardTokenMaxAge is how long a signed request stays valid, which bounds
+// the clock skew between members of a cluster as well.
+const forwardTokenMaxAge = 30 * time.Second
+
+// leaderRPCs are the RPCs that change replicated state, or report what only
+// the leader knows, with the responses they return. Followers pass them on
+// to the leader.
+var leaderRPCs = map[string]func() proto.Message{
+	"/api.v1.RuneService/Put":                func() proto.Message { return new(apiv1.PutResponse) },
+	"/api.v1.RuneService/Txn":                func() proto.Message { return new(apiv1.TxnResponse) },
+	"/api.v1.SysService/EnableMount":         func() proto.Message { return new(apiv1.EnableMountResponse) },
+	"/api.v1.SysService/DisableMount":        func() proto.Message { return new(apiv1.DisableMountResponse) },
+	"/api.v1.SysService/TuneMount":           func() proto.Message { return new(apiv1.TuneMountResponse) },
+	"/api.v1.SysService/MigrateStoragePaths": func() proto.Message { return new(apiv1.MigrateStoragePathsResponse) },
+	"/api.v1.RaftService/Join":               func() proto.Message { return new(apiv1.JoinResponse) },
+	"/api.v1.RaftService/RemovePeer":         func() proto.Message { return new(apiv1.RemovePeerResponse) },
+	"/api.v1.RaftService/ListPeers":          func() proto.Message { return new(apiv1.ListPeersResponse) },
+	"/api.v1.RaftService/ClusterHealth":      func() proto.Message { return new(apiv1.ClusterHealthResponse) },
+}
+
+// readRPCs are passed on to the leader as well when they ask for a
+// consistency only the leader can give.
+var readRPCs = map[string]func() proto.Message{
+	"/api.v1.RuneService/Get":  func() proto.Message { return new(apiv1.GetResponse) },
+	"/api.v1.RuneService/List": func() proto.Message { return new(apiv1.ListResponse) },
+}
+
+// forward is a unary interceptor that passes requests only the leader can
+// serve on to it, so that clients can send them to any member of the
+// cluster. The caller's metadata and deadline go along with them.
+func (s *GRPCServer) forward(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	newResponse, ok := leaderRPCs[info.FullMethod]
+	if consistency, isRead := s.readConsistency(req); isRead && needsLeader(consistency) {
+		newResponse, ok = readRPCs[info.FullMethod]
+	}
+	if !ok || s.Cluster == nil {
+		return handler(ctx, req)
+	}
+
+	if token, ok := forwardedToken(ctx); ok {
+		if err := s.checkForwardToken(token, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		// Leadership moved while the request was passed on, so let the
+		// client try again rather than pass it around.
+		if !s.Cluster.IsLeader() {
+			return nil, status.Error(codes.Unavailable, "this node is no longer the raft leader, try again")
+		}
+		return handler(ctx, req)
+	}
+	if s.Cluster.IsLeader() {
+		return handler(ctx, req)
+	}
+
+	if !s.Seal.IsUnsealed() {
+		return nil, status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	conn, err := s.leaderConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.forwardToken(info.FullMethod, req, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := newResponse()
+	var header metadata.MD
+	err = conn.Invoke(forwardedContext(ctx, token), info.FullMethod, req, resp, grpc.Header(&header))
+	s.invalidateForwarded(req)
+	if err != nil {
+		return nil, err
+	}
+	// Pass on what the leader reports about the request, such as the
+	// applied index of a read.
+	forwardedHeader := metadata.MD{}
+	for key, values := range header {
+		if strings.HasPrefix(key, "rune-") {
+			forwardedHeader[key] = values
+		}
+	}
+	_ = grpc.SetHeader(ctx, forwardedHeader)
+	return resp, nil
+}
+
+// leaderConn returns a connection to the leader. Connections are kept open
+// for later requests.
+func (s *GRPCServer) leaderConn(ctx context.Context) (*grpc.ClientConn, error) {
+	addr, err := s.Cluster.LeaderAPIAddr(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "cannot reach the raft leader: %v", err)
+	}
+
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if conn, ok := s.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "cannot reach the raft leader at %s: %v", addr, err)
+	}
+	if s.conns == nil {
+		s.conns = make(map[string]*grpc.ClientConn)
+	}
+	s.conns[addr] = conn
+	return conn, nil
+}
+
+// Close closes the connections to the leaders requests were passed on to.
+func (s *GRPCServer) Close() error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	var errs []error
+	for addr, conn := range s.conns {
+		errs = append(errs, conn.Close())
+		delete(s.conns, addr)
+	}
+	return errors.Join(errs...)
+}
+
+// forwardToken signs a request this node passes on to the leader with the
+// cluster secret. The token names the time it was signed and a random
+// nonce, so that the leader can refuse it if it is replayed, and covers the
+// method and the request, so that it cannot be used for another.
+func (s *GRPCServer) forwardToken(method string, req any, now time.Time) (string, error) {
+	if len(s.ClusterSecret) == 0 {
+		return "", status.Error(codes.FailedPrecondition, "cannot pass the request on to the raft leader without a cluster secret")
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", status.Error(codes.Internal, "failed to authenticate to the raft leader")
+	}
+	prefix := strconv.FormatInt(now.UnixMilli(), 10) + "." + base64.RawURLEncoding.EncodeToString(nonce)
+	mac, err := s.forwardMAC(prefix, method, req)
+	if err != nil {
+		return "", status.Error(codes.Internal, "failed to authenticate to the raft leader")
+	}
+	return prefix + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+func (s *GRPCServer) checkForwardToken(token, method string, req any) error {
+	if len(s.ClusterSecret) == 0 {
+		return status.Error(codes.Unauthenticated, "forwarded requests are refused without a cluster secret")
+	}
+	unauthenticated := status.Error(codes.Unauthenticated, "forwarded request is not from a member of the cluster")
+
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return unauthenticated
+	}
+	prefix, encoded := token[:i], token[i+1:]
+	got, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return unauthenticated
+	}
+	expected, err := s.forwardMAC(prefix, method, req)
+	if err != nil {
+		return status.Error(codes.Internal, "failed to authenticate forwarded request")
+	}
+	if !hmac.Equal(got, expected) {
+		return unauthenticated
+	}
+
+	millis, nonce, _ := strings.Cut(prefix, ".")
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return unauthenticated
+	}
+	signed, now := time.UnixMilli(ms), time.Now()
+	if now.Sub(signed).Abs() > forwardTokenMaxAge {
+		return status.Error(codes.Unauthenticated, "forwarded request has expired, check that the clocks of the cluster agree")
+	}
+	if !s.nonces.add(nonce, signed.Add(forwardTokenMaxAge), now) {
+		return status.Error(codes.Unauthenticated, "forwarded request was replayed")
+	}
+	return nil
+}
+
+// forwardMAC returns the signature of a forwarded request.
+func (s *GRPCServer) forwardMAC(prefix, method string, req any) ([]byte, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil, errors.New("request is not a protobuf message")
+	}
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdf.Key(sha256.New, s.ClusterSecret, nil, forwardTokenInfo, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	for _, part := range [][]byte{[]byte(prefix), []byte(method), body} {
+		mac.Write(binary.AppendUvarint(nil, uint64(len(part))))
+		mac.Write(part)
+	}
+	return mac.Sum(nil), nil
+}
+
+// nonceCache remembers the nonces of the forwarded requests the leader has
+// accepted until they expire, so that none is accepted twice.
+type nonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]struct{}
+	// expiry holds the nonces in the order they expire in, so that add only
+	// looks at the ones that have.
+	expiry nonceHeap
+}
+
+// add records nonce until expires, and reports whether it was new.
+func (c *nonceCache) add(nonce string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.expiry) > 0 && now.After(c.expiry[0].expires) {
+		delete(c.nonces, heap.Pop(&c.expiry).(nonceExpiry).nonce)
+	}
+	if _, ok := c.nonces[nonce]; ok {
+		return false
+	}
+	if c.nonces == nil {
+		c.nonces = make(map[string]struct{})
+	}
+	c.nonces[nonce] = struct{}{}
+	heap.Push(&c.expiry, nonceExpiry{nonce: nonce, expires: expires})
+	return true
+}
+
+type nonceExpiry struct {
+	nonce   string
+	expires time.Time
+}
+
+// nonceHeap orders nonces by when they expire. Followers sign requests with
+// their own clocks, so they do not arrive in that order.
+type nonceHeap []nonceExpiry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h nonceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x any)        { *h = append(*h, x.(nonceExpiry)) }
+
+func (h *nonceHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	old[len(old)-1] = nonceExpiry{}
+	*h = old[:len(old)-1]
+	return x
+}
+
+// invalidateForwarded drops the decrypted secrets a request passed on to the
+// leader may have changed, as the handlers would have.
+func (s *GRPCServer) invalidateForwarded(req any) {
+	switch req := req.(type) {
+	case *apiv1.PutRequest:
+		s.invalidatePlaintext(req.Path)
+	case *apiv1.TxnRequest:
+		for _, op := range req.Ops {
+			s.invalidatePlaintext(op.Path)
+		}
+	case *apiv1.EnableMountRequest, *apiv1.DisableMountRequest, *apiv1.TuneMountRequest:
+		s.purgePlaintext()
+	}
+}
+
+// forwardedContext passes the caller's metadata and deadline on to the
+// leader, marked as forwarded by this node.
+func forwardedContext(ctx context.Context, token string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	out := metadata.MD{}
+	for key, values := range md {
+		// Transport headers are set again for the new request.
+		if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") ||
+			key == "content-type" || key == "user-agent" || key == forwardedKey {
+			continue
+		}
+		out[key] = values
+	}
+	out.Set(forwardedKey, token)
+	return metadata.NewOutgoingContext(ctx, out)
+}
+
+func forwardedToken(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(forwardedKey)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// recordingStore records the metadata and deadline of the last write.
+type recordingStore struct {
+	*storage.MemStore
+
+	mu       sync.Mutex
+	md       metadata.MD
+	deadline bool
+}
+
+func (r *recordingStore) Put(ctx context.Context, key string, value []byte) error {
+	r.mu.Lock()
+	r.md, _ = metadata.FromIncomingContext(ctx)
+	_, r.deadline = ctx.Deadline()
+	r.mu.Unlock()
+	return r.MemStore.Put(ctx, key, value)
+}
+
+// serve serves cfg on a local port and returns a client connection to it.
+func serve(t *testing.T, cfg *Config) (string, *grpc.ClientConn) {
+	t.Helper()
+
+	gsrv, srv, err := NewGRPCServer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go gsrv.Serve(l)
+	t.Cleanup(func() {
+		gsrv.Stop()
+		if err := srv.Close(); err != nil {
+			t.Errorf("failed to close server: %v", err)
+		}
+	})
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return l.Addr().String(), conn
+}
+
+func TestGRPCServer_Forward(t *testing.T) {
+	ctx := context.Background()
+	// Every node generates its own seal keys, so only the cluster secret is
+	// shared.
+	seal := &mockSealer{unsealed: true, key: []byte("0123456789abcdef0123456789abcdef")}
+	followerSeal := &mockSealer{unsealed: true, key: []byte("fedcba9876543210fedcba9876543210")}
+	secret := []byte("a secret the whole cluster shares")
+
+	leaderStore := &recordingStore{MemStore: storage.NewMemStore()}
+	leaderCluster := &mockCluster{leader: true}
+	leaderAddr, leaderConn := serve(t, &Config{
+		Storage:       leaderStore,
+		Seal:          seal,
+		Cluster:       leaderCluster,
+		ClusterSecret: secret,
+		AppliedIndex:  func() uint64 { return 42 },
+	})
+
+	followerStore := storage.NewMemStore()
+	_, followerConn := serve(t, &Config{Storage: followerStore, Seal: followerSeal, Cluster: &mockCluster{leaderAddr: leaderAddr}, ClusterSecret: secret})
+	follower := apiv1.NewRuneServiceClient(followerConn)
+
+	t.Run("writes", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(metadata.AppendToOutgoingContext(ctx, "x-request-id", "req-1"), 5*time.Second)
+		defer cancel()
+		if _, err := follower.Put(ctx, &apiv1.PutRequest{Path: "secret/db", Value: []byte("s3cr3t")}); err != nil {
+			t.Fatalf("Put() returned an unexpected error: %v", err)
+		}
+
+		if value, err := leaderStore.Get(ctx, "secret/db"); err != nil || string(value) != "s3cr3t" {
+			t.Fatalf("expected the write on the leader, got %q (%v)", value, err)
+		}
+		if _, err := followerStore.Get(ctx, "secret/db"); err == nil {
+			t.Fatal("expected the write not to be served by the follower")
+		}
+		leaderStore.mu.Lock()
+		defer leaderStore.mu.Unlock()
+		if got := leaderStore.md.Get("x-request-id"); len(got) != 1 || got[0] != "req-1" || !leaderStore.deadline {
+			t.Fatalf("expected the metadata and deadline to be passed on, got %v (deadline %t)", leaderStore.md, leaderStore.deadline)
+		}
+	})
+
+	t.Run("membership changes", func(t *testing.T) {
+		raftClient := apiv1.NewRaftServiceClient(followerConn)
+		if _, err := raftClient.Join(ctx, &apiv1.JoinRequest{NodeId: "node-2", RaftAddress: "10.0.0.2:7000"}); err != nil {
+			t.Fatalf("Join() returned an unexpected error: %v", err)
+		}
+		if peers, _ := leaderCluster.Peers(ctx); len(peers) != 1 || peers[0].ID != "node-2" {
+			t.Fatalf("expected the node to join on the leader, got %v", peers)
+		}
+	})
+
+	t.Run("reads", func(t *testing.T) {
+		_, err := follower.Get(ctx, &apiv1.GetRequest{Path: "secret/db"})
+		expectCode(t, err, codes.NotFound)
+
+		var header metadata.MD
+		resp, err := follower.Get(ctx, &apiv1.GetRequest{
+			Path:        "secret/db",
+			Consistency: apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE,
+		}, grpc.Header(&header))
+		if err != nil || string(resp.Value) != "s3cr3t" {
+			t.Fatalf("expected the read to be served by the leader, got %v (%v)", resp, err)
+		}
+		if got := header.Get(appliedIndexHeader); len(got) != 1 || got[0] != "42" {
+			t.Fatalf("expected the leader's applied index to be passed on, got %v", header)
+		}
+
+		_, readerConn := serve(t, &Config{
+			Storage:         storage.NewMemStore(),
+			Seal:            followerSeal,
+			Cluster:         &mockCluster{leaderAddr: leaderAddr},
+			ClusterSecret:   secret,
+			ReadConsistency: apiv1.ReadConsistency_READ_CONSISTENCY_LEADER,
+		})
+		reader := apiv1.NewRuneServiceClient(readerConn)
+		resp, err = reader.Get(ctx, &apiv1.GetRequest{Path: "secret/db"})
+		if err != nil || string(resp.Value) != "s3cr3t" {
+			t.Fatalf("expected reads to be served by the leader by default, got %v (%v)", resp, err)
+		}
+		_, err = reader.Get(ctx, &apiv1.GetRequest{Path: "secret/db", Consistency: apiv1.ReadConsistency_READ_CONSISTENCY_STALE})
+		expectCode(t, err, codes.NotFound)
+	})
+
+	signer := &GRPCServer{Config: &Config{ClusterSecret: secret}}
+	const putMethod = "/api.v1.RuneService/Put"
+
+	t.Run("failure on forwarded request to a follower", func(t *testing.T) {
+		req := &apiv1.PutRequest{Path: "secret/db", Value: []byte("v")}
+		token, err := signer.forwardToken(putMethod, req, time.Now())
+		if err != nil {
+			t.Fatalf("failed to sign request: %v", err)
+		}
+		ctx := metadata.AppendToOutgoingContext(ctx, forwardedKey, token)
+		_, err = follower.Put(ctx, req)
+		expectCode(t, err, codes.Unavailable)
+	})
+
+	t.Run("failure on forged forwarded request", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(ctx, forwardedKey, "forged")
+		_, err := apiv1.NewRuneServiceClient(leaderConn).Put(ctx, &apiv1.PutRequest{Path: "secret/db", Value: []byte("v")})
+		expectCode(t, err, codes.Unauthenticated)
+	})
+
+	t.Run("failure on replayed or altered request", func(t *testing.T) {
+		leader := apiv1.NewRuneServiceClient(leaderConn)
+		req := &apiv1.PutRequest{Path: "secret/replayed", Value: []byte("v")}
+		token, err := signer.forwardToken(putMethod, req, time.Now())
+		if err != nil {
+			t.Fatalf("failed to sign request: %v", err)
+		}
+		ctx := metadata.AppendToOutgoingContext(ctx, forwardedKey, token)
+		if _, err := leader.Put(ctx, req); err != nil {
+			t.Fatalf("expected the signed request to be accepted, got %v", err)
+		}
+		_, err = leader.Put(ctx, req)
+		expectCode(t, err, codes.Unauthenticated)
+
+		token, _ = signer.forwardToken(putMethod, req, time.Now())
+		ctx = metadata.AppendToOutgoingContext(context.Background(), forwardedKey, token)
+		_, err = leader.Put(ctx, &apiv1.PutRequest{Path: "secret/db", Value: []byte("altered")})
+		expectCode(t, err, codes.Unauthenticated)
+
+		token, _ = signer.forwardToken(putMethod, req, time.Now().Add(-time.Minute))
+		ctx = metadata.AppendToOutgoingContext(context.Background(), forwardedKey, token)
+		_, err = leader.Put(ctx, req)
+		expectCode(t, err, codes.Unauthenticated)
+	})
+
+	t.Run("failure from another cluster", func(t *testing.T) {
+		_, conn := serve(t, &Config{Storage: storage.NewMemStore(), Seal: followerSeal, Cluster: &mockCluster{leaderAddr: leaderAddr}, ClusterSecret: []byte("the secret of some other cluster")})
+		_, err := apiv1.NewRuneServiceClient(conn).Put(ctx, &apiv1.PutRequest{Path: "secret/db", Value: []byte("v")})
+		expectCode(t, err, codes.Unauthenticated)
+	})
+
+	t.Run("failure without a cluster secret", func(t *testing.T) {
+		_, conn := serve(t, &Config{Storage: storage.NewMemStore(), Seal: followerSeal, Cluster: &mockCluster{leaderAddr: leaderAddr}})
+		_, err := apiv1.NewRuneServiceClient(conn).Put(ctx, &apiv1.PutRequest{Path: "secret/db", Value: []byte("v")})
+		expectCode(t, err, codes.FailedPrecondition)
+	})
+
+	t.Run("failure without a leader", func(t *testing.T) {
+		_, conn := serve(t, &Config{Storage: storage.NewMemStore(), Seal: seal, Cluster: &mockCluster{}})
+		_, err := apiv1.NewRuneServiceClient(conn).Put(ctx, &apiv1.PutRequest{Path: "secret/db", Value: []byte("v")})
+		expectCode(t, err, codes.Unavailable)
+	})
+}
+
+func TestNonceCache(t *testing.T) {
+	var c nonceCache
+	now := time.Now()
+
+	// Nonces signed by followers with different clocks expire out of order.
+	if !c.add("late", now.Add(time.Minute), now) || !c.add("early", now.Add(time.Second), now) {
+		t.Fatal("expected new nonces to be accepted")
+	}
+	if c.add("early", now.Add(time.Second), now) {
+		t.Fatal("expected a replayed nonce to be refused")
+	}
+
+	// Once the early nonce has expired it is forgotten, and the late one is
+	// still remembered.
+	now = now.Add(2 * time.Second)
+	if c.add("late", now.Add(time.Minute), now) {
+		t.Fatal("expected a replayed nonce to be refused until it expires")
+	}
+	if len(c.nonces) != 1 || len(c.expiry) != 1 {
+		t.Fatalf("expected only the late nonce to be remembered, got %v", c.nonces)
+	}
+}
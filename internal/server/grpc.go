@@ -2,9 +2,20 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"strings"
+	"sync"
+	"time"
 
 	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/consensus"
+	"github.com/thelamedev/rune/internal/logical"
+	"github.com/thelamedev/rune/internal/merkle"
+	"github.com/thelamedev/rune/internal/mount"
+	"github.com/thelamedev/rune/internal/storage"
+	"github.com/thelamedev/rune/internal/watch"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -13,12 +24,39 @@ import (
 var (
 	ErrStorageNotConfigured = errors.New("storage is not configured")
 	ErrSealNotConfigured    = errors.New("seal is not configured")
-	ErrCryptoNotConfigured  = errors.New("crypto is not configured")
 )
 
+// Storer is where the server keeps secrets. It is expected to be the mount
+// table, which routes each path to the secrets engine mounted above it.
 type Storer interface {
 	Get(ctx context.Context, key string) ([]byte, error)
 	Put(ctx context.Context, key string, value []byte) error
+	List(ctx context.Context, prefix string) ([]string, error)
+	Transaction(ctx context.Context, ops []storage.TxnOp) error
+}
+
+const (
+	defaultListPageSize = 100
+	maxListPageSize     = 1000
+
+	defaultPlaintextCacheEntries = 1024
+)
+
+// Watcher streams changes to the secrets in Storage, with the same paths.
+type Watcher interface {
+	Subscribe(prefix string, fromIndex uint64) (*watch.Subscription, error)
+}
+
+// HashTree serves the hash tree of the node's storage.
+type HashTree interface {
+	Node(path string) (*merkle.Node, error)
+}
+
+// PathMigrator moves stored values between the plain and hashed path
+// layouts of the barrier.
+type PathMigrator interface {
+	MigratePaths(ctx context.Context) (int, error)
+	HashesPaths() bool
 }
 
 type Sealer interface {
@@ -26,31 +64,72 @@ type Sealer interface {
 	MasterKey() ([]byte, error)
 }
 
-type CryptoEngine interface {
-	Encrypt(plaintext []byte) ([]byte, error)
-	Decrypt(payload []byte) ([]byte, error)
-}
-
 type Config struct {
 	Storage Storer
 	Seal    Sealer
-	Crypto  CryptoEngine
+	// Events enables the Watch RPC when set.
+	Events Watcher
+	// Mounts enables the SysService mount RPCs when set.
+	Mounts Mounter
+	// Backend is the node's storage backend, below the barrier and any
+	// replication. It enables the SysService storage RPCs when set.
+	Backend storage.Storage
+	// Hashes enables the StorageHash RPC when set. AppliedIndex reports the
+	// Raft log index the node's storage reflects, if it is replicated.
+	Hashes       HashTree
+	AppliedIndex func() uint64
+	// Paths enables the MigrateStoragePaths RPC when set.
+	Paths PathMigrator
+	// Cluster enables the RaftService RPCs when set. Requests that change
+	// replicated state are then passed on to the leader when they reach a
+	// follower, and so are reads that ask for the leader's consistency.
+	Cluster Cluster
+	// ClusterSecret is shared by every member of the cluster. Followers sign
+	// the requests they pass on to the leader with it, and requests are
+	// only passed on with one.
+	ClusterSecret []byte
+	// ReadConsistency is the consistency of reads that do not ask for one,
+	// READ_CONSISTENCY_STALE if unset.
+	ReadConsistency apiv1.ReadConsistency
+
+	// PlaintextCacheTTL enables caching of decrypted secrets for hot paths.
+	// Entries are dropped when written through this server, but writes
+	// replicated from other nodes only take effect once the entry expires,
+	// so keep it short. Only stale reads are served from the cache. Zero
+	// disables it.
+	PlaintextCacheTTL time.Duration
+	// PlaintextCacheEntries bounds the number of decrypted secrets cached.
+	PlaintextCacheEntries int
 }
 
 type GRPCServer struct {
 	apiv1.UnimplementedRuneServiceServer
+	apiv1.UnimplementedSysServiceServer
+	apiv1.UnimplementedRaftServiceServer
 	*Config
+
+	plaintext *plaintextCache
+
+	// conns holds the connections to the leaders requests were passed on to.
+	connMu sync.Mutex
+	conns  map[string]*grpc.ClientConn
+	// nonces holds the forwarded requests accepted while leading.
+	nonces nonceCache
 }
 
-func NewGRPCServer(cfg *Config) (*grpc.Server, error) {
-	gsrv := grpc.NewServer()
+// NewGRPCServer returns a gRPC server serving cfg, and the services it
+// serves, which have to be closed once it has stopped.
+func NewGRPCServer(cfg *Config) (*grpc.Server, *GRPCServer, error) {
 	srv, err := newRuneServiceServer(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	gsrv := grpc.NewServer(grpc.UnaryInterceptor(srv.forward))
 
 	apiv1.RegisterRuneServiceServer(gsrv, srv)
-	return gsrv, nil
+	apiv1.RegisterSysServiceServer(gsrv, srv)
+	apiv1.RegisterRaftServiceServer(gsrv, srv)
+	return gsrv, srv, nil
 }
 
 func newRuneServiceServer(cfg *Config) (*GRPCServer, error) {
@@ -60,31 +139,55 @@ func newRuneServiceServer(cfg *Config) (*GRPCServer, error) {
 	if cfg.Seal == nil {
 		return nil, ErrSealNotConfigured
 	}
-	if cfg.Crypto == nil {
-		return nil, ErrCryptoNotConfigured
-	}
 
-	return &GRPCServer{
+	srv := &GRPCServer{
 		Config: cfg,
-	}, nil
+	}
+	if cfg.PlaintextCacheTTL > 0 {
+		entries := cfg.PlaintextCacheEntries
+		if entries <= 0 {
+			entries = defaultPlaintextCacheEntries
+		}
+		srv.plaintext = newPlaintextCache(entries, cfg.PlaintextCacheTTL)
+	}
+
+	return srv, nil
 }
 
 func (s *GRPCServer) Get(ctx context.Context, req *apiv1.GetRequest) (*apiv1.GetResponse, error) {
 	if !s.Seal.IsUnsealed() {
 		return nil, status.Error(codes.FailedPrecondition, "vault is sealed")
 	}
+	if err := s.beginRead(ctx, req); err != nil {
+		return nil, err
+	}
 
-	encryptedPayload, err := s.Storage.Get(ctx, req.Path)
-	if err != nil {
-		return nil, status.Error(codes.NotFound, "secret not found")
+	// Cached secrets may lag behind writes replicated from other nodes, so
+	// only stale reads are served from the cache.
+	cache := s.plaintext
+	if consistency, _ := s.readConsistency(req); consistency != apiv1.ReadConsistency_READ_CONSISTENCY_STALE {
+		cache = nil
 	}
 
-	decryptedPayload, err := s.Crypto.Decrypt(encryptedPayload)
+	var gen uint64
+	if cache != nil {
+		value, g, ok := cache.get(req.Path)
+		if ok {
+			return &apiv1.GetResponse{Value: value}, nil
+		}
+		gen = g
+	}
+
+	value, err := s.Storage.Get(ctx, req.Path)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "failed to decrypt secret")
+		return nil, storageError(err, "failed to read secret")
+	}
+
+	if cache != nil {
+		cache.add(req.Path, value, gen)
 	}
 
-	return &apiv1.GetResponse{Value: decryptedPayload}, nil
+	return &apiv1.GetResponse{Value: value}, nil
 }
 
 func (s *GRPCServer) Put(ctx context.Context, req *apiv1.PutRequest) (*apiv1.PutResponse, error) {
@@ -92,15 +195,170 @@ func (s *GRPCServer) Put(ctx context.Context, req *apiv1.PutRequest) (*apiv1.Put
 		return nil, status.Error(codes.FailedPrecondition, "vault is sealed")
 	}
 
-	encryptedPayload, err := s.Crypto.Encrypt(req.Value)
+	err := s.Storage.Put(ctx, req.Path, req.Value)
+	s.invalidatePlaintext(req.Path)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "failed to encrypt secret")
+		return nil, storageError(err, "failed to store secret")
 	}
 
-	err = s.Storage.Put(ctx, req.Path, encryptedPayload)
+	return &apiv1.PutResponse{Success: true}, nil
+}
+
+func (s *GRPCServer) Txn(ctx context.Context, req *apiv1.TxnRequest) (*apiv1.TxnResponse, error) {
+	if !s.Seal.IsUnsealed() {
+		return nil, status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	if len(req.Ops) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "transaction has no operations")
+	}
+
+	ops := make([]storage.TxnOp, 0, len(req.Ops))
+	for _, op := range req.Ops {
+		switch op.Type {
+		case apiv1.TxnOp_PUT:
+			ops = append(ops, storage.TxnOp{Op: storage.TxnPut, Key: op.Path, Value: op.Value})
+		case apiv1.TxnOp_DELETE:
+			ops = append(ops, storage.TxnOp{Op: storage.TxnDelete, Key: op.Path})
+		case apiv1.TxnOp_CHECK_EXISTS:
+			ops = append(ops, storage.TxnOp{Op: storage.TxnCheckExists, Key: op.Path})
+		case apiv1.TxnOp_CHECK_MISSING:
+			ops = append(ops, storage.TxnOp{Op: storage.TxnCheck, Key: op.Path})
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown transaction op: %v", op.Type)
+		}
+	}
+
+	err := s.Storage.Transaction(ctx, ops)
+	for _, op := range ops {
+		s.invalidatePlaintext(op.Key)
+	}
+	if errors.Is(err, storage.ErrTxnCheckFailed) {
+		return &apiv1.TxnResponse{Success: false}, nil
+	}
 	if err != nil {
-		return nil, status.Error(codes.Internal, "failed to store secret")
+		return nil, storageError(err, "failed to apply transaction")
 	}
 
-	return &apiv1.PutResponse{Success: true}, nil
+	return &apiv1.TxnResponse{Success: true}, nil
+}
+
+func (s *GRPCServer) List(ctx context.Context, req *apiv1.ListRequest) (*apiv1.ListResponse, error) {
+	if !s.Seal.IsUnsealed() {
+		return nil, status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	if err := s.beginRead(ctx, req); err != nil {
+		return nil, err
+	}
+
+	prefix := req.Path
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	pageSize := int(req.PageSize)
+	switch {
+	case pageSize < 0:
+		return nil, status.Error(codes.InvalidArgument, "page size must not be negative")
+	case pageSize == 0:
+		pageSize = defaultListPageSize
+	case pageSize > maxListPageSize:
+		pageSize = maxListPageSize
+	}
+
+	opts := storage.ListOptions{Prefix: prefix, Limit: pageSize}
+	if !req.Recursive {
+		opts.Delimiter = "/"
+	}
+	if req.PageToken != "" {
+		cursor, err := base64.RawURLEncoding.DecodeString(req.PageToken)
+		if err != nil || !strings.HasPrefix(string(cursor), prefix) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		opts.StartAfter = string(cursor)
+	}
+
+	res, err := storage.ListPaged(ctx, s.Storage, opts)
+	if err != nil {
+		return nil, storageError(err, "failed to list secrets")
+	}
+
+	resp := &apiv1.ListResponse{Entries: make([]*apiv1.ListEntry, 0, len(res.Entries))}
+	for _, entry := range res.Entries {
+		resp.Entries = append(resp.Entries, &apiv1.ListEntry{
+			Name:   strings.TrimPrefix(entry.Key, prefix),
+			Folder: entry.IsFolder,
+		})
+	}
+	if res.Cursor != "" {
+		resp.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(res.Cursor))
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) Watch(req *apiv1.WatchRequest, stream apiv1.RuneService_WatchServer) error {
+	if !s.Seal.IsUnsealed() {
+		return status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	if s.Events == nil {
+		return status.Error(codes.Unimplemented, "watch is not enabled on this server")
+	}
+
+	sub, err := s.Events.Subscribe(req.Path, req.StartIndex)
+	if errors.Is(err, watch.ErrCompacted) {
+		return status.Errorf(codes.OutOfRange, "events after index %d are no longer available, re-read and watch without a start index", req.StartIndex)
+	}
+	if err != nil {
+		return status.Error(codes.Internal, "failed to start watch")
+	}
+	defer sub.Close()
+
+	ctx := stream.Context()
+	for {
+		ev, err := sub.Next(ctx)
+		switch {
+		case errors.Is(err, watch.ErrLagging):
+			return status.Error(codes.ResourceExhausted, "watcher fell behind, resume from the last received index")
+		case errors.Is(err, watch.ErrCompacted):
+			return status.Error(codes.OutOfRange, "storage was restored from a snapshot, re-read and watch again")
+		case err != nil:
+			return status.FromContextError(err).Err()
+		}
+
+		event := &apiv1.WatchEvent{Type: apiv1.WatchEvent_PUT, Path: ev.Key, Index: ev.Index}
+		if ev.Op == watch.OpDelete {
+			event.Type = apiv1.WatchEvent_DELETE
+		}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *GRPCServer) invalidatePlaintext(path string) {
+	if s.plaintext != nil {
+		s.plaintext.remove(path)
+	}
+}
+
+// storageError maps an error from the storage layer to a gRPC status, using
+// msg for unexpected failures.
+func storageError(err error, msg string) error {
+	switch {
+	case errors.Is(err, barrier.ErrSealed):
+		return status.Error(codes.FailedPrecondition, "vault is sealed")
+	case errors.Is(err, storage.ErrKeyNotFound):
+		return status.Error(codes.NotFound, "secret not found")
+	case errors.Is(err, mount.ErrNoMount), errors.Is(err, mount.ErrCrossMount),
+		errors.Is(err, logical.ErrUnsupportedOperation):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, consensus.ErrInvalidCommand), errors.Is(err, storage.ErrInvalidKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, consensus.ErrUnsupportedVersion):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, msg)
+	}
 }
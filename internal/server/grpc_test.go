@@ -3,9 +3,17 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/consensus"
+	"github.com/thelamedev/rune/internal/storage"
+	"github.com/thelamedev/rune/internal/watch"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -18,6 +26,7 @@ type mockStorer struct {
 	putErr  error
 	getErr  error
 	listErr error
+	txnErr  error
 }
 
 func (m *mockStorer) Get(ctx context.Context, key string) ([]byte, error) {
@@ -26,8 +35,7 @@ func (m *mockStorer) Get(ctx context.Context, key string) ([]byte, error) {
 	}
 	val, ok := m.data[key]
 	if !ok {
-		// Simulate a not-found error
-		return nil, errors.New("not found")
+		return nil, fmt.Errorf("%w: %s", storage.ErrKeyNotFound, key)
 	}
 	return val, nil
 }
@@ -43,6 +51,43 @@ func (m *mockStorer) Put(ctx context.Context, key string, value []byte) error {
 	return nil
 }
 
+func (m *mockStorer) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	if m.txnErr != nil {
+		return m.txnErr
+	}
+	for _, op := range ops {
+		_, exists := m.data[op.Key]
+		if (op.Op == storage.TxnCheckExists && !exists) || (op.Op == storage.TxnCheck && exists) {
+			return storage.ErrTxnCheckFailed
+		}
+	}
+	if m.data == nil {
+		m.data = make(map[string][]byte)
+	}
+	for _, op := range ops {
+		switch op.Op {
+		case storage.TxnPut:
+			m.data[op.Key] = op.Value
+		case storage.TxnDelete:
+			delete(m.data, op.Key)
+		}
+	}
+	return nil
+}
+
+func (m *mockStorer) List(ctx context.Context, prefix string) ([]string, error) {
+	if m.listErr != nil {
+		return nil, m.listErr
+	}
+	var keys []string
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
 // mockSealer is a mock of the Sealer interface.
 type mockSealer struct {
 	unsealed bool
@@ -61,31 +106,6 @@ func (m *mockSealer) MasterKey() ([]byte, error) {
 	return m.key, nil
 }
 
-// mockCryptoEngine is a mock of the CryptoEngine interface.
-// It performs a fake "encryption" by prepending a string.
-type mockCryptoEngine struct {
-	encryptErr error
-	decryptErr error
-}
-
-func (m *mockCryptoEngine) Encrypt(plaintext []byte) ([]byte, error) {
-	if m.encryptErr != nil {
-		return nil, m.encryptErr
-	}
-	return append([]byte("encrypted:"), plaintext...), nil
-}
-
-func (m *mockCryptoEngine) Decrypt(payload []byte) ([]byte, error) {
-	if m.decryptErr != nil {
-		return nil, m.decryptErr
-	}
-	prefix := []byte("encrypted:")
-	if len(payload) < len(prefix) {
-		return nil, errors.New("invalid payload")
-	}
-	return payload[len(prefix):], nil
-}
-
 // --- Test Cases ---
 
 func TestGRPCServer_Put(t *testing.T) {
@@ -97,7 +117,6 @@ func TestGRPCServer_Put(t *testing.T) {
 			Config: &Config{
 				Storage: &mockStorer{},
 				Seal:    &mockSealer{unsealed: true},
-				Crypto:  &mockCryptoEngine{},
 			},
 		}
 		_, err := server.Put(ctx, req)
@@ -119,17 +138,33 @@ func TestGRPCServer_Put(t *testing.T) {
 		}
 	})
 
-	t.Run("failure on encryption", func(t *testing.T) {
+	t.Run("failure when barrier is sealed", func(t *testing.T) {
 		server := &GRPCServer{
 			Config: &Config{
-				Seal:   &mockSealer{unsealed: true},
-				Crypto: &mockCryptoEngine{encryptErr: errors.New("crypto boom")}, // Encryption fails
+				Seal:    &mockSealer{unsealed: true},
+				Storage: &mockStorer{putErr: barrier.ErrSealed}, // Sealed underneath the server
 			},
 		}
 		_, err := server.Put(ctx, req)
 		st, ok := status.FromError(err)
-		if !ok || st.Code() != codes.Internal {
-			t.Fatalf("expected Internal error, got: %v", err)
+		if !ok || st.Code() != codes.FailedPrecondition {
+			t.Fatalf("expected FailedPrecondition, got: %v", err)
+		}
+	})
+
+	t.Run("failure on a command the cluster rejects", func(t *testing.T) {
+		for err, code := range map[error]codes.Code{
+			fmt.Errorf("%w: key too long", consensus.ErrInvalidCommand): codes.InvalidArgument,
+			consensus.ErrUnsupportedVersion:                             codes.FailedPrecondition,
+		} {
+			server := &GRPCServer{
+				Config: &Config{
+					Seal:    &mockSealer{unsealed: true},
+					Storage: &mockStorer{putErr: err},
+				},
+			}
+			_, err := server.Put(ctx, req)
+			expectCode(t, err, code)
 		}
 	})
 
@@ -137,7 +172,6 @@ func TestGRPCServer_Put(t *testing.T) {
 		server := &GRPCServer{
 			Config: &Config{
 				Seal:    &mockSealer{unsealed: true},
-				Crypto:  &mockCryptoEngine{},
 				Storage: &mockStorer{putErr: errors.New("db boom")}, // Storage fails
 			},
 		}
@@ -153,15 +187,13 @@ func TestGRPCServer_Get(t *testing.T) {
 	ctx := context.Background()
 	path := "test/secret"
 	value := []byte("my-value")
-	encryptedValue := append([]byte("encrypted:"), value...)
 	req := &apiv1.GetRequest{Path: path}
 
 	t.Run("success", func(t *testing.T) {
 		server := &GRPCServer{
 			Config: &Config{
-				Storage: &mockStorer{data: map[string][]byte{path: encryptedValue}},
+				Storage: &mockStorer{data: map[string][]byte{path: value}},
 				Seal:    &mockSealer{unsealed: true},
-				Crypto:  &mockCryptoEngine{},
 			},
 		}
 		res, err := server.Get(ctx, req)
@@ -191,7 +223,6 @@ func TestGRPCServer_Get(t *testing.T) {
 			Config: &Config{
 				Storage: &mockStorer{}, // Empty storage
 				Seal:    &mockSealer{unsealed: true},
-				Crypto:  &mockCryptoEngine{},
 			},
 		}
 		_, err := server.Get(ctx, req)
@@ -204,9 +235,8 @@ func TestGRPCServer_Get(t *testing.T) {
 	t.Run("failure on decryption", func(t *testing.T) {
 		server := &GRPCServer{
 			Config: &Config{
-				Storage: &mockStorer{data: map[string][]byte{path: encryptedValue}},
+				Storage: &mockStorer{getErr: errors.New("failed to decrypt")}, // The barrier fails to decrypt
 				Seal:    &mockSealer{unsealed: true},
-				Crypto:  &mockCryptoEngine{decryptErr: errors.New("crypto boom")}, // Decryption fails
 			},
 		}
 		_, err := server.Get(ctx, req)
@@ -216,3 +246,418 @@ func TestGRPCServer_Get(t *testing.T) {
 		}
 	})
 }
+
+func TestGRPCServer_Txn(t *testing.T) {
+	ctx := context.Background()
+	req := &apiv1.TxnRequest{Ops: []*apiv1.TxnOp{
+		{Type: apiv1.TxnOp_CHECK_MISSING, Path: "test/secret"},
+		{Type: apiv1.TxnOp_PUT, Path: "test/secret", Value: []byte("my-value")},
+		{Type: apiv1.TxnOp_PUT, Path: "test/secret/meta", Value: []byte("v1")},
+	}}
+
+	t.Run("success", func(t *testing.T) {
+		store := &mockStorer{}
+		server := &GRPCServer{
+			Config: &Config{
+				Storage: store,
+				Seal:    &mockSealer{unsealed: true},
+			},
+		}
+		res, err := server.Txn(ctx, req)
+		if err != nil {
+			t.Fatalf("Txn() returned an unexpected error: %v", err)
+		}
+		if !res.Success {
+			t.Fatal("expected transaction to succeed")
+		}
+		if string(store.data["test/secret"]) != "my-value" {
+			t.Errorf("expected value to be stored, got %q", store.data["test/secret"])
+		}
+	})
+
+	t.Run("check failure", func(t *testing.T) {
+		store := &mockStorer{data: map[string][]byte{"test/secret": []byte("old")}}
+		server := &GRPCServer{
+			Config: &Config{
+				Storage: store,
+				Seal:    &mockSealer{unsealed: true},
+			},
+		}
+		res, err := server.Txn(ctx, req)
+		if err != nil {
+			t.Fatalf("Txn() returned an unexpected error: %v", err)
+		}
+		if res.Success {
+			t.Fatal("expected transaction to fail its check")
+		}
+		if _, ok := store.data["test/secret/meta"]; ok {
+			t.Error("expected no writes when a check fails")
+		}
+	})
+
+	t.Run("failure when sealed", func(t *testing.T) {
+		server := &GRPCServer{
+			Config: &Config{
+				Seal: &mockSealer{unsealed: false}, // Vault is sealed
+			},
+		}
+		_, err := server.Txn(ctx, req)
+		st, ok := status.FromError(err)
+		if !ok || st.Code() != codes.FailedPrecondition {
+			t.Fatalf("expected FailedPrecondition, got: %v", err)
+		}
+	})
+
+	t.Run("failure on empty transaction", func(t *testing.T) {
+		server := &GRPCServer{
+			Config: &Config{
+				Seal: &mockSealer{unsealed: true},
+			},
+		}
+		_, err := server.Txn(ctx, &apiv1.TxnRequest{})
+		st, ok := status.FromError(err)
+		if !ok || st.Code() != codes.InvalidArgument {
+			t.Fatalf("expected InvalidArgument, got: %v", err)
+		}
+	})
+
+	t.Run("failure on storage", func(t *testing.T) {
+		server := &GRPCServer{
+			Config: &Config{
+				Seal:    &mockSealer{unsealed: true},
+				Storage: &mockStorer{txnErr: errors.New("db boom")}, // Storage fails
+			},
+		}
+		_, err := server.Txn(ctx, req)
+		st, ok := status.FromError(err)
+		if !ok || st.Code() != codes.Internal {
+			t.Fatalf("expected Internal error, got: %v", err)
+		}
+	})
+}
+
+func TestGRPCServer_List(t *testing.T) {
+	ctx := context.Background()
+	data := map[string][]byte{
+		"secrets/api/key":         []byte("abc"),
+		"secrets/db/pass":         []byte("pass"),
+		"secrets/db/replica/pass": []byte("pass"),
+		"secrets/root":            []byte("root"),
+	}
+
+	t.Run("success", func(t *testing.T) {
+		server := &GRPCServer{
+			Config: &Config{
+				Storage: &mockStorer{data: data},
+				Seal:    &mockSealer{unsealed: true},
+			},
+		}
+		res, err := server.List(ctx, &apiv1.ListRequest{Path: "secrets"})
+		if err != nil {
+			t.Fatalf("List() returned an unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, e := range res.Entries {
+			got = append(got, e.Name)
+		}
+		expected := []string{"api/", "db/", "root"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected entries %q, got %q", expected, got)
+		}
+		if !res.Entries[0].Folder || res.Entries[2].Folder {
+			t.Errorf("folder flags are wrong: %v", res.Entries)
+		}
+		if res.NextPageToken != "" {
+			t.Errorf("expected no next page token, got %q", res.NextPageToken)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		server := &GRPCServer{
+			Config: &Config{
+				Storage: &mockStorer{data: data},
+				Seal:    &mockSealer{unsealed: true},
+			},
+		}
+
+		var got []string
+		req := &apiv1.ListRequest{Path: "secrets/", Recursive: true, PageSize: 3}
+		for {
+			res, err := server.List(ctx, req)
+			if err != nil {
+				t.Fatalf("List() returned an unexpected error: %v", err)
+			}
+			for _, e := range res.Entries {
+				got = append(got, e.Name)
+			}
+			if res.NextPageToken == "" {
+				break
+			}
+			req.PageToken = res.NextPageToken
+		}
+
+		expected := []string{"api/key", "db/pass", "db/replica/pass", "root"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected entries %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("failure when sealed", func(t *testing.T) {
+		server := &GRPCServer{
+			Config: &Config{
+				Seal: &mockSealer{unsealed: false}, // Vault is sealed
+			},
+		}
+		_, err := server.List(ctx, &apiv1.ListRequest{Path: "secrets"})
+		st, ok := status.FromError(err)
+		if !ok || st.Code() != codes.FailedPrecondition {
+			t.Fatalf("expected FailedPrecondition, got: %v", err)
+		}
+	})
+
+	t.Run("failure on invalid page token", func(t *testing.T) {
+		server := &GRPCServer{
+			Config: &Config{
+				Storage: &mockStorer{data: data},
+				Seal:    &mockSealer{unsealed: true},
+			},
+		}
+		_, err := server.List(ctx, &apiv1.ListRequest{Path: "secrets", PageToken: "Y29uZmln"}) // "config"
+		st, ok := status.FromError(err)
+		if !ok || st.Code() != codes.InvalidArgument {
+			t.Fatalf("expected InvalidArgument, got: %v", err)
+		}
+	})
+
+	t.Run("failure on storage", func(t *testing.T) {
+		server := &GRPCServer{
+			Config: &Config{
+				Seal:    &mockSealer{unsealed: true},
+				Storage: &mockStorer{listErr: errors.New("db boom")}, // Storage fails
+			},
+		}
+		_, err := server.List(ctx, &apiv1.ListRequest{Path: "secrets"})
+		st, ok := status.FromError(err)
+		if !ok || st.Code() != codes.Internal {
+			t.Fatalf("expected Internal error, got: %v", err)
+		}
+	})
+}
+
+func TestGRPCServer_PlaintextCache(t *testing.T) {
+	ctx := context.Background()
+	path := "test/secret"
+	store := &mockStorer{data: map[string][]byte{path: []byte("v1")}}
+	server, err := newRuneServiceServer(&Config{
+		Storage:           store,
+		Seal:              &mockSealer{unsealed: true},
+		PlaintextCacheTTL: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("newRuneServiceServer() returned an unexpected error: %v", err)
+	}
+
+	if _, err := server.Get(ctx, &apiv1.GetRequest{Path: path}); err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+
+	// Served from the cache even though storage now fails.
+	store.getErr = errors.New("db boom")
+	res, err := server.Get(ctx, &apiv1.GetRequest{Path: path})
+	if err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+	if string(res.Value) != "v1" {
+		t.Errorf("expected cached value %q, got %q", "v1", res.Value)
+	}
+	store.getErr = nil
+
+	if _, err := server.Put(ctx, &apiv1.PutRequest{Path: path, Value: []byte("v2")}); err != nil {
+		t.Fatalf("Put() returned an unexpected error: %v", err)
+	}
+	res, err = server.Get(ctx, &apiv1.GetRequest{Path: path})
+	if err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+	if string(res.Value) != "v2" {
+		t.Errorf("expected Put to invalidate the cache, got %q", res.Value)
+	}
+}
+
+func TestGRPCServer_PlaintextCacheConsistency(t *testing.T) {
+	ctx := context.Background()
+	path := "test/secret"
+	store := &mockStorer{data: map[string][]byte{path: []byte("v1")}}
+	server, err := newRuneServiceServer(&Config{
+		Storage:           store,
+		Seal:              &mockSealer{unsealed: true},
+		Cluster:           &mockCluster{leader: true},
+		PlaintextCacheTTL: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("newRuneServiceServer() returned an unexpected error: %v", err)
+	}
+	if _, err := server.Get(ctx, &apiv1.GetRequest{Path: path}); err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+
+	// A write replicated from another node does not pass through this
+	// server, so only the reads that may be stale can miss it.
+	store.data[path] = []byte("v2")
+	for consistency, want := range map[apiv1.ReadConsistency]string{
+		apiv1.ReadConsistency_READ_CONSISTENCY_STALE:        "v1",
+		apiv1.ReadConsistency_READ_CONSISTENCY_LEADER:       "v2",
+		apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE: "v2",
+	} {
+		res, err := server.Get(ctx, &apiv1.GetRequest{Path: path, Consistency: consistency})
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if string(res.Value) != want {
+			t.Errorf("expected %q for a %v read, got %q", want, consistency, res.Value)
+		}
+	}
+
+	server.ReadConsistency = apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE
+	if res, err := server.Get(ctx, &apiv1.GetRequest{Path: path}); err != nil || string(res.Value) != "v2" {
+		t.Errorf("expected reads of the default consistency to skip the cache, got %q (%v)", res.GetValue(), err)
+	}
+}
+
+// racingStorer runs onGet once, after reading a value but before returning
+// it, to stand in for a write that lands while a read is in flight.
+type racingStorer struct {
+	*mockStorer
+	onGet func()
+}
+
+func (r *racingStorer) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := r.mockStorer.Get(ctx, key)
+	if onGet := r.onGet; onGet != nil {
+		r.onGet = nil
+		onGet()
+	}
+	return value, err
+}
+
+func TestGRPCServer_PlaintextCacheRace(t *testing.T) {
+	ctx := context.Background()
+	path := "test/secret"
+	store := &racingStorer{mockStorer: &mockStorer{data: map[string][]byte{path: []byte("v1")}}}
+	server, err := newRuneServiceServer(&Config{
+		Storage:           store,
+		Seal:              &mockSealer{unsealed: true},
+		PlaintextCacheTTL: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("newRuneServiceServer() returned an unexpected error: %v", err)
+	}
+
+	store.onGet = func() {
+		if _, err := server.Put(ctx, &apiv1.PutRequest{Path: path, Value: []byte("v2")}); err != nil {
+			t.Errorf("Put() returned an unexpected error: %v", err)
+		}
+	}
+	if _, err := server.Get(ctx, &apiv1.GetRequest{Path: path}); err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+
+	res, err := server.Get(ctx, &apiv1.GetRequest{Path: path})
+	if err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+	if string(res.Value) != "v2" {
+		t.Errorf("a read that raced a write cached %q", res.Value)
+	}
+}
+
+// mockWatchStream collects the events sent by Watch.
+type mockWatchStream struct {
+	apiv1.RuneService_WatchServer
+	ctx    context.Context
+	events chan *apiv1.WatchEvent
+}
+
+func (m *mockWatchStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockWatchStream) Send(ev *apiv1.WatchEvent) error {
+	m.events <- ev
+	return nil
+}
+
+func TestGRPCServer_Watch(t *testing.T) {
+	hub := watch.NewHub(16)
+	hub.Publish(watch.Event{Index: 1, Key: "logical/kv/secrets/db", Op: watch.OpPut})
+	hub.Publish(watch.Event{Index: 2, Key: "logical/kv/config/feature", Op: watch.OpPut})
+
+	server := &GRPCServer{
+		Config: &Config{
+			Seal:   &mockSealer{unsealed: true},
+			Events: hub.View("logical/kv/"),
+		},
+	}
+
+	t.Run("resume and stream", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := &mockWatchStream{ctx: ctx, events: make(chan *apiv1.WatchEvent, 8)}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Watch(&apiv1.WatchRequest{Path: "secrets/", StartIndex: 2}, stream)
+		}()
+		hub.Publish(watch.Event{Index: 3, Key: "logical/kv/secrets/db", Op: watch.OpDelete})
+
+		ev := <-stream.events
+		if ev.Path != "secrets/db" || ev.Type != apiv1.WatchEvent_DELETE || ev.Index != 3 {
+			t.Fatalf("unexpected event: %v", ev)
+		}
+		cancel()
+		if err := <-done; status.Code(err) != codes.Canceled {
+			t.Fatalf("expected Canceled after the client went away, got %v", err)
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		stream = &mockWatchStream{ctx: ctx, events: make(chan *apiv1.WatchEvent, 8)}
+		go server.Watch(&apiv1.WatchRequest{Path: "secrets/", StartIndex: 1}, stream)
+
+		ev = <-stream.events
+		if ev.Path != "secrets/db" || ev.Index != 3 {
+			t.Fatalf("expected to resume with index 3, got %v", ev)
+		}
+	})
+
+	t.Run("failure on compacted index", func(t *testing.T) {
+		small := watch.NewHub(1)
+		for i := uint64(1); i <= 3; i++ {
+			small.Publish(watch.Event{Index: i, Key: "a", Op: watch.OpPut})
+		}
+		server := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}, Events: small}}
+
+		err := server.Watch(&apiv1.WatchRequest{StartIndex: 1}, &mockWatchStream{ctx: context.Background()})
+		if status.Code(err) != codes.OutOfRange {
+			t.Fatalf("expected OutOfRange, got: %v", err)
+		}
+	})
+
+	t.Run("failure when sealed", func(t *testing.T) {
+		server := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: false}, Events: hub}}
+
+		err := server.Watch(&apiv1.WatchRequest{}, &mockWatchStream{ctx: context.Background()})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("expected FailedPrecondition, got: %v", err)
+		}
+	})
+
+	t.Run("failure when not enabled", func(t *testing.T) {
+		server := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}}}
+
+		err := server.Watch(&apiv1.WatchRequest{}, &mockWatchStream{ctx: context.Background()})
+		if status.Code(err) != codes.Unimplemented {
+			t.Fatalf("expected Unimplemented, got: %v", err)
+		}
+	})
+}
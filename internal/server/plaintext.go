@@ -0,0 +1,83 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/thelamedev/rune/internal/lru"
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// plaintextCache holds decrypted secrets for a short while.
+type plaintextCache struct {
+	lru *lru.Cache[string, []byte]
+
+	// gen is bumped by every invalidation. As in storage.Cache, a read only
+	// fills the cache if nothing was invalidated while it read from storage,
+	// so a slow read cannot cache a value a concurrent write has replaced.
+	mu  sync.Mutex
+	gen uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func newPlaintextCache(entries int, ttl time.Duration) *plaintextCache {
+	return &plaintextCache{
+		lru: lru.New[string, []byte](lru.Options[[]byte]{
+			MaxEntries: entries,
+			TTL:        ttl,
+		}),
+	}
+}
+
+// get returns the cached value of path. On a miss it also returns the
+// generation to pass to add once the value has been read.
+func (c *plaintextCache) get(path string) ([]byte, uint64, bool) {
+	if value, ok := c.lru.Get(path); ok {
+		c.hits.Add(1)
+		metrics.IncrCounter([]string{"rune", "server", "plaintext_cache", "hit"}, 1)
+		return value, 0, true
+	}
+	c.misses.Add(1)
+	metrics.IncrCounter([]string{"rune", "server", "plaintext_cache", "miss"}, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return nil, c.gen, false
+}
+
+// add caches value for path unless something was invalidated since gen.
+func (c *plaintextCache) add(path string, value []byte, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen == gen {
+		c.lru.Add(path, value)
+	}
+}
+
+func (c *plaintextCache) remove(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, path := range paths {
+		c.lru.Remove(path)
+	}
+}
+
+func (c *plaintextCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.lru.Purge()
+}
+
+func (c *plaintextCache) stats() storage.CacheStats {
+	return storage.CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: c.lru.Len(),
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Cluster manages the members of the Raft cluster for the RaftService RPCs.
+// Only the leader can serve them; forward passes them on from other nodes.
+type Cluster interface {
+	IsLeader() bool
+	LeaderAPIAddr(ctx context.Context) (string, error)
+	Join(ctx context.Context, id, addr, apiAddr string) error
+	RemovePeer(ctx context.Context, id string) error
+	Peers(ctx context.Context) ([]raft.Peer, error)
+	// Barrier and LeaderContact serve reads that ask for a ReadConsistency.
+	Barrier(ctx context.Context) error
+	LeaderContact() (time.Duration, bool)
+	// Health and Stats serve the autopilot RPCs.
+	Health(ctx context.Context) (raft.ClusterHealth, error)
+	Stats() raft.ServerStats
+}
+
+func (s *GRPCServer) Join(ctx context.Context, req *apiv1.JoinRequest) (*apiv1.JoinResponse, error) {
+	if err := s.checkCluster(); err != nil {
+		return nil, err
+	}
+	if req.NodeId == "" || req.RaftAddress == "" {
+		return nil, status.Error(codes.InvalidArgument, "node ID and raft address are required")
+	}
+
+	if err := s.Cluster.Join(ctx, req.NodeId, req.RaftAddress, req.ApiAddress); err != nil {
+		return nil, clusterError(err, "failed to join node")
+	}
+	return &apiv1.JoinResponse{}, nil
+}
+
+func (s *GRPCServer) RemovePeer(ctx context.Context, req *apiv1.RemovePeerRequest) (*apiv1.RemovePeerResponse, error) {
+	if err := s.checkCluster(); err != nil {
+		return nil, err
+	}
+	if req.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "node ID is required")
+	}
+
+	if err := s.Cluster.RemovePeer(ctx, req.NodeId); err != nil {
+		return nil, clusterError(err, "failed to remove peer")
+	}
+	return &apiv1.RemovePeerResponse{}, nil
+}
+
+// ListPeers is passed on to the leader like the changes to the membership,
+// since only the leader knows when it last heard from each peer.
+func (s *GRPCServer) ListPeers(ctx context.Context, req *apiv1.ListPeersRequest) (*apiv1.ListPeersResponse, error) {
+	if err := s.checkCluster(); err != nil {
+		return nil, err
+	}
+
+	peers, err := s.Cluster.Peers(ctx)
+	if err != nil {
+		return nil, clusterError(err, "failed to list peers")
+	}
+	resp := &apiv1.ListPeersResponse{Peers: make([]*apiv1.Peer, 0, len(peers))}
+	for _, p := range peers {
+		peer := &apiv1.Peer{
+			NodeId:      p.ID,
+			RaftAddress: p.Address,
+			ApiAddress:  p.APIAddr,
+			Voter:       p.Voter,
+			Leader:      p.Leader,
+		}
+		if !p.LastContact.IsZero() {
+			peer.LastContactMs = max(time.Since(p.LastContact).Milliseconds(), 1)
+		}
+		resp.Peers = append(resp.Peers, peer)
+	}
+	return resp, nil
+}
+
+// ListQuarantined is served by whichever node it reaches, from its own
+// storage, where the FSM records the entries it skipped.
+func (s *GRPCServer) ListQuarantined(ctx context.Context, req *apiv1.ListQuarantinedRequest) (*apiv1.ListQuarantinedResponse, error) {
+	if err := s.checkCluster(); err != nil {
+		return nil, err
+	}
+	if s.Backend == nil {
+		return nil, status.Error(codes.Unimplemented, "storage operations are not enabled on this server")
+	}
+
+	entries, err := raft.Quarantined(ctx, s.Backend)
+	if err != nil {
+		return nil, clusterError(err, "failed to list quarantined log entries")
+	}
+	resp := &apiv1.ListQuarantinedResponse{Entries: make([]*apiv1.QuarantinedEntry, 0, len(entries))}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, &apiv1.QuarantinedEntry{
+			Index:  e.Index,
+			Term:   e.Term,
+			Reason: e.Reason,
+			Data:   e.Data,
+		})
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) checkCluster() error {
+	if !s.Seal.IsUnsealed() {
+		return status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	if s.Cluster == nil {
+		return status.Error(codes.Unimplemented, "raft is not enabled on this server")
+	}
+	return nil
+}
+
+// clusterError maps an error from the cluster to a gRPC status, using msg for
+// unexpected failures.
+func clusterError(err error, msg string) error {
+	switch {
+	case errors.Is(err, raft.ErrUnknownPeer):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, raft.ErrNoLeader), errors.Is(err, raft.ErrNotLeader):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, raft.ErrAutopilotDisabled):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Errorf(codes.Internal, "%s: %v", msg, err)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/raft"
+	"github.com/thelamedev/rune/internal/storage"
+	"google.golang.org/grpc/codes"
+)
+
+// mockCluster is a mock of the Cluster interface.
+type mockCluster struct {
+	leader     bool
+	leaderAddr string
+	// contact is how long ago a follower heard from the leader.
+	contact    time.Duration
+	barrierErr error
+	// health is reported by Health, which fails as if autopilot were
+	// disabled without it.
+	health *raft.ClusterHealth
+	stats  raft.ServerStats
+
+	mu       sync.Mutex
+	peers    []raft.Peer
+	barriers int
+}
+
+func (m *mockCluster) IsLeader() bool {
+	return m.leader
+}
+
+func (m *mockCluster) LeaderAPIAddr(ctx context.Context) (string, error) {
+	if m.leaderAddr == "" {
+		return "", raft.ErrNoLeader
+	}
+	return m.leaderAddr, nil
+}
+
+func (m *mockCluster) Join(ctx context.Context, id, addr, apiAddr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peers = append(m.peers, raft.Peer{ID: id, Address: addr, APIAddr: apiAddr, Voter: true})
+	return nil
+}
+
+func (m *mockCluster) RemovePeer(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, p := range m.peers {
+		if p.ID == id {
+			m.peers = append(m.peers[:i], m.peers[i+1:]...)
+			return nil
+		}
+	}
+	return raft.ErrUnknownPeer
+}
+
+func (m *mockCluster) Peers(ctx context.Context) ([]raft.Peer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]raft.Peer(nil), m.peers...), nil
+}
+
+func (m *mockCluster) Barrier(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.barriers++
+	if !m.leader {
+		return raft.ErrNotLeader
+	}
+	return m.barrierErr
+}
+
+func (m *mockCluster) LeaderContact() (time.Duration, bool) {
+	if m.leader {
+		return 0, true
+	}
+	return m.contact, m.contact > 0
+}
+
+func (m *mockCluster) Health(ctx context.Context) (raft.ClusterHealth, error) {
+	if m.health == nil {
+		return raft.ClusterHealth{}, raft.ErrAutopilotDisabled
+	}
+	return *m.health, nil
+}
+
+func (m *mockCluster) Stats() raft.ServerStats {
+	return m.stats
+}
+
+func TestGRPCServer_Raft(t *testing.T) {
+	ctx := context.Background()
+	cluster := &mockCluster{leader: true}
+	server := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}, Cluster: cluster}}
+
+	t.Run("success", func(t *testing.T) {
+		_, err := server.Join(ctx, &apiv1.JoinRequest{NodeId: "node-2", RaftAddress: "10.0.0.2:7000", ApiAddress: "10.0.0.2:8000"})
+		if err != nil {
+			t.Fatalf("Join() returned an unexpected error: %v", err)
+		}
+		resp, err := server.ListPeers(ctx, &apiv1.ListPeersRequest{})
+		if err != nil {
+			t.Fatalf("ListPeers() returned an unexpected error: %v", err)
+		}
+		if len(resp.Peers) != 1 || resp.Peers[0].NodeId != "node-2" || resp.Peers[0].ApiAddress != "10.0.0.2:8000" || !resp.Peers[0].Voter {
+			t.Fatalf("expected the joined node, got %v", resp.Peers)
+		}
+
+		_, err = server.RemovePeer(ctx, &apiv1.RemovePeerRequest{NodeId: "node-2"})
+		if err != nil {
+			t.Fatalf("RemovePeer() returned an unexpected error: %v", err)
+		}
+		if peers, _ := cluster.Peers(ctx); len(peers) != 0 {
+			t.Fatalf("expected the peer to be removed, got %v", peers)
+		}
+	})
+
+	t.Run("unknown peer", func(t *testing.T) {
+		_, err := server.RemovePeer(ctx, &apiv1.RemovePeerRequest{NodeId: "node-9"})
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("failure on invalid request", func(t *testing.T) {
+		_, err := server.Join(ctx, &apiv1.JoinRequest{NodeId: "node-2"})
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("failure when sealed", func(t *testing.T) {
+		sealed := &GRPCServer{Config: &Config{Seal: &mockSealer{}, Cluster: cluster}}
+		_, err := sealed.ListPeers(ctx, &apiv1.ListPeersRequest{})
+		expectCode(t, err, codes.FailedPrecondition)
+	})
+
+	t.Run("failure when not enabled", func(t *testing.T) {
+		plain := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}}}
+		_, err := plain.ListPeers(ctx, &apiv1.ListPeersRequest{})
+		expectCode(t, err, codes.Unimplemented)
+	})
+}
+
+func TestGRPCServer_ListQuarantined(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemStore()
+	fsm := raft.NewFSM(backend)
+	fsm.Apply(&hraft.Log{Index: 7, Term: 2, Type: hraft.LogCommand, Data: []byte("garbage")})
+
+	server := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}, Cluster: &mockCluster{}, Backend: backend}}
+	resp, err := server.ListQuarantined(ctx, &apiv1.ListQuarantinedRequest{})
+	if err != nil {
+		t.Fatalf("ListQuarantined() returned an unexpected error: %v", err)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].Index != 7 || resp.Entries[0].Term != 2 || string(resp.Entries[0].Data) != "garbage" || resp.Entries[0].Reason == "" {
+		t.Fatalf("expected the quarantined entry, got %v", resp.Entries)
+	}
+
+	t.Run("failure when not enabled", func(t *testing.T) {
+		plain := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}, Backend: backend}}
+		_, err := plain.ListQuarantined(ctx, &apiv1.ListQuarantinedRequest{})
+		expectCode(t, err, codes.Unimplemented)
+	})
+}
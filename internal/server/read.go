@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"strconv"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// appliedIndexHeader reports the index of the last Raft log entry the
+	// node serving a read had applied before reading.
+	appliedIndexHeader = "rune-applied-index"
+	// lastContactHeader reports how long ago a follower serving a stale
+	// read last heard from the leader, in milliseconds.
+	lastContactHeader = "rune-last-contact-ms"
+)
+
+// readConsistency returns the consistency a Get or List asks for, falling
+// back to the server's default, and false for other requests.
+func (s *GRPCServer) readConsistency(req any) (apiv1.ReadConsistency, bool) {
+	var consistency apiv1.ReadConsistency
+	switch req := req.(type) {
+	case *apiv1.GetRequest:
+		consistency = req.Consistency
+	case *apiv1.ListRequest:
+		consistency = req.Consistency
+	default:
+		return 0, false
+	}
+
+	if consistency == apiv1.ReadConsistency_READ_CONSISTENCY_DEFAULT {
+		consistency = s.ReadConsistency
+	}
+	if consistency == apiv1.ReadConsistency_READ_CONSISTENCY_DEFAULT {
+		consistency = apiv1.ReadConsistency_READ_CONSISTENCY_STALE
+	}
+	return consistency, true
+}
+
+// needsLeader reports whether reads with consistency are served by the leader.
+func needsLeader(consistency apiv1.ReadConsistency) bool {
+	return consistency == apiv1.ReadConsistency_READ_CONSISTENCY_LEADER ||
+		consistency == apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE
+}
+
+// beginRead makes sure this node can serve a read with the consistency req
+// asks for, and reports how up to date the read is in the response headers.
+// Reads that need the leader have been passed on to it by forward already.
+func (s *GRPCServer) beginRead(ctx context.Context, req any) error {
+	consistency, _ := s.readConsistency(req)
+	switch {
+	case !needsLeader(consistency) && consistency != apiv1.ReadConsistency_READ_CONSISTENCY_STALE:
+		return status.Errorf(codes.InvalidArgument, "unknown read consistency: %v", consistency)
+	case s.Cluster == nil:
+		// A single server is always up to date.
+	case consistency == apiv1.ReadConsistency_READ_CONSISTENCY_LEADER:
+		if !s.Cluster.IsLeader() {
+			return status.Error(codes.Unavailable, "this node is no longer the raft leader, try again")
+		}
+	case consistency == apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE:
+		if err := s.Cluster.Barrier(ctx); err != nil {
+			return clusterError(err, "failed to confirm raft leadership")
+		}
+	}
+
+	header := metadata.MD{}
+	if s.AppliedIndex != nil {
+		header.Set(appliedIndexHeader, strconv.FormatUint(s.AppliedIndex(), 10))
+	}
+	if s.Cluster != nil && consistency == apiv1.ReadConsistency_READ_CONSISTENCY_STALE {
+		if age, ok := s.Cluster.LeaderContact(); ok {
+			header.Set(lastContactHeader, strconv.FormatInt(age.Milliseconds(), 10))
+		}
+	}
+	// Handlers called outside of a gRPC server have no headers to set.
+	_ = grpc.SetHeader(ctx, header)
+	return nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestGRPCServer_ReadConsistency(t *testing.T) {
+	ctx := context.Background()
+	seal := &mockSealer{unsealed: true}
+	store := storage.NewMemStore()
+	if err := store.Put(ctx, "secret/db", []byte("s3cr3t")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+	appliedIndex := func() uint64 { return 7 }
+
+	t.Run("stale reads report their staleness", func(t *testing.T) {
+		_, conn := serve(t, &Config{
+			Storage:      store,
+			Seal:         seal,
+			Cluster:      &mockCluster{contact: 1500 * time.Millisecond},
+			AppliedIndex: appliedIndex,
+		})
+		client := apiv1.NewRuneServiceClient(conn)
+
+		var header metadata.MD
+		if _, err := client.Get(ctx, &apiv1.GetRequest{Path: "secret/db"}, grpc.Header(&header)); err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if got := header.Get(appliedIndexHeader); len(got) != 1 || got[0] != "7" {
+			t.Fatalf("expected applied index 7, got %v", header)
+		}
+		if got := header.Get(lastContactHeader); len(got) != 1 || got[0] != "1500" {
+			t.Fatalf("expected the last contact with the leader, got %v", header)
+		}
+
+		header = nil
+		if _, err := client.List(ctx, &apiv1.ListRequest{Path: "secret"}, grpc.Header(&header)); err != nil {
+			t.Fatalf("List() returned an unexpected error: %v", err)
+		}
+		if got := header.Get(appliedIndexHeader); len(got) != 1 || got[0] != "7" {
+			t.Fatalf("expected applied index 7, got %v", header)
+		}
+	})
+
+	t.Run("linearizable reads wait for a barrier", func(t *testing.T) {
+		cluster := &mockCluster{leader: true}
+		server := &GRPCServer{Config: &Config{Storage: store, Seal: seal, Cluster: cluster}}
+
+		req := &apiv1.GetRequest{Path: "secret/db", Consistency: apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE}
+		if _, err := server.Get(ctx, req); err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		_, err := server.List(ctx, &apiv1.ListRequest{Path: "secret", Consistency: apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE})
+		if err != nil {
+			t.Fatalf("List() returned an unexpected error: %v", err)
+		}
+		if cluster.barriers != 2 {
+			t.Fatalf("expected a barrier for every read, got %d", cluster.barriers)
+		}
+
+		// Leader reads trust the lease instead.
+		if _, err := server.Get(ctx, &apiv1.GetRequest{Path: "secret/db", Consistency: apiv1.ReadConsistency_READ_CONSISTENCY_LEADER}); err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if cluster.barriers != 2 {
+			t.Fatalf("expected no barrier for a leader read, got %d", cluster.barriers)
+		}
+
+		cluster.barrierErr = errors.New("timed out enqueuing operation")
+		_, err = server.Get(ctx, req)
+		expectCode(t, err, codes.Internal)
+	})
+
+	t.Run("failure on a deposed leader", func(t *testing.T) {
+		server := &GRPCServer{Config: &Config{Storage: store, Seal: seal, Cluster: &mockCluster{}}}
+		for _, consistency := range []apiv1.ReadConsistency{
+			apiv1.ReadConsistency_READ_CONSISTENCY_LEADER,
+			apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE,
+		} {
+			_, err := server.Get(ctx, &apiv1.GetRequest{Path: "secret/db", Consistency: consistency})
+			expectCode(t, err, codes.Unavailable)
+		}
+	})
+
+	t.Run("single servers serve every consistency", func(t *testing.T) {
+		server := &GRPCServer{Config: &Config{Storage: store, Seal: seal}}
+		_, err := server.Get(ctx, &apiv1.GetRequest{Path: "secret/db", Consistency: apiv1.ReadConsistency_READ_CONSISTENCY_LINEARIZABLE})
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+	})
+
+	t.Run("failure on unknown consistency", func(t *testing.T) {
+		server := &GRPCServer{Config: &Config{Storage: store, Seal: seal}}
+		_, err := server.Get(ctx, &apiv1.GetRequest{Path: "secret/db", Consistency: apiv1.ReadConsistency(42)})
+		expectCode(t, err, codes.InvalidArgument)
+	})
+}
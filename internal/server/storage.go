@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/merkle"
+	"github.com/thelamedev/rune/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *GRPCServer) StorageStats(ctx context.Context, req *apiv1.StorageStatsRequest) (*apiv1.StorageStatsResponse, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	stats, err := storage.CollectStats(ctx, s.Backend, storage.StatsOptions{
+		PrefixDepth: int(req.PrefixDepth),
+		TopValues:   int(req.TopValues),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to collect storage stats")
+	}
+
+	resp := &apiv1.StorageStatsResponse{
+		Keys:          stats.Keys,
+		ValueBytes:    stats.ValueBytes,
+		Prefixes:      make([]*apiv1.PrefixStats, 0, len(stats.Prefixes)),
+		LargestValues: make([]*apiv1.ValueSize, 0, len(stats.LargestValues)),
+	}
+	for _, p := range stats.Prefixes {
+		resp.Prefixes = append(resp.Prefixes, &apiv1.PrefixStats{Prefix: p.Prefix, Keys: p.Keys, ValueBytes: p.ValueBytes})
+	}
+	for _, v := range stats.LargestValues {
+		resp.LargestValues = append(resp.LargestValues, &apiv1.ValueSize{Key: v.Key, Size: uint64(v.Size)})
+	}
+	if f := stats.File; f != nil {
+		resp.File = &apiv1.FileStats{
+			Path:         f.Path,
+			Size:         f.Size,
+			PageSize:     uint32(f.PageSize),
+			FreePages:    uint64(f.FreePages),
+			PendingPages: uint64(f.PendingPages),
+			FreeBytes:    f.FreeBytes(),
+		}
+	}
+	if stats.Cache != nil {
+		resp.Cache = cacheStatsToProto(*stats.Cache)
+	}
+	if s.plaintext != nil {
+		resp.PlaintextCache = cacheStatsToProto(s.plaintext.stats())
+	}
+	return resp, nil
+}
+
+func cacheStatsToProto(stats storage.CacheStats) *apiv1.CacheStats {
+	return &apiv1.CacheStats{
+		Hits:     stats.Hits,
+		Misses:   stats.Misses,
+		HitRatio: stats.HitRatio(),
+		Entries:  int64(stats.Entries),
+		Bytes:    int64(stats.Bytes),
+	}
+}
+
+func (s *GRPCServer) CompactStorage(ctx context.Context, req *apiv1.CompactStorageRequest) (*apiv1.CompactStorageResponse, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	res, err := storage.Compact(ctx, s.Backend)
+	if errors.Is(err, storage.ErrCompactUnsupported) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to compact storage")
+	}
+	return &apiv1.CompactStorageResponse{SizeBefore: res.SizeBefore, SizeAfter: res.SizeAfter}, nil
+}
+
+func (s *GRPCServer) StorageHash(ctx context.Context, req *apiv1.StorageHashRequest) (*apiv1.StorageHashResponse, error) {
+	if !s.Seal.IsUnsealed() {
+		return nil, status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	if s.Hashes == nil {
+		return nil, status.Error(codes.Unimplemented, "storage hashes are not enabled on this server")
+	}
+
+	// Read the index first: the tree may then include later writes, but a
+	// node whose index matches another's has at least the same writes.
+	var index uint64
+	if s.AppliedIndex != nil {
+		index = s.AppliedIndex()
+	}
+	node, err := s.Hashes.Node(req.Path)
+	if errors.Is(err, merkle.ErrInvalidPath) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to read storage hash")
+	}
+
+	resp := &apiv1.StorageHashResponse{
+		Path:         node.Path,
+		Hash:         node.Hash,
+		Children:     node.Children,
+		AppliedIndex: index,
+	}
+	for _, e := range node.Entries {
+		resp.Entries = append(resp.Entries, &apiv1.KeyHash{Key: e.Key, Hash: e.Hash})
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) MigrateStoragePaths(ctx context.Context, req *apiv1.MigrateStoragePathsRequest) (*apiv1.MigrateStoragePathsResponse, error) {
+	if !s.Seal.IsUnsealed() {
+		return nil, status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	if s.Paths == nil {
+		return nil, status.Error(codes.Unimplemented, "path migration is not enabled on this server")
+	}
+
+	moved, err := s.Paths.MigratePaths(ctx)
+	if err != nil {
+		return nil, storageError(err, "failed to migrate storage paths")
+	}
+	return &apiv1.MigrateStoragePathsResponse{Moved: uint64(moved), PathHashing: s.Paths.HashesPaths()}, nil
+}
+
+func (s *GRPCServer) checkBackend() error {
+	if !s.Seal.IsUnsealed() {
+		return status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	if s.Backend == nil {
+		return status.Error(codes.Unimplemented, "storage operations are not enabled on this server")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/crypto"
+	"github.com/thelamedev/rune/internal/merkle"
+	"github.com/thelamedev/rune/internal/storage"
+	"google.golang.org/grpc/codes"
+)
+
+func TestGRPCServer_Storage(t *testing.T) {
+	ctx := context.Background()
+
+	bolt, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "rune.db"))
+	if err != nil {
+		t.Fatalf("failed to create bolt store: %v", err)
+	}
+	if err := bolt.Initialize(ctx); err != nil {
+		t.Fatalf("failed to initialize bolt store: %v", err)
+	}
+	defer bolt.Close()
+	for _, key := range []string{"logical/kv/a", "logical/kv/b", "sys/mounts"} {
+		if err := bolt.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+	}
+
+	cache := storage.NewCache(bolt, storage.CacheOptions{MaxEntries: 8})
+	server := &GRPCServer{
+		Config: &Config{
+			Seal:    &mockSealer{unsealed: true},
+			Backend: cache,
+		},
+		plaintext: newPlaintextCache(8, time.Minute),
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		for range 4 {
+			if _, err := cache.Get(ctx, "sys/mounts"); err != nil {
+				t.Fatalf("failed to read through the cache: %v", err)
+			}
+		}
+		resp, err := server.StorageStats(ctx, &apiv1.StorageStatsRequest{TopValues: 1})
+		if err != nil {
+			t.Fatalf("StorageStats() returned an unexpected error: %v", err)
+		}
+		if resp.Keys != 3 || len(resp.Prefixes) != 2 || resp.Prefixes[0].Keys != 2 {
+			t.Fatalf("unexpected stats: %v", resp)
+		}
+		if len(resp.LargestValues) != 1 || resp.File == nil || resp.File.Size == 0 {
+			t.Fatalf("expected the largest value and file stats, got %v", resp)
+		}
+		if c := resp.Cache; c == nil || c.Hits != 3 || c.Misses != 1 || c.HitRatio != 0.75 {
+			t.Fatalf("expected cache stats with 3 hits and 1 miss, got %v", resp.Cache)
+		}
+		if resp.PlaintextCache == nil {
+			t.Fatal("expected plaintext cache stats")
+		}
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		resp, err := server.CompactStorage(ctx, &apiv1.CompactStorageRequest{})
+		if err != nil {
+			t.Fatalf("CompactStorage() returned an unexpected error: %v", err)
+		}
+		if resp.SizeBefore == 0 || resp.SizeAfter == 0 {
+			t.Fatalf("expected file sizes, got %v", resp)
+		}
+		if _, err := bolt.Get(ctx, "sys/mounts"); err != nil {
+			t.Fatalf("expected data to survive compaction, got %v", err)
+		}
+	})
+
+	t.Run("failure on unsupported backend", func(t *testing.T) {
+		mem := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}, Backend: storage.NewMemStore()}}
+		_, err := mem.CompactStorage(ctx, &apiv1.CompactStorageRequest{})
+		expectCode(t, err, codes.FailedPrecondition)
+	})
+
+	t.Run("failure when not enabled", func(t *testing.T) {
+		plain := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}}}
+		_, err := plain.StorageStats(ctx, &apiv1.StorageStatsRequest{})
+		expectCode(t, err, codes.Unimplemented)
+	})
+}
+
+func TestGRPCServer_StorageHash(t *testing.T) {
+	ctx := context.Background()
+
+	tree := merkle.NewTree()
+	tree.Set("logical/kv/a", []byte("a"))
+	server := &GRPCServer{
+		Config: &Config{
+			Seal:         &mockSealer{unsealed: true},
+			Hashes:       tree,
+			AppliedIndex: func() uint64 { return 42 },
+		},
+	}
+
+	t.Run("root", func(t *testing.T) {
+		resp, err := server.StorageHash(ctx, &apiv1.StorageHashRequest{})
+		if err != nil {
+			t.Fatalf("StorageHash() returned an unexpected error: %v", err)
+		}
+		if !bytes.Equal(resp.Hash, tree.Root()) || len(resp.Children) != merkle.Fanout || resp.AppliedIndex != 42 {
+			t.Fatalf("unexpected root: %v", resp)
+		}
+	})
+
+	t.Run("leaf", func(t *testing.T) {
+		var leaf *apiv1.StorageHashResponse
+		for i := range 1 << (4 * merkle.Depth) {
+			resp, err := server.StorageHash(ctx, &apiv1.StorageHashRequest{Path: fmt.Sprintf("%03x", i)})
+			if err != nil {
+				t.Fatalf("StorageHash() returned an unexpected error: %v", err)
+			}
+			if len(resp.Entries) > 0 {
+				leaf = resp
+			}
+		}
+		if leaf == nil || len(leaf.Entries) != 1 || leaf.Entries[0].Key != "logical/kv/a" {
+			t.Fatalf("expected one leaf to hold the key, got %v", leaf)
+		}
+	})
+
+	t.Run("failure on invalid path", func(t *testing.T) {
+		_, err := server.StorageHash(ctx, &apiv1.StorageHashRequest{Path: "xyz"})
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("failure when sealed", func(t *testing.T) {
+		sealed := &GRPCServer{Config: &Config{Seal: &mockSealer{}, Hashes: tree}}
+		_, err := sealed.StorageHash(ctx, &apiv1.StorageHashRequest{})
+		expectCode(t, err, codes.FailedPrecondition)
+	})
+}
+
+func TestGRPCServer_MigrateStoragePaths(t *testing.T) {
+	ctx := context.Background()
+
+	backend := storage.NewMemStore()
+	key := make([]byte, crypto.KeySize)
+	rand.Read(key)
+	plain, hashed := barrier.New(backend), barrier.New(backend, barrier.WithPathHashing())
+	for _, b := range []*barrier.Barrier{plain, hashed} {
+		if err := b.Unseal(key); err != nil {
+			t.Fatalf("failed to unseal barrier: %v", err)
+		}
+	}
+	if err := plain.Put(ctx, "logical/kv/db", []byte("x")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+
+	server := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}, Paths: hashed}}
+	resp, err := server.MigrateStoragePaths(ctx, &apiv1.MigrateStoragePathsRequest{})
+	if err != nil {
+		t.Fatalf("MigrateStoragePaths() returned an unexpected error: %v", err)
+	}
+	if resp.Moved != 1 || !resp.PathHashing {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if got, err := hashed.Get(ctx, "logical/kv/db"); err != nil || string(got) != "x" {
+		t.Fatalf("expected the value at its hashed path, got %q (%v)", got, err)
+	}
+
+	t.Run("failure when not enabled", func(t *testing.T) {
+		plain := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}}}
+		_, err := plain.MigrateStoragePaths(ctx, &apiv1.MigrateStoragePathsRequest{})
+		expectCode(t, err, codes.Unimplemented)
+	})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/mount"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Mounter manages the mount table for the SysService RPCs.
+type Mounter interface {
+	Mount(ctx context.Context, entry mount.Entry) (mount.Entry, error)
+	Unmount(ctx context.Context, path string) error
+	Tune(ctx context.Context, path string, tune mount.Tune) (mount.Entry, error)
+	Mounts() []mount.Entry
+}
+
+func (s *GRPCServer) EnableMount(ctx context.Context, req *apiv1.EnableMountRequest) (*apiv1.EnableMountResponse, error) {
+	if err := s.checkMounts(); err != nil {
+		return nil, err
+	}
+
+	entry, err := s.Mounts.Mount(ctx, mount.Entry{
+		Path:        req.Path,
+		Type:        req.Type,
+		Description: req.Description,
+		Options:     req.Options,
+	})
+	// The new mount takes over paths whose secrets another mount served.
+	s.purgePlaintext()
+	if err != nil {
+		return nil, mountError(err, "failed to enable mount")
+	}
+	return &apiv1.EnableMountResponse{Mount: mountToProto(entry)}, nil
+}
+
+func (s *GRPCServer) DisableMount(ctx context.Context, req *apiv1.DisableMountRequest) (*apiv1.DisableMountResponse, error) {
+	if err := s.checkMounts(); err != nil {
+		return nil, err
+	}
+
+	err := s.Mounts.Unmount(ctx, req.Path)
+	// Secrets of the mount may be cached under paths another mount takes.
+	s.purgePlaintext()
+	if err != nil {
+		return nil, mountError(err, "failed to disable mount")
+	}
+	return &apiv1.DisableMountResponse{}, nil
+}
+
+func (s *GRPCServer) TuneMount(ctx context.Context, req *apiv1.TuneMountRequest) (*apiv1.TuneMountResponse, error) {
+	if err := s.checkMounts(); err != nil {
+		return nil, err
+	}
+
+	entry, err := s.Mounts.Tune(ctx, req.Path, mount.Tune{
+		Description: req.Description,
+		Options:     req.Options,
+	})
+	if err != nil {
+		return nil, mountError(err, "failed to tune mount")
+	}
+	s.purgePlaintext()
+	return &apiv1.TuneMountResponse{Mount: mountToProto(entry)}, nil
+}
+
+func (s *GRPCServer) ListMounts(ctx context.Context, req *apiv1.ListMountsRequest) (*apiv1.ListMountsResponse, error) {
+	if err := s.checkMounts(); err != nil {
+		return nil, err
+	}
+
+	entries := s.Mounts.Mounts()
+	resp := &apiv1.ListMountsResponse{Mounts: make([]*apiv1.Mount, 0, len(entries))}
+	for _, entry := range entries {
+		resp.Mounts = append(resp.Mounts, mountToProto(entry))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) checkMounts() error {
+	if !s.Seal.IsUnsealed() {
+		return status.Error(codes.FailedPrecondition, "vault is sealed")
+	}
+	if s.Mounts == nil {
+		return status.Error(codes.Unimplemented, "mounts are not enabled on this server")
+	}
+	return nil
+}
+
+func (s *GRPCServer) purgePlaintext() {
+	if s.plaintext != nil {
+		s.plaintext.purge()
+	}
+}
+
+func mountToProto(entry mount.Entry) *apiv1.Mount {
+	return &apiv1.Mount{
+		Path:        entry.Path,
+		Type:        entry.Type,
+		Id:          entry.ID,
+		Description: entry.Description,
+		Options:     entry.Options,
+	}
+}
+
+// mountError maps an error from the mount table to a gRPC status, using msg
+// for unexpected failures.
+func mountError(err error, msg string) error {
+	switch {
+	case errors.Is(err, mount.ErrNoMount):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, mount.ErrPathInUse):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, mount.ErrInvalidPath), errors.Is(err, mount.ErrUnknownType), errors.Is(err, mount.ErrInvalidOptions):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return storageError(err, msg)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	apiv1 "github.com/thelamedev/rune/api/v1"
+	"github.com/thelamedev/rune/internal/barrier"
+	"github.com/thelamedev/rune/internal/crypto"
+	"github.com/thelamedev/rune/internal/logical"
+	"github.com/thelamedev/rune/internal/logical/kv"
+	"github.com/thelamedev/rune/internal/mount"
+	"github.com/thelamedev/rune/internal/storage"
+	"github.com/thelamedev/rune/internal/watch"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newMountedServer(t *testing.T) *GRPCServer {
+	t.Helper()
+
+	hub := watch.NewHub(0)
+	b := barrier.New(watch.NewStore(storage.NewMemStore(), hub))
+	key := make([]byte, crypto.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate master key: %v", err)
+	}
+	if err := b.Unseal(key); err != nil {
+		t.Fatalf("failed to unseal barrier: %v", err)
+	}
+
+	table := mount.NewTable(b, hub, map[string]logical.Factory{"kv": kv.Factory})
+	if err := table.Load(t.Context()); err != nil {
+		t.Fatalf("failed to load mount table: %v", err)
+	}
+	return &GRPCServer{
+		Config: &Config{
+			Storage: table,
+			Seal:    &mockSealer{unsealed: true},
+			Events:  table,
+			Mounts:  table,
+		},
+	}
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if st, ok := status.FromError(err); !ok || st.Code() != code {
+		t.Fatalf("expected %v, got: %v", code, err)
+	}
+}
+
+func TestGRPCServer_Mounts(t *testing.T) {
+	ctx := context.Background()
+	server := newMountedServer(t)
+
+	t.Run("enable", func(t *testing.T) {
+		resp, err := server.EnableMount(ctx, &apiv1.EnableMountRequest{Path: "team", Type: "kv", Description: "team secrets"})
+		if err != nil {
+			t.Fatalf("EnableMount() returned an unexpected error: %v", err)
+		}
+		if resp.Mount.Path != "team/" || resp.Mount.Id == "" {
+			t.Fatalf("unexpected mount: %v", resp.Mount)
+		}
+
+		_, err = server.EnableMount(ctx, &apiv1.EnableMountRequest{Path: "team/", Type: "kv"})
+		expectCode(t, err, codes.AlreadyExists)
+		_, err = server.EnableMount(ctx, &apiv1.EnableMountRequest{Path: "pki/", Type: "pki"})
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("routing", func(t *testing.T) {
+		if _, err := server.Put(ctx, &apiv1.PutRequest{Path: "team/db", Value: []byte("team")}); err != nil {
+			t.Fatalf("Put() returned an unexpected error: %v", err)
+		}
+		if _, err := server.Put(ctx, &apiv1.PutRequest{Path: "secret/db", Value: []byte("default")}); err != nil {
+			t.Fatalf("Put() returned an unexpected error: %v", err)
+		}
+		resp, err := server.Get(ctx, &apiv1.GetRequest{Path: "team/db"})
+		if err != nil || string(resp.Value) != "team" {
+			t.Fatalf("expected the team mount's value, got %v (%v)", resp, err)
+		}
+
+		_, err = server.Put(ctx, &apiv1.PutRequest{Path: "unmounted/db", Value: []byte("x")})
+		expectCode(t, err, codes.InvalidArgument)
+		_, err = server.Txn(ctx, &apiv1.TxnRequest{Ops: []*apiv1.TxnOp{
+			{Type: apiv1.TxnOp_DELETE, Path: "team/db"},
+			{Type: apiv1.TxnOp_DELETE, Path: "secret/db"},
+		}})
+		expectCode(t, err, codes.InvalidArgument)
+
+		list, err := server.List(ctx, &apiv1.ListRequest{})
+		if err != nil {
+			t.Fatalf("List() returned an unexpected error: %v", err)
+		}
+		if len(list.Entries) != 2 || list.Entries[0].Name != "secret/" || list.Entries[1].Name != "team/" {
+			t.Fatalf("expected the root to list the mounts, got %v", list.Entries)
+		}
+	})
+
+	t.Run("tune", func(t *testing.T) {
+		description := "renamed"
+		resp, err := server.TuneMount(ctx, &apiv1.TuneMountRequest{Path: "team/", Description: &description})
+		if err != nil {
+			t.Fatalf("TuneMount() returned an unexpected error: %v", err)
+		}
+		if resp.Mount.Description != description {
+			t.Fatalf("expected description %q, got %q", description, resp.Mount.Description)
+		}
+
+		_, err = server.TuneMount(ctx, &apiv1.TuneMountRequest{Path: "team/", Options: map[string]string{"bogus": "1"}})
+		expectCode(t, err, codes.InvalidArgument)
+		_, err = server.TuneMount(ctx, &apiv1.TuneMountRequest{Path: "missing/"})
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("disable and list", func(t *testing.T) {
+		if _, err := server.DisableMount(ctx, &apiv1.DisableMountRequest{Path: "team/"}); err != nil {
+			t.Fatalf("DisableMount() returned an unexpected error: %v", err)
+		}
+		_, err := server.Get(ctx, &apiv1.GetRequest{Path: "team/db"})
+		expectCode(t, err, codes.InvalidArgument)
+
+		resp, err := server.ListMounts(ctx, &apiv1.ListMountsRequest{})
+		if err != nil {
+			t.Fatalf("ListMounts() returned an unexpected error: %v", err)
+		}
+		if len(resp.Mounts) != 1 || resp.Mounts[0].Path != mount.DefaultPath || resp.Mounts[0].Type != "kv" {
+			t.Fatalf("expected only the default mount, got %v", resp.Mounts)
+		}
+	})
+
+	t.Run("failure when sealed", func(t *testing.T) {
+		sealed := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: false}, Mounts: server.Mounts}}
+		_, err := sealed.ListMounts(ctx, &apiv1.ListMountsRequest{})
+		expectCode(t, err, codes.FailedPrecondition)
+	})
+
+	t.Run("failure when not enabled", func(t *testing.T) {
+		plain := &GRPCServer{Config: &Config{Seal: &mockSealer{unsealed: true}}}
+		_, err := plain.EnableMount(ctx, &apiv1.EnableMountRequest{Path: "team/", Type: "kv"})
+		expectCode(t, err, codes.Unimplemented)
+	})
+}
+
+func TestGRPCServer_MountsPurgePlaintext(t *testing.T) {
+	ctx := context.Background()
+	server := newMountedServer(t)
+	server.plaintext = newPlaintextCache(8, time.Minute)
+
+	if _, err := server.Put(ctx, &apiv1.PutRequest{Path: "secret/team/db", Value: []byte("default")}); err != nil {
+		t.Fatalf("Put() returned an unexpected error: %v", err)
+	}
+	if _, err := server.Get(ctx, &apiv1.GetRequest{Path: "secret/team/db"}); err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+
+	// The new mount takes the path over, so the cached secret must go.
+	if _, err := server.EnableMount(ctx, &apiv1.EnableMountRequest{Path: "secret/team/", Type: "kv"}); err != nil {
+		t.Fatalf("EnableMount() returned an unexpected error: %v", err)
+	}
+	_, err := server.Get(ctx, &apiv1.GetRequest{Path: "secret/team/db"})
+	expectCode(t, err, codes.NotFound)
+}
@@ -0,0 +1,64 @@
+package storage
+
+import "context"
+
+// Batcher is implemented by backends that can apply many transactions for
+// the cost of one, such as a single bbolt transaction and fsync.
+type Batcher interface {
+	// Batch applies each of txns as its own transaction, in order, and
+	// returns the result of each. A transaction whose checks fail is skipped
+	// with ErrTxnCheckFailed, and one with a key the backend cannot store
+	// with ErrInvalidKey, without affecting the others. Any other error fails
+	// the whole batch, in which case none of it is applied.
+	Batch(ctx context.Context, txns [][]TxnOp) ([]error, error)
+}
+
+// Batch applies txns in one go if s is a Batcher. Other backends apply them
+// one transaction at a time, so when one fails with an error other than
+// ErrTxnCheckFailed or ErrInvalidKey, the transactions before it stay
+// applied.
+func Batch(ctx context.Context, s Storage, txns [][]TxnOp) ([]error, error) {
+	if b, ok := s.(Batcher); ok {
+		return b.Batch(ctx, txns)
+	}
+
+	results := make([]error, len(txns))
+	for i, ops := range txns {
+		err := s.Transaction(ctx, ops)
+		if err != nil && !rejected(err) {
+			return nil, err
+		}
+		results[i] = err
+	}
+	return results, nil
+}
+
+// applyBatch applies txns through get and write, the primitives of a single
+// backend transaction, checking each against the writes of those before it.
+func applyBatch(txns [][]TxnOp, get func(key string) []byte, write func(op TxnOp) error) ([]error, error) {
+	results := make([]error, len(txns))
+	for i, ops := range txns {
+		for _, op := range ops {
+			if err := checkTxnOp(op, get(op.Key)); err != nil {
+				results[i] = err
+				break
+			}
+		}
+		if results[i] != nil {
+			if !rejected(results[i]) {
+				return nil, results[i]
+			}
+			continue
+		}
+
+		for _, op := range ops {
+			if op.Op != TxnPut && op.Op != TxnDelete {
+				continue
+			}
+			if err := write(op); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+// plainStore hides the Batcher implementation of the store it wraps.
+type plainStore struct {
+	Storage
+}
+
+func TestBatch(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) Storage{
+		"bolt":     func(t *testing.T) Storage { return newTestBoltStore(t) },
+		"inmem":    func(t *testing.T) Storage { return NewMemStore() },
+		"fallback": func(t *testing.T) Storage { return plainStore{NewMemStore()} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			store := newStore(t)
+			if err := store.Put(ctx, "a", []byte("1")); err != nil {
+				t.Fatalf("failed to put value: %v", err)
+			}
+
+			results, err := Batch(ctx, store, [][]TxnOp{
+				{{Op: TxnCheck, Key: "a", Value: []byte("1")}, {Op: TxnPut, Key: "b", Value: []byte("2")}},
+				{{Op: TxnCheckExists, Key: "c"}, {Op: TxnPut, Key: "d", Value: []byte("4")}},
+				// Later transactions see the writes of earlier ones.
+				{{Op: TxnCheck, Key: "b", Value: []byte("2")}, {Op: TxnDelete, Key: "a"}},
+				{{Op: TxnCheck, Key: "a"}, {Op: TxnPut, Key: "c", Value: []byte("3")}},
+			})
+			if err != nil {
+				t.Fatalf("batch failed: %v", err)
+			}
+			if len(results) != 4 || results[0] != nil || !errors.Is(results[1], ErrTxnCheckFailed) || results[2] != nil || results[3] != nil {
+				t.Fatalf("unexpected results %v", results)
+			}
+
+			for key, want := range map[string]string{"b": "2", "c": "3"} {
+				got, err := store.Get(ctx, key)
+				if err != nil || string(got) != want {
+					t.Fatalf("expected %q at %q, got %q (%v)", want, key, got, err)
+				}
+			}
+			for _, key := range []string{"a", "d"} {
+				if _, err := store.Get(ctx, key); !errors.Is(err, ErrKeyNotFound) {
+					t.Fatalf("expected %q to be missing, got %v", key, err)
+				}
+			}
+		})
+	}
+
+	for name, store := range map[string]Storage{"bolt": newTestBoltStore(t), "inmem": NewMemStore()} {
+		t.Run(name+" fails as a whole", func(t *testing.T) {
+			_, err := Batch(t.Context(), store, [][]TxnOp{
+				{{Op: TxnPut, Key: "e", Value: []byte("5")}},
+				{{Op: TxnOpType(42), Key: "e"}},
+			})
+			if err == nil || errors.Is(err, ErrTxnCheckFailed) {
+				t.Fatalf("expected the batch to fail, got %v", err)
+			}
+			if _, err := store.Get(t.Context(), "e"); !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("expected nothing to be applied, got %v", err)
+			}
+		})
+	}
+}
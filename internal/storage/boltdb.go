@@ -1,11 +1,15 @@
 package storage
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,13 +18,37 @@ import (
 
 var bucketName = []byte("rune_bucket")
 
+func init() {
+	Register("bolt", func(config map[string]string) (Storage, error) {
+		path := config["path"]
+		if path == "" {
+			path = "rune.db"
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+		return NewBoltStore(path)
+	})
+}
+
+// compactTxMaxSize bounds the size of each transaction copying data into a
+// compacted database.
+const compactTxMaxSize = 64 << 20
+
 type BoltStore struct {
-	db     *bbolt.DB
-	dbPath string
+	// mu guards db, which Restore and Compact swap out for a freshly opened
+	// database.
+	mu sync.RWMutex
+	// writeMu is held shared by writers and exclusively by Compact, which
+	// must not miss writes made while it copies the database.
+	writeMu sync.RWMutex
+	db      *bbolt.DB
+	dbPath  string
 }
 
 func NewBoltStore(path string) (*BoltStore, error) {
-	db, err := bbolt.Open(path, 0o600, nil)
+	// Fail rather than block forever when another process holds the file.
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return nil, err
 	}
@@ -28,7 +56,7 @@ func NewBoltStore(path string) (*BoltStore, error) {
 }
 
 func (s *BoltStore) Initialize(ctx context.Context) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.update(func(tx *bbolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists(bucketName)
 		if err != nil {
 			return fmt.Errorf("failed to create bucket: %w", err)
@@ -39,14 +67,14 @@ func (s *BoltStore) Initialize(ctx context.Context) error {
 
 func (s *BoltStore) Get(ctx context.Context, key string) ([]byte, error) {
 	var value []byte
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.view(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(bucketName)
 		if bucket == nil {
 			return fmt.Errorf("bucket not found")
 		}
 		val := bucket.Get([]byte(key))
 		if val == nil {
-			return fmt.Errorf("key not found: %s", key)
+			return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
 		}
 
 		value = make([]byte, len(val))
@@ -60,7 +88,7 @@ func (s *BoltStore) Get(ctx context.Context, key string) ([]byte, error) {
 }
 
 func (s *BoltStore) Put(ctx context.Context, key string, value []byte) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(bucketName)
 		if bucket == nil {
 			return fmt.Errorf("failed to get bucket: %s", bucketName)
@@ -70,7 +98,7 @@ func (s *BoltStore) Put(ctx context.Context, key string, value []byte) error {
 }
 
 func (s *BoltStore) Delete(ctx context.Context, key string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(bucketName)
 		if bucket == nil {
 			return fmt.Errorf("failed to get bucket: %s", bucketName)
@@ -82,7 +110,7 @@ func (s *BoltStore) Delete(ctx context.Context, key string) error {
 func (s *BoltStore) List(ctx context.Context, prefix string) ([]string, error) {
 	var keys []string
 
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.view(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(bucketName)
 		if bucket == nil {
 			return fmt.Errorf("bucket not found")
@@ -103,43 +131,554 @@ func (s *BoltStore) List(ctx context.Context, prefix string) ([]string, error) {
 	return keys, nil
 }
 
+// Transaction applies ops atomically in a single bbolt transaction. All checks
+// are evaluated before any write, so either every op is applied or none are.
+func (s *BoltStore) Transaction(ctx context.Context, ops []TxnOp) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return fmt.Errorf("failed to get bucket: %s", bucketName)
+		}
+
+		for _, op := range ops {
+			if err := checkTxnOp(op, bucket.Get([]byte(op.Key))); err != nil {
+				return err
+			}
+		}
+
+		for _, op := range ops {
+			var err error
+			switch op.Op {
+			case TxnPut:
+				err = bucket.Put([]byte(op.Key), op.Value)
+			case TxnDelete:
+				err = bucket.Delete([]byte(op.Key))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to apply transaction op on %q: %w", op.Key, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Batch applies txns in a single bbolt transaction, so that they share one
+// fsync.
+func (s *BoltStore) Batch(ctx context.Context, txns [][]TxnOp) ([]error, error) {
+	var results []error
+	err := s.update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return fmt.Errorf("failed to get bucket: %s", bucketName)
+		}
+
+		var err error
+		results, err = applyBatch(txns, func(key string) []byte {
+			return bucket.Get([]byte(key))
+		}, func(op TxnOp) error {
+			var err error
+			if op.Op == TxnPut {
+				err = bucket.Put([]byte(op.Key), op.Value)
+			} else {
+				err = bucket.Delete([]byte(op.Key))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to apply transaction op on %q: %w", op.Key, err)
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ListPaged walks the bucket with a cursor, seeking past the contents of each
+// folder instead of visiting every key inside it.
+func (s *BoltStore) ListPaged(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	p := newListPager(opts)
+
+	err := s.view(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		c := bucket.Cursor()
+		prefixBytes := []byte(opts.Prefix)
+
+		k, _ := c.Seek([]byte(p.start()))
+		for k != nil && bytes.HasPrefix(k, prefixBytes) {
+			key := string(k)
+			if p.add(key) {
+				break
+			}
+			if next, ok := p.skipTo(key); ok {
+				k, _ = c.Seek([]byte(next))
+				continue
+			}
+			k, _ = c.Next()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	return p.result(), nil
+}
+
+// Walk visits every key under prefix within a single read transaction.
+func (s *BoltStore) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	return s.view(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		c := bucket.Cursor()
+		prefixBytes := []byte(prefix)
+
+		for k, v := c.Seek(prefixBytes); k != nil && bytes.HasPrefix(k, prefixBytes); k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(string(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *BoltStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.db.Close()
 }
 
+func (s *BoltStore) view(fn func(*bbolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.View(fn)
+}
+
+func (s *BoltStore) update(fn func(*bbolt.Tx) error) error {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Update(fn)
+}
+
 func (b *BoltStore) Snapshot(w io.Writer) error {
-	return b.db.View(func(tx *bbolt.Tx) error {
+	return b.view(func(tx *bbolt.Tx) error {
 		// Set a large timeout for the snapshot to complete.
 		_, err := tx.WriteTo(w)
 		return err
 	})
 }
 
+// SnapshotView opens a read transaction to write the snapshot from later.
+// Until the view is released, Restore and Compact wait to swap the database,
+// writes that grow the file wait to remap it, and bbolt cannot reuse the
+// pages the transaction still sees, so release it as soon as it has been
+// written, and never wait for a write while holding it.
+func (b *BoltStore) SnapshotView() (SnapshotView, error) {
+	b.mu.RLock()
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		b.mu.RUnlock()
+		return nil, errors.Wrap(err, "failed to begin snapshot transaction")
+	}
+	return &boltView{tx: tx, unlock: b.mu.RUnlock}, nil
+}
+
+type boltView struct {
+	tx     *bbolt.Tx
+	unlock func()
+}
+
+func (v *boltView) Snapshot(w io.Writer) error {
+	_, err := v.tx.WriteTo(w)
+	return err
+}
+
+func (v *boltView) Release() {
+	if v.tx == nil {
+		return
+	}
+	v.tx.Rollback()
+	v.unlock()
+	v.tx = nil
+}
+
+// Restore replaces the database with a snapshot produced by Snapshot, or with
+// a record stream such as the other backends' snapshots. The snapshot is
+// streamed into a temporary file next to the database and
+// verified before it atomically replaces the live file, so a bad or truncated
+// snapshot leaves the store untouched. Writers wait for the restore, and
+// readers block only while the files are swapped.
 func (b *BoltStore) Restore(r io.Reader) error {
-	if err := b.db.Close(); err != nil {
-		return errors.Wrap(err, "failed to close database before restoring")
+	// Exclude Compact, which would swap its copy of the old data back in.
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	br := bufio.NewReader(r)
+	var tmpPath string
+	var err error
+	if magic, _ := br.Peek(len(recordsMagic)); bytes.Equal(magic, recordsMagic) {
+		tmpPath, err = b.writeRecordsFile(br)
+	} else {
+		tmpPath, err = b.writeRestoreFile(br)
+	}
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tmpPath)
 
-	snapshotData, err := io.ReadAll(r)
+	return b.replaceFile(tmpPath, "restoring")
+}
+
+// Compact rewrites the database into a fresh file, leaving out the free pages
+// that bbolt never returns to the filesystem, and swaps it in. Reads continue
+// while the data is copied; writes wait until the new file is in place.
+func (b *BoltStore) Compact(ctx context.Context) (*CompactResult, error) {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	before, err := os.Stat(b.dbPath)
 	if err != nil {
-		return errors.Wrap(err, "failed to read snapshot data")
+		return nil, errors.Wrap(err, "failed to stat database")
 	}
 
-	if err := os.WriteFile(b.dbPath, snapshotData, 0o600); err != nil {
-		return errors.Wrap(err, "failed to write snapshot data to database")
+	tmp, err := os.CreateTemp(filepath.Dir(b.dbPath), filepath.Base(b.dbPath)+".compact-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary compaction file")
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	dst, err := bbolt.Open(tmpPath, 0o600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open compaction file")
+	}
+	b.mu.RLock()
+	err = compactCopy(ctx, dst, b.db, compactTxMaxSize)
+	b.mu.RUnlock()
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to copy database")
+	}
+	if err := verifyBoltFile(tmpPath); err != nil {
+		return nil, err
+	}
+
+	if err := b.replaceFile(tmpPath, "compacting"); err != nil {
+		return nil, err
+	}
+	after, err := os.Stat(b.dbPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat compacted database")
+	}
+	return &CompactResult{SizeBefore: before.Size(), SizeAfter: after.Size()}, nil
+}
+
+// compactCopy copies the buckets of src into dst, like bbolt.Compact, in
+// transactions of up to maxSize bytes. Unlike bbolt.Compact it stops as soon
+// as ctx is done. The store keeps its keys in flat buckets, so a nested
+// bucket fails the copy rather than being left out.
+func compactCopy(ctx context.Context, dst, src *bbolt.DB, maxSize int) error {
+	return src.View(func(srcTx *bbolt.Tx) error {
+		tx, err := dst.Begin(true)
+		if err != nil {
+			return err
+		}
+		defer func() { tx.Rollback() }()
+
+		size := 0
+		err = srcTx.ForEach(func(name []byte, srcBucket *bbolt.Bucket) error {
+			bucket, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+			if err := bucket.SetSequence(srcBucket.Sequence()); err != nil {
+				return err
+			}
+			return srcBucket.ForEach(func(k, v []byte) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if v == nil {
+					return fmt.Errorf("bucket %s holds nested bucket %s", name, k)
+				}
+				if size > 0 && size+len(k)+len(v) > maxSize {
+					if err := tx.Commit(); err != nil {
+						return err
+					}
+					if tx, err = dst.Begin(true); err != nil {
+						return err
+					}
+					bucket = tx.Bucket(name)
+					size = 0
+				}
+				// Keys arrive in order, so pages can be filled completely.
+				bucket.FillPercent = 1.0
+				size += len(k) + len(v)
+				return bucket.Put(k, v)
+			})
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// StorageStats walks every key in a single read transaction and reports the
+// state of the database file alongside.
+func (b *BoltStore) StorageStats(ctx context.Context, opts StatsOptions) (*Stats, error) {
+	c := newStatsCollector(opts)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			c.add(string(k), len(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(b.dbPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat database")
+	}
+	dbStats := b.db.Stats()
+
+	stats := c.result()
+	stats.File = &FileStats{
+		Path:         b.dbPath,
+		Size:         info.Size(),
+		PageSize:     b.db.Info().PageSize,
+		FreePages:    dbStats.FreePageN,
+		PendingPages: dbStats.PendingPageN,
+	}
+	return stats, nil
+}
+
+// replaceFile atomically replaces the database with the verified database at
+// path and reopens it. If the new file cannot be opened, the original is put
+// back. action names the operation in errors.
+func (b *BoltStore) replaceFile(path, action string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Keep a link to the current database so it can be put back if the
+	// new file cannot be opened.
+	backupPath := b.dbPath + ".pre-restore"
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove stale restore backup")
+	}
+	if err := os.Link(b.dbPath, backupPath); err != nil {
+		return errors.Wrapf(err, "failed to back up database before %s", action)
+	}
+	defer os.Remove(backupPath)
+
+	if err := b.db.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close database before %s", action)
+	}
+
+	if err := os.Rename(path, b.dbPath); err != nil {
+		return b.reopenAfterFailedRestore(backupPath, errors.Wrap(err, "failed to replace database"))
+	}
+	if err := syncDir(filepath.Dir(b.dbPath)); err != nil {
+		return b.reopenAfterFailedRestore(backupPath, errors.Wrap(err, "failed to sync database directory"))
 	}
 
 	db, err := bbolt.Open(b.dbPath, 0o600, &bbolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
-		return errors.Wrap(err, "failed to open database after restoring")
+		return b.reopenAfterFailedRestore(backupPath, errors.Wrapf(err, "failed to open database after %s", action))
 	}
 
 	b.db = db
-	return b.db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucketName)
+	return nil
+}
+
+// writeRestoreFile streams r into a synced temporary file in the database's
+// directory and checks that it is a usable database. It returns the path of
+// the temporary file, which the caller must remove.
+func (b *BoltStore) writeRestoreFile(r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(b.dbPath), filepath.Base(b.dbPath)+".restore-*")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temporary restore file")
+	}
+	tmpPath := tmp.Name()
+
+	err = func() error {
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, r); err != nil {
+			return errors.Wrap(err, "failed to read snapshot data")
+		}
+		if err := tmp.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync snapshot data")
+		}
+		return tmp.Close()
+	}()
+	if err == nil {
+		err = verifyBoltFile(tmpPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// writeRecordsFile builds a database file from a record stream. Records are
+// committed in transactions of up to compactTxMaxSize bytes so that a large
+// stream is not held in memory at once.
+func (b *BoltStore) writeRecordsFile(r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(b.dbPath), filepath.Base(b.dbPath)+".restore-*")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temporary restore file")
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+
+	err = func() error {
+		rr, err := newRecordReader(r)
 		if err != nil {
-			return fmt.Errorf("failed to create bucket: %w", err)
+			return err
+		}
+		db, err := bbolt.Open(tmpPath, 0o600, &bbolt.Options{Timeout: 1 * time.Second})
+		if err != nil {
+			return errors.Wrap(err, "failed to open temporary restore file")
+		}
+		if err := copyRecords(db, rr); err != nil {
+			db.Close()
+			return err
+		}
+		return db.Close()
+	}()
+	if err == nil {
+		err = verifyBoltFile(tmpPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+func copyRecords(db *bbolt.DB, rr *recordReader) error {
+	for done := false; !done; {
+		err := db.Update(func(tx *bbolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists(bucketName)
+			if err != nil {
+				return err
+			}
+			for size := 0; size < compactTxMaxSize; {
+				key, value, err := rr.Next()
+				if err == io.EOF {
+					done = true
+					return nil
+				}
+				if err != nil {
+					return errors.Wrap(err, "failed to read snapshot record")
+				}
+				if err := bucket.Put([]byte(key), value); err != nil {
+					return err
+				}
+				size += len(key) + len(value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reopenAfterFailedRestore puts the backed up database back in place and
+// reopens it, so that a failed restore leaves the store as it was.
+func (b *BoltStore) reopenAfterFailedRestore(backupPath string, cause error) error {
+	if err := os.Rename(backupPath, b.dbPath); err != nil {
+		return errors.Wrapf(cause, "failed to put back original database (%v)", err)
+	}
+	db, err := bbolt.Open(b.dbPath, 0o600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errors.Wrapf(cause, "failed to reopen original database (%v)", err)
+	}
+	b.db = db
+	return cause
+}
+
+// verifyBoltFile checks that path is a complete, consistent bbolt database
+// holding the Rune bucket.
+func verifyBoltFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	db, err := openBoltReadOnly(path)
+	if err != nil {
+		return errors.Wrap(err, "snapshot is not a valid database")
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		// bbolt memory-maps the file and trusts its meta page, so a
+		// truncated file must be caught before reading any pages or reads
+		// will fault.
+		if tx.Size() > info.Size() {
+			return fmt.Errorf("snapshot is truncated: %d bytes, expected %d", info.Size(), tx.Size())
+		}
+		if tx.Bucket(bucketName) == nil {
+			return fmt.Errorf("snapshot is missing bucket %s", bucketName)
+		}
+		for err := range tx.Check() {
+			return errors.Wrap(err, "snapshot failed consistency check")
 		}
 		return nil
 	})
 }
+
+// openBoltReadOnly opens the bbolt database at path read-only. bbolt reads
+// the meta pages through its memory map as it opens the file, which faults
+// on a file too short to hold them, so such faults fail the open instead.
+func openBoltReadOnly(path string) (db *bbolt.DB, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			db, err = nil, fmt.Errorf("failed to read database: %v", r)
+		}
+	}()
+	return bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -2,9 +2,15 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -107,6 +113,55 @@ func TestBoltStore(t *testing.T) {
 	})
 }
 
+func TestBoltStore_Transaction(t *testing.T) {
+	store := newTestBoltStore(t)
+	ctx := t.Context()
+
+	if err := store.Put(ctx, "secrets/app/key", []byte("v1")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		err := store.Transaction(ctx, []TxnOp{
+			{Op: TxnCheck, Key: "secrets/app/key", Value: []byte("v1")},
+			{Op: TxnCheck, Key: "secrets/app/meta"},
+			{Op: TxnPut, Key: "secrets/app/key", Value: []byte("v2")},
+			{Op: TxnPut, Key: "secrets/app/meta", Value: []byte("version=2")},
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		for k, want := range map[string]string{"secrets/app/key": "v2", "secrets/app/meta": "version=2"} {
+			got, err := store.Get(ctx, k)
+			if err != nil {
+				t.Fatalf("failed to get %q: %v", k, err)
+			}
+			if string(got) != want {
+				t.Fatalf("expected %q at %q, got %q", want, k, got)
+			}
+		}
+	})
+
+	t.Run("Failed check applies nothing", func(t *testing.T) {
+		err := store.Transaction(ctx, []TxnOp{
+			{Op: TxnDelete, Key: "secrets/app/meta"},
+			{Op: TxnPut, Key: "secrets/app/index", Value: []byte("1")},
+			{Op: TxnCheckExists, Key: "secrets/app/missing"},
+		})
+		if !errors.Is(err, ErrTxnCheckFailed) {
+			t.Fatalf("expected ErrTxnCheckFailed, got %v", err)
+		}
+
+		if _, err := store.Get(ctx, "secrets/app/meta"); err != nil {
+			t.Fatalf("expected delete to be rolled back, got: %v", err)
+		}
+		if _, err := store.Get(ctx, "secrets/app/index"); err == nil {
+			t.Fatal("expected put to be rolled back")
+		}
+	})
+}
+
 func TestBoltStore_Snapshot(t *testing.T) {
 	ctx := t.Context()
 	store := newTestBoltStore(t)
@@ -133,7 +188,7 @@ func TestBoltStore_Snapshot(t *testing.T) {
 	}
 
 	// 3. Restore the snapshot into a new database to verify it.
-	restoreDir := "."
+	restoreDir := t.TempDir()
 	restorePath := filepath.Join(restoreDir, "restore.db")
 
 	if err := os.WriteFile(restorePath, buf.Bytes(), 0o600); err != nil {
@@ -166,3 +221,231 @@ func TestBoltStore_Snapshot(t *testing.T) {
 		}
 	}
 }
+
+func TestBoltStore_Restore(t *testing.T) {
+	ctx := t.Context()
+
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "rune.db"))
+	if err != nil {
+		t.Fatalf("failed to create bolt store: %v", err)
+	}
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("failed to initialize bolt store: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			t.Fatalf("failed to close bolt store: %v", err)
+		}
+	})
+
+	if err := store.Put(ctx, "key1", []byte("from-snapshot")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	var snapshot bytes.Buffer
+	if err := store.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	if err := store.Put(ctx, "key1", []byte("after-snapshot")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Put(ctx, "key2", []byte("after-snapshot")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	t.Run("Invalid snapshot leaves the database intact", func(t *testing.T) {
+		invalid := []io.Reader{
+			strings.NewReader("definitely not a bolt database"),
+			bytes.NewReader(snapshot.Bytes()[:snapshot.Len()/2]),
+			// Cut off within the meta pages, and right after them.
+			bytes.NewReader(snapshot.Bytes()[:os.Getpagesize()]),
+			bytes.NewReader(snapshot.Bytes()[:2*os.Getpagesize()]),
+			bytes.NewReader(snapshot.Bytes()[:snapshot.Len()-os.Getpagesize()]),
+		}
+		for _, r := range invalid {
+			if err := store.Restore(r); err == nil {
+				t.Fatal("expected error restoring an invalid snapshot")
+			}
+
+			got, err := store.Get(ctx, "key2")
+			if err != nil {
+				t.Fatalf("Get after failed restore failed: %v", err)
+			}
+			if string(got) != "after-snapshot" {
+				t.Fatalf("expected original value after failed restore, got %q", got)
+			}
+		}
+	})
+
+	t.Run("Restore with concurrent readers", func(t *testing.T) {
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if _, err := store.Get(ctx, "key1"); err != nil {
+						t.Errorf("Get during restore failed: %v", err)
+						return
+					}
+				}
+			}()
+		}
+
+		err := store.Restore(bytes.NewReader(snapshot.Bytes()))
+		close(stop)
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+
+		got, err := store.Get(ctx, "key1")
+		if err != nil {
+			t.Fatalf("Get after restore failed: %v", err)
+		}
+		if string(got) != "from-snapshot" {
+			t.Fatalf("expected restored value, got %q", got)
+		}
+		if _, err := store.Get(ctx, "key2"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected key written after the snapshot to be gone, got %v", err)
+		}
+	})
+
+	entries, err := os.ReadDir(filepath.Dir(store.dbPath))
+	if err != nil {
+		t.Fatalf("failed to read database directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the database file to remain, got %v", entries)
+	}
+}
+
+func TestBoltStore_Compact(t *testing.T) {
+	ctx := t.Context()
+
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "rune.db"))
+	if err != nil {
+		t.Fatalf("failed to create bolt store: %v", err)
+	}
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("failed to initialize bolt store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	value := bytes.Repeat([]byte("x"), 4096)
+	for i := range 1000 {
+		if err := store.Put(ctx, fmt.Sprintf("logical/kv/%04d", i), value); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+	}
+	for i := range 990 {
+		if err := store.Delete(ctx, fmt.Sprintf("logical/kv/%04d", i)); err != nil {
+			t.Fatalf("failed to delete value: %v", err)
+		}
+	}
+	if err := store.Put(ctx, "sys/mounts", []byte("table")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+
+	stats, err := CollectStats(ctx, NewCache(store, CacheOptions{}), StatsOptions{})
+	if err != nil {
+		t.Fatalf("failed to collect stats: %v", err)
+	}
+	if stats.File == nil || stats.File.FreeBytes() == 0 {
+		t.Fatalf("expected deleted values to leave free pages, got %+v", stats.File)
+	}
+	expected := []PrefixStats{
+		{Prefix: "logical/", Keys: 10, ValueBytes: 10 * 4096},
+		{Prefix: "sys/", Keys: 1, ValueBytes: 5},
+	}
+	if !reflect.DeepEqual(stats.Prefixes, expected) {
+		t.Fatalf("expected prefixes %+v, got %+v", expected, stats.Prefixes)
+	}
+
+	// Writers wait for the compaction, and must not be lost by it.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := store.Put(ctx, "during", []byte("compaction")); err != nil {
+			t.Errorf("Put during compaction failed: %v", err)
+		}
+	}()
+
+	res, err := Compact(ctx, NewCache(store, CacheOptions{}))
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if res.SizeAfter >= res.SizeBefore {
+		t.Fatalf("expected compaction to shrink the file, got %d -> %d bytes", res.SizeBefore, res.SizeAfter)
+	}
+
+	for _, key := range []string{"logical/kv/0995", "sys/mounts", "during"} {
+		if _, err := store.Get(ctx, key); err != nil {
+			t.Fatalf("expected %s to survive compaction, got %v", key, err)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(store.dbPath))
+	if err != nil {
+		t.Fatalf("failed to read database directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the database file to remain, got %v", entries)
+	}
+}
+
+// cancelAfter is a context that is cancelled once Err has been called n
+// times, to cancel an operation part way through.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestBoltStore_CompactCancelled(t *testing.T) {
+	ctx := t.Context()
+
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "rune.db"))
+	if err != nil {
+		t.Fatalf("failed to create bolt store: %v", err)
+	}
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("failed to initialize bolt store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	for i := range 100 {
+		if err := store.Put(ctx, fmt.Sprintf("logical/kv/%04d", i), []byte("value")); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+	}
+
+	cancelled := &cancelAfter{Context: ctx, n: 10}
+	if _, err := store.Compact(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the copy to stop when cancelled, got %v", err)
+	}
+
+	keys, err := store.List(ctx, "")
+	if err != nil || len(keys) != 100 {
+		t.Fatalf("expected a cancelled compaction to leave the store intact, got %d keys (%v)", len(keys), err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(store.dbPath))
+	if err != nil {
+		t.Fatalf("failed to read database directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the database file to remain, got %v", entries)
+	}
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/thelamedev/rune/internal/lru"
+)
+
+// CacheOptions bounds the size of a Cache.
+type CacheOptions struct {
+	// MaxEntries is the maximum number of values kept in memory.
+	MaxEntries int
+	// MaxBytes is the maximum total size of the values kept in memory. Zero
+	// means no bound.
+	MaxBytes int
+}
+
+// CacheStats reports how effective a Cache has been.
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Bytes   int
+}
+
+// HitRatio returns the fraction of reads served from the cache.
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Cache is a read-through Storage decorator that keeps recently read values
+// in a size-bounded LRU. Values are cached exactly as the backend stores
+// them, so encrypted values stay encrypted in memory.
+//
+// Writes through the Cache invalidate the keys they touch. Writes that reach
+// the backend any other way must be reported with Invalidate, which is why the
+// Raft FSM should apply commands through the Cache rather than underneath it.
+type Cache struct {
+	backend Storage
+	lru     *lru.Cache[string, []byte]
+
+	// gen is bumped by every invalidation. A read only fills the cache if no
+	// invalidation happened while it was reading from the backend, so a slow
+	// read cannot cache a value that a concurrent write has replaced.
+	mu  sync.Mutex
+	gen uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewCache(backend Storage, opts CacheOptions) *Cache {
+	return &Cache{
+		backend: backend,
+		lru: lru.New[string, []byte](lru.Options[[]byte]{
+			MaxEntries: opts.MaxEntries,
+			MaxCost:    opts.MaxBytes,
+			Cost:       func(v []byte) int { return len(v) },
+		}),
+	}
+}
+
+func (c *Cache) Initialize(ctx context.Context) error {
+	return c.backend.Initialize(ctx)
+}
+
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, ok := c.lru.Get(key); ok {
+		c.hits.Add(1)
+		metrics.IncrCounter([]string{"rune", "storage", "cache", "hit"}, 1)
+		return cloneValue(value), nil
+	}
+	c.misses.Add(1)
+	metrics.IncrCounter([]string{"rune", "storage", "cache", "miss"}, 1)
+
+	c.mu.Lock()
+	gen := c.gen
+	c.mu.Unlock()
+
+	value, err := c.backend.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.gen == gen {
+		c.lru.Add(key, cloneValue(value))
+	}
+	c.mu.Unlock()
+
+	return value, nil
+}
+
+func (c *Cache) Put(ctx context.Context, key string, value []byte) error {
+	defer c.Invalidate(key)
+	return c.backend.Put(ctx, key, value)
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	defer c.Invalidate(key)
+	return c.backend.Delete(ctx, key)
+}
+
+func (c *Cache) List(ctx context.Context, prefix string) ([]string, error) {
+	return c.backend.List(ctx, prefix)
+}
+
+func (c *Cache) ListPaged(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	return ListPaged(ctx, c.backend, opts)
+}
+
+func (c *Cache) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	return Walk(ctx, c.backend, prefix, fn)
+}
+
+func (c *Cache) Transaction(ctx context.Context, ops []TxnOp) error {
+	keys := make([]string, 0, len(ops))
+	for _, op := range ops {
+		if op.Op == TxnPut || op.Op == TxnDelete {
+			keys = append(keys, op.Key)
+		}
+	}
+	defer c.Invalidate(keys...)
+	return c.backend.Transaction(ctx, ops)
+}
+
+func (c *Cache) Batch(ctx context.Context, txns [][]TxnOp) ([]error, error) {
+	var keys []string
+	for _, ops := range txns {
+		for _, op := range ops {
+			if op.Op == TxnPut || op.Op == TxnDelete {
+				keys = append(keys, op.Key)
+			}
+		}
+	}
+	defer c.Invalidate(keys...)
+	return Batch(ctx, c.backend, txns)
+}
+
+func (c *Cache) Snapshot(w io.Writer) error {
+	return c.backend.Snapshot(w)
+}
+
+func (c *Cache) Restore(r io.Reader) error {
+	defer c.Purge()
+	return c.backend.Restore(r)
+}
+
+func (c *Cache) Close() error {
+	c.Purge()
+	return c.backend.Close()
+}
+
+// Unwrap returns the backend the Cache reads through to.
+func (c *Cache) Unwrap() Storage {
+	return c.backend
+}
+
+// Invalidate drops keys from the cache.
+func (c *Cache) Invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, key := range keys {
+		c.lru.Remove(key)
+	}
+}
+
+// Purge drops every cached value.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.lru.Purge()
+}
+
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: c.lru.Len(),
+		Bytes:   c.lru.Cost(),
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// countingStore counts the reads that reach the wrapped store.
+type countingStore struct {
+	*MemStore
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.gets++
+	return s.MemStore.Get(ctx, key)
+}
+
+func TestCache(t *testing.T) {
+	ctx := t.Context()
+	backend := &countingStore{MemStore: NewMemStore()}
+	cache := NewCache(backend, CacheOptions{MaxEntries: 2})
+
+	if err := cache.Put(ctx, "key1", []byte("v1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	t.Run("Read through", func(t *testing.T) {
+		for range 3 {
+			got, err := cache.Get(ctx, "key1")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if string(got) != "v1" {
+				t.Fatalf("expected %q, got %q", "v1", got)
+			}
+			got[0] = 'x' // must not corrupt the cached value
+		}
+		if backend.gets != 1 {
+			t.Fatalf("expected 1 backend read, got %d", backend.gets)
+		}
+
+		stats := cache.Stats()
+		if stats.Hits != 2 || stats.Misses != 1 {
+			t.Fatalf("expected 2 hits and 1 miss, got %+v", stats)
+		}
+		if ratio := stats.HitRatio(); ratio < 0.66 || ratio > 0.67 {
+			t.Fatalf("expected hit ratio of 2/3, got %f", ratio)
+		}
+	})
+
+	t.Run("Put invalidates", func(t *testing.T) {
+		if err := cache.Put(ctx, "key1", []byte("v2")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		got, err := cache.Get(ctx, "key1")
+		if err != nil || string(got) != "v2" {
+			t.Fatalf("expected %q after Put, got %q (%v)", "v2", got, err)
+		}
+	})
+
+	t.Run("Transaction invalidates", func(t *testing.T) {
+		err := cache.Transaction(ctx, []TxnOp{{Op: TxnPut, Key: "key1", Value: []byte("v3")}})
+		if err != nil {
+			t.Fatalf("Transaction failed: %v", err)
+		}
+		got, err := cache.Get(ctx, "key1")
+		if err != nil || string(got) != "v3" {
+			t.Fatalf("expected %q after Transaction, got %q (%v)", "v3", got, err)
+		}
+	})
+
+	t.Run("Batch invalidates", func(t *testing.T) {
+		results, err := cache.Batch(ctx, [][]TxnOp{
+			{{Op: TxnCheckExists, Key: "missing"}, {Op: TxnPut, Key: "key1", Value: []byte("lost")}},
+			{{Op: TxnPut, Key: "key1", Value: []byte("v4")}},
+		})
+		if err != nil || !errors.Is(results[0], ErrTxnCheckFailed) || results[1] != nil {
+			t.Fatalf("unexpected batch results %v (%v)", results, err)
+		}
+		got, err := cache.Get(ctx, "key1")
+		if err != nil || string(got) != "v4" {
+			t.Fatalf("expected %q after Batch, got %q (%v)", "v4", got, err)
+		}
+	})
+
+	t.Run("Delete invalidates", func(t *testing.T) {
+		if err := cache.Delete(ctx, "key1"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := cache.Get(ctx, "key1"); err == nil {
+			t.Fatal("expected error after Delete, got nil")
+		}
+	})
+
+	t.Run("Restore purges", func(t *testing.T) {
+		snapshotSource := NewMemStore()
+		if err := snapshotSource.Put(ctx, "key2", []byte("restored")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		var snap bytes.Buffer
+		if err := snapshotSource.Snapshot(&snap); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+
+		if err := cache.Put(ctx, "key2", []byte("cached")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if _, err := cache.Get(ctx, "key2"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+
+		if err := cache.Restore(&snap); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+		got, err := cache.Get(ctx, "key2")
+		if err != nil || string(got) != "restored" {
+			t.Fatalf("expected %q after Restore, got %q (%v)", "restored", got, err)
+		}
+	})
+
+	t.Run("Bounded size", func(t *testing.T) {
+		for _, k := range []string{"a", "b", "c"} {
+			if err := cache.Put(ctx, k, []byte(k)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if _, err := cache.Get(ctx, k); err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+		}
+		if entries := cache.Stats().Entries; entries != 2 {
+			t.Fatalf("expected 2 cached entries, got %d", entries)
+		}
+	})
+}
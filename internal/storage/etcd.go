@@ -0,0 +1,441 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	defaultEtcdPrefix  = "/rune/"
+	defaultEtcdLockTTL = 15 * time.Second
+
+	// etcdPageSize is the number of keys fetched per range request, which
+	// keeps large listings and snapshots from building huge responses.
+	etcdPageSize = 1000
+)
+
+func init() {
+	Register("etcd", func(config map[string]string) (Storage, error) {
+		opts := EtcdOptions{
+			Prefix:   config["prefix"],
+			Username: config["username"],
+			Password: config["password"],
+		}
+		if endpoints := config["endpoints"]; endpoints != "" {
+			opts.Endpoints = strings.Split(endpoints, ",")
+		}
+		for name, dst := range map[string]*time.Duration{
+			"dial_timeout": &opts.DialTimeout,
+			"lock_ttl":     &opts.LockTTL,
+		} {
+			if v, ok := config[name]; ok {
+				d, err := time.ParseDuration(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid etcd option %s: %w", name, err)
+				}
+				*dst = d
+			}
+		}
+		return NewEtcdStore(opts)
+	})
+}
+
+// EtcdOptions configures an EtcdStore.
+type EtcdOptions struct {
+	Endpoints []string
+	// Prefix scopes every key Rune writes, so an etcd cluster can be shared
+	// with other applications. It defaults to "/rune/".
+	Prefix   string
+	Username string
+	Password string
+	// DialTimeout bounds the initial connection. It defaults to 5 seconds.
+	DialTimeout time.Duration
+	// LockTTL is how long an HA lock survives after its holder stops
+	// renewing it. It defaults to 15 seconds.
+	LockTTL time.Duration
+}
+
+// EtcdStore stores keys in an etcd v3 cluster. Data lives under
+// Prefix+"data/" and HA locks under Prefix+"lock/".
+//
+// Transactions map onto a single etcd Txn, so they are atomic and isolated
+// across every server sharing the cluster, and are limited by the cluster's
+// maximum number of operations per transaction (128 by default).
+type EtcdStore struct {
+	client *clientv3.Client
+	prefix string
+	opts   EtcdOptions
+}
+
+func NewEtcdStore(opts EtcdOptions) (*EtcdStore, error) {
+	if len(opts.Endpoints) == 0 {
+		return nil, errors.New("etcd storage requires at least one endpoint")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = defaultEtcdPrefix
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.LockTTL == 0 {
+		opts.LockTTL = defaultEtcdLockTTL
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   opts.Endpoints,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		DialTimeout: opts.DialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd client: %w", err)
+	}
+	return &EtcdStore{client: client, prefix: opts.Prefix + "data/", opts: opts}, nil
+}
+
+// Initialize checks that the cluster is reachable.
+func (s *EtcdStore) Initialize(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.DialTimeout)
+	defer cancel()
+
+	if _, err := s.client.Get(ctx, s.prefix, clientv3.WithCountOnly()); err != nil {
+		return fmt.Errorf("failed to reach etcd: %w", err)
+	}
+	return nil
+}
+
+func (s *EtcdStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.client.Get(ctx, s.prefix+key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %q: %w", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (s *EtcdStore) Put(ctx context.Context, key string, value []byte) error {
+	if _, err := s.client.Put(ctx, s.prefix+key, string(value)); err != nil {
+		return fmt.Errorf("failed to put %q: %w", key, err)
+	}
+	return nil
+}
+
+func (s *EtcdStore) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.Delete(ctx, s.prefix+key); err != nil {
+		return fmt.Errorf("failed to delete %q: %w", key, err)
+	}
+	return nil
+}
+
+func (s *EtcdStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.scan(ctx, prefix, prefix, 0, true, func(key string, _ []byte) (string, bool) {
+		keys = append(keys, key)
+		return "", false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	return keys, nil
+}
+
+// ListPaged reads range requests starting at the cursor, jumping over the
+// contents of each folder it collapses.
+func (s *EtcdStore) ListPaged(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	p := newListPager(opts)
+
+	err := s.scan(ctx, opts.Prefix, p.start(), 0, true, func(key string, _ []byte) (string, bool) {
+		if p.add(key) {
+			return "", true
+		}
+		next, _ := p.skipTo(key)
+		return next, false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	return p.result(), nil
+}
+
+// Walk visits every key under prefix as of a single revision, so the walk
+// sees a consistent view even while other servers write.
+func (s *EtcdStore) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	var walkErr error
+	err := s.scan(ctx, prefix, prefix, 0, false, func(key string, value []byte) (string, bool) {
+		walkErr = fn(key, value)
+		return "", walkErr != nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk keys: %w", err)
+	}
+	return walkErr
+}
+
+// scan visits the keys under prefix from start onwards in pages, all read at
+// the revision of the first page (or at rev, if set). fn may return a key to
+// seek forward to, and true to end the scan.
+func (s *EtcdStore) scan(ctx context.Context, prefix, start string, rev int64, keysOnly bool, fn func(key string, value []byte) (string, bool)) error {
+	end := clientv3.GetPrefixRangeEnd(s.prefix + prefix)
+	from := s.prefix + start
+
+	for {
+		opts := []clientv3.OpOption{
+			clientv3.WithRange(end),
+			clientv3.WithLimit(etcdPageSize),
+			clientv3.WithRev(rev),
+		}
+		if keysOnly {
+			opts = append(opts, clientv3.WithKeysOnly())
+		}
+		resp, err := s.client.Get(ctx, from, opts...)
+		if err != nil {
+			return err
+		}
+		rev = resp.Header.Revision
+
+		seek := ""
+		for _, kv := range resp.Kvs {
+			key := string(kv.Key)
+			if seek != "" && key < seek {
+				continue
+			}
+			next, done := fn(strings.TrimPrefix(key, s.prefix), kv.Value)
+			if done {
+				return nil
+			}
+			if next != "" {
+				seek = s.prefix + next
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return nil
+		}
+
+		from = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+		if seek > from {
+			from = seek
+		}
+	}
+}
+
+// Transaction runs ops as a single etcd Txn: every check becomes a compare
+// and every write an operation in the success branch.
+func (s *EtcdStore) Transaction(ctx context.Context, ops []TxnOp) error {
+	var cmps []clientv3.Cmp
+	// etcd rejects a Txn that writes the same key twice, so only the last
+	// write to each key is kept, which is the state the ops leave behind.
+	last := make(map[string]int)
+	for i, op := range ops {
+		key := s.prefix + op.Key
+		switch op.Op {
+		case TxnCheck:
+			if op.Value == nil {
+				cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+			} else {
+				cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", string(op.Value)))
+			}
+		case TxnCheckExists:
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), ">", 0))
+		case TxnPut, TxnDelete:
+			last[op.Key] = i
+		default:
+			return fmt.Errorf("unknown transaction op %d", op.Op)
+		}
+	}
+
+	var writes []clientv3.Op
+	for i, op := range ops {
+		if j, ok := last[op.Key]; !ok || j != i {
+			continue
+		}
+		if op.Op == TxnPut {
+			writes = append(writes, clientv3.OpPut(s.prefix+op.Key, string(op.Value)))
+		} else {
+			writes = append(writes, clientv3.OpDelete(s.prefix+op.Key))
+		}
+	}
+
+	resp, err := s.client.Txn(ctx).If(cmps...).Then(writes...).Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if !resp.Succeeded {
+		return ErrTxnCheckFailed
+	}
+	return nil
+}
+
+// Snapshot writes every key/value pair as a record stream, read page by page
+// at a single revision.
+func (s *EtcdStore) Snapshot(w io.Writer) error {
+	rw, err := NewRecordWriter(w)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot header: %w", err)
+	}
+	err = s.Walk(context.Background(), "", func(key string, value []byte) error {
+		return rw.Write(key, value)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot record: %w", err)
+	}
+	return rw.Close()
+}
+
+// Restore writes the snapshot's records in batches and then deletes the keys
+// the snapshot does not contain. Each batch is atomic, but the restore as a
+// whole is not: a failed restore leaves a mix of old and restored keys, and
+// should be retried.
+func (s *EtcdStore) Restore(r io.Reader) error {
+	ctx := context.Background()
+
+	rr, err := newRecordReader(r)
+	if err != nil {
+		return err
+	}
+
+	restored := make(map[string]struct{})
+	var batch []clientv3.Op
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := s.client.Txn(ctx).Then(batch...).Commit(); err != nil {
+			return fmt.Errorf("failed to write restored keys: %w", err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		k, v, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot record: %w", err)
+		}
+		if _, dup := restored[k]; dup {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, clientv3.OpPut(s.prefix+k, string(v)))
+		restored[k] = struct{}{}
+		if len(batch) == 100 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	var stale []string
+	err = s.scan(ctx, "", "", 0, true, func(key string, _ []byte) (string, bool) {
+		if _, ok := restored[key]; !ok {
+			stale = append(stale, key)
+		}
+		return "", false
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	for _, key := range stale {
+		if err := s.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EtcdStore) Close() error {
+	return s.client.Close()
+}
+
+// HALock returns a lock implemented as an etcd election whose candidates are
+// kept alive by a lease, so a crashed holder loses the lock after LockTTL.
+func (s *EtcdStore) HALock(key, value string) (HALock, error) {
+	return &etcdLock{
+		client: s.client,
+		key:    s.opts.Prefix + "lock/" + key,
+		value:  value,
+		ttl:    int((s.opts.LockTTL + time.Second - 1) / time.Second),
+	}, nil
+}
+
+type etcdLock struct {
+	client *clientv3.Client
+	key    string
+	value  string
+	ttl    int
+
+	mu       sync.Mutex
+	session  *concurrency.Session
+	election *concurrency.Election
+}
+
+func (l *etcdLock) Lock(ctx context.Context) (<-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.session != nil {
+		return nil, errors.New("lock is already held")
+	}
+
+	session, err := concurrency.NewSession(l.client, concurrency.WithTTL(l.ttl))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock session: %w", err)
+	}
+	election := concurrency.NewElection(session, l.key)
+	if err := election.Campaign(ctx, l.value); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	l.session, l.election = session, election
+	return session.Done(), nil
+}
+
+func (l *etcdLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.session == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := l.election.Resign(ctx)
+	if closeErr := l.session.Close(); err == nil {
+		err = closeErr
+	}
+	l.session, l.election = nil, nil
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+func (l *etcdLock) Holder(ctx context.Context) (string, bool, error) {
+	// The holder is the candidate with the lowest create revision.
+	resp, err := l.client.Get(ctx, l.key+"/", clientv3.WithFirstCreate()...)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to look up lock holder: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return "", false, nil
+	}
+	return string(resp.Kvs[0].Value), true, nil
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// startEmbeddedEtcd runs a single-member etcd cluster for the duration of the
+// test and returns its client endpoint.
+func startEmbeddedEtcd(t *testing.T) string {
+	t.Helper()
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peerURL.String())
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("failed to start etcd: %v", err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd did not become ready in time")
+	}
+	return clientURL.Host
+}
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func newTestEtcdStore(t *testing.T, endpoint, prefix string) *EtcdStore {
+	t.Helper()
+
+	store, err := NewEtcdStore(EtcdOptions{
+		Endpoints: []string{endpoint},
+		Prefix:    prefix,
+		LockTTL:   time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create etcd store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Initialize(t.Context()); err != nil {
+		t.Fatalf("failed to initialize etcd store: %v", err)
+	}
+	return store
+}
+
+func TestEtcdStore(t *testing.T) {
+	endpoint := startEmbeddedEtcd(t)
+	store := newTestEtcdStore(t, endpoint, "/test/")
+	other := newTestEtcdStore(t, endpoint, "/other/")
+	ctx := t.Context()
+
+	keys := map[string][]byte{
+		"secrets/db/pass": []byte("my-test-password"),
+		"secrets/db":      []byte("a key and a folder"),
+		"secrets/api":     []byte("api-key"),
+		"config/feature":  []byte("true"),
+	}
+
+	t.Run("Put and Get", func(t *testing.T) {
+		for k, v := range keys {
+			if err := store.Put(ctx, k, v); err != nil {
+				t.Fatalf("failed to put %q: %v", k, err)
+			}
+		}
+		for k, v := range keys {
+			got, err := store.Get(ctx, k)
+			if err != nil {
+				t.Fatalf("failed to get %q: %v", k, err)
+			}
+			if !bytes.Equal(got, v) {
+				t.Fatalf("expected value %q at %q, got %q", v, k, got)
+			}
+		}
+		if _, err := other.Get(ctx, "config/feature"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected keys to be scoped to their prefix, got %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		got, err := store.List(ctx, "secrets/")
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected := []string{"secrets/api", "secrets/db", "secrets/db/pass"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("ListPaged", func(t *testing.T) {
+		res, err := store.ListPaged(ctx, ListOptions{Prefix: "secrets/", Delimiter: "/"})
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected := []ListEntry{{Key: "secrets/api"}, {Key: "secrets/db"}, {Key: "secrets/db/", IsFolder: true}}
+		if !reflect.DeepEqual(res.Entries, expected) {
+			t.Fatalf("expected entries %v, got %v", expected, res.Entries)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.Delete(ctx, "secrets/db/pass"); err != nil {
+			t.Fatalf("failed to delete value: %v", err)
+		}
+		if _, err := store.Get(ctx, "secrets/db/pass"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound after delete, got %v", err)
+		}
+		if err := store.Delete(ctx, "secrets/db/pass"); err != nil {
+			t.Fatalf("deleting a non-existent key should not produce an error, but got: %v", err)
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		err := store.Transaction(ctx, []TxnOp{
+			{Op: TxnPut, Key: "secrets/new", Value: []byte("v1")},
+			{Op: TxnCheck, Key: "config/feature", Value: []byte("false")},
+		})
+		if !errors.Is(err, ErrTxnCheckFailed) {
+			t.Fatalf("expected ErrTxnCheckFailed, got %v", err)
+		}
+		if _, err := store.Get(ctx, "secrets/new"); err == nil {
+			t.Fatal("expected failed transaction to write nothing")
+		}
+
+		err = store.Transaction(ctx, []TxnOp{
+			{Op: TxnCheck, Key: "config/feature", Value: []byte("true")},
+			{Op: TxnCheck, Key: "secrets/new", Value: nil},
+			{Op: TxnCheckExists, Key: "secrets/api"},
+			{Op: TxnPut, Key: "secrets/new", Value: []byte("v1")},
+			{Op: TxnPut, Key: "secrets/new", Value: []byte("v2")},
+			{Op: TxnDelete, Key: "config/feature"},
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		got, err := store.Get(ctx, "secrets/new")
+		if err != nil || string(got) != "v2" {
+			t.Fatalf("expected the last write to win, got %q (%v)", got, err)
+		}
+		if _, err := store.Get(ctx, "config/feature"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected config/feature to be deleted, got %v", err)
+		}
+	})
+
+	t.Run("Snapshot and Restore", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := store.Snapshot(&buf); err != nil {
+			t.Fatalf("failed to snapshot: %v", err)
+		}
+		if err := other.Put(ctx, "stale", []byte("gone after restore")); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+		if err := other.Restore(&buf); err != nil {
+			t.Fatalf("failed to restore: %v", err)
+		}
+
+		want, _ := store.List(ctx, "")
+		got, err := other.List(ctx, "")
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected restored keys %q, got %q", want, got)
+		}
+	})
+}
+
+func TestEtcdStore_HALock(t *testing.T) {
+	endpoint := startEmbeddedEtcd(t)
+	ctx := t.Context()
+
+	first, err := newTestEtcdStore(t, endpoint, "/ha/").HALock("active", "node-1:8000")
+	if err != nil {
+		t.Fatalf("failed to create lock: %v", err)
+	}
+	second, err := newTestEtcdStore(t, endpoint, "/ha/").HALock("active", "node-2:8000")
+	if err != nil {
+		t.Fatalf("failed to create lock: %v", err)
+	}
+
+	if _, held, err := first.Holder(ctx); err != nil || held {
+		t.Fatalf("expected no holder before locking, got %v (%v)", held, err)
+	}
+
+	lost, err := first.Lock(ctx)
+	if err != nil {
+		t.Fatalf("failed to acquire lock: %v", err)
+	}
+	holder, held, err := second.Holder(ctx)
+	if err != nil || !held || holder != "node-1:8000" {
+		t.Fatalf("expected node-1 to hold the lock, got %q (%v, %v)", holder, held, err)
+	}
+
+	// The second node blocks until the first steps down.
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := second.Lock(waitCtx); err == nil {
+		t.Fatal("expected the lock to be held by the first node")
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := second.Lock(ctx)
+		acquired <- err
+	}()
+
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("failed to release lock: %v", err)
+	}
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected releasing the lock to close its lost channel")
+	}
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("failed to acquire lock after release: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("second node did not acquire the released lock")
+	}
+	holder, _, _ = first.Holder(ctx)
+	if holder != "node-2:8000" {
+		t.Fatalf("expected node-2 to hold the lock, got %q", holder)
+	}
+	second.Unlock()
+}
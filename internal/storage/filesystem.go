@@ -0,0 +1,398 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+func init() {
+	Register("file", func(config map[string]string) (Storage, error) {
+		path := config["path"]
+		if path == "" {
+			return nil, errors.New("file storage requires a path")
+		}
+		return NewFileStore(path), nil
+	})
+}
+
+// FileStore stores one file per key under a directory tree, which makes it
+// easy to inspect and back up with ordinary tools.
+//
+// Key segments separated by "/" map to directories, and the final segment to
+// a file whose name is prefixed with "_" so that a key can coexist with keys
+// nested below it ("a" and "a/b"). Segments are percent-escaped so any key is
+// a safe relative path.
+//
+// Every write goes to a temporary file that is renamed into place, so a key
+// always holds either its old or its new value. Transactions are atomic with
+// respect to other callers of the same FileStore, but a crash part way through
+// one can leave only some of its writes applied.
+type FileStore struct {
+	// mu serializes writes so transactions are isolated, and lets Restore
+	// swap the whole directory out from under readers.
+	mu   sync.RWMutex
+	root string
+}
+
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root: filepath.Clean(root)}
+}
+
+func (s *FileStore) Initialize(ctx context.Context) error {
+	if err := os.MkdirAll(s.root, 0o700); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.get(key)
+}
+
+func (s *FileStore) get(key string) ([]byte, error) {
+	value, err := os.ReadFile(s.keyPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", key, err)
+	}
+	return value, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(key, value)
+}
+
+func (s *FileStore) put(key string, value []byte) error {
+	if err := s.ValidateKey(key); err != nil {
+		return err
+	}
+	path := s.keyPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for %q: %w", key, err)
+	}
+	if err := writeFileAtomic(path, value); err != nil {
+		return fmt.Errorf("failed to write %q: %w", key, err)
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.delete(key)
+}
+
+func (s *FileStore) delete(key string) error {
+	if err := s.ValidateKey(key); err != nil {
+		return err
+	}
+	path := s.keyPath(key)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %q: %w", key, err)
+	}
+
+	// Prune directories left empty, so listings do not walk dead branches.
+	for dir := filepath.Dir(path); dir != s.root && strings.HasPrefix(dir, s.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// List walks the deepest directory that contains every key under prefix.
+func (s *FileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.list(ctx, prefix)
+}
+
+func (s *FileStore) list(ctx context.Context, prefix string) ([]string, error) {
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = filepath.Join(s.root, dirPath(strings.Split(prefix[:i], "/")))
+	}
+
+	var keys []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasPrefix(d.Name(), "_") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key, err := pathKey(rel)
+		if err != nil {
+			return nil // not written by us
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Walk visits every key under prefix while holding the read lock, so no
+// writes interleave with the walk. fn must not call back into the store.
+func (s *FileStore) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys, err := s.list(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		value, err := s.get(key)
+		if err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) Transaction(ctx context.Context, ops []TxnOp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, op := range ops {
+		if err := s.ValidateKey(op.Key); err != nil {
+			return err
+		}
+	}
+	for _, op := range ops {
+		current, err := s.get(op.Key)
+		if errors.Is(err, ErrKeyNotFound) {
+			current, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkTxnOp(op, current); err != nil {
+			return err
+		}
+	}
+
+	for _, op := range ops {
+		var err error
+		switch op.Op {
+		case TxnPut:
+			err = s.put(op.Key, op.Value)
+		case TxnDelete:
+			err = s.delete(op.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot writes every key/value pair as a record stream in key order.
+func (s *FileStore) Snapshot(w io.Writer) error {
+	rw, err := NewRecordWriter(w)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot header: %w", err)
+	}
+	err = s.Walk(context.Background(), "", func(key string, value []byte) error {
+		return rw.Write(key, value)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot record: %w", err)
+	}
+	return rw.Close()
+}
+
+// Restore builds the snapshot's tree in a sibling directory and only swaps it
+// in for the current one once the whole snapshot has been written.
+func (s *FileStore) Restore(r io.Reader) error {
+	rr, err := newRecordReader(r)
+	if err != nil {
+		return err
+	}
+
+	staging, err := os.MkdirTemp(filepath.Dir(s.root), filepath.Base(s.root)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	restored := &FileStore{root: staging}
+	for {
+		k, v, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot record: %w", err)
+		}
+		if err := restored.put(k, v); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := staging + ".old"
+	if err := os.Rename(s.root, old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to move current data aside: %w", err)
+	}
+	if err := os.Rename(staging, s.root); err != nil {
+		os.Rename(old, s.root)
+		return fmt.Errorf("failed to move restored data into place: %w", err)
+	}
+	return os.RemoveAll(old)
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// Most filesystems limit names to 255 bytes, and paths to 4096 bytes.
+const (
+	maxNameLen = 255
+	maxPathLen = 4095
+)
+
+// ValidateKey fails with ErrInvalidKey for keys whose file would have a name
+// or path longer than the filesystem allows, once escaped.
+func (s *FileStore) ValidateKey(key string) error {
+	segments := strings.Split(key, "/")
+	last := len(segments) - 1
+	for i, seg := range segments {
+		name := "_" + escapeSegment(seg)
+		if i < last {
+			name = escapeDirSegment(seg)
+		}
+		if len(name) > maxNameLen {
+			return fmt.Errorf("%w: a segment of %q is %d bytes once escaped, over %d", ErrInvalidKey, key, len(name), maxNameLen)
+		}
+	}
+	if path := s.keyPath(key); len(path) > maxPathLen {
+		return fmt.Errorf("%w: the path of %q is %d bytes, over %d", ErrInvalidKey, key, len(path), maxPathLen)
+	}
+	return nil
+}
+
+func (s *FileStore) keyPath(key string) string {
+	segments := strings.Split(key, "/")
+	last := len(segments) - 1
+	return filepath.Join(s.root, dirPath(segments[:last]), "_"+escapeSegment(segments[last]))
+}
+
+// dirPath maps the directory segments of a key to a relative filesystem path.
+func dirPath(segments []string) string {
+	escaped := make([]string, len(segments))
+	for i, seg := range segments {
+		escaped[i] = escapeDirSegment(seg)
+	}
+	return filepath.Join(escaped...)
+}
+
+// pathKey is the inverse of keyPath for a path relative to the root.
+func pathKey(rel string) (string, error) {
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	last := len(segments) - 1
+	for i, seg := range segments {
+		if i == last {
+			seg = strings.TrimPrefix(seg, "_")
+		} else if seg == "%" {
+			segments[i] = ""
+			continue
+		}
+		unescaped, err := url.PathUnescape(seg)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = unescaped
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+func escapeSegment(seg string) string {
+	escaped := url.PathEscape(seg)
+	switch escaped {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+	return escaped
+}
+
+// escapeDirSegment escapes a directory segment so it can never collide with a
+// key file (which starts with "_") or be empty. A lone "%" is never produced
+// by percent-escaping, so it stands in for an empty segment.
+func escapeDirSegment(seg string) string {
+	if seg == "" {
+		return "%"
+	}
+	escaped := escapeSegment(seg)
+	if strings.HasPrefix(escaped, "_") {
+		escaped = "%5F" + escaped[1:]
+	}
+	return escaped
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path once it is safely on disk.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newTestFileStore(t *testing.T) *FileStore {
+	t.Helper()
+
+	store := NewFileStore(filepath.Join(t.TempDir(), "data"))
+	if err := store.Initialize(t.Context()); err != nil {
+		t.Fatalf("failed to initialize file store: %v", err)
+	}
+	return store
+}
+
+func TestFileStore(t *testing.T) {
+	store := newTestFileStore(t)
+	ctx := t.Context()
+
+	// Keys that would be unsafe or ambiguous as naive paths.
+	keys := map[string][]byte{
+		"secrets/db/pass":   []byte("my-test-password"),
+		"secrets/db":        []byte("a key and a folder"),
+		"secrets/_db/user":  []byte("leading underscore"),
+		"secrets/../escape": []byte("dot dot"),
+		"secrets//empty":    []byte("empty segment"),
+		"secrets/trailing/": []byte("trailing slash"),
+		"secrets/100%/sure": []byte("percent"),
+		"config/feature":    []byte("true"),
+		"/config/feature":   []byte("leading slash"),
+	}
+
+	t.Run("Put and Get", func(t *testing.T) {
+		for k, v := range keys {
+			if err := store.Put(ctx, k, v); err != nil {
+				t.Fatalf("failed to put %q: %v", k, err)
+			}
+		}
+		for k, v := range keys {
+			got, err := store.Get(ctx, k)
+			if err != nil {
+				t.Fatalf("failed to get %q: %v", k, err)
+			}
+			if !bytes.Equal(got, v) {
+				t.Fatalf("expected value %q at %q, got %q", v, k, got)
+			}
+		}
+
+		// Nothing may be written outside the root.
+		entries, err := os.ReadDir(filepath.Dir(store.root))
+		if err != nil {
+			t.Fatalf("failed to read parent directory: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected only the data directory next to the root, got %v", entries)
+		}
+	})
+
+	t.Run("Got non-existent key", func(t *testing.T) {
+		_, err := store.Get(ctx, "secrets/missing")
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		got, err := store.List(ctx, "secrets/")
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		var expected []string
+		for k := range keys {
+			if strings.HasPrefix(k, "secrets/") {
+				expected = append(expected, k)
+			}
+		}
+		sort.Strings(expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, got)
+		}
+
+		got, err = store.List(ctx, "secrets/d")
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected = []string{"secrets/db", "secrets/db/pass"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, got)
+		}
+
+		got, err = store.List(ctx, "missing/")
+		if err != nil || len(got) != 0 {
+			t.Fatalf("expected no keys under a missing folder, got %q (%v)", got, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.Delete(ctx, "secrets/db/pass"); err != nil {
+			t.Fatalf("failed to delete value: %v", err)
+		}
+		if _, err := store.Get(ctx, "secrets/db/pass"); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+		if err := store.Delete(ctx, "secrets/db/pass"); err != nil {
+			t.Fatalf("deleting a non-existent key should not produce an error, but got: %v", err)
+		}
+		if _, err := store.Get(ctx, "secrets/db"); err != nil {
+			t.Fatalf("deleting a nested key must not affect its parent key: %v", err)
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		err := store.Transaction(ctx, []TxnOp{
+			{Op: TxnPut, Key: "secrets/new", Value: []byte("v1")},
+			{Op: TxnCheck, Key: "config/feature", Value: []byte("false")},
+		})
+		if !errors.Is(err, ErrTxnCheckFailed) {
+			t.Fatalf("expected ErrTxnCheckFailed, got %v", err)
+		}
+		if _, err := store.Get(ctx, "secrets/new"); err == nil {
+			t.Fatal("expected failed transaction to write nothing")
+		}
+
+		err = store.Transaction(ctx, []TxnOp{
+			{Op: TxnCheck, Key: "config/feature", Value: []byte("true")},
+			{Op: TxnPut, Key: "secrets/new", Value: []byte("v1")},
+			{Op: TxnDelete, Key: "config/feature"},
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if _, err := store.Get(ctx, "config/feature"); err == nil {
+			t.Fatal("expected config/feature to be deleted")
+		}
+	})
+}
+
+func TestFileStore_InvalidKeys(t *testing.T) {
+	store := newTestFileStore(t)
+	ctx := t.Context()
+	long := "secrets/" + strings.Repeat("x", 300)
+
+	if err := store.Put(ctx, long, []byte("v")); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for a name too long, got %v", err)
+	}
+	if err := store.Delete(ctx, long); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey deleting a name too long, got %v", err)
+	}
+	deep := strings.Repeat(strings.Repeat("d", 200)+"/", 25) + "key"
+	if err := ValidateKey(store, deep); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for a path too long, got %v", err)
+	}
+
+	// A rejected transaction is skipped without failing the batch.
+	results, err := Batch(ctx, store, [][]TxnOp{
+		{{Op: TxnPut, Key: "ok", Value: []byte("v")}, {Op: TxnPut, Key: long, Value: []byte("v")}},
+		{{Op: TxnPut, Key: "after", Value: []byte("v")}},
+	})
+	if err != nil {
+		t.Fatalf("expected the batch to be applied, got %v", err)
+	}
+	if !errors.Is(results[0], ErrInvalidKey) || results[1] != nil {
+		t.Fatalf("expected only the first transaction to be rejected, got %v", results)
+	}
+	if _, err := store.Get(ctx, "ok"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected the rejected transaction to write nothing, got %v", err)
+	}
+	if _, err := store.Get(ctx, "after"); err != nil {
+		t.Fatalf("expected later transactions to be applied, got %v", err)
+	}
+}
+
+func TestFileStore_Snapshot(t *testing.T) {
+	ctx := t.Context()
+	store := newTestFileStore(t)
+
+	keysToSet := map[string][]byte{
+		"key1":       []byte("value1"),
+		"nested/key": []byte("value2"),
+	}
+	for k, v := range keysToSet {
+		if err := store.Put(ctx, k, v); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := store.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// Snapshots are interchangeable with the in-memory backend.
+	mem := NewMemStore()
+	if err := mem.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Restore into MemStore failed: %v", err)
+	}
+
+	restoredStore := newTestFileStore(t)
+	if err := restoredStore.Put(ctx, "stale", []byte("gone after restore")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := restoredStore.Restore(&buf); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	for _, s := range []Storage{mem, restoredStore} {
+		keys, err := s.List(ctx, "")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if expected := []string{"key1", "nested/key"}; !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("expected keys %q after restore, got %q", expected, keys)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"bolt", "file", "inmem"} {
+		t.Run(name, func(t *testing.T) {
+			store, err := Open(name, map[string]string{"path": filepath.Join(dir, name)})
+			if err != nil {
+				t.Fatalf("failed to open %s backend: %v", name, err)
+			}
+			defer store.Close()
+
+			if err := store.Initialize(t.Context()); err != nil {
+				t.Fatalf("failed to initialize %s backend: %v", name, err)
+			}
+			if err := store.Put(t.Context(), "key", []byte("value")); err != nil {
+				t.Fatalf("failed to put value: %v", err)
+			}
+		})
+	}
+
+	if _, err := Open("nope", nil); err == nil {
+		t.Fatal("expected error opening an unknown backend")
+	}
+}
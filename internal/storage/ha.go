@@ -0,0 +1,27 @@
+package storage
+
+import "context"
+
+// HABackend is implemented by backends that several servers can share. Only
+// one server, the active node, may serve requests at a time, and it is
+// elected by holding a lock in the backend.
+type HABackend interface {
+	Storage
+	// HALock returns a lock named key. value identifies the caller, usually
+	// by its advertised API address, and is what other servers see as the
+	// current holder.
+	HALock(key, value string) (HALock, error)
+}
+
+// HALock is a distributed lock held by at most one server at a time.
+type HALock interface {
+	// Lock blocks until the lock is acquired or ctx is done. The returned
+	// channel is closed if the lock is later lost, for example because the
+	// holder could not reach the backend for too long.
+	Lock(ctx context.Context) (<-chan struct{}, error)
+	// Unlock releases the lock if it is held.
+	Unlock() error
+	// Holder returns the value of the server currently holding the lock,
+	// and false if nobody holds it.
+	Holder(ctx context.Context) (string, bool, error)
+}
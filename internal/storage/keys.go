@@ -0,0 +1,28 @@
+package storage
+
+import "errors"
+
+// ErrInvalidKey is returned for keys a backend cannot store, such as keys
+// longer than it allows. Backends return it before writing anything, so
+// every replica rejects the same keys alike.
+var ErrInvalidKey = errors.New("key cannot be stored by this backend")
+
+// KeyValidator is implemented by backends that cannot store every key.
+type KeyValidator interface {
+	// ValidateKey fails with ErrInvalidKey if key cannot be stored.
+	ValidateKey(key string) error
+}
+
+// ValidateKey checks that s, or the backend it wraps, can store key.
+func ValidateKey(s Storage, key string) error {
+	if v, ok := unwrapTo[KeyValidator](s); ok {
+		return v.ValidateKey(key)
+	}
+	return nil
+}
+
+// rejected reports whether a transaction failed without being applied for a
+// reason of its own, rather than because the backend failed.
+func rejected(err error) bool {
+	return errors.Is(err, ErrTxnCheckFailed) || errors.Is(err, ErrInvalidKey)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// ListOptions controls a paged listing.
+type ListOptions struct {
+	// Prefix restricts the listing to keys that start with it.
+	Prefix string
+	// Delimiter, when set, groups every key that contains it after Prefix
+	// into a single folder entry ending in the delimiter, so only the
+	// immediate children of Prefix are returned. An empty Delimiter lists
+	// every key under Prefix.
+	Delimiter string
+	// StartAfter resumes a listing after the given entry, which is the Cursor
+	// of the previous page.
+	StartAfter string
+	// Limit caps the number of entries returned. Zero means no limit.
+	Limit int
+}
+
+type ListEntry struct {
+	// Key is the full key, or the full folder path including the trailing
+	// delimiter for folder entries.
+	Key      string
+	IsFolder bool
+}
+
+type ListResult struct {
+	Entries []ListEntry
+	// Cursor is set when the listing was truncated by Limit, and should be
+	// passed as StartAfter to fetch the next page.
+	Cursor string
+}
+
+// Lister is the subset of Storage needed for listing.
+type Lister interface {
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// PagedLister is implemented by backends that can page through keys natively
+// instead of loading every key under the prefix.
+type PagedLister interface {
+	ListPaged(ctx context.Context, opts ListOptions) (*ListResult, error)
+}
+
+// ListPaged lists keys according to opts, using the backend's native paging
+// when it has one and falling back to filtering the output of List.
+func ListPaged(ctx context.Context, l Lister, opts ListOptions) (*ListResult, error) {
+	if pl, ok := l.(PagedLister); ok {
+		return pl.ListPaged(ctx, opts)
+	}
+
+	keys, err := l.List(ctx, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	p := newListPager(opts)
+	for _, key := range keys {
+		if p.add(key) {
+			break
+		}
+	}
+	return p.result(), nil
+}
+
+// listPager accumulates keys visited in order into a page of entries,
+// collapsing folders and applying StartAfter and Limit.
+type listPager struct {
+	opts ListOptions
+	res  ListResult
+}
+
+func newListPager(opts ListOptions) *listPager {
+	return &listPager{opts: opts}
+}
+
+// start returns the first key a scan needs to visit.
+func (p *listPager) start() string {
+	if p.opts.StartAfter == "" || p.opts.StartAfter < p.opts.Prefix {
+		return p.opts.Prefix
+	}
+	if p.opts.Delimiter != "" && strings.HasSuffix(p.opts.StartAfter, p.opts.Delimiter) {
+		return prefixEnd(p.opts.StartAfter)
+	}
+	return p.opts.StartAfter + "\x00"
+}
+
+// add visits key and reports whether the page is complete. Once it returns
+// true the scan can stop.
+func (p *listPager) add(key string) bool {
+	if !strings.HasPrefix(key, p.opts.Prefix) {
+		return false
+	}
+
+	entry := ListEntry{Key: key}
+	if p.opts.Delimiter != "" {
+		rest := key[len(p.opts.Prefix):]
+		if i := strings.Index(rest, p.opts.Delimiter); i >= 0 {
+			entry = ListEntry{Key: p.opts.Prefix + rest[:i+len(p.opts.Delimiter)], IsFolder: true}
+		}
+	}
+
+	if entry.Key <= p.opts.StartAfter {
+		return false
+	}
+	if n := len(p.res.Entries); n > 0 && p.res.Entries[n-1] == entry {
+		return false
+	}
+	if p.opts.Limit > 0 && len(p.res.Entries) == p.opts.Limit {
+		p.res.Cursor = p.res.Entries[len(p.res.Entries)-1].Key
+		return true
+	}
+
+	p.res.Entries = append(p.res.Entries, entry)
+	return false
+}
+
+// skipTo returns the key a cursor-based scan can seek to after key was added,
+// skipping every remaining key inside the folder key collapsed into.
+func (p *listPager) skipTo(key string) (string, bool) {
+	n := len(p.res.Entries)
+	if n == 0 || !p.res.Entries[n-1].IsFolder || !strings.HasPrefix(key, p.res.Entries[n-1].Key) {
+		return "", false
+	}
+	end := prefixEnd(p.res.Entries[n-1].Key)
+	return end, end != ""
+}
+
+func (p *listPager) result() *ListResult {
+	return &p.res
+}
+
+// prefixEnd returns the smallest key that sorts after every key starting with
+// prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListPaged(t *testing.T) {
+	ctx := t.Context()
+	keys := []string{
+		"config/feature",
+		"secrets/api/key",
+		"secrets/db/pass",
+		"secrets/db/replica/pass",
+		"secrets/db/user",
+		"secrets/root",
+		"secrets/tls/cert",
+		"secrets0",
+	}
+
+	stores := map[string]Storage{
+		"BoltStore": newTestBoltStore(t),
+		"MemStore":  NewMemStore(),
+	}
+
+	testCases := []struct {
+		name     string
+		opts     ListOptions
+		expected []ListEntry
+	}{
+		{
+			name: "immediate children",
+			opts: ListOptions{Prefix: "secrets/", Delimiter: "/"},
+			expected: []ListEntry{
+				{Key: "secrets/api/", IsFolder: true},
+				{Key: "secrets/db/", IsFolder: true},
+				{Key: "secrets/root"},
+				{Key: "secrets/tls/", IsFolder: true},
+			},
+		},
+		{
+			name: "nested folder",
+			opts: ListOptions{Prefix: "secrets/db/", Delimiter: "/"},
+			expected: []ListEntry{
+				{Key: "secrets/db/pass"},
+				{Key: "secrets/db/replica/", IsFolder: true},
+				{Key: "secrets/db/user"},
+			},
+		},
+		{
+			name: "recursive",
+			opts: ListOptions{Prefix: "secrets/db/"},
+			expected: []ListEntry{
+				{Key: "secrets/db/pass"},
+				{Key: "secrets/db/replica/pass"},
+				{Key: "secrets/db/user"},
+			},
+		},
+		{
+			name: "root",
+			opts: ListOptions{Delimiter: "/"},
+			expected: []ListEntry{
+				{Key: "config/", IsFolder: true},
+				{Key: "secrets/", IsFolder: true},
+				{Key: "secrets0"},
+			},
+		},
+	}
+
+	for name, store := range stores {
+		for _, k := range keys {
+			if err := store.Put(ctx, k, []byte("value")); err != nil {
+				t.Fatalf("failed to put value: %v", err)
+			}
+		}
+
+		for _, tc := range testCases {
+			t.Run(name+"/"+tc.name, func(t *testing.T) {
+				res, err := ListPaged(ctx, store, tc.opts)
+				if err != nil {
+					t.Fatalf("failed to list keys: %v", err)
+				}
+				if !reflect.DeepEqual(res.Entries, tc.expected) {
+					t.Fatalf("expected entries %v, got %v", tc.expected, res.Entries)
+				}
+				if res.Cursor != "" {
+					t.Fatalf("expected no cursor for a complete listing, got %q", res.Cursor)
+				}
+
+				// Paging one entry at a time must yield the same entries.
+				var paged []ListEntry
+				opts := tc.opts
+				opts.Limit = 1
+				for {
+					res, err := ListPaged(ctx, store, opts)
+					if err != nil {
+						t.Fatalf("failed to list page: %v", err)
+					}
+					paged = append(paged, res.Entries...)
+					if res.Cursor == "" {
+						break
+					}
+					if len(paged) > len(tc.expected) {
+						t.Fatalf("paging did not terminate, got %v", paged)
+					}
+					opts.StartAfter = res.Cursor
+				}
+				if !reflect.DeepEqual(paged, tc.expected) {
+					t.Fatalf("expected paged entries %v, got %v", tc.expected, paged)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemStore is an in-memory Storage implementation. Nothing it holds survives
+// the process, which makes it suitable for tests and dev-mode servers.
+type MemStore struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+func init() {
+	Register("inmem", func(config map[string]string) (Storage, error) {
+		return NewMemStore(), nil
+	})
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{data: make(map[string][]byte)}
+}
+
+func (s *MemStore) Initialize(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	val, ok := s.data[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+
+	value := make([]byte, len(val))
+	copy(value, val)
+	return value, nil
+}
+
+func (s *MemStore) Put(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[key] = cloneValue(value)
+	return nil
+}
+
+func (s *MemStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data, key)
+	return nil
+}
+
+// List returns the keys under prefix in lexicographic order, matching the
+// cursor order of BoltStore.
+func (s *MemStore) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for k := range s.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Walk visits every key under prefix while holding the read lock, so the walk
+// sees a consistent view. fn must not call back into the store.
+func (s *MemStore) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for k := range s.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(k, s.data[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) Transaction(ctx context.Context, ops []TxnOp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, op := range ops {
+		if err := checkTxnOp(op, s.data[op.Key]); err != nil {
+			return err
+		}
+	}
+
+	for _, op := range ops {
+		switch op.Op {
+		case TxnPut:
+			s.data[op.Key] = cloneValue(op.Value)
+		case TxnDelete:
+			delete(s.data, op.Key)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) Batch(ctx context.Context, txns [][]TxnOp) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Writes are staged, so that a failing batch leaves the store untouched.
+	staged := make(map[string][]byte)
+	get := func(key string) []byte {
+		if value, ok := staged[key]; ok {
+			return value
+		}
+		return s.data[key]
+	}
+	results, err := applyBatch(txns, get, func(op TxnOp) error {
+		if op.Op == TxnPut {
+			staged[op.Key] = cloneValue(op.Value)
+		} else {
+			staged[op.Key] = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range staged {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+	return results, nil
+}
+
+// Snapshot writes every key/value pair as a record stream in key order.
+func (s *MemStore) Snapshot(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return writeMemSnapshot(w, s.data)
+}
+
+// SnapshotView copies the map of the store, but not the values, which are
+// never modified once stored.
+func (s *MemStore) SnapshotView() (SnapshotView, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return memView(maps.Clone(s.data)), nil
+}
+
+type memView map[string][]byte
+
+func (v memView) Snapshot(w io.Writer) error {
+	return writeMemSnapshot(w, v)
+}
+
+func (v memView) Release() {}
+
+func writeMemSnapshot(w io.Writer, data map[string][]byte) error {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rw, err := NewRecordWriter(w)
+	if err != nil {
+		return errors.Wrap(err, "failed to write snapshot header")
+	}
+	for _, k := range keys {
+		if err := rw.Write(k, data[k]); err != nil {
+			return errors.Wrap(err, "failed to write snapshot record")
+		}
+	}
+	return rw.Close()
+}
+
+// Restore replaces the entire contents of the store with the snapshot. The
+// existing data is only discarded once the snapshot has been read in full.
+func (s *MemStore) Restore(r io.Reader) error {
+	rr, err := newRecordReader(r)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string][]byte)
+	for {
+		k, v, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read snapshot record")
+		}
+		data[k] = v
+	}
+
+	s.mu.Lock()
+	s.data = data
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) Close() error {
+	return nil
+}
+
+// cloneValue copies value so callers cannot mutate stored data. A nil value is
+// stored as an empty one, as bbolt does.
+func cloneValue(value []byte) []byte {
+	v := make([]byte, len(value))
+	copy(v, value)
+	return v
+}
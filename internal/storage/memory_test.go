@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMemStore(t *testing.T) {
+	store := NewMemStore()
+	ctx := t.Context()
+
+	key := "my-test-key"
+	value := []byte("my-test-password")
+
+	t.Run("Put and Get", func(t *testing.T) {
+		if err := store.Put(ctx, key, value); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+
+		got, err := store.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get value: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, value) {
+			t.Fatalf("expected value %q, got %q", value, got)
+		}
+	})
+
+	t.Run("Got non-existent key", func(t *testing.T) {
+		_, err := store.Get(ctx, "non-existent-key")
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		testKeys := map[string][]byte{
+			"secrets/db/user": []byte("my-test-user"),
+			"secrets/db/pass": []byte("my-test-password"),
+			"secrets/api/key": []byte("abc"),
+			"config/feature":  []byte("true"),
+		}
+		for k, v := range testKeys {
+			if err := store.Put(ctx, k, v); err != nil {
+				t.Fatalf("failed to put value: %v", err)
+			}
+		}
+
+		expected := []string{"secrets/db/pass", "secrets/db/user"}
+		keys, err := store.List(ctx, "secrets/db/")
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+
+		if !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, keys)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.Delete(ctx, key); err != nil {
+			t.Fatalf("failed to delete value: %v", err)
+		}
+
+		_, err := store.Get(ctx, key)
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+
+		err = store.Delete(ctx, key)
+		if err != nil {
+			t.Fatalf("deleting a non-existent key should not produce an error, but got: %v", err)
+		}
+	})
+}
+
+func TestMemStore_Snapshot(t *testing.T) {
+	ctx := t.Context()
+	store := NewMemStore()
+
+	keysToSet := map[string][]byte{
+		"key1": []byte("value1"),
+		"key2": []byte("value2"),
+		"key3": {},
+	}
+	for k, v := range keysToSet {
+		if err := store.Put(ctx, k, v); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := store.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	restoredStore := NewMemStore()
+	if err := restoredStore.Put(ctx, "stale", []byte("gone after restore")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := restoredStore.Restore(&buf); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	for k, v := range keysToSet {
+		retrievedValue, err := restoredStore.Get(ctx, k)
+		if err != nil {
+			t.Fatalf("Get from restored store failed for key %q: %v", k, err)
+		}
+		if !bytes.Equal(v, retrievedValue) {
+			t.Errorf("Get from restored store returned incorrect value for key %q: got %q, want %q", k, retrievedValue, v)
+		}
+	}
+
+	if _, err := restoredStore.Get(ctx, "stale"); err == nil {
+		t.Error("expected restore to replace existing data")
+	}
+
+	t.Run("Rejects truncated snapshot", func(t *testing.T) {
+		var snap bytes.Buffer
+		if err := store.Snapshot(&snap); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+
+		truncated := snap.Bytes()[:snap.Len()-3]
+		if err := restoredStore.Restore(bytes.NewReader(truncated)); err == nil {
+			t.Fatal("expected error restoring a truncated snapshot")
+		}
+		if _, err := restoredStore.Get(ctx, "key1"); err != nil {
+			t.Fatalf("failed restore should leave existing data intact: %v", err)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// recordsMagic identifies a snapshot written as a stream of key/value
+// records rather than a raw bbolt file.
+var recordsMagic = []byte("RUNEREC1")
+
+// maxRecordFieldSize bounds a single key or value read from a snapshot so a
+// corrupt length prefix cannot make us allocate unbounded memory.
+const maxRecordFieldSize = 64 << 20
+
+var (
+	errRecordsTruncated = errors.New("record stream is truncated")
+	errRecordsChecksum  = errors.New("record stream checksum mismatch")
+)
+
+// RecordWriter streams key/value pairs as uvarint length-prefixed records.
+// Each key length is stored plus one, so that a zero length marks the
+// trailer, which holds the number of records and a SHA-256 checksum of the
+// stream up to the checksum itself.
+type RecordWriter struct {
+	w     *bufio.Writer
+	sum   hash.Hash
+	out   io.Writer
+	count uint64
+	buf   [binary.MaxVarintLen64]byte
+}
+
+// NewRecordWriter starts a record stream on w. The stream is what Snapshot
+// writes and Restore reads for every backend other than BoltStore, which
+// accepts it as well.
+func NewRecordWriter(w io.Writer) (*RecordWriter, error) {
+	rw := &RecordWriter{w: bufio.NewWriter(w), sum: sha256.New()}
+	rw.out = io.MultiWriter(rw.w, rw.sum)
+	if _, err := rw.w.Write(recordsMagic); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func (rw *RecordWriter) Write(key string, value []byte) error {
+	if err := rw.writeUvarint(uint64(len(key)) + 1); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(rw.out, key); err != nil {
+		return err
+	}
+	if err := rw.writeUvarint(uint64(len(value))); err != nil {
+		return err
+	}
+	if _, err := rw.out.Write(value); err != nil {
+		return err
+	}
+	rw.count++
+	return nil
+}
+
+func (rw *RecordWriter) writeUvarint(v uint64) error {
+	n := binary.PutUvarint(rw.buf[:], v)
+	_, err := rw.out.Write(rw.buf[:n])
+	return err
+}
+
+// Close writes the trailer. A stream that is not closed is rejected by
+// recordReader as truncated.
+func (rw *RecordWriter) Close() error {
+	if err := rw.writeUvarint(0); err != nil {
+		return err
+	}
+	if err := rw.writeUvarint(rw.count); err != nil {
+		return err
+	}
+	if _, err := rw.w.Write(rw.sum.Sum(nil)); err != nil {
+		return err
+	}
+	return rw.w.Flush()
+}
+
+// recordReader reads records written by RecordWriter.
+type recordReader struct {
+	r     *bufio.Reader
+	sum   hash.Hash
+	count uint64
+	done  bool
+}
+
+func newRecordReader(r io.Reader) (*recordReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(recordsMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if !bytes.Equal(magic, recordsMagic) {
+		return nil, errors.New("snapshot is not a record stream")
+	}
+	return &recordReader{r: br, sum: sha256.New()}, nil
+}
+
+// Next returns the next record, or io.EOF once the trailer has been read and
+// verified. A stream that ends without a trailer fails as truncated.
+func (rr *recordReader) Next() (string, []byte, error) {
+	if rr.done {
+		return "", nil, io.EOF
+	}
+
+	n, err := rr.readUvarint()
+	if err != nil {
+		return "", nil, err
+	}
+	if n == 0 {
+		if err := rr.readTrailer(); err != nil {
+			return "", nil, err
+		}
+		rr.done = true
+		return "", nil, io.EOF
+	}
+
+	key, err := rr.readBytes(n - 1)
+	if err != nil {
+		return "", nil, err
+	}
+	n, err = rr.readUvarint()
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := rr.readBytes(n)
+	if err != nil {
+		return "", nil, err
+	}
+	rr.count++
+	return string(key), value, nil
+}
+
+func (rr *recordReader) readTrailer() error {
+	count, err := rr.readUvarint()
+	if err != nil {
+		return err
+	}
+	want := rr.sum.Sum(nil)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(rr.r, got); err != nil {
+		return errRecordsTruncated
+	}
+	if count != rr.count {
+		return fmt.Errorf("%w: trailer counts %d records, read %d", errRecordsChecksum, count, rr.count)
+	}
+	if !bytes.Equal(got, want) {
+		return errRecordsChecksum
+	}
+	return nil
+}
+
+func (rr *recordReader) readUvarint() (uint64, error) {
+	n, err := binary.ReadUvarint(hashingByteReader{rr})
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, errRecordsTruncated
+	}
+	return n, err
+}
+
+func (rr *recordReader) readBytes(n uint64) ([]byte, error) {
+	if n > maxRecordFieldSize {
+		return nil, fmt.Errorf("snapshot record too large: %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errRecordsTruncated
+		}
+		return nil, err
+	}
+	rr.sum.Write(b)
+	return b, nil
+}
+
+// hashingByteReader adds the bytes of a uvarint to the checksum as it is
+// read.
+type hashingByteReader struct {
+	rr *recordReader
+}
+
+func (h hashingByteReader) ReadByte() (byte, error) {
+	b, err := h.rr.r.ReadByte()
+	if err == nil {
+		h.rr.sum.Write([]byte{b})
+	}
+	return b, err
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// writeRecords returns a record stream of n records, and the offsets at
+// which each record ends.
+func writeRecords(t *testing.T, n int) ([]byte, []int) {
+	t.Helper()
+	var buf bytes.Buffer
+	rw, err := NewRecordWriter(&buf)
+	if err != nil {
+		t.Fatalf("failed to start record stream: %v", err)
+	}
+	var boundaries []int
+	for i := range n {
+		if err := rw.Write(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("failed to write record: %v", err)
+		}
+		rw.w.Flush()
+		boundaries = append(boundaries, buf.Len())
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("failed to close record stream: %v", err)
+	}
+	return buf.Bytes(), boundaries
+}
+
+func readRecords(data []byte) (int, error) {
+	rr, err := newRecordReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		_, _, err := rr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func TestRecords(t *testing.T) {
+	data, boundaries := writeRecords(t, 3)
+
+	if n, err := readRecords(data); err != nil || n != 3 {
+		t.Fatalf("expected 3 records, got %d (%v)", n, err)
+	}
+
+	t.Run("truncated at a record boundary", func(t *testing.T) {
+		for _, end := range boundaries {
+			if _, err := readRecords(data[:end]); !errors.Is(err, errRecordsTruncated) {
+				t.Fatalf("expected a stream cut off after %d bytes to be truncated, got %v", end, err)
+			}
+		}
+	})
+
+	t.Run("truncated trailer", func(t *testing.T) {
+		if _, err := readRecords(data[:len(data)-1]); !errors.Is(err, errRecordsTruncated) {
+			t.Fatalf("expected a truncated trailer to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("corrupt record", func(t *testing.T) {
+		corrupt := bytes.Clone(data)
+		corrupt[boundaries[0]-1] ^= 0xff
+		if _, err := readRecords(corrupt); !errors.Is(err, errRecordsChecksum) {
+			t.Fatalf("expected a checksum mismatch, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory creates a storage backend from its configuration. The returned
+// backend has not been initialized yet.
+type Factory func(config map[string]string) (Storage, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a backend available under name. It panics if name is already
+// registered, since that is always a programming error.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("storage: Register called twice for backend " + name)
+	}
+	registry[name] = factory
+}
+
+// Open creates the backend registered under name.
+func Open(name string, config map[string]string) (Storage, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q (available: %v)", name, Backends())
+	}
+	return factory(config)
+}
+
+// Backends returns the names of all registered backends.
+func Backends() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
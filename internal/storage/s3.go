@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func init() {
+	Register("s3", func(config map[string]string) (Storage, error) {
+		opts := S3Options{
+			Endpoint:  config["endpoint"],
+			Bucket:    config["bucket"],
+			Prefix:    config["prefix"],
+			Region:    config["region"],
+			AccessKey: config["access_key"],
+			SecretKey: config["secret_key"],
+		}
+		for name, dst := range map[string]*bool{
+			"insecure":           &opts.Insecure,
+			"path_style":         &opts.PathStyle,
+			"conditional_writes": &opts.ConditionalWrites,
+		} {
+			if v, ok := config[name]; ok {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid s3 option %s: %w", name, err)
+				}
+				*dst = b
+			}
+		}
+		return NewS3Store(opts)
+	})
+}
+
+// S3Options configures an S3Store.
+type S3Options struct {
+	// Endpoint is the host[:port] of the S3-compatible API, e.g.
+	// "s3.amazonaws.com" or "localhost:9000".
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to every key, so several vaults can share a bucket.
+	Prefix string
+	Region string
+	// AccessKey and SecretKey are static credentials. When empty, credentials
+	// are taken from the standard AWS environment variables.
+	AccessKey string
+	SecretKey string
+	// Insecure talks plain HTTP to the endpoint.
+	Insecure bool
+	// PathStyle addresses the bucket as endpoint/bucket instead of as a
+	// bucket.endpoint virtual host, as most self-hosted servers require.
+	PathStyle bool
+	// ConditionalWrites makes transactions use If-Match/If-None-Match so
+	// that a write racing with another writer of the bucket fails the
+	// transaction instead of silently overwriting it. The server must support
+	// conditional PUTs.
+	ConditionalWrites bool
+}
+
+// S3Store stores one object per key in an S3-compatible bucket.
+//
+// Objects sort by key, so List and ListPaged map directly onto
+// ListObjectsV2. S3 has no multi-object transactions: like FileStore, a
+// transaction is atomic with respect to other callers of the same S3Store,
+// but a failure part way through one can leave only some of its writes
+// applied.
+type S3Store struct {
+	// mu serializes transactions against other writes through this store.
+	mu     sync.RWMutex
+	client *minio.Client
+	opts   S3Options
+}
+
+func NewS3Store(opts S3Options) (*S3Store, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, errors.New("s3 storage requires an endpoint and a bucket")
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{&credentials.EnvAWS{}})
+	if opts.AccessKey != "" || opts.SecretKey != "" {
+		creds = credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, "")
+	}
+	lookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !opts.Insecure,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+	return &S3Store{client: client, opts: opts}, nil
+}
+
+// Initialize checks that the bucket exists and is reachable. Rune never
+// creates buckets itself.
+func (s *S3Store) Initialize(ctx context.Context) error {
+	ok, err := s.client.BucketExists(ctx, s.opts.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to reach bucket %q: %w", s.opts.Bucket, err)
+	}
+	if !ok {
+		return fmt.Errorf("bucket %q does not exist", s.opts.Bucket)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, _, err := s.get(ctx, key)
+	return value, err
+}
+
+// get returns the value of key along with its ETag.
+func (s *S3Store) get(ctx context.Context, key string) ([]byte, string, error) {
+	obj, err := s.client.GetObject(ctx, s.opts.Bucket, s.objectKey(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", s.objectError(key, err)
+	}
+	defer obj.Close()
+
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, "", s.objectError(key, err)
+	}
+	value, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, "", s.objectError(key, err)
+	}
+	return value, info.ETag, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, value []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.put(ctx, key, value, minio.PutObjectOptions{})
+}
+
+func (s *S3Store) put(ctx context.Context, key string, value []byte, opts minio.PutObjectOptions) error {
+	if err := s.ValidateKey(key); err != nil {
+		return err
+	}
+	opts.ContentType = "application/octet-stream"
+	_, err := s.client.PutObject(ctx, s.opts.Bucket, s.objectKey(key), bytes.NewReader(value), int64(len(value)), opts)
+	if err != nil {
+		return s.objectError(key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.delete(ctx, key)
+}
+
+func (s *S3Store) delete(ctx context.Context, key string) error {
+	if err := s.ValidateKey(key); err != nil {
+		return err
+	}
+	err := s.client.RemoveObject(ctx, s.opts.Bucket, s.objectKey(key), minio.RemoveObjectOptions{})
+	if err != nil {
+		if err := s.objectError(key, err); !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.scan(ctx, prefix, "", func(key string) bool {
+		keys = append(keys, key)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ListPaged streams keys from ListObjectsV2 starting at the cursor and stops
+// as soon as the page is full.
+func (s *S3Store) ListPaged(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	p := newListPager(opts)
+
+	startAfter := ""
+	if opts.StartAfter >= opts.Prefix {
+		startAfter = opts.StartAfter
+	}
+	if err := s.scan(ctx, opts.Prefix, startAfter, p.add); err != nil {
+		return nil, err
+	}
+	return p.result(), nil
+}
+
+// scan visits keys under prefix in order, starting after startAfter, until fn
+// returns true.
+func (s *S3Store) scan(ctx context.Context, prefix, startAfter string, fn func(key string) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the lister goroutine when fn ends the scan early
+
+	opts := minio.ListObjectsOptions{
+		Prefix:    s.objectKey(prefix),
+		Recursive: true,
+	}
+	if startAfter != "" {
+		opts.StartAfter = s.objectKey(startAfter)
+	}
+	for obj := range s.client.ListObjects(ctx, s.opts.Bucket, opts) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list keys: %w", obj.Err)
+		}
+		if fn(strings.TrimPrefix(obj.Key, s.opts.Prefix)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Transaction evaluates every check, then applies the writes one object at a
+// time. With ConditionalWrites, each write is conditioned on the object still
+// being in the state the checks observed.
+func (s *S3Store) Transaction(ctx context.Context, ops []TxnOp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, op := range ops {
+		if err := s.ValidateKey(op.Key); err != nil {
+			return err
+		}
+	}
+	etags := make(map[string]string)
+	for _, op := range ops {
+		current, etag, err := s.get(ctx, op.Key)
+		if errors.Is(err, ErrKeyNotFound) {
+			current, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkTxnOp(op, current); err != nil {
+			return err
+		}
+		etags[op.Key] = etag
+	}
+
+	for _, op := range ops {
+		var err error
+		switch op.Op {
+		case TxnPut:
+			var opts minio.PutObjectOptions
+			if etag, ok := etags[op.Key]; ok && s.opts.ConditionalWrites {
+				if etag != "" {
+					opts.SetMatchETag(etag)
+				} else {
+					opts.SetMatchETagExcept("*")
+				}
+			}
+			err = s.put(ctx, op.Key, op.Value, opts)
+			delete(etags, op.Key) // later writes to the key follow our own
+		case TxnDelete:
+			err = s.delete(ctx, op.Key)
+			delete(etags, op.Key)
+		}
+		if err != nil {
+			if isPreconditionFailed(err) {
+				return fmt.Errorf("%w: %s was modified concurrently", ErrTxnCheckFailed, op.Key)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot exports every object as a record stream in key order, reading one
+// object at a time.
+func (s *S3Store) Snapshot(w io.Writer) error {
+	ctx := context.Background()
+
+	rw, err := NewRecordWriter(w)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot header: %w", err)
+	}
+
+	var scanErr error
+	err = s.scan(ctx, "", "", func(key string) bool {
+		value, _, err := s.get(ctx, key)
+		if errors.Is(err, ErrKeyNotFound) {
+			return false // deleted since it was listed
+		}
+		if err == nil {
+			err = rw.Write(key, value)
+		}
+		scanErr = err
+		return err != nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot record: %w", err)
+	}
+	return rw.Close()
+}
+
+// Restore uploads every record in the snapshot and then deletes the objects
+// the snapshot does not contain. The snapshot is streamed, so only its keys
+// are held in memory. A failed restore leaves a mix of old and restored
+// objects, and should be retried.
+func (s *S3Store) Restore(r io.Reader) error {
+	ctx := context.Background()
+
+	rr, err := newRecordReader(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	restored := make(map[string]struct{})
+	for {
+		k, v, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot record: %w", err)
+		}
+		if err := s.put(ctx, k, v, minio.PutObjectOptions{}); err != nil {
+			return err
+		}
+		restored[k] = struct{}{}
+	}
+
+	var stale []string
+	err = s.scan(ctx, "", "", func(key string) bool {
+		if _, ok := restored[key]; !ok {
+			stale = append(stale, key)
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range stale {
+		if err := s.delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *S3Store) Close() error {
+	return nil
+}
+
+// maxObjectKeyLen is the longest object key S3 allows, in bytes.
+const maxObjectKeyLen = 1024
+
+// ValidateKey fails with ErrInvalidKey for keys whose object key, with the
+// configured prefix, is longer than S3 allows.
+func (s *S3Store) ValidateKey(key string) error {
+	if n := len(s.objectKey(key)); n > maxObjectKeyLen {
+		return fmt.Errorf("%w: the object key of %q is %d bytes, over %d", ErrInvalidKey, key, n, maxObjectKeyLen)
+	}
+	return nil
+}
+
+func (s *S3Store) objectKey(key string) string {
+	return s.opts.Prefix + key
+}
+
+func (s *S3Store) objectError(key string, err error) error {
+	if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return fmt.Errorf("s3 request for %q failed: %w", key, err)
+}
+
+// isPreconditionFailed reports whether err is a conditional write that lost a
+// race with another writer.
+func isPreconditionFailed(err error) bool {
+	var resp minio.ErrorResponse
+	return errors.As(err, &resp) && resp.StatusCode == http.StatusPreconditionFailed
+}
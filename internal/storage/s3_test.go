@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+)
+
+// newTestS3Store starts an in-process fake S3 server holding the bucket
+// "rune" and returns a store using it under prefix.
+func newTestS3Store(t *testing.T, prefix string) *S3Store {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("rune"); err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+	srv := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	store, err := NewS3Store(S3Options{
+		Endpoint:          u.Host,
+		Bucket:            "rune",
+		Prefix:            prefix,
+		Region:            "us-east-1",
+		AccessKey:         "test",
+		SecretKey:         "test",
+		Insecure:          true,
+		PathStyle:         true,
+		ConditionalWrites: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create s3 store: %v", err)
+	}
+	if err := store.Initialize(t.Context()); err != nil {
+		t.Fatalf("failed to initialize s3 store: %v", err)
+	}
+	return store
+}
+
+func TestS3Store(t *testing.T) {
+	store := newTestS3Store(t, "vault/")
+	ctx := t.Context()
+
+	keys := map[string][]byte{
+		"secrets/db/pass": []byte("my-test-password"),
+		"secrets/db":      []byte("a key and a folder"),
+		"secrets/api":     []byte("api-key"),
+		"config/feature":  []byte("true"),
+	}
+
+	t.Run("Put and Get", func(t *testing.T) {
+		for k, v := range keys {
+			if err := store.Put(ctx, k, v); err != nil {
+				t.Fatalf("failed to put %q: %v", k, err)
+			}
+		}
+		for k, v := range keys {
+			got, err := store.Get(ctx, k)
+			if err != nil {
+				t.Fatalf("failed to get %q: %v", k, err)
+			}
+			if !bytes.Equal(got, v) {
+				t.Fatalf("expected value %q at %q, got %q", v, k, got)
+			}
+		}
+	})
+
+	t.Run("Got non-existent key", func(t *testing.T) {
+		_, err := store.Get(ctx, "secrets/missing")
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		got, err := store.List(ctx, "secrets/")
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected := []string{"secrets/api", "secrets/db", "secrets/db/pass"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("ListPaged", func(t *testing.T) {
+		opts := ListOptions{Prefix: "secrets/", Delimiter: "/", Limit: 2}
+		res, err := store.ListPaged(ctx, opts)
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected := []ListEntry{{Key: "secrets/api"}, {Key: "secrets/db"}}
+		if !reflect.DeepEqual(res.Entries, expected) || res.Cursor != "secrets/db" {
+			t.Fatalf("expected first page %v, got %v (cursor %q)", expected, res.Entries, res.Cursor)
+		}
+
+		opts.StartAfter = res.Cursor
+		res, err = store.ListPaged(ctx, opts)
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		expected = []ListEntry{{Key: "secrets/db/", IsFolder: true}}
+		if !reflect.DeepEqual(res.Entries, expected) || res.Cursor != "" {
+			t.Fatalf("expected last page %v, got %v (cursor %q)", expected, res.Entries, res.Cursor)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.Delete(ctx, "secrets/db/pass"); err != nil {
+			t.Fatalf("failed to delete value: %v", err)
+		}
+		if _, err := store.Get(ctx, "secrets/db/pass"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound after delete, got %v", err)
+		}
+		if err := store.Delete(ctx, "secrets/db/pass"); err != nil {
+			t.Fatalf("deleting a non-existent key should not produce an error, but got: %v", err)
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		err := store.Transaction(ctx, []TxnOp{
+			{Op: TxnPut, Key: "secrets/new", Value: []byte("v1")},
+			{Op: TxnCheck, Key: "config/feature", Value: []byte("false")},
+		})
+		if !errors.Is(err, ErrTxnCheckFailed) {
+			t.Fatalf("expected ErrTxnCheckFailed, got %v", err)
+		}
+		if _, err := store.Get(ctx, "secrets/new"); err == nil {
+			t.Fatal("expected failed transaction to write nothing")
+		}
+
+		err = store.Transaction(ctx, []TxnOp{
+			{Op: TxnCheck, Key: "config/feature", Value: []byte("true")},
+			{Op: TxnPut, Key: "config/feature", Value: []byte("false")},
+			{Op: TxnPut, Key: "secrets/new", Value: []byte("v1")},
+			{Op: TxnPut, Key: "secrets/new", Value: []byte("v2")},
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		got, err := store.Get(ctx, "config/feature")
+		if err != nil || string(got) != "false" {
+			t.Fatalf("expected config/feature to be updated, got %q (%v)", got, err)
+		}
+	})
+}
+
+func TestS3Store_ConditionalWrite(t *testing.T) {
+	store := newTestS3Store(t, "")
+	ctx := t.Context()
+
+	if err := store.Put(ctx, "lock", []byte("a")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+	_, etag, err := store.get(ctx, "lock")
+	if err != nil {
+		t.Fatalf("failed to get value: %v", err)
+	}
+
+	// Another writer replaces the object after our transaction read it.
+	if err := store.Put(ctx, "lock", []byte("b")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+
+	var opts minio.PutObjectOptions
+	opts.SetMatchETag(etag)
+	if err := store.put(ctx, "lock", []byte("c"), opts); !isPreconditionFailed(err) {
+		t.Fatalf("expected a write conditioned on a stale ETag to fail its precondition, got %v", err)
+	}
+
+	opts = minio.PutObjectOptions{}
+	opts.SetMatchETagExcept("*")
+	if err := store.put(ctx, "lock", []byte("c"), opts); !isPreconditionFailed(err) {
+		t.Fatalf("expected a create-only write of an existing key to fail its precondition, got %v", err)
+	}
+}
+
+func TestS3Store_Snapshot(t *testing.T) {
+	ctx := t.Context()
+	store := newTestS3Store(t, "a/")
+
+	keys := map[string][]byte{
+		"secrets/one": []byte("1"),
+		"secrets/two": []byte("2"),
+	}
+	for k, v := range keys {
+		if err := store.Put(ctx, k, v); err != nil {
+			t.Fatalf("failed to put %q: %v", k, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := store.Snapshot(&buf); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+
+	restored := newTestS3Store(t, "b/")
+	if err := restored.Put(ctx, "stale", []byte("gone after restore")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+
+	got, err := restored.List(ctx, "")
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	expected := []string{"secrets/one", "secrets/two"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected restored keys %q, got %q", expected, got)
+	}
+	for k, v := range keys {
+		value, err := restored.Get(ctx, k)
+		if err != nil || !bytes.Equal(value, v) {
+			t.Fatalf("expected %q at %q, got %q (%v)", v, k, value, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotView is the contents of a store as they were when the view was
+// taken, however they have changed since.
+type SnapshotView interface {
+	// Snapshot writes the view in the format of the store's Snapshot.
+	Snapshot(w io.Writer) error
+	// Release frees what the view holds on to. It may be called more than
+	// once.
+	Release()
+}
+
+// Viewer is implemented by backends that can take a SnapshotView without
+// copying their contents, such as from a bbolt read transaction.
+type Viewer interface {
+	SnapshotView() (SnapshotView, error)
+}
+
+// TakeSnapshotView returns a view of s as it is now. Backends that are not
+// Viewers are snapshotted into a temporary file in dir right away, which has
+// to have room for a copy of the store.
+func TakeSnapshotView(s Storage, dir string) (SnapshotView, error) {
+	if v, ok := unwrapTo[Viewer](s); ok {
+		return v.SnapshotView()
+	}
+
+	f, err := os.CreateTemp(dir, "view-*.tmp")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create snapshot view file")
+	}
+	view := &fileView{f: f}
+	buf := bufio.NewWriter(f)
+	if err := s.Snapshot(buf); err != nil {
+		view.Release()
+		return nil, errors.Wrap(err, "failed to snapshot store")
+	}
+	if err := buf.Flush(); err != nil {
+		view.Release()
+		return nil, errors.Wrap(err, "failed to write snapshot view")
+	}
+	return view, nil
+}
+
+// fileView is a snapshot written to a temporary file.
+type fileView struct {
+	f *os.File
+}
+
+func (v *fileView) Snapshot(w io.Writer) error {
+	if _, err := v.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, v.f)
+	return err
+}
+
+func (v *fileView) Release() {
+	if v.f == nil {
+		return
+	}
+	v.f.Close()
+	os.Remove(v.f.Name())
+	v.f = nil
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultStatsPrefixDepth = 1
+	defaultStatsTopValues   = 10
+)
+
+// ErrCompactUnsupported is returned by Compact for backends that cannot be
+// compacted.
+var ErrCompactUnsupported = errors.New("storage backend does not support compaction")
+
+// StatsOptions controls what CollectStats reports.
+type StatsOptions struct {
+	// PrefixDepth is the number of "/"-separated key segments that keys are
+	// grouped by. Zero means 1, so keys are counted per top-level prefix.
+	PrefixDepth int
+	// TopValues is the number of largest values to report. Zero means 10.
+	TopValues int
+}
+
+// Stats describes what a backend holds.
+type Stats struct {
+	Keys       uint64
+	ValueBytes uint64
+	// Prefixes holds the key counts per prefix, sorted by prefix.
+	Prefixes []PrefixStats
+	// LargestValues holds the largest values, largest first.
+	LargestValues []ValueSize
+	// File is set by backends that store everything in a single file.
+	File *FileStats
+	// Cache is set when the backend is read through a Cache.
+	Cache *CacheStats
+}
+
+type PrefixStats struct {
+	Prefix     string
+	Keys       uint64
+	ValueBytes uint64
+}
+
+type ValueSize struct {
+	Key  string
+	Size int
+}
+
+// FileStats describes the database file of a file-backed store.
+type FileStats struct {
+	Path string
+	// Size is the size of the file on disk.
+	Size     int64
+	PageSize int
+	// FreePages are pages that hold no data and are reused by later writes.
+	// PendingPages are freed pages that open read transactions still use.
+	FreePages    int
+	PendingPages int
+}
+
+// FreeBytes is the space in the file that compaction can reclaim.
+func (s *FileStats) FreeBytes() int64 {
+	return int64(s.FreePages+s.PendingPages) * int64(s.PageSize)
+}
+
+// StatsReporter is implemented by backends that can collect their stats more
+// cheaply, or in more detail, than by walking every key.
+type StatsReporter interface {
+	StorageStats(ctx context.Context, opts StatsOptions) (*Stats, error)
+}
+
+// CompactResult describes a compaction.
+type CompactResult struct {
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// Compactor is implemented by backends whose files do not shrink when data
+// is deleted, and which can rewrite them to reclaim the space.
+type Compactor interface {
+	Compact(ctx context.Context) (*CompactResult, error)
+}
+
+// CollectStats collects the stats of s, or of the backend it wraps. Backends
+// without a StatsReporter have every key walked.
+func CollectStats(ctx context.Context, s Storage, opts StatsOptions) (*Stats, error) {
+	stats, err := collectStats(ctx, s, opts)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := unwrapTo[*Cache](s); ok {
+		cache := c.Stats()
+		stats.Cache = &cache
+	}
+	return stats, nil
+}
+
+func collectStats(ctx context.Context, s Storage, opts StatsOptions) (*Stats, error) {
+	if r, ok := unwrapTo[StatsReporter](s); ok {
+		return r.StorageStats(ctx, opts)
+	}
+
+	c := newStatsCollector(opts)
+	err := Walk(ctx, s, "", func(key string, value []byte) error {
+		c.add(key, len(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.result(), nil
+}
+
+// Compact compacts s, or the backend it wraps.
+func Compact(ctx context.Context, s Storage) (*CompactResult, error) {
+	if c, ok := unwrapTo[Compactor](s); ok {
+		return c.Compact(ctx)
+	}
+	return nil, ErrCompactUnsupported
+}
+
+// unwrapTo looks through decorators that expose the backend they wrap for one
+// implementing T.
+func unwrapTo[T any](s Storage) (T, bool) {
+	for {
+		if t, ok := s.(T); ok {
+			return t, true
+		}
+		u, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		s = u.Unwrap()
+	}
+}
+
+// statsCollector accumulates Stats from keys visited in any order.
+type statsCollector struct {
+	opts     StatsOptions
+	stats    Stats
+	prefixes map[string]*PrefixStats
+}
+
+func newStatsCollector(opts StatsOptions) *statsCollector {
+	if opts.PrefixDepth <= 0 {
+		opts.PrefixDepth = defaultStatsPrefixDepth
+	}
+	if opts.TopValues <= 0 {
+		opts.TopValues = defaultStatsTopValues
+	}
+	return &statsCollector{opts: opts, prefixes: make(map[string]*PrefixStats)}
+}
+
+func (c *statsCollector) add(key string, size int) {
+	c.stats.Keys++
+	c.stats.ValueBytes += uint64(size)
+
+	prefix := keyPrefix(key, c.opts.PrefixDepth)
+	p, ok := c.prefixes[prefix]
+	if !ok {
+		p = &PrefixStats{Prefix: prefix}
+		c.prefixes[prefix] = p
+	}
+	p.Keys++
+	p.ValueBytes += uint64(size)
+
+	// Keep LargestValues sorted, largest first, and at most TopValues long.
+	top := c.stats.LargestValues
+	if len(top) == c.opts.TopValues && size <= top[len(top)-1].Size {
+		return
+	}
+	i := sort.Search(len(top), func(i int) bool { return top[i].Size < size })
+	if len(top) < c.opts.TopValues {
+		top = append(top, ValueSize{})
+	}
+	copy(top[i+1:], top[i:])
+	top[i] = ValueSize{Key: key, Size: size}
+	c.stats.LargestValues = top
+}
+
+func (c *statsCollector) result() *Stats {
+	stats := c.stats
+	stats.Prefixes = make([]PrefixStats, 0, len(c.prefixes))
+	for _, p := range c.prefixes {
+		stats.Prefixes = append(stats.Prefixes, *p)
+	}
+	sort.Slice(stats.Prefixes, func(i, j int) bool {
+		return stats.Prefixes[i].Prefix < stats.Prefixes[j].Prefix
+	})
+	return &stats
+}
+
+// keyPrefix returns the first depth segments of key, including their
+// trailing "/". Keys with fewer segments are their own prefix.
+func keyPrefix(key string, depth int) string {
+	end := 0
+	for range depth {
+		i := strings.IndexByte(key[end:], '/')
+		if i < 0 {
+			return key
+		}
+		end += i + 1
+	}
+	return key[:end]
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectStats(t *testing.T) {
+	ctx := t.Context()
+	store := NewMemStore()
+
+	values := map[string]int{
+		"logical/kv/db/pass":  10,
+		"logical/kv/db/user":  4,
+		"logical/team/api":    30,
+		"sys/mounts":          20,
+		"top-level":           1,
+		"logical/team/api/v2": 25,
+	}
+	for key, size := range values {
+		if err := store.Put(ctx, key, []byte(strings.Repeat("x", size))); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+	}
+
+	stats, err := CollectStats(ctx, store, StatsOptions{PrefixDepth: 2, TopValues: 3})
+	if err != nil {
+		t.Fatalf("failed to collect stats: %v", err)
+	}
+	if stats.Keys != 6 || stats.ValueBytes != 90 || stats.File != nil {
+		t.Fatalf("unexpected totals: %+v", stats)
+	}
+
+	expectedPrefixes := []PrefixStats{
+		{Prefix: "logical/kv/", Keys: 2, ValueBytes: 14},
+		{Prefix: "logical/team/", Keys: 2, ValueBytes: 55},
+		{Prefix: "sys/mounts", Keys: 1, ValueBytes: 20},
+		{Prefix: "top-level", Keys: 1, ValueBytes: 1},
+	}
+	if !reflect.DeepEqual(stats.Prefixes, expectedPrefixes) {
+		t.Fatalf("expected prefixes %+v, got %+v", expectedPrefixes, stats.Prefixes)
+	}
+
+	expectedLargest := []ValueSize{
+		{Key: "logical/team/api", Size: 30},
+		{Key: "logical/team/api/v2", Size: 25},
+		{Key: "sys/mounts", Size: 20},
+	}
+	if !reflect.DeepEqual(stats.LargestValues, expectedLargest) {
+		t.Fatalf("expected largest values %+v, got %+v", expectedLargest, stats.LargestValues)
+	}
+
+	if _, err := Compact(ctx, store); !errors.Is(err, ErrCompactUnsupported) {
+		t.Fatalf("expected ErrCompactUnsupported, got %v", err)
+	}
+}
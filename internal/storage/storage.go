@@ -2,16 +2,49 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
 )
 
+var (
+	ErrKeyNotFound    = errors.New("key not found")
+	ErrTxnCheckFailed = errors.New("transaction check failed")
+)
+
 type Storage interface {
 	Initialize(ctx context.Context) error
 	Get(ctx context.Context, key string) ([]byte, error)
 	Put(ctx context.Context, key string, value []byte) error
 	Delete(ctx context.Context, key string) error
 	List(ctx context.Context, prefix string) ([]string, error)
+	Transaction(ctx context.Context, ops []TxnOp) error
 	Snapshot(w io.Writer) error
 	Restore(r io.Reader) error
 	Close() error
 }
+
+type TxnOpType int
+
+const (
+	// TxnPut writes Value at Key.
+	TxnPut TxnOpType = iota
+	// TxnDelete removes Key. Deleting a missing key is not an error.
+	TxnDelete
+	// TxnCheck asserts that Key currently holds exactly Value. A nil Value
+	// asserts that Key does not exist.
+	TxnCheck
+	// TxnCheckExists asserts that Key exists, regardless of its value.
+	TxnCheckExists
+)
+
+// TxnOp is a single operation in a transaction. Operations are applied in
+// order, and all checks are evaluated against the state as it was before the
+// transaction started.
+//
+// Value is always encoded, as null when it is nil, so that a check for an
+// empty value is not mistaken for a check that the key is missing.
+type TxnOp struct {
+	Op    TxnOpType `json:"op"`
+	Key   string    `json:"key"`
+	Value []byte    `json:"value"`
+}
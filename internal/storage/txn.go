@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// checkTxnOp validates op against the current value of its key, where a nil
+// current value means the key does not exist. Write ops always pass.
+func checkTxnOp(op TxnOp, current []byte) error {
+	switch op.Op {
+	case TxnPut, TxnDelete:
+		return nil
+	case TxnCheck:
+		if op.Value == nil {
+			if current != nil {
+				return fmt.Errorf("%w: key exists: %s", ErrTxnCheckFailed, op.Key)
+			}
+			return nil
+		}
+		if current == nil || !bytes.Equal(current, op.Value) {
+			return fmt.Errorf("%w: value mismatch: %s", ErrTxnCheckFailed, op.Key)
+		}
+		return nil
+	case TxnCheckExists:
+		if current == nil {
+			return fmt.Errorf("%w: key not found: %s", ErrTxnCheckFailed, op.Key)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown transaction op: %d", op.Op)
+	}
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// WalkFunc is called for every key visited by Walk. Returning an error stops
+// the walk and is returned from Walk. The value must not be retained after
+// the function returns.
+type WalkFunc func(key string, value []byte) error
+
+// Walker is implemented by backends that can visit every key and value under
+// a prefix from a single consistent view of the store.
+type Walker interface {
+	Walk(ctx context.Context, prefix string, fn WalkFunc) error
+}
+
+// Walk calls fn for every key under prefix in key order. Backends that do not
+// implement Walker are walked with List and Get, so keys written or deleted
+// during the walk may or may not be seen.
+func Walk(ctx context.Context, s Storage, prefix string, fn WalkFunc) error {
+	if w, ok := s.(Walker); ok {
+		return w.Walk(ctx, prefix, fn)
+	}
+
+	keys, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		value, err := s.Get(ctx, key)
+		if errors.Is(err, ErrKeyNotFound) {
+			// Deleted since it was listed.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", key, err)
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
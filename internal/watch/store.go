@@ -0,0 +1,128 @@
+package watch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+// indexKey holds the highest index a Store has reserved. Indexes are
+// reserved in blocks of indexBlock, so that only one write in indexBlock also
+// has to write the key, and a restarted server carries on above the last
+// block instead of starting over.
+const (
+	indexKey   = "core/watch/index"
+	indexBlock = 1024
+)
+
+// Store publishes every write made through it to a hub. It is for servers
+// that write to storage directly; with Raft, the FSM publishes instead so
+// that events carry log indexes and fire on every node.
+//
+// Indexes are reserved in storage before they are used, so they keep
+// increasing when the server restarts, although they may skip ahead.
+type Store struct {
+	storage.Storage
+	hub *Hub
+
+	// mu orders writes with their events.
+	mu sync.Mutex
+	// next is the last index handed out, and limit the last one reserved.
+	next, limit uint64
+	loaded      bool
+}
+
+func NewStore(backend storage.Storage, hub *Hub) *Store {
+	return &Store{Storage: backend, hub: hub}
+}
+
+func (s *Store) Put(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.nextIndex(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.Put(ctx, key, value); err != nil {
+		return err
+	}
+	s.hub.Publish(Event{Index: index, Key: key, Op: OpPut})
+	return nil
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.nextIndex(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.Delete(ctx, key); err != nil {
+		return err
+	}
+	s.hub.Publish(Event{Index: index, Key: key, Op: OpDelete})
+	return nil
+}
+
+func (s *Store) Transaction(ctx context.Context, ops []storage.TxnOp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.nextIndex(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.Transaction(ctx, ops); err != nil {
+		return err
+	}
+	s.hub.PublishTxn(index, ops)
+	return nil
+}
+
+// nextIndex returns the index of the next write, reserving another block of
+// indexes first if the current one is used up. It is called with mu held.
+func (s *Store) nextIndex(ctx context.Context) (uint64, error) {
+	if !s.loaded {
+		value, err := s.Storage.Get(ctx, indexKey)
+		switch {
+		case errors.Is(err, storage.ErrKeyNotFound):
+		case err != nil:
+			return 0, fmt.Errorf("failed to read watch index: %w", err)
+		case len(value) != 8:
+			return 0, fmt.Errorf("watch index is corrupt: %d bytes", len(value))
+		default:
+			s.limit = binary.BigEndian.Uint64(value)
+		}
+		s.next = s.limit
+		s.loaded = true
+	}
+
+	s.next = max(s.next, s.hub.LastIndex())
+	if s.next >= s.limit {
+		limit := s.next + indexBlock
+		if err := s.Storage.Put(ctx, indexKey, binary.BigEndian.AppendUint64(nil, limit)); err != nil {
+			return 0, fmt.Errorf("failed to reserve watch indexes: %w", err)
+		}
+		s.limit = limit
+	}
+	s.next++
+	return s.next, nil
+}
+
+func (s *Store) ListPaged(ctx context.Context, opts storage.ListOptions) (*storage.ListResult, error) {
+	return storage.ListPaged(ctx, s.Storage, opts)
+}
+
+func (s *Store) Walk(ctx context.Context, prefix string, fn storage.WalkFunc) error {
+	return storage.Walk(ctx, s.Storage, prefix, fn)
+}
+
+func (s *Store) Unwrap() storage.Storage {
+	return s.Storage
+}
@@ -0,0 +1,295 @@
+// Package watch fans out change events to subscribers watching key prefixes.
+//
+// Events are published by whatever applies writes to a node's storage, in
+// the order they are applied, and are numbered by an increasing index (the
+// Raft log index when replicating). The hub keeps a window of recent events
+// so that a subscriber can resume from the last index it saw.
+package watch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+var (
+	// ErrCompacted is returned when resuming from an index whose events are
+	// no longer retained. The subscriber has to re-read the state it cares
+	// about and watch from the current index.
+	ErrCompacted = errors.New("watch index has been compacted")
+	// ErrLagging ends a subscription whose consumer fell too far behind.
+	ErrLagging = errors.New("watcher fell too far behind")
+	ErrClosed  = errors.New("subscription is closed")
+)
+
+const (
+	DefaultRetention = 4096
+
+	// maxPending bounds the events queued for a single subscriber.
+	maxPending = 4096
+)
+
+type Op int
+
+const (
+	OpPut Op = iota
+	OpDelete
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Event describes a change to a single key. It never carries the value.
+type Event struct {
+	Index uint64
+	Key   string
+	Op    Op
+}
+
+// Hub retains recent events and delivers new ones to subscribers.
+type Hub struct {
+	mu        sync.Mutex
+	retention int
+	events    []Event // the most recent events, oldest first
+	// first is the lowest index that a subscriber can resume after.
+	first uint64
+	last  uint64
+	subs  map[*Subscription]struct{}
+}
+
+// NewHub returns a hub retaining the last retention events for resumption.
+func NewHub(retention int) *Hub {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &Hub{retention: retention, subs: make(map[*Subscription]struct{})}
+}
+
+// LastIndex returns the index of the last published event.
+func (h *Hub) LastIndex() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+// Publish records events, which must all carry the same index and must be
+// newer than every event published before. Older events, as seen when a
+// node replays its log after a restart, are ignored.
+func (h *Hub) Publish(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	index := events[0].Index
+	if index <= h.last {
+		return
+	}
+	if h.last == 0 {
+		// Nothing before the first event we see can be replayed.
+		h.first = index - 1
+	}
+	h.last = index
+
+	h.events = append(h.events, events...)
+	if over := len(h.events) - h.retention; over > 0 {
+		h.first = h.events[over-1].Index
+		h.events = append(h.events[:0], h.events[over:]...)
+	}
+
+	for sub := range h.subs {
+		sub.deliver(events)
+	}
+}
+
+// Invalidate ends every subscription with ErrCompacted and drops the retained
+// events. It is called when storage is replaced wholesale, as on a snapshot
+// restore, since the change cannot be described as events.
+func (h *Hub) Invalidate() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		sub.fail(ErrCompacted)
+		delete(h.subs, sub)
+	}
+	h.events = nil
+	h.first = h.last
+}
+
+// PublishTxn publishes the writes of a transaction applied at index.
+func (h *Hub) PublishTxn(index uint64, ops []storage.TxnOp) {
+	var events []Event
+	for _, op := range ops {
+		switch op.Op {
+		case storage.TxnPut:
+			events = append(events, Event{Index: index, Key: op.Key, Op: OpPut})
+		case storage.TxnDelete:
+			events = append(events, Event{Index: index, Key: op.Key, Op: OpDelete})
+		}
+	}
+	h.Publish(events...)
+}
+
+// Subscribe watches keys under prefix. With fromIndex zero, only events
+// published from now on are delivered; otherwise delivery resumes with the
+// first event after fromIndex, or fails with ErrCompacted if the hub does not
+// hold every event since.
+func (h *Hub) Subscribe(prefix string, fromIndex uint64) (*Subscription, error) {
+	return h.SubscribeFunc(prefix, fromIndex, nil)
+}
+
+// SubscribeFunc is like Subscribe, but passes the key of every event under
+// prefix through rewrite before delivering it. Events for which rewrite
+// returns false are dropped.
+func (h *Hub) SubscribeFunc(prefix string, fromIndex uint64, rewrite func(key string) (string, bool)) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Resuming needs every event after fromIndex. An index past the last
+	// event is one the hub never saw, as when a client resumes against a
+	// node that restarted and has not caught up, so nothing it missed can
+	// be vouched for either.
+	if fromIndex != 0 && (fromIndex < h.first || fromIndex > h.last) {
+		return nil, ErrCompacted
+	}
+
+	sub := &Subscription{
+		hub:     h,
+		prefix:  prefix,
+		rewrite: rewrite,
+		from:    fromIndex,
+		notify:  make(chan struct{}, 1),
+	}
+	if fromIndex == 0 {
+		sub.from = h.last
+	}
+	sub.deliver(h.events)
+	h.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// View returns a view of the hub's events under prefix, with keys relative
+// to it, to match a barrier view of the same prefix.
+func (h *Hub) View(prefix string) *View {
+	return &View{hub: h, prefix: prefix}
+}
+
+// View scopes subscriptions to a prefix of the hub.
+type View struct {
+	hub    *Hub
+	prefix string
+}
+
+func (v *View) Subscribe(prefix string, fromIndex uint64) (*Subscription, error) {
+	return v.hub.SubscribeFunc(v.prefix+prefix, fromIndex, func(key string) (string, bool) {
+		return strings.TrimPrefix(key, v.prefix), true
+	})
+}
+
+// Subscription is a stream of events under a prefix.
+type Subscription struct {
+	hub     *Hub
+	prefix  string
+	rewrite func(key string) (string, bool)
+	// from is the index after which events are delivered.
+	from   uint64
+	notify chan struct{}
+
+	mu      sync.Mutex
+	pending []Event
+	err     error
+}
+
+// deliver queues the matching events. It is called with the hub lock held.
+func (s *Subscription) deliver(events []Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	for _, ev := range events {
+		if ev.Index <= s.from || !strings.HasPrefix(ev.Key, s.prefix) {
+			continue
+		}
+		if s.rewrite != nil {
+			key, ok := s.rewrite(ev.Key)
+			if !ok {
+				continue
+			}
+			ev.Key = key
+		}
+		if len(s.pending) == maxPending {
+			s.err = ErrLagging
+			s.pending = nil
+			break
+		}
+		s.pending = append(s.pending, ev)
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Next blocks until an event is available or ctx is done.
+func (s *Subscription) Next(ctx context.Context) (Event, error) {
+	for {
+		s.mu.Lock()
+		if len(s.pending) > 0 {
+			ev := s.pending[0]
+			s.pending = s.pending[1:]
+			s.mu.Unlock()
+			return ev, nil
+		}
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return Event{}, err
+		}
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		}
+	}
+}
+
+// Close stops delivery. Pending events are discarded.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+
+	s.fail(ErrClosed)
+}
+
+// fail ends the subscription with err, unless it has already ended.
+func (s *Subscription) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+	s.pending = nil
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
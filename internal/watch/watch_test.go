@@ -0,0 +1,188 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thelamedev/rune/internal/storage"
+)
+
+func nextEvent(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	ev, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to receive event: %v", err)
+	}
+	return ev
+}
+
+func expectNoEvent(t *testing.T, sub *Subscription) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if ev, err := sub.Next(ctx); err == nil {
+		t.Fatalf("expected no event, got %+v", ev)
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub(4)
+
+	sub, err := hub.Subscribe("secrets/", 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	hub.Publish(Event{Index: 1, Key: "config/feature", Op: OpPut})
+	hub.Publish(Event{Index: 2, Key: "secrets/db", Op: OpPut})
+	hub.PublishTxn(3, []storage.TxnOp{
+		{Op: storage.TxnCheckExists, Key: "secrets/db"},
+		{Op: storage.TxnDelete, Key: "secrets/db"},
+		{Op: storage.TxnPut, Key: "secrets/api", Value: []byte("ignored")},
+	})
+
+	expected := []Event{
+		{Index: 2, Key: "secrets/db", Op: OpPut},
+		{Index: 3, Key: "secrets/db", Op: OpDelete},
+		{Index: 3, Key: "secrets/api", Op: OpPut},
+	}
+	for _, want := range expected {
+		if got := nextEvent(t, sub); got != want {
+			t.Fatalf("expected event %+v, got %+v", want, got)
+		}
+	}
+	expectNoEvent(t, sub)
+
+	// Replayed entries, such as after a restart, are not published twice.
+	hub.Publish(Event{Index: 2, Key: "secrets/db", Op: OpPut})
+	expectNoEvent(t, sub)
+	if hub.LastIndex() != 3 {
+		t.Fatalf("expected last index 3, got %d", hub.LastIndex())
+	}
+}
+
+func TestHub_Resume(t *testing.T) {
+	hub := NewHub(3)
+	for i := uint64(10); i < 15; i++ {
+		hub.Publish(Event{Index: i, Key: "secrets/db", Op: OpPut})
+	}
+
+	// Events 12, 13 and 14 are retained, so a client that saw 11 can resume.
+	sub, err := hub.Subscribe("", 11)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	for _, want := range []uint64{12, 13, 14} {
+		if ev := nextEvent(t, sub); ev.Index != want {
+			t.Fatalf("expected index %d, got %d", want, ev.Index)
+		}
+	}
+	sub.Close()
+
+	if _, err := hub.Subscribe("", 10); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected ErrCompacted when resuming before the window, got %v", err)
+	}
+	if _, err := hub.Subscribe("", 15); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected ErrCompacted when resuming past the last event, got %v", err)
+	}
+	if _, err := NewHub(3).Subscribe("", 11); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected ErrCompacted when resuming on an empty hub, got %v", err)
+	}
+
+	hub.Invalidate()
+	if _, err := hub.Subscribe("", 13); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected ErrCompacted after invalidation, got %v", err)
+	}
+}
+
+func TestHub_View(t *testing.T) {
+	hub := NewHub(0)
+	view := hub.View("logical/kv/")
+
+	sub, err := view.Subscribe("db/", 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	hub.Publish(Event{Index: 1, Key: "sys/mounts", Op: OpPut})
+	hub.Publish(Event{Index: 2, Key: "logical/kv/db/pass", Op: OpPut})
+
+	if ev := nextEvent(t, sub); ev.Key != "db/pass" || ev.Index != 2 {
+		t.Fatalf("expected a relative event for db/pass, got %+v", ev)
+	}
+}
+
+func TestSubscription_Lagging(t *testing.T) {
+	hub := NewHub(0)
+	sub, err := hub.Subscribe("", 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	for i := uint64(1); i <= maxPending+1; i++ {
+		hub.Publish(Event{Index: i, Key: "k", Op: OpPut})
+	}
+	if _, err := sub.Next(t.Context()); !errors.Is(err, ErrLagging) {
+		t.Fatalf("expected ErrLagging, got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	hub := NewHub(0)
+	store := NewStore(storage.NewMemStore(), hub)
+	ctx := t.Context()
+
+	sub, err := hub.Subscribe("", 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	if err := store.Put(ctx, "a", []byte("1")); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	err = store.Transaction(ctx, []storage.TxnOp{{Op: storage.TxnCheckExists, Key: "missing"}})
+	if !errors.Is(err, storage.ErrTxnCheckFailed) {
+		t.Fatalf("expected ErrTxnCheckFailed, got %v", err)
+	}
+
+	expected := []Event{{Index: 1, Key: "a", Op: OpPut}, {Index: 2, Key: "a", Op: OpDelete}}
+	for _, want := range expected {
+		if got := nextEvent(t, sub); got != want {
+			t.Fatalf("expected event %+v, got %+v", want, got)
+		}
+	}
+	expectNoEvent(t, sub)
+}
+
+func TestStore_IndexSurvivesRestart(t *testing.T) {
+	ctx := t.Context()
+	backend := storage.NewMemStore()
+
+	var last uint64
+	for restart := range 3 {
+		hub := NewHub(0)
+		store := NewStore(backend, hub)
+		if err := store.Put(ctx, "a", []byte("1")); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+		if got := hub.LastIndex(); got <= last {
+			t.Fatalf("index went from %d to %d after restart %d", last, got, restart)
+		}
+
+		for range indexBlock {
+			if err := store.Delete(ctx, "a"); err != nil {
+				t.Fatalf("failed to delete value: %v", err)
+			}
+		}
+		last = hub.LastIndex()
+	}
+}